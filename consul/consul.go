@@ -16,6 +16,7 @@ package consul
 
 import (
 	"strconv"
+	"strings"
 
 	"github.com/deciphernow/gm-fabric-go/dbutil"
 	"github.com/hashicorp/consul/api"
@@ -48,7 +49,7 @@ func (c *Client) Register(opts ...RegistrarOption) (string, error) {
 			Port: options.Service.Port,
 		}
 	} else {
-		// Register a service with an http check
+		// Register a service with a check
 		r = &api.AgentServiceRegistration{
 			// Create a unique hash for the service
 			ID: id,
@@ -58,13 +59,8 @@ func (c *Client) Register(opts ...RegistrarOption) (string, error) {
 			Tags: options.Tags,
 			// Port of service
 			Port: options.Service.Port,
-			// Register an HTTP check
-			Check: &api.AgentServiceCheck{
-				Interval: options.Check.Interval,
-				Timeout:  options.Check.Timeout,
-				HTTP:     options.Check.Protocol + "://" + options.Service.Host + ":" + strconv.Itoa(options.Service.Port) + options.Check.Endpoint,
-				Status:   "passing",
-			},
+			// Register a check for the protocol
+			Check: newServiceCheck(options),
 		}
 	}
 
@@ -72,6 +68,33 @@ func (c *Client) Register(opts ...RegistrarOption) (string, error) {
 	return id, c.Agent().ServiceRegister(r)
 }
 
+// newServiceCheck creates the consul check for the configured protocol:
+// a gRPC check calls the grpc.health.v1 service (see health.NewGRPCServer),
+// with the endpoint as the service name; a TCP check connects to the service
+// port; an HTTP(S) check gets the endpoint (see health.Registry.Handler)
+func newServiceCheck(options RegistrarOptions) *api.AgentServiceCheck {
+	address := options.Service.Host + ":" + strconv.Itoa(options.Service.Port)
+	check := &api.AgentServiceCheck{
+		Interval: options.Check.Interval,
+		Timeout:  options.Check.Timeout,
+		Status:   "passing",
+	}
+
+	switch options.Check.Protocol {
+	case "grpc":
+		check.GRPC = address
+		if service := strings.TrimPrefix(options.Check.Endpoint, "/"); service != "" {
+			check.GRPC += "/" + service
+		}
+	case "tcp":
+		check.TCP = address
+	default:
+		check.HTTP = options.Check.Protocol + "://" + address + options.Check.Endpoint
+	}
+
+	return check
+}
+
 // Deregister will remove a service from the consul agent
 func (c *Client) Deregister(id string) error {
 	// Deregister the service using the unique hash
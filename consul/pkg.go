@@ -41,10 +41,10 @@ type ServiceConfig struct {
 
 // CheckConfig is the object you pass to Register that provides configuration option to the Consul Service Check
 type CheckConfig struct {
-	Protocol string `json:"protocol" toml:"protocol" yaml:"protocol"`     // http - https - tcp
+	Protocol string `json:"protocol" toml:"protocol" yaml:"protocol"`     // http - https - tcp - grpc
 	Interval string `json:"interval" toml:"interval" yaml:"interval"`     // how often you want the health check to run
 	Timeout  string `json:"timeout" toml:"timeout" yaml:"timeout"`        // how long the health check should wait before it times out
-	Endpoint string `json:"api_endpoint" toml:"endpoint" yaml:"endpoint"` // service endpoint the health check will ping
+	Endpoint string `json:"api_endpoint" toml:"endpoint" yaml:"endpoint"` // service endpoint the health check will ping (gRPC service name for grpc)
 }
 
 // WithServiceConfig follows functional opts pattern to inject a service config object into the service registration
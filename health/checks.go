@@ -0,0 +1,71 @@
+// Copyright 2017 Decipher Technology Studios LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package health
+
+import (
+	"context"
+
+	"github.com/garyburd/redigo/redis"
+	"github.com/pkg/errors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+	mgo "gopkg.in/mgo.v2"
+)
+
+// RedisCheck returns a check that sends PING on a connection from pool,
+// as created by dbutil.NewRedisConnection
+func RedisCheck(pool *redis.Pool) CheckFunc {
+	return func(ctx context.Context) error {
+		conn := pool.Get()
+		defer conn.Close()
+
+		_, err := conn.Do("PING")
+		return errors.Wrap(err, "redis PING")
+	}
+}
+
+// MongoCheck returns a check that pings the server on a copy of session,
+// as created by dbutil.NewMongoSession
+func MongoCheck(session *mgo.Session) CheckFunc {
+	return func(ctx context.Context) error {
+		s := session.Copy()
+		defer s.Close()
+
+		return errors.Wrap(s.Ping(), "mongo ping")
+	}
+}
+
+// GRPCConnCheck returns a check that passes when conn is ready.
+// An idle connection is asked to connect, and a connecting one is given
+// until the check times out to become ready.
+func GRPCConnCheck(conn *grpc.ClientConn) CheckFunc {
+	return func(ctx context.Context) error {
+		for {
+			state := conn.GetState()
+			switch state {
+			case connectivity.Ready:
+				return nil
+			case connectivity.Shutdown:
+				return errors.New("grpc connection shut down")
+			case connectivity.Idle:
+				conn.Connect()
+			}
+
+			if !conn.WaitForStateChange(ctx, state) {
+				return errors.Errorf("grpc connection %s", state)
+			}
+		}
+	}
+}
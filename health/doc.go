@@ -0,0 +1,36 @@
+// Copyright 2017 Decipher Technology Studios LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+/*Package health implements a registry of named health checks, shared by the
+HTTP endpoint that Consul polls, the metrics server and the standard
+grpc.health.v1 service.
+
+    registry := health.NewRegistry()
+    registry.Register("redis", health.RedisCheck(pool), health.CacheOption(5*time.Second))
+    registry.Register("mongo", health.MongoCheck(session), health.TimeoutOption(2*time.Second))
+    registry.Register("accounts", health.GRPCConnCheck(conn), health.NonCriticalOption())
+
+    http.Handle("/health", registry.Handler())
+    healthpb.RegisterHealthServer(grpcServer, health.NewGRPCServer(registry))
+
+    server := metricsserver.NewServer(
+        metricsAddress,
+        metricsserver.ReadinessRegistryOption(registry),
+    )
+
+A failing critical check makes the service unhealthy; a failing non-critical
+check is reported as a warning. Each check runs with a timeout, and its result
+can be cached so that frequent polling does not load the dependency.
+*/
+package health
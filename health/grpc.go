@@ -0,0 +1,131 @@
+// Copyright 2017 Decipher Technology Studios LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package health
+
+import (
+	"context"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+// DefaultWatchInterval is the default interval at which a Watch call
+// reruns the checks
+const DefaultWatchInterval = 5 * time.Second
+
+// GRPCServer implements the standard grpc.health.v1 Health service on top of
+// a Registry. The empty service name reports the health of the whole
+// registry; other service names report the checks mapped to them by
+// ServiceOption.
+type GRPCServer struct {
+	healthpb.UnimplementedHealthServer
+
+	registry      *Registry
+	services      map[string][]string
+	watchInterval time.Duration
+}
+
+// ServiceOption maps a service name to the checks that determine its
+// health. With no checks, the service is healthy if the whole registry is.
+func ServiceOption(service string, checks ...string) func(*GRPCServer) {
+	return func(s *GRPCServer) {
+		s.services[service] = checks
+	}
+}
+
+// WatchIntervalOption sets the interval at which a Watch call reruns the
+// checks, sending a response whenever the status changes
+func WatchIntervalOption(interval time.Duration) func(*GRPCServer) {
+	return func(s *GRPCServer) {
+		s.watchInterval = interval
+	}
+}
+
+// NewGRPCServer returns a Health service reporting the checks of registry,
+// to be registered with healthpb.RegisterHealthServer
+func NewGRPCServer(registry *Registry, options ...func(*GRPCServer)) *GRPCServer {
+	s := GRPCServer{
+		registry:      registry,
+		services:      map[string][]string{"": nil},
+		watchInterval: DefaultWatchInterval,
+	}
+	for _, o := range options {
+		o(&s)
+	}
+
+	return &s
+}
+
+// Check implements healthpb.HealthServer; it returns a NotFound error for
+// an unknown service
+func (s *GRPCServer) Check(
+	ctx context.Context,
+	req *healthpb.HealthCheckRequest,
+) (*healthpb.HealthCheckResponse, error) {
+	servingStatus := s.servingStatus(ctx, req.Service)
+	if servingStatus == healthpb.HealthCheckResponse_SERVICE_UNKNOWN {
+		return nil, status.Errorf(codes.NotFound, "unknown service %q", req.Service)
+	}
+
+	return &healthpb.HealthCheckResponse{Status: servingStatus}, nil
+}
+
+// Watch implements healthpb.HealthServer; it sends the status of the service
+// at once, then whenever it changes, until the client goes away
+func (s *GRPCServer) Watch(
+	req *healthpb.HealthCheckRequest,
+	stream healthpb.Health_WatchServer,
+) error {
+	ctx := stream.Context()
+
+	ticker := time.NewTicker(s.watchInterval)
+	defer ticker.Stop()
+
+	var last healthpb.HealthCheckResponse_ServingStatus = -1
+	for {
+		servingStatus := s.servingStatus(ctx, req.Service)
+		if servingStatus != last {
+			err := stream.Send(&healthpb.HealthCheckResponse{Status: servingStatus})
+			if err != nil {
+				return status.Errorf(codes.Canceled, "stream.Send failed: %v", err)
+			}
+			last = servingStatus
+		}
+
+		select {
+		case <-ctx.Done():
+			return status.Error(codes.Canceled, "stream has ended")
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *GRPCServer) servingStatus(
+	ctx context.Context,
+	service string,
+) healthpb.HealthCheckResponse_ServingStatus {
+	checks, ok := s.services[service]
+	if !ok {
+		return healthpb.HealthCheckResponse_SERVICE_UNKNOWN
+	}
+
+	if s.registry.Run(ctx, checks...).Status == StatusFail {
+		return healthpb.HealthCheckResponse_NOT_SERVING
+	}
+
+	return healthpb.HealthCheckResponse_SERVING
+}
@@ -0,0 +1,115 @@
+// Copyright 2017 Decipher Technology Studios LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package health
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+func TestGRPCServer(t *testing.T) {
+	var failing int32
+	toggleCheck := func(ctx context.Context) error {
+		if atomic.LoadInt32(&failing) != 0 {
+			return errors.New("failing")
+		}
+		return nil
+	}
+
+	r := NewRegistry()
+	if err := r.Register("toggle", toggleCheck); err != nil {
+		t.Fatalf("Register failed: %s", err)
+	}
+	if err := r.Register("other", failCheck, NonCriticalOption()); err != nil {
+		t.Fatalf("Register failed: %s", err)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen failed: %s", err)
+	}
+	server := grpc.NewServer()
+	healthpb.RegisterHealthServer(server, NewGRPCServer(
+		r,
+		ServiceOption("svc", "toggle"),
+		WatchIntervalOption(10*time.Millisecond),
+	))
+	go server.Serve(listener)
+	defer server.Stop()
+
+	conn, err := grpc.Dial(listener.Addr().String(), grpc.WithInsecure())
+	if err != nil {
+		t.Fatalf("grpc.Dial failed: %s", err)
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err = GRPCConnCheck(conn)(ctx); err != nil {
+		t.Fatalf("GRPCConnCheck failed: %s", err)
+	}
+
+	client := healthpb.NewHealthClient(conn)
+	for _, service := range []string{"", "svc"} {
+		resp, err := client.Check(ctx, &healthpb.HealthCheckRequest{Service: service})
+		if err != nil {
+			t.Fatalf("%q: Check failed: %s", service, err)
+		}
+		if resp.Status != healthpb.HealthCheckResponse_SERVING {
+			t.Fatalf("%q: expected SERVING found %s", service, resp.Status)
+		}
+	}
+
+	_, err = client.Check(ctx, &healthpb.HealthCheckRequest{Service: "unknown"})
+	if status.Code(err) != codes.NotFound {
+		t.Fatalf("expected NotFound, found %v", err)
+	}
+
+	stream, err := client.Watch(ctx, &healthpb.HealthCheckRequest{Service: "svc"})
+	if err != nil {
+		t.Fatalf("Watch failed: %s", err)
+	}
+	resp, err := stream.Recv()
+	if err != nil {
+		t.Fatalf("stream.Recv failed: %s", err)
+	}
+	if resp.Status != healthpb.HealthCheckResponse_SERVING {
+		t.Fatalf("expected SERVING found %s", resp.Status)
+	}
+
+	atomic.StoreInt32(&failing, 1)
+	resp, err = stream.Recv()
+	if err != nil {
+		t.Fatalf("stream.Recv failed: %s", err)
+	}
+	if resp.Status != healthpb.HealthCheckResponse_NOT_SERVING {
+		t.Fatalf("expected NOT_SERVING found %s", resp.Status)
+	}
+
+	conn.Close()
+	if err = GRPCConnCheck(conn)(ctx); err == nil {
+		t.Fatal("expected GRPCConnCheck to fail on a closed connection")
+	}
+}
@@ -0,0 +1,305 @@
+// Copyright 2017 Decipher Technology Studios LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package health
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// CheckFunc checks a dependency of the service, returning an error if it is
+// unavailable. It should return promptly when ctx is done.
+type CheckFunc func(ctx context.Context) error
+
+// Status is the outcome of a check, or of all the checks in a registry
+type Status string
+
+const (
+	// StatusPass means the check succeeded
+	StatusPass Status = "pass"
+
+	// StatusWarn means a non-critical check failed
+	StatusWarn Status = "warn"
+
+	// StatusFail means a critical check failed
+	StatusFail Status = "fail"
+)
+
+// DefaultTimeout is the default time allowed for each check
+const DefaultTimeout = 5 * time.Second
+
+// severity orders statuses from best to worst
+func (s Status) severity() int {
+	switch s {
+	case StatusPass:
+		return 0
+	case StatusWarn:
+		return 1
+	}
+	return 2
+}
+
+// Result is the outcome of running one check
+type Result struct {
+	Name     string        `json:"name"`
+	Status   Status        `json:"status"`
+	Critical bool          `json:"critical"`
+	Error    string        `json:"error,omitempty"`
+	Checked  time.Time     `json:"checked"`
+	Duration time.Duration `json:"duration_ns"`
+	Cached   bool          `json:"cached,omitempty"`
+}
+
+// Report is the outcome of running the checks of a registry.
+// Its Status is the worst status of its checks.
+type Report struct {
+	Status Status   `json:"status"`
+	Checks []Result `json:"checks"`
+}
+
+type registeredCheck struct {
+	name     string
+	check    CheckFunc
+	timeout  time.Duration
+	cacheTTL time.Duration
+	critical bool
+
+	// the lock serializes runs, so that callers arriving while a cached
+	// check runs share its result
+	sync.Mutex
+	last Result
+}
+
+// CheckOption configures a check when it is registered
+type CheckOption func(*registeredCheck)
+
+// TimeoutOption sets the time allowed for the check; a check that has not
+// returned by then fails. The default is DefaultTimeout.
+func TimeoutOption(timeout time.Duration) CheckOption {
+	return func(c *registeredCheck) {
+		c.timeout = timeout
+	}
+}
+
+// CacheOption reuses the result of the check for ttl after it runs.
+// By default the check runs on every request.
+func CacheOption(ttl time.Duration) CheckOption {
+	return func(c *registeredCheck) {
+		c.cacheTTL = ttl
+	}
+}
+
+// NonCriticalOption makes the failure of the check a warning, which does not
+// make the service unhealthy
+func NonCriticalOption() CheckOption {
+	return func(c *registeredCheck) {
+		c.critical = false
+	}
+}
+
+// Registry is a set of named checks. Checks can be registered and
+// unregistered while the registry is in use.
+type Registry struct {
+	sync.Mutex
+	checks map[string]*registeredCheck
+}
+
+// NewRegistry returns an empty registry
+func NewRegistry() *Registry {
+	return &Registry{checks: make(map[string]*registeredCheck)}
+}
+
+// Register adds a named check to the registry.
+// Checks are critical unless NonCriticalOption is given.
+func (r *Registry) Register(
+	name string,
+	check CheckFunc,
+	options ...CheckOption,
+) error {
+	c := registeredCheck{
+		name:     name,
+		check:    check,
+		timeout:  DefaultTimeout,
+		critical: true,
+	}
+	for _, o := range options {
+		o(&c)
+	}
+
+	r.Lock()
+	defer r.Unlock()
+
+	if _, ok := r.checks[name]; ok {
+		return errors.Errorf("check %q already registered", name)
+	}
+	r.checks[name] = &c
+
+	return nil
+}
+
+// Unregister removes a named check from the registry, returning false if
+// there is no such check
+func (r *Registry) Unregister(name string) bool {
+	r.Lock()
+	defer r.Unlock()
+
+	if _, ok := r.checks[name]; !ok {
+		return false
+	}
+	delete(r.checks, name)
+
+	return true
+}
+
+// Names returns the sorted names of the registered checks
+func (r *Registry) Names() []string {
+	r.Lock()
+	defer r.Unlock()
+
+	names := make([]string, 0, len(r.checks))
+	for name := range r.checks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// Run runs the named checks concurrently, or all checks if no names are
+// given, and reports their results in order of name.
+// A name that is not registered is reported as a failed critical check.
+func (r *Registry) Run(ctx context.Context, names ...string) Report {
+	if len(names) == 0 {
+		names = r.Names()
+	} else {
+		names = append([]string(nil), names...)
+		sort.Strings(names)
+	}
+
+	r.Lock()
+	checks := make([]*registeredCheck, len(names))
+	for i, name := range names {
+		checks[i] = r.checks[name]
+	}
+	r.Unlock()
+
+	report := Report{Status: StatusPass, Checks: make([]Result, len(names))}
+
+	var wg sync.WaitGroup
+	for i := range checks {
+		if checks[i] == nil {
+			report.Checks[i] = Result{
+				Name:     names[i],
+				Status:   StatusFail,
+				Critical: true,
+				Error:    "unknown check",
+				Checked:  time.Now(),
+			}
+			continue
+		}
+
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			report.Checks[i] = checks[i].run(ctx)
+		}(i)
+	}
+	wg.Wait()
+
+	for _, result := range report.Checks {
+		if result.Status.severity() > report.Status.severity() {
+			report.Status = result.Status
+		}
+	}
+
+	return report
+}
+
+// Check runs all checks and returns an error naming the failed critical
+// checks, if any. It can be given to the metrics server as a health or
+// readiness check.
+func (r *Registry) Check(ctx context.Context) error {
+	report := r.Run(ctx)
+	if report.Status != StatusFail {
+		return nil
+	}
+
+	var failures []string
+	for _, result := range report.Checks {
+		if result.Status == StatusFail {
+			failures = append(failures, result.Name+": "+result.Error)
+		}
+	}
+
+	return errors.New(strings.Join(failures, "; "))
+}
+
+func (c *registeredCheck) run(ctx context.Context) Result {
+	c.Lock()
+	defer c.Unlock()
+
+	start := time.Now()
+	if c.cacheTTL > 0 && !c.last.Checked.IsZero() &&
+		start.Sub(c.last.Checked) < c.cacheTTL {
+		result := c.last
+		result.Cached = true
+		return result
+	}
+
+	checkCtx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	// run the check in its own goroutine so that a check that ignores its
+	// context cannot outlive the timeout
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- c.check(checkCtx)
+	}()
+
+	var err error
+	select {
+	case err = <-errChan:
+	case <-checkCtx.Done():
+		err = errors.Wrap(checkCtx.Err(), "check did not complete")
+	}
+
+	result := Result{
+		Name:     c.name,
+		Status:   StatusPass,
+		Critical: c.critical,
+		Checked:  start,
+		Duration: time.Since(start),
+	}
+	if err != nil {
+		result.Error = err.Error()
+		result.Status = StatusFail
+		if !c.critical {
+			result.Status = StatusWarn
+		}
+	}
+
+	// a result caused by the caller giving up says nothing about the
+	// dependency, so it is not cached
+	if ctx.Err() == nil {
+		c.last = result
+	}
+
+	return result
+}
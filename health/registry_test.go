@@ -0,0 +1,195 @@
+// Copyright 2017 Decipher Technology Studios LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+func passCheck(ctx context.Context) error {
+	return nil
+}
+
+func failCheck(ctx context.Context) error {
+	return errors.New("unavailable")
+}
+
+func TestRun(t *testing.T) {
+	testCases := []struct {
+		name           string
+		critical       CheckFunc
+		nonCritical    CheckFunc
+		expectedStatus Status
+	}{
+		{"all pass", passCheck, passCheck, StatusPass},
+		{"non-critical fails", passCheck, failCheck, StatusWarn},
+		{"critical fails", failCheck, passCheck, StatusFail},
+		{"all fail", failCheck, failCheck, StatusFail},
+	}
+
+	for i, tc := range testCases {
+		t.Run(fmt.Sprintf("%d: %s", i, tc.name), func(t *testing.T) {
+			r := NewRegistry()
+			if err := r.Register("critical", tc.critical); err != nil {
+				t.Fatalf("Register failed: %s", err)
+			}
+			err := r.Register("noncritical", tc.nonCritical, NonCriticalOption())
+			if err != nil {
+				t.Fatalf("Register failed: %s", err)
+			}
+
+			report := r.Run(context.Background())
+			if report.Status != tc.expectedStatus {
+				t.Fatalf("expected %s found %s", tc.expectedStatus, report.Status)
+			}
+			if len(report.Checks) != 2 || report.Checks[0].Name != "critical" {
+				t.Fatalf("unexpected checks %+v", report.Checks)
+			}
+
+			err = r.Check(context.Background())
+			if (err != nil) != (tc.expectedStatus == StatusFail) {
+				t.Fatalf("unexpected Check result %v", err)
+			}
+		})
+	}
+}
+
+func TestRegister(t *testing.T) {
+	r := NewRegistry()
+	if err := r.Register("a", passCheck); err != nil {
+		t.Fatalf("Register failed: %s", err)
+	}
+	if err := r.Register("a", passCheck); err == nil {
+		t.Fatal("expected an error registering a duplicate name")
+	}
+
+	report := r.Run(context.Background(), "a", "b")
+	if report.Status != StatusFail || report.Checks[1].Error != "unknown check" {
+		t.Fatalf("unexpected report %+v", report)
+	}
+
+	if !r.Unregister("a") {
+		t.Fatal("Unregister returned false")
+	}
+	if r.Unregister("a") {
+		t.Fatal("second Unregister returned true")
+	}
+	if names := r.Names(); len(names) != 0 {
+		t.Fatalf("expected no names, found %v", names)
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	r := NewRegistry()
+	block := make(chan struct{})
+	defer close(block)
+
+	// the check ignores its context
+	err := r.Register(
+		"slow",
+		func(ctx context.Context) error {
+			<-block
+			return nil
+		},
+		TimeoutOption(10*time.Millisecond),
+	)
+	if err != nil {
+		t.Fatalf("Register failed: %s", err)
+	}
+
+	report := r.Run(context.Background())
+	if report.Status != StatusFail {
+		t.Fatalf("expected %s found %s", StatusFail, report.Status)
+	}
+	if !strings.Contains(report.Checks[0].Error, "did not complete") {
+		t.Fatalf("unexpected error %q", report.Checks[0].Error)
+	}
+}
+
+func TestCache(t *testing.T) {
+	r := NewRegistry()
+
+	var calls int32
+	countCheck := func(ctx context.Context) error {
+		atomic.AddInt32(&calls, 1)
+		return nil
+	}
+
+	if err := r.Register("cached", countCheck, CacheOption(time.Hour)); err != nil {
+		t.Fatalf("Register failed: %s", err)
+	}
+	if err := r.Register("uncached", countCheck); err != nil {
+		t.Fatalf("Register failed: %s", err)
+	}
+
+	for i := 0; i < 3; i++ {
+		report := r.Run(context.Background())
+		if report.Checks[0].Cached != (i > 0) {
+			t.Fatalf("run %d: unexpected Cached %t", i, report.Checks[0].Cached)
+		}
+	}
+	if calls != 4 {
+		t.Fatalf("expected 4 calls, found %d", calls)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	r := NewRegistry()
+	if err := r.Register("pass", passCheck); err != nil {
+		t.Fatalf("Register failed: %s", err)
+	}
+	if err := r.Register("fail", failCheck); err != nil {
+		t.Fatalf("Register failed: %s", err)
+	}
+
+	testCases := []struct {
+		query          string
+		expectedCode   int
+		expectedChecks int
+	}{
+		{"", http.StatusServiceUnavailable, 2},
+		{"?check=pass", http.StatusOK, 1},
+		{"?check=pass&check=fail", http.StatusServiceUnavailable, 2},
+	}
+
+	for i, tc := range testCases {
+		req := httptest.NewRequest("GET", "/health"+tc.query, nil)
+		rec := httptest.NewRecorder()
+		r.Handler().ServeHTTP(rec, req)
+
+		if rec.Code != tc.expectedCode {
+			t.Fatalf("%d: expected status %d found %d", i, tc.expectedCode, rec.Code)
+		}
+
+		var report Report
+		if err := json.Unmarshal(rec.Body.Bytes(), &report); err != nil {
+			t.Fatalf("%d: json.Unmarshal failed: %s", i, err)
+		}
+		if len(report.Checks) != tc.expectedChecks {
+			t.Fatalf("%d: expected %d checks found %d",
+				i, tc.expectedChecks, len(report.Checks))
+		}
+	}
+}
@@ -19,10 +19,10 @@ import (
 	"sync"
 	"time"
 
-	"github.com/pkg/errors"
+	"google.golang.org/grpc/codes"
 
+	"github.com/deciphernow/gm-fabric-go/metrics/cardinality"
 	"github.com/deciphernow/gm-fabric-go/metrics/subject"
-	"github.com/montanaflynn/stats"
 )
 
 // APIStatsEntry reports stats on an individual API call
@@ -31,8 +31,19 @@ type APIStatsEntry struct {
 	Key        string
 	Transport  subject.EventTransport
 	HTTPStatus int
-	PrevRoute  string
-	Err        error
+
+	// Method is the HTTP method, if any.
+	// Entries are stored under EndpointKey, e.g. route/users/GET
+	Method string
+
+	// GRPCCode is the status code of a gRPC call
+	GRPCCode codes.Code
+
+	PrevRoute string
+	Err       error
+
+	// Retries is the number of times an outgoing request was retried
+	Retries int64
 
 	// BeginTime is the earliest point that we can store a timestamp
 	BeginTime time.Time
@@ -76,24 +87,25 @@ type APIStats struct {
 	sync.Mutex
 	Cache  *APIStatsCache
 	Counts CumulativeCounts
-}
-
-type throughputAccum struct {
-	receivedSecs  int64
-	bytesReceived int64
-	sentSecs      int64
-	bytesSent     int64
-}
 
-type endpointResultType struct {
-	throughputAccum
-	apiStats       map[string]APIEndpointStats
-	latencySamples map[string][]float64
-}
-
-type allEndpointsResultType struct {
-	allEndpoints               APIEndpointStats
-	allEndpointsLatencySamples []float64
+	// cursors holds the counts at each consumer's last delta;
+	// origin is the cursor of a new consumer, the counts restored
+	// from a snapshot. cursorUse orders the cursors by last use,
+	// so that the least recently used is evicted beyond maxConsumers.
+	cursors      map[string]CumulativeCounts
+	cursorUse    map[string]uint64
+	useCount     uint64
+	maxConsumers int
+	origin       CumulativeCounts
+
+	// endpoints accumulates the transactions in the cache by key
+	endpoints map[string]*endpointAccum
+
+	guard            *cardinality.Guard
+	windows          *timeWindows
+	windowDurations  []time.Duration
+	windowResolution time.Duration
+	now              func() time.Time
 }
 
 // indices to percentiles
@@ -108,216 +120,247 @@ const (
 	pCount = 6 // number of percentile values
 )
 
-func New(cacheSize int) *APIStats {
-	return &APIStats{
-		Cache:  NewAPIStatsCache(cacheSize),
-		Counts: newCumulativeCounts(),
+// WindowsOption returns an APIStats option function that sets the time
+// windows reported by GetWindowStats. No windows disables windowed stats.
+func WindowsOption(windows ...time.Duration) func(*APIStats) {
+	return func(st *APIStats) {
+		st.windowDurations = windows
+	}
+}
+
+// WindowResolutionOption returns an APIStats option function that sets the
+// width of the buckets that make up a window. Windows are rounded up to a
+// multiple of the resolution.
+func WindowResolutionOption(resolution time.Duration) func(*APIStats) {
+	return func(st *APIStats) {
+		if resolution > 0 {
+			st.windowResolution = resolution
+		}
+	}
+}
+
+// DefaultMaxConsumers is the number of delta consumers whose cursors are
+// kept when no MaxConsumersOption is given
+const DefaultMaxConsumers = 16
+
+// MaxConsumersOption returns an APIStats option function that sets the
+// number of delta consumers whose cursors are kept; beyond that, the
+// cursor of the least recently used consumer is discarded
+func MaxConsumersOption(maxConsumers int) func(*APIStats) {
+	return func(st *APIStats) {
+		if maxConsumers > 0 {
+			st.maxConsumers = maxConsumers
+		}
+	}
+}
+
+// CardinalityGuardOption returns an APIStats option function that folds
+// keys rejected by guard into cardinality.OtherKey
+func CardinalityGuardOption(guard *cardinality.Guard) func(*APIStats) {
+	return func(st *APIStats) {
+		st.guard = guard
 	}
 }
 
+// New creates APIStats that keep the last cacheSize entries for
+// GetEndpointStats, and the entries stored during each time window
+// for GetWindowStats.
+func New(cacheSize int, options ...func(*APIStats)) *APIStats {
+	st := APIStats{
+		Cache:            NewAPIStatsCache(cacheSize),
+		Counts:           newCumulativeCounts(),
+		cursors:          make(map[string]CumulativeCounts),
+		cursorUse:        make(map[string]uint64),
+		maxConsumers:     DefaultMaxConsumers,
+		origin:           newCumulativeCounts(),
+		endpoints:        make(map[string]*endpointAccum),
+		windowDurations:  DefaultWindows,
+		windowResolution: DefaultWindowResolution,
+		now:              time.Now,
+	}
+
+	for _, f := range options {
+		f(&st)
+	}
+
+	st.windows = newTimeWindows(st.windowDurations, st.windowResolution, st.now())
+
+	return &st
+}
+
 func (st *APIStats) Store(entry APIStatsEntry) {
+	key := st.guard.Key(entry.Key)
+	entry.Key = EndpointKey(APIStatsEntry{
+		Key:    key,
+		Method: entry.Method,
+	})
+
 	st.Lock()
 	defer st.Unlock()
 
-	st.Cache.Store(entry)
+	st.cacheEntry(entry)
+
+	st.windows.store(entry, st.now())
+
+	// outgoing requests have keys of their own, but are not part of the
+	// totals of the requests received
+	client := entry.Transport.IsClient()
 
-	st.Counts.TotalEvents++
 	st.Counts.TransportEvents[entry.Transport]++
+	if !client {
+		st.Counts.TotalEvents++
+		if entry.Method != "" {
+			st.Counts.MethodEvents[entry.Method]++
+		}
+	}
 	keyEvents, ok := st.Counts.KeyEvents[entry.Key]
 	if !ok {
-		keyEvents.StatusEvents = make(map[int]int64)
-		keyEvents.StatusClassEvents = make(map[string]int64)
+		keyEvents = newKeyEventsEntry()
 	}
+	keyEvents.Key = key
+	keyEvents.Method = entry.Method
 	keyEvents.Events++
-	if entry.Transport == subject.EventTransportHTTP ||
-		entry.Transport == subject.EventTransportHTTPS {
+	keyEvents.Client = client
+	keyEvents.Retries += entry.Retries
+	switch entry.Transport {
+	case subject.EventTransportHTTP, subject.EventTransportHTTPS,
+		subject.EventTransportHTTPClient, subject.EventTransportHTTPSClient:
 		keyEvents.StatusEvents[entry.HTTPStatus]++
 		keyEvents.StatusClassEvents[statusClass(entry.HTTPStatus)]++
+	case subject.EventTransportRPC, subject.EventTransportRPCWithTLS:
+		keyEvents.GRPCCodeEvents[entry.GRPCCode]++
+		st.Counts.GRPCCodeEvents[entry.GRPCCode]++
 	}
 	st.Counts.KeyEvents[entry.Key] = keyEvents
 }
 
-// GetLatencyStats returns a summary of the stats currently available in the cache
-func (st *APIStats) GetEndpointStats() (map[string]APIEndpointStats, error) {
-	st.Lock()
-	defer st.Unlock()
-
-	endpointResult := st.accumulateEndpointStats()
-
-	// computeEndpointStats modifies endpointResult
-	allEndpointsResult, err := st.computeEndpointStats(&endpointResult)
-	if err != nil {
-		return nil, errors.Wrapf(err, "computeEndpointStats")
-	}
-
-	if allEndpointsResult.allEndpoints.Count > 0 {
-		allEndpointsResult.allEndpoints.Avg =
-			float64(allEndpointsResult.allEndpoints.Sum) / float64(allEndpointsResult.allEndpoints.Count)
-		percentileValues, err :=
-			computePercentiles(allEndpointsResult.allEndpointsLatencySamples)
-		if err != nil {
-			return nil, errors.Wrapf(err, "computePercentiles")
-		}
-		allEndpointsResult.allEndpoints.P50 = percentileValues[p50]
-		allEndpointsResult.allEndpoints.P90 = percentileValues[p90]
-		allEndpointsResult.allEndpoints.P95 = percentileValues[p95]
-		allEndpointsResult.allEndpoints.P99 = percentileValues[p99]
-		allEndpointsResult.allEndpoints.P9990 = percentileValues[p9990]
-		allEndpointsResult.allEndpoints.P9999 = percentileValues[p9999]
-
-		if endpointResult.receivedSecs > 0 {
-			allEndpointsResult.allEndpoints.InThroughput =
-				endpointResult.bytesReceived / endpointResult.receivedSecs
-		}
-
-		if endpointResult.sentSecs > 0 {
-			allEndpointsResult.allEndpoints.OutThroughput =
-				endpointResult.bytesSent / endpointResult.sentSecs
+// cacheEntry pushes an entry into the cache and updates the accumulators
+// of the keys of the entry and the evicted entry, if any.
+// It must be called with the lock held.
+func (st *APIStats) cacheEntry(entry APIStatsEntry) {
+	if evicted, ok := st.Cache.Push(entry); ok {
+		if accum := st.endpoints[evicted.Key]; accum != nil {
+			accum.remove(evicted)
+			if accum.count <= 0 {
+				delete(st.endpoints, evicted.Key)
+			}
 		}
 	}
-
-	endpointResult.apiStats["all"] = allEndpointsResult.allEndpoints
-
-	return endpointResult.apiStats, nil
+	accum, ok := st.endpoints[entry.Key]
+	if !ok {
+		accum = newEndpointAccum()
+		st.endpoints[entry.Key] = accum
+	}
+	accum.add(entry)
 }
 
-func (st *APIStats) accumulateEndpointStats() endpointResultType {
-	endpointResult := endpointResultType{
-		apiStats:       make(map[string]APIEndpointStats),
-		latencySamples: make(map[string][]float64),
+// EndpointKey returns the key that an entry is stored under:
+// Key/Method if the entry has a method, Key otherwise
+func EndpointKey(entry APIStatsEntry) string {
+	if entry.Method == "" {
+		return entry.Key
 	}
-	endpointAccumMap := make(map[string]throughputAccum)
-
-	// read through all cached transactions (trans) accumulating stats per
-	// endpoint
-	for trans := range st.Cache.Traverse() {
-
-		endpoint := endpointResult.apiStats[trans.Key]
-		if endpoint.Routes == nil {
-			endpoint.Routes = make(map[string]struct{})
-		}
-		if trans.PrevRoute != "" {
-			endpoint.Routes[trans.PrevRoute] = struct{}{}
-		}
-		endpointLatencySamples := endpointResult.latencySamples[trans.Key]
-		endpointAccum := endpointAccumMap[trans.Key]
-
-		latencyDuration := trans.RequestTime.Sub(trans.BeginTime)
-		latency := duration2ms(latencyDuration)
-
-		endpointLatencySamples = append(endpointLatencySamples, float64(latency))
-
-		endpoint.Count++
-		endpoint.Sum += latency
+	return fmt.Sprintf("%s/%s", entry.Key, entry.Method)
+}
 
-		if endpoint.Min == 0 || latency < endpoint.Min {
-			endpoint.Min = latency
-		}
-		if latency > endpoint.Max {
-			endpoint.Max = latency
-		}
-		if trans.Err != nil {
-			endpoint.Errors++
-		}
+// GetEndpointStats returns a summary of the stats currently available in the cache
+// The stats are maintained as entries are stored, so the cost of this call
+// depends on the number of keys, not on the size of the cache.
+func (st *APIStats) GetEndpointStats() (map[string]APIEndpointStats, error) {
+	st.Lock()
+	defer st.Unlock()
 
-		if (!trans.InCaptureTime.IsZero()) && (!trans.RequestTime.IsZero()) {
-			requestSecs := int64(trans.InCaptureTime.Sub(trans.RequestTime).Seconds())
-			if requestSecs > 0 {
-				endpointAccum.receivedSecs += requestSecs
-				endpointAccum.bytesReceived += trans.InWireLength
-			}
+	result := make(map[string]APIEndpointStats, len(st.endpoints)+1)
+	all := newEndpointAccum()
+	for key, accum := range st.endpoints {
+		result[key] = accum.endpointStats()
+		if !accum.client {
+			all.merge(accum)
 		}
-
-		if (!trans.OutCaptureTime.IsZero()) && (!trans.ResponseTime.IsZero()) {
-			responseSecs := int64(trans.OutCaptureTime.Sub(trans.ResponseTime).Seconds())
-			if responseSecs > 0 {
-				endpointAccum.sentSecs += responseSecs
-				endpointAccum.bytesSent += trans.OutWireLength
-			}
-		}
-
-		endpointAccumMap[trans.Key] = endpointAccum
-		endpointResult.apiStats[trans.Key] = endpoint
-		endpointResult.latencySamples[trans.Key] = endpointLatencySamples
 	}
+	result["all"] = all.endpointStats()
 
-	for key := range endpointAccumMap {
-		endpoint := endpointResult.apiStats[key]
-		endpointAccum := endpointAccumMap[key]
+	return result, nil
+}
 
-		if endpointAccum.receivedSecs > 0 {
-			endpoint.InThroughput = endpointAccum.bytesReceived / endpointAccum.receivedSecs
-			endpointResult.receivedSecs += endpointAccum.receivedSecs
-			endpointResult.bytesReceived += endpointAccum.bytesReceived
-		}
+// GetWindowStats returns a summary of the entries stored during each time
+// window, in the order the windows were configured. Unlike GetEndpointStats,
+// the results cover the same time span regardless of the request rate.
+func (st *APIStats) GetWindowStats() ([]WindowStats, error) {
+	st.Lock()
+	now := st.now()
+	windows := st.windows.snapshot(now)
+	st.Unlock()
 
-		if endpointAccum.sentSecs > 0 {
-			endpoint.OutThroughput = endpointAccum.bytesSent / endpointAccum.sentSecs
-			endpointResult.sentSecs += endpointAccum.sentSecs
-			endpointResult.bytesSent += endpointAccum.bytesSent
-		}
+	// merge the buckets without blocking Store
+	return windows.getStats(now), nil
+}
 
-		endpointResult.apiStats[key] = endpoint
-	}
+// GetCumulativeCounts returns cumulative counts of events
+func (st *APIStats) GetCumulativeCounts() CumulativeCounts {
+	st.Lock()
+	defer st.Unlock()
 
-	return endpointResult
+	return copyCumulativeCounts(st.Counts)
 }
 
-func (st *APIStats) computeEndpointStats(
-	endpointResult *endpointResultType,
-) (allEndpointsResultType, error) {
-	var allEndpointsResult allEndpointsResultType
+// GetCumulativeCountsDelta returns the counts of events since the previous
+// call with the same consumer, or since the start on the first call.
+// Counts restored from a snapshot are never part of a delta.
+// Each consumer has its own cursor, so consumers such as the dashboard and
+// a push reporter do not reset each other. The cursors of at most
+// MaxConsumersOption consumers are kept.
+func (st *APIStats) GetCumulativeCountsDelta(consumer string) CumulativeCounts {
+	st.Lock()
+	defer st.Unlock()
 
-	// read through accumulated endpoint stats computing statistical values
-	// (AVG, etc.), and accumulating total for all endpoints
+	current := copyCumulativeCounts(st.Counts)
+	previous, ok := st.cursors[consumer]
+	if !ok {
+		previous = st.origin
+	}
+	st.setCursor(consumer, current)
 
-	for name := range endpointResult.apiStats {
-		endpoint := endpointResult.apiStats[name]
-		endpointLatencySamples := endpointResult.latencySamples[name]
+	return addCumulativeCounts(current, previous, -1)
+}
 
-		allEndpointsResult.allEndpoints.Count += endpoint.Count
-		if endpoint.Count > 0 {
-			endpoint.Avg = float64(endpoint.Sum) / float64(endpoint.Count)
-		}
+// ResetCumulativeCounts moves the consumer's cursor to the current counts,
+// so that its next delta counts only the events from now on
+func (st *APIStats) ResetCumulativeCounts(consumer string) {
+	st.Lock()
+	defer st.Unlock()
 
-		if endpoint.Max > allEndpointsResult.allEndpoints.Max {
-			allEndpointsResult.allEndpoints.Max = endpoint.Max
-		}
-		if allEndpointsResult.allEndpoints.Min == 0 || endpoint.Min < allEndpointsResult.allEndpoints.Min {
-			allEndpointsResult.allEndpoints.Min = endpoint.Min
-		}
-		allEndpointsResult.allEndpoints.Sum += endpoint.Sum
+	st.setCursor(consumer, copyCumulativeCounts(st.Counts))
+}
 
-		if endpoint.Count > 0 {
-			percentileValues, err := computePercentiles(endpointLatencySamples)
-			if err != nil {
-				return allEndpointsResultType{}, errors.Wrapf(err, "computePercentiles")
+// setCursor must be called with the lock held; it evicts the least
+// recently used cursor to make room for a new consumer
+func (st *APIStats) setCursor(consumer string, counts CumulativeCounts) {
+	if _, ok := st.cursors[consumer]; !ok && len(st.cursors) >= st.maxConsumers {
+		var oldest string
+		var oldestUse uint64
+		for name, use := range st.cursorUse {
+			if oldestUse == 0 || use < oldestUse {
+				oldest, oldestUse = name, use
 			}
-			endpoint.P50 = percentileValues[p50]
-			endpoint.P90 = percentileValues[p90]
-			endpoint.P95 = percentileValues[p95]
-			endpoint.P99 = percentileValues[p99]
-			endpoint.P9990 = percentileValues[p9990]
-			endpoint.P9999 = percentileValues[p9999]
 		}
-
-		allEndpointsResult.allEndpointsLatencySamples =
-			append(allEndpointsResult.allEndpointsLatencySamples, endpointLatencySamples...)
-
-		allEndpointsResult.allEndpoints.Errors += endpoint.Errors
-
-		endpointResult.apiStats[name] = endpoint
+		delete(st.cursors, oldest)
+		delete(st.cursorUse, oldest)
 	}
 
-	return allEndpointsResult, nil
+	st.useCount++
+	st.cursors[consumer] = counts
+	st.cursorUse[consumer] = st.useCount
 }
 
-// GetCumulativeCounts returns cumulative counts of events
-func (st *APIStats) GetCumulativeCounts() CumulativeCounts {
+// RemoveConsumer discards the consumer's cursor; its next delta
+// counts the events since the start
+func (st *APIStats) RemoveConsumer(consumer string) {
 	st.Lock()
 	defer st.Unlock()
 
-	return copyCumulativeCounts(st.Counts)
+	delete(st.cursors, consumer)
+	delete(st.cursorUse, consumer)
 }
 
 func duration2ms(d time.Duration) int64 {
@@ -326,24 +369,6 @@ func duration2ms(d time.Duration) int64 {
 	return d.Nanoseconds() / nsPerMs
 }
 
-func computePercentiles(elapsedMS []float64) ([]int64, error) {
-	var err error
-
-	targets := []float64{50, 90, 95, 99, 99.9, 99.99}
-	values := make([]int64, pCount)
-
-	for i, target := range targets {
-		var p float64
-		p, err = stats.PercentileNearestRank(elapsedMS, target)
-		if err != nil {
-			return nil, errors.Wrapf(err, "PercentileNearestRank")
-		}
-		values[i] = int64(p)
-	}
-
-	return values, nil
-}
-
 // statusClass returns a string of the form NXX for HTTP Status, e.g. 2XX
 func statusClass(status int) string {
 	return fmt.Sprintf("%dXX", status/100)
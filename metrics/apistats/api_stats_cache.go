@@ -35,15 +35,25 @@ func (ec *APIStatsCache) Size() int {
 
 // Store appends a new entry to the cache, writing over the oldest if necessary
 func (ec *APIStatsCache) Store(entry APIStatsEntry) {
+	ec.Push(entry)
+}
+
+// Push appends a new entry to the cache, writing over the oldest if necessary
+// it returns the entry that was written over, if any
+func (ec *APIStatsCache) Push(entry APIStatsEntry) (APIStatsEntry, bool) {
 	if ec.activeSize < len(ec.cache) {
 		ec.activeSize++
 		ec.end = ec.activeSize - 1
 		ec.cache[ec.end] = entry
-	} else {
-		ec.end = (ec.end + 1) % len(ec.cache)
-		ec.cache[ec.end] = entry
-		ec.start = (ec.end + 1) % len(ec.cache)
+		return APIStatsEntry{}, false
 	}
+
+	ec.end = (ec.end + 1) % len(ec.cache)
+	evicted := ec.cache[ec.end]
+	ec.cache[ec.end] = entry
+	ec.start = (ec.end + 1) % len(ec.cache)
+
+	return evicted, true
 }
 
 // Traverse iterates through the cache, pushing entries into the channel
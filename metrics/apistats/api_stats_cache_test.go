@@ -68,7 +68,7 @@ func TestAPICache(t *testing.T) {
 	}
 	content := getCacheContent(c)
 	if len(content) != 0 {
-		t.Fatalf("getCacheContent: expected 0, found %q", content)
+		t.Fatalf("getCacheContent: expected 0, found %v", content)
 	}
 
 	for i, tc := range testCases {
@@ -79,7 +79,7 @@ func TestAPICache(t *testing.T) {
 			}
 			content = getCacheContent(c)
 			if len(content) != len(tc.expectedContent) {
-				t.Fatalf("getCacheContent: expected %v, found %q",
+				t.Fatalf("getCacheContent: expected %v, found %v",
 					tc.expectedContent, content)
 			}
 			for j, k := range tc.expectedContent {
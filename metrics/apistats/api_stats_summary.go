@@ -51,3 +51,12 @@ type EndpointStatsGetter interface {
 	// GetCumulativeCounts returns cumulative counts of events
 	GetCumulativeCounts() CumulativeCounts
 }
+
+// DeltaCountsGetter provides access to the counts of events since a
+// consumer's previous call
+type DeltaCountsGetter interface {
+
+	// GetCumulativeCountsDelta returns the counts of events since the
+	// previous call with the same consumer
+	GetCumulativeCountsDelta(consumer string) CumulativeCounts
+}
@@ -20,6 +20,8 @@ import (
 	"time"
 
 	"github.com/pkg/errors"
+
+	"github.com/deciphernow/gm-fabric-go/metrics/cardinality"
 )
 
 type probefunc func(APIEndpointStats) error
@@ -351,3 +353,72 @@ func TestAPIStats(t *testing.T) {
 		})
 	}
 }
+
+func TestAPIStatsEviction(t *testing.T) {
+	const size = 10
+	beginTime := time.Now()
+
+	stats := New(size)
+	// fill the cache with slow "aaa" requests, then push them out
+	// with fast "bbb" requests
+	for i := 0; i < size; i++ {
+		stats.Store(APIStatsEntry{
+			Key:         "aaa",
+			PrevRoute:   "route",
+			BeginTime:   beginTime,
+			RequestTime: beginTime.Add(time.Second),
+			Err:         errors.New("failed"),
+		})
+	}
+	for i := 0; i < size; i++ {
+		stats.Store(APIStatsEntry{
+			Key:         "bbb",
+			BeginTime:   beginTime,
+			RequestTime: beginTime.Add(time.Duration(i+1) * time.Millisecond),
+		})
+	}
+
+	result, err := stats.GetEndpointStats()
+	if err != nil {
+		t.Fatalf("stats.GetEndpointStats failed: %s", err)
+	}
+	if _, ok := result["aaa"]; ok {
+		t.Fatalf("evicted key still reported: %v", result["aaa"])
+	}
+
+	for _, key := range []string{"bbb", "all"} {
+		ep := result[key]
+		if ep.Count != size || ep.Sum != 55 || ep.Errors != 0 {
+			t.Fatalf("%s: expected count %d sum 55 errors 0, found %d, %d, %d",
+				key, size, ep.Count, ep.Sum, ep.Errors)
+		}
+		if ep.Min != 1 || ep.Max != 10 || ep.P50 != 5 || ep.P90 != 9 {
+			t.Fatalf("%s: expected min 1 max 10 p50 5 p90 9, found %d, %d, %d, %d",
+				key, ep.Min, ep.Max, ep.P50, ep.P90)
+		}
+		if len(ep.Routes) != 0 {
+			t.Fatalf("%s: expected no routes, found %v", key, ep.Routes)
+		}
+	}
+}
+
+func TestAPIStatsCardinality(t *testing.T) {
+	stats := New(16, CardinalityGuardOption(cardinality.New(2)))
+	for _, key := range []string{"aaa", "bbb", "ccc", "ddd", "aaa"} {
+		stats.Store(APIStatsEntry{Key: key})
+	}
+
+	counts := stats.GetCumulativeCounts()
+	for key, expected := range map[string]int64{
+		"aaa":                2,
+		"bbb":                1,
+		cardinality.OtherKey: 2,
+	} {
+		if n := counts.KeyEvents[key].Events; n != expected {
+			t.Fatalf("%s: expected %d events found %d", key, expected, n)
+		}
+	}
+	if len(counts.KeyEvents) != 3 {
+		t.Fatalf("expected 3 keys found %v", counts.KeyEvents)
+	}
+}
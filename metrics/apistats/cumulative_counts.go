@@ -14,36 +14,134 @@
 
 package apistats
 
-import "github.com/deciphernow/gm-fabric-go/metrics/subject"
+import (
+	"google.golang.org/grpc/codes"
+
+	"github.com/deciphernow/gm-fabric-go/metrics/subject"
+)
 
 type KeyEventsEntry struct {
+	// Key and Method are what the events were stored under, e.g. route/users
+	// and GET for route/users/GET, so that reports need not parse the key
+	Key    string
+	Method string
+
 	Events            int64
 	StatusEvents      map[int]int64
 	StatusClassEvents map[string]int64
+	GRPCCodeEvents    map[codes.Code]int64
+
+	// Client is true for the key of outgoing requests, which are left
+	// out of the totals
+	Client bool
+
+	// Retries is the number of retries of outgoing requests
+	Retries int64
 }
 
 type CumulativeCounts struct {
 	TotalEvents     int64
 	TransportEvents map[subject.EventTransport]int64
+	MethodEvents    map[string]int64
+	GRPCCodeEvents  map[codes.Code]int64
 	KeyEvents       map[string]KeyEventsEntry
 }
 
+func newKeyEventsEntry() KeyEventsEntry {
+	return KeyEventsEntry{
+		StatusEvents:      make(map[int]int64),
+		StatusClassEvents: make(map[string]int64),
+		GRPCCodeEvents:    make(map[codes.Code]int64),
+	}
+}
+
 func newCumulativeCounts() CumulativeCounts {
 	return CumulativeCounts{
 		TransportEvents: make(map[subject.EventTransport]int64),
+		MethodEvents:    make(map[string]int64),
+		GRPCCodeEvents:  make(map[codes.Code]int64),
 		KeyEvents:       make(map[string]KeyEventsEntry),
 	}
 }
 
+func copyKeyEventsEntry(inp KeyEventsEntry) KeyEventsEntry {
+	outp := newKeyEventsEntry()
+	outp.Key = inp.Key
+	outp.Method = inp.Method
+	outp.Events = inp.Events
+	outp.Client = inp.Client
+	outp.Retries = inp.Retries
+	for key, value := range inp.StatusEvents {
+		outp.StatusEvents[key] = value
+	}
+	for key, value := range inp.StatusClassEvents {
+		outp.StatusClassEvents[key] = value
+	}
+	for key, value := range inp.GRPCCodeEvents {
+		outp.GRPCCodeEvents[key] = value
+	}
+
+	return outp
+}
+
 func copyCumulativeCounts(inp CumulativeCounts) CumulativeCounts {
 	outp := newCumulativeCounts()
 	outp.TotalEvents = inp.TotalEvents
 	for key, value := range inp.KeyEvents {
-		outp.KeyEvents[key] = value
+		outp.KeyEvents[key] = copyKeyEventsEntry(value)
 	}
 	for key, value := range inp.TransportEvents {
 		outp.TransportEvents[key] = value
 	}
+	for key, value := range inp.MethodEvents {
+		outp.MethodEvents[key] = value
+	}
+	for key, value := range inp.GRPCCodeEvents {
+		outp.GRPCCodeEvents[key] = value
+	}
+
+	return outp
+}
+
+// addKeyEventsEntry adds sign (1 or -1) times the counts of b to a
+func addKeyEventsEntry(a, b KeyEventsEntry, sign int64) KeyEventsEntry {
+	outp := copyKeyEventsEntry(a)
+	if outp.Key == "" {
+		outp.Key, outp.Method = b.Key, b.Method
+	}
+	outp.Events += sign * b.Events
+	outp.Client = outp.Client || b.Client
+	outp.Retries += sign * b.Retries
+	for key, value := range b.StatusEvents {
+		outp.StatusEvents[key] += sign * value
+	}
+	for key, value := range b.StatusClassEvents {
+		outp.StatusClassEvents[key] += sign * value
+	}
+	for key, value := range b.GRPCCodeEvents {
+		outp.GRPCCodeEvents[key] += sign * value
+	}
+
+	return outp
+}
+
+// addCumulativeCounts adds sign (1 or -1) times the counts of b to a.
+// With sign -1 the result is the counts accumulated in a since b.
+func addCumulativeCounts(a, b CumulativeCounts, sign int64) CumulativeCounts {
+	outp := copyCumulativeCounts(a)
+	outp.TotalEvents += sign * b.TotalEvents
+	for key, value := range b.KeyEvents {
+		outp.KeyEvents[key] = addKeyEventsEntry(outp.KeyEvents[key], value, sign)
+	}
+	for key, value := range b.TransportEvents {
+		outp.TransportEvents[key] += sign * value
+	}
+	for key, value := range b.MethodEvents {
+		outp.MethodEvents[key] += sign * value
+	}
+	for key, value := range b.GRPCCodeEvents {
+		outp.GRPCCodeEvents[key] += sign * value
+	}
 
 	return outp
 }
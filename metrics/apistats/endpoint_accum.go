@@ -0,0 +1,146 @@
+// Copyright 2017 Decipher Technology Studios LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apistats
+
+// percentileTargets are the percentiles reported in APIEndpointStats,
+// indexed by p50 ... p9999
+var percentileTargets = []float64{50, 90, 95, 99, 99.9, 99.99}
+
+type throughputAccum struct {
+	receivedSecs  int64
+	bytesReceived int64
+	sentSecs      int64
+	bytesSent     int64
+}
+
+// endpointAccum accumulates the transactions of an endpoint incrementally,
+// so that its stats can be computed without visiting every transaction.
+// Transactions can be removed as well as added.
+type endpointAccum struct {
+	throughputAccum
+	count   int64
+	sum     int64
+	errors  int32
+	routes  map[string]int64
+	latency latencySketch
+
+	// client is true for the accumulator of an outgoing request key
+	client bool
+}
+
+func newEndpointAccum() *endpointAccum {
+	return &endpointAccum{routes: make(map[string]int64)}
+}
+
+func (a *endpointAccum) add(trans APIStatsEntry) {
+	a.update(trans, 1)
+}
+
+func (a *endpointAccum) remove(trans APIStatsEntry) {
+	a.update(trans, -1)
+}
+
+// update adds n (1 or -1) times the transaction to the accumulator
+func (a *endpointAccum) update(trans APIStatsEntry, n int64) {
+	latency := duration2ms(trans.RequestTime.Sub(trans.BeginTime))
+
+	a.client = trans.Transport.IsClient()
+
+	a.count += n
+	a.sum += n * latency
+	a.latency.addCount(sketchIndex(latency), n)
+
+	if trans.Err != nil {
+		a.errors += int32(n)
+	}
+
+	if trans.PrevRoute != "" {
+		a.routes[trans.PrevRoute] += n
+		if a.routes[trans.PrevRoute] <= 0 {
+			delete(a.routes, trans.PrevRoute)
+		}
+	}
+
+	if (!trans.InCaptureTime.IsZero()) && (!trans.RequestTime.IsZero()) {
+		requestSecs := int64(trans.InCaptureTime.Sub(trans.RequestTime).Seconds())
+		if requestSecs > 0 {
+			a.receivedSecs += n * requestSecs
+			a.bytesReceived += n * trans.InWireLength
+		}
+	}
+
+	if (!trans.OutCaptureTime.IsZero()) && (!trans.ResponseTime.IsZero()) {
+		responseSecs := int64(trans.OutCaptureTime.Sub(trans.ResponseTime).Seconds())
+		if responseSecs > 0 {
+			a.sentSecs += n * responseSecs
+			a.bytesSent += n * trans.OutWireLength
+		}
+	}
+}
+
+func (a *endpointAccum) merge(b *endpointAccum) {
+	a.client = b.client
+	a.count += b.count
+	a.sum += b.sum
+	a.errors += b.errors
+	a.latency.merge(&b.latency)
+	for route, n := range b.routes {
+		a.routes[route] += n
+	}
+
+	a.receivedSecs += b.receivedSecs
+	a.bytesReceived += b.bytesReceived
+	a.sentSecs += b.sentSecs
+	a.bytesSent += b.bytesSent
+}
+
+// endpointStats computes the stats of the accumulated transactions;
+// Min, Max and the percentiles are within 1% of the exact values
+func (a *endpointAccum) endpointStats() APIEndpointStats {
+	stats := APIEndpointStats{
+		Count:  a.count,
+		Sum:    a.sum,
+		Errors: a.errors,
+		Routes: make(map[string]struct{}, len(a.routes)),
+	}
+	for route := range a.routes {
+		stats.Routes[route] = struct{}{}
+	}
+
+	if a.count <= 0 {
+		return stats
+	}
+
+	stats.Avg = float64(a.sum) / float64(a.count)
+	stats.Min = a.latency.min()
+	stats.Max = a.latency.max()
+
+	percentileValues := a.latency.percentiles(percentileTargets)
+	stats.P50 = percentileValues[p50]
+	stats.P90 = percentileValues[p90]
+	stats.P95 = percentileValues[p95]
+	stats.P99 = percentileValues[p99]
+	stats.P9990 = percentileValues[p9990]
+	stats.P9999 = percentileValues[p9999]
+
+	if a.receivedSecs > 0 {
+		stats.InThroughput = a.bytesReceived / a.receivedSecs
+	}
+	if a.sentSecs > 0 {
+		stats.OutThroughput = a.bytesSent / a.sentSecs
+	}
+
+	return stats
+}
@@ -0,0 +1,149 @@
+// Copyright 2017 Decipher Technology Studios LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apistats
+
+import (
+	"math"
+	"math/bits"
+)
+
+// latencySketch is a log-linear histogram of latencies in milliseconds,
+// in the style of an HDR histogram.
+// Values below exactLimit have a bucket each; above that, every power of two
+// is split into subBuckets buckets, so a value is reported within 1/128
+// (0.8%) of its true value. A sketch covering latencies up to a day holds
+// fewer than 2000 counters, regardless of the number of values added.
+//
+// Sketches can be merged, and values can be removed as well as added.
+type latencySketch struct {
+	counts []int64
+	count  int64
+}
+
+const (
+	subBucketBits = 6
+	subBuckets    = 1 << subBucketBits // buckets per power of two
+	exactLimit    = 2 * subBuckets     // values below this are exact
+)
+
+// sketchIndex returns the index of the bucket holding value
+func sketchIndex(value int64) int {
+	if value < 0 {
+		value = 0
+	}
+	if value < exactLimit {
+		return int(value)
+	}
+
+	// value is in [2^k, 2^(k+1)); shift it into [subBuckets, exactLimit)
+	k := bits.Len64(uint64(value)) - 1
+	shift := uint(k - subBucketBits)
+
+	return int(shift)*subBuckets + int(value>>shift)
+}
+
+// sketchBounds returns the lowest value in a bucket and the bucket's width
+func sketchBounds(index int) (int64, int64) {
+	if index < exactLimit {
+		return int64(index), 1
+	}
+
+	shift := uint(index/subBuckets - 1)
+	m := int64(index%subBuckets + subBuckets)
+
+	return m << shift, 1 << shift
+}
+
+// sketchValue returns the value reported for a bucket
+func sketchValue(index int) int64 {
+	lower, width := sketchBounds(index)
+	return lower + (width-1)/2
+}
+
+func (s *latencySketch) add(value int64) {
+	s.addCount(sketchIndex(value), 1)
+}
+
+func (s *latencySketch) remove(value int64) {
+	s.addCount(sketchIndex(value), -1)
+}
+
+func (s *latencySketch) addCount(index int, n int64) {
+	if index >= len(s.counts) {
+		counts := make([]int64, index+1)
+		copy(counts, s.counts)
+		s.counts = counts
+	}
+	s.counts[index] += n
+	s.count += n
+}
+
+func (s *latencySketch) merge(other *latencySketch) {
+	for index := len(other.counts) - 1; index >= 0; index-- {
+		if other.counts[index] != 0 {
+			s.addCount(index, other.counts[index])
+		}
+	}
+}
+
+// min returns the value of the lowest non-empty bucket
+func (s *latencySketch) min() int64 {
+	for index, n := range s.counts {
+		if n > 0 {
+			return sketchValue(index)
+		}
+	}
+	return 0
+}
+
+// max returns the value of the highest non-empty bucket
+func (s *latencySketch) max() int64 {
+	for index := len(s.counts) - 1; index >= 0; index-- {
+		if s.counts[index] > 0 {
+			return sketchValue(index)
+		}
+	}
+	return 0
+}
+
+// percentiles returns the nearest rank value for each of the targets,
+// which must be in ascending order, in a single pass through the buckets
+func (s *latencySketch) percentiles(targets []float64) []int64 {
+	values := make([]int64, len(targets))
+	if s.count <= 0 {
+		return values
+	}
+
+	var cumulative int64
+	t := 0
+	for index, n := range s.counts {
+		if n <= 0 {
+			continue
+		}
+		cumulative += n
+		for ; t < len(targets); t++ {
+			rank := int64(math.Ceil(targets[t] / 100 * float64(s.count)))
+			if rank > cumulative {
+				break
+			}
+			values[t] = sketchValue(index)
+		}
+		if t == len(targets) {
+			break
+		}
+	}
+
+	return values
+}
@@ -0,0 +1,125 @@
+// Copyright 2017 Decipher Technology Studios LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apistats
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+// nearestRank returns the exact nearest rank percentile of sorted values
+func nearestRank(sorted []int64, target float64) int64 {
+	rank := int(math.Ceil(target / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+func checkPercentiles(t *testing.T, s *latencySketch, values []int64) {
+	sorted := append([]int64(nil), values...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	found := s.percentiles(percentileTargets)
+	for i, target := range percentileTargets {
+		expected := nearestRank(sorted, target)
+		if math.Abs(float64(found[i]-expected)) > float64(expected)/128 {
+			t.Fatalf("p%v: expected %d found %d", target, expected, found[i])
+		}
+	}
+}
+
+func TestSketchBuckets(t *testing.T) {
+	// every value must fall inside the bounds of its bucket, and the
+	// reported value must be within 1/128 of it
+	for value := int64(0); value < 1<<20; value++ {
+		index := sketchIndex(value)
+		lower, width := sketchBounds(index)
+		if value < lower || value >= lower+width {
+			t.Fatalf("%d: outside bucket %d [%d, %d)", value, index, lower, lower+width)
+		}
+		reported := sketchValue(index)
+		if math.Abs(float64(reported-value)) > float64(value)/128 {
+			t.Fatalf("%d: reported as %d", value, reported)
+		}
+	}
+
+	// a day in milliseconds
+	if n := sketchIndex(86400000); n > 2000 {
+		t.Fatalf("expected fewer than 2000 buckets for a day, found %d", n)
+	}
+}
+
+func TestSketchPercentiles(t *testing.T) {
+	rng := rand.New(rand.NewSource(42))
+
+	testCases := []struct {
+		name     string
+		generate func() int64
+	}{
+		{"constant", func() int64 { return 42 }},
+		{"uniform", func() int64 { return rng.Int63n(1000) }},
+		{"exponential", func() int64 { return int64(rng.ExpFloat64() * 200) }},
+		{"bimodal", func() int64 {
+			if rng.Intn(100) < 95 {
+				return 5 + rng.Int63n(10)
+			}
+			return 3000 + rng.Int63n(2000)
+		}},
+	}
+
+	for i, tc := range testCases {
+		t.Run(fmt.Sprintf("%d: %s", i, tc.name), func(t *testing.T) {
+			var s latencySketch
+			values := make([]int64, 20000)
+			for j := range values {
+				values[j] = tc.generate()
+				s.add(values[j])
+			}
+			checkPercentiles(t, &s, values)
+		})
+	}
+}
+
+func TestSketchMergeRemove(t *testing.T) {
+	rng := rand.New(rand.NewSource(42))
+
+	var a, b, all latencySketch
+	var values []int64
+	for i := 0; i < 5000; i++ {
+		fast := rng.Int63n(100)
+		slow := 1000 + rng.Int63n(1000)
+		a.add(fast)
+		b.add(slow)
+		values = append(values, fast, slow)
+	}
+	all.merge(&a)
+	all.merge(&b)
+	checkPercentiles(t, &all, values)
+
+	// removing the slow values leaves the fast ones
+	var fastValues []int64
+	for i := 0; i < len(values); i += 2 {
+		fastValues = append(fastValues, values[i])
+		all.remove(values[i+1])
+	}
+	checkPercentiles(t, &all, fastValues)
+	if max := all.max(); max >= 100 {
+		t.Fatalf("max: expected < 100 found %d", max)
+	}
+}
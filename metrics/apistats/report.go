@@ -16,6 +16,7 @@ package apistats
 
 import (
 	"fmt"
+	"strings"
 
 	"github.com/deciphernow/gm-fabric-go/metrics/flatjson"
 	"github.com/deciphernow/gm-fabric-go/metrics/subject"
@@ -24,14 +25,26 @@ import (
 
 // Report implements the Reporter interface it is called by the metrics server
 func (st *APIStats) Report(jWriter *flatjson.Writer) error {
+	return st.report(jWriter, st.GetCumulativeCounts())
+}
+
+// ReportDelta reports like Report, but if consumer is not empty the request
+// counts are the deltas since the consumer's previous report
+// (see GetCumulativeCountsDelta). The latency stats are not affected.
+func (st *APIStats) ReportDelta(jWriter *flatjson.Writer, consumer string) error {
+	if consumer == "" {
+		return st.Report(jWriter)
+	}
+	return st.report(jWriter, st.GetCumulativeCountsDelta(consumer))
+}
+
+func (st *APIStats) report(jWriter *flatjson.Writer, counts CumulativeCounts) error {
 	var err error
 	var summary APIStatsSummary
-	var counts CumulativeCounts
 
 	if summary.APIStats, err = st.GetEndpointStats(); err != nil {
 		return errors.Wrap(err, "st.GetEndpointStats()")
 	}
-	counts = st.GetCumulativeCounts()
 
 	err = jWriter.Write(fmt.Sprintf("%s/%s", "Total", "requests"), counts.TotalEvents)
 	if err != nil {
@@ -42,6 +55,20 @@ func (st *APIStats) Report(jWriter *flatjson.Writer) error {
 		return errors.Wrap(err, "writeTransport")
 	}
 
+	for method, events := range counts.MethodEvents {
+		err = jWriter.WriteMetric(
+			fmt.Sprintf("all/%s/requests", method),
+			flatjson.Metric{
+				Name:   "all_requests",
+				Labels: []flatjson.Label{{Name: "method", Value: method}},
+			},
+			events,
+		)
+		if err != nil {
+			return errors.Wrapf(err, "jWriter.Write %s requests", method)
+		}
+	}
+
 	allEvents := accumulateAllEvents(summary, counts)
 
 	for path, value := range summary.APIStats {
@@ -51,12 +78,28 @@ func (st *APIStats) Report(jWriter *flatjson.Writer) error {
 		} else {
 			keyEvents = counts.KeyEvents[path]
 		}
-		err = writeValue(path, value, keyEvents, jWriter)
+		em := newEndpointMetric(path, keyEvents)
+		err = writeValue(path, em, value, keyEvents, jWriter)
 		if err != nil {
 			return errors.Wrap(err, "writeValue")
 		}
 	}
 
+	windowStats, err := st.GetWindowStats()
+	if err != nil {
+		return errors.Wrap(err, "st.GetWindowStats()")
+	}
+
+	for _, ws := range windowStats {
+		for path, value := range ws.Endpoints {
+			em := newEndpointMetric(path, counts.KeyEvents[path])
+			err = writeWindowValue(path, em, windowLabel(ws.Window), value, jWriter)
+			if err != nil {
+				return errors.Wrap(err, "writeWindowValue")
+			}
+		}
+	}
+
 	return nil
 }
 
@@ -65,10 +108,12 @@ func (st *APIStats) writeTransport(
 	counts CumulativeCounts,
 ) error {
 	transportLabels := map[subject.EventTransport]string{
-		subject.EventTransportHTTP:       "HTTP",
-		subject.EventTransportHTTPS:      "HTTPS",
-		subject.EventTransportRPC:        "RPC",
-		subject.EventTransportRPCWithTLS: "RPC_TLS",
+		subject.EventTransportHTTP:        "HTTP",
+		subject.EventTransportHTTPS:       "HTTPS",
+		subject.EventTransportRPC:         "RPC",
+		subject.EventTransportRPCWithTLS:  "RPC_TLS",
+		subject.EventTransportHTTPClient:  "HTTP_CLIENT",
+		subject.EventTransportHTTPSClient: "HTTPS_CLIENT",
 	}
 
 	for _, transport := range []subject.EventTransport{
@@ -76,6 +121,8 @@ func (st *APIStats) writeTransport(
 		subject.EventTransportHTTPS,
 		subject.EventTransportRPC,
 		subject.EventTransportRPCWithTLS,
+		subject.EventTransportHTTPClient,
+		subject.EventTransportHTTPSClient,
 	} {
 		err := jWriter.Write(
 			fmt.Sprintf("%s/%s", transportLabels[transport], "requests"),
@@ -90,18 +137,65 @@ func (st *APIStats) writeTransport(
 	return nil
 }
 
+// endpointMetric holds the metric name prefix and labels of the stats of
+// an endpoint, taken from the key and method it was stored under, e.g.
+// route_ and {route="/users",method="GET"} for route/users/GET
+type endpointMetric struct {
+	prefix string
+	labels []flatjson.Label
+}
+
+// newEndpointMetric returns the endpointMetric of path. A path whose key
+// is not known, such as one restored from a snapshot taken before keys
+// were counted with their method, has none.
+func newEndpointMetric(path string, keyEvents KeyEventsEntry) endpointMetric {
+	if path == "all" {
+		return endpointMetric{prefix: "all"}
+	}
+	if keyEvents.Key == "" {
+		return endpointMetric{}
+	}
+
+	kind, name := "endpoint", keyEvents.Key
+	if i := strings.Index(keyEvents.Key, "/"); i > 0 {
+		switch keyEvents.Key[:i] {
+		case "route":
+			kind, name = "route", keyEvents.Key[i:]
+		case "function", "client":
+			kind, name = keyEvents.Key[:i], keyEvents.Key[i+1:]
+		}
+	}
+
+	labels := []flatjson.Label{{Name: kind, Value: name}}
+	if keyEvents.Method != "" {
+		labels = append(labels, flatjson.Label{Name: "method", Value: keyEvents.Method})
+	}
+
+	return endpointMetric{prefix: kind, labels: labels}
+}
+
+// metric returns the Metric of stat, e.g. latency_ms.p99, with the
+// labels of the endpoint followed by labels
+func (em endpointMetric) metric(stat string, labels ...flatjson.Label) flatjson.Metric {
+	if em.prefix == "" {
+		return flatjson.Metric{}
+	}
+
+	all := make([]flatjson.Label, 0, len(em.labels)+len(labels))
+	return flatjson.Metric{
+		Name:   em.prefix + "_" + strings.Replace(stat, ".", "_", -1),
+		Labels: append(append(all, em.labels...), labels...),
+	}
+}
+
 func accumulateAllEvents(
 	summary APIStatsSummary,
 	counts CumulativeCounts,
 ) KeyEventsEntry {
-	allEvents := KeyEventsEntry{
-		StatusEvents:      make(map[int]int64),
-		StatusClassEvents: make(map[string]int64),
-	}
+	allEvents := newKeyEventsEntry()
 
 	for path := range summary.APIStats {
-		if path != "all" {
-			keyEvents := counts.KeyEvents[path]
+		if keyEvents := counts.KeyEvents[path]; path != "all" && !keyEvents.Client {
 			allEvents.Events += keyEvents.Events
 			for key, value := range keyEvents.StatusEvents {
 				allEvents.StatusEvents[key] += value
@@ -109,6 +203,9 @@ func accumulateAllEvents(
 			for key, value := range keyEvents.StatusClassEvents {
 				allEvents.StatusClassEvents[key] += value
 			}
+			for key, value := range keyEvents.GRPCCodeEvents {
+				allEvents.GRPCCodeEvents[key] += value
+			}
 		}
 	}
 
@@ -117,15 +214,31 @@ func accumulateAllEvents(
 
 func writeValue(
 	path string,
+	em endpointMetric,
 	value APIEndpointStats,
 	keyEvents KeyEventsEntry,
 	jWriter *flatjson.Writer,
 ) error {
-	err := jWriter.Write(fmt.Sprintf("%s/%s", path, "requests"), keyEvents.Events)
+	err := jWriter.WriteMetric(
+		fmt.Sprintf("%s/%s", path, "requests"),
+		em.metric("requests"),
+		keyEvents.Events,
+	)
 	if err != nil {
 		return errors.Wrapf(err, "jWriter.Write %s requests", path)
 	}
 
+	if keyEvents.Client {
+		err = jWriter.WriteMetric(
+			fmt.Sprintf("%s/%s", path, "retries"),
+			em.metric("retries"),
+			keyEvents.Retries,
+		)
+		if err != nil {
+			return errors.Wrapf(err, "jWriter.Write %s retries", path)
+		}
+	}
+
 	var routes string
 	for route := range value.Routes {
 		if len(routes) == 0 {
@@ -134,25 +247,44 @@ func writeValue(
 			routes = fmt.Sprintf("%s:%s", routes, route)
 		}
 	}
-	err = jWriter.Write(fmt.Sprintf("%s/%s", path, "routes"), routes)
+	err = jWriter.WriteMetric(fmt.Sprintf("%s/%s", path, "routes"), em.metric("routes"), routes)
 	if err != nil {
 		return errors.Wrapf(err, "jWriter.Write %s routes", path)
 	}
 
 	for stat, statValue := range keyEvents.StatusEvents {
-		err = jWriter.Write(fmt.Sprintf("%s/status/%d", path, stat), statValue)
+		err = jWriter.WriteMetric(
+			fmt.Sprintf("%s/status/%d", path, stat),
+			em.metric("status", flatjson.Label{Name: "status", Value: fmt.Sprint(stat)}),
+			statValue,
+		)
 		if err != nil {
 			return errors.Wrapf(err, "jWriter.Write %s status", path)
 		}
 	}
 
 	for statClass, statClassValue := range keyEvents.StatusClassEvents {
-		err = jWriter.Write(fmt.Sprintf("%s/status/%s", path, statClass), statClassValue)
+		err = jWriter.WriteMetric(
+			fmt.Sprintf("%s/status/%s", path, statClass),
+			em.metric("status", flatjson.Label{Name: "status", Value: statClass}),
+			statClassValue,
+		)
 		if err != nil {
 			return errors.Wrapf(err, "jWriter.Write %s statClass", path)
 		}
 	}
 
+	for code, codeValue := range keyEvents.GRPCCodeEvents {
+		err = jWriter.WriteMetric(
+			fmt.Sprintf("%s/grpc_status/%s", path, code),
+			em.metric("grpc_status", flatjson.Label{Name: "grpc_status", Value: code.String()}),
+			codeValue,
+		)
+		if err != nil {
+			return errors.Wrapf(err, "jWriter.Write %s grpc_status", path)
+		}
+	}
+
 	for _, x := range []struct {
 		label string
 		val   interface{}
@@ -172,7 +304,7 @@ func writeValue(
 		{"in_throughput", value.InThroughput},
 		{"out_throughput", value.OutThroughput},
 	} {
-		err = jWriter.Write(fmt.Sprintf("%s/%s", path, x.label), x.val)
+		err = jWriter.WriteMetric(fmt.Sprintf("%s/%s", path, x.label), em.metric(x.label), x.val)
 		if err != nil {
 			return errors.Wrapf(err, "jWriter.Write %s/%s", path, x.label)
 		}
@@ -180,3 +312,45 @@ func writeValue(
 
 	return nil
 }
+
+// writeWindowValue writes the stats for a time window under keys qualified
+// by the window, e.g. all/5m/latency_ms.p99
+func writeWindowValue(
+	path string,
+	em endpointMetric,
+	window string,
+	value WindowEndpointStats,
+	jWriter *flatjson.Writer,
+) error {
+	for _, x := range []struct {
+		label string
+		val   interface{}
+	}{
+		{"requests", value.Count},
+		{"requests_per_sec", value.RequestRate},
+		{"latency_ms.avg", value.Avg},
+		{"latency_ms.max", value.Max},
+		{"latency_ms.min", value.Min},
+		{"latency_ms.p50", value.P50},
+		{"latency_ms.p90", value.P90},
+		{"latency_ms.p95", value.P95},
+		{"latency_ms.p99", value.P99},
+		{"latency_ms.p9990", value.P9990},
+		{"latency_ms.p9999", value.P9999},
+		{"errors.count", value.Errors},
+		{"errors.rate", value.ErrorRate},
+		{"in_throughput", value.InThroughput},
+		{"out_throughput", value.OutThroughput},
+	} {
+		err := jWriter.WriteMetric(
+			fmt.Sprintf("%s/%s/%s", path, window, x.label),
+			em.metric(x.label, flatjson.Label{Name: "window", Value: window}),
+			x.val,
+		)
+		if err != nil {
+			return errors.Wrapf(err, "jWriter.Write %s/%s/%s", path, window, x.label)
+		}
+	}
+
+	return nil
+}
@@ -17,10 +17,14 @@ package apistats
 import (
 	"bytes"
 	"encoding/json"
+	"fmt"
 	"testing"
 	"time"
 
+	"google.golang.org/grpc/codes"
+
 	"github.com/deciphernow/gm-fabric-go/metrics/flatjson"
+	"github.com/deciphernow/gm-fabric-go/metrics/subject"
 )
 
 func TestReport(t *testing.T) {
@@ -56,3 +60,257 @@ func TestReport(t *testing.T) {
 	t.Logf("ts = %q", ts)
 
 }
+
+func TestReportMethodAndGRPCStatus(t *testing.T) {
+	stats := New(16)
+	for _, entry := range []APIStatsEntry{
+		{Key: "route/users", Method: "GET", Transport: subject.EventTransportHTTP, HTTPStatus: 200},
+		{Key: "route/users", Method: "GET", Transport: subject.EventTransportHTTP, HTTPStatus: 404},
+		{Key: "route/users", Method: "DELETE", Transport: subject.EventTransportHTTP, HTTPStatus: 204},
+		{Key: "function/Hello", Transport: subject.EventTransportRPC, GRPCCode: codes.OK},
+		{Key: "function/Hello", Transport: subject.EventTransportRPC, GRPCCode: codes.NotFound},
+		{Key: "function/Hello", Transport: subject.EventTransportRPC, GRPCCode: codes.NotFound},
+	} {
+		stats.Store(entry)
+	}
+
+	var buffer bytes.Buffer
+	w, err := flatjson.New(&buffer)
+	if err != nil {
+		t.Fatalf("New failed: %s", err)
+	}
+	if err = stats.Report(w); err != nil {
+		t.Fatalf("stats.Report failed: %s", err)
+	}
+	if err = w.Flush(); err != nil {
+		t.Fatalf("w.Flush() failed: %s", err)
+	}
+
+	var ts map[string]interface{}
+	data := buffer.Bytes()
+	if err = json.Unmarshal(data, &ts); err != nil {
+		t.Fatalf("json.Unmarshal failed: %s; %s", err, string(data))
+	}
+
+	for key, expected := range map[string]float64{
+		"route/users/GET/requests":            2,
+		"route/users/GET/status/404":          1,
+		"route/users/GET/status/2XX":          1,
+		"route/users/GET/latency_ms.count":    2,
+		"route/users/DELETE/requests":         1,
+		"route/users/DELETE/status/204":       1,
+		"function/Hello/requests":             3,
+		"function/Hello/grpc_status/OK":       1,
+		"function/Hello/grpc_status/NotFound": 2,
+		"all/grpc_status/NotFound":            2,
+		"all/GET/requests":                    2,
+		"all/DELETE/requests":                 1,
+	} {
+		if ts[key] != expected {
+			t.Fatalf("%s: expected %v found %v", key, expected, ts[key])
+		}
+	}
+}
+
+func TestReportClient(t *testing.T) {
+	stats := New(16)
+	for _, entry := range []APIStatsEntry{
+		{Key: "route/users", Method: "GET", Transport: subject.EventTransportHTTP, HTTPStatus: 200},
+		{Key: "client/catalog/items", Method: "GET", Transport: subject.EventTransportHTTPClient, HTTPStatus: 200, Retries: 2},
+		{Key: "client/catalog/items", Method: "GET", Transport: subject.EventTransportHTTPClient, HTTPStatus: 503},
+	} {
+		stats.Store(entry)
+	}
+
+	var buffer bytes.Buffer
+	w, err := flatjson.New(&buffer)
+	if err != nil {
+		t.Fatalf("New failed: %s", err)
+	}
+	if err = stats.Report(w); err != nil {
+		t.Fatalf("stats.Report failed: %s", err)
+	}
+	if err = w.Flush(); err != nil {
+		t.Fatalf("w.Flush() failed: %s", err)
+	}
+
+	var ts map[string]interface{}
+	data := buffer.Bytes()
+	if err = json.Unmarshal(data, &ts); err != nil {
+		t.Fatalf("json.Unmarshal failed: %s; %s", err, string(data))
+	}
+
+	// outgoing requests are not requests received by the service
+	for key, expected := range map[string]float64{
+		"client/catalog/items/GET/requests":   2,
+		"client/catalog/items/GET/retries":    2,
+		"client/catalog/items/GET/status/503": 1,
+		"all/requests":                        1,
+		"all/GET/requests":                    1,
+	} {
+		if ts[key] != expected {
+			t.Fatalf("%s: expected %v found %v", key, expected, ts[key])
+		}
+	}
+}
+
+func TestReportDelta(t *testing.T) {
+	stats := New(16)
+	store := func(n int) {
+		for i := 0; i < n; i++ {
+			stats.Store(APIStatsEntry{Key: "route/users", Method: "GET",
+				Transport: subject.EventTransportHTTP, HTTPStatus: 200})
+		}
+	}
+	report := func(consumer string) map[string]interface{} {
+		var buffer bytes.Buffer
+		w, err := flatjson.New(&buffer)
+		if err != nil {
+			t.Fatalf("New failed: %s", err)
+		}
+		if err = stats.ReportDelta(w, consumer); err != nil {
+			t.Fatalf("stats.ReportDelta failed: %s", err)
+		}
+		if err = w.Flush(); err != nil {
+			t.Fatalf("w.Flush() failed: %s", err)
+		}
+		var ts map[string]interface{}
+		if err = json.Unmarshal(buffer.Bytes(), &ts); err != nil {
+			t.Fatalf("json.Unmarshal failed: %s; %s", err, buffer.String())
+		}
+		return ts
+	}
+
+	testCases := []struct {
+		store    int
+		consumer string
+		expected float64
+	}{
+		{store: 3, consumer: "dashboard", expected: 3},
+		{store: 2, consumer: "dashboard", expected: 2},
+		{store: 0, consumer: "dashboard", expected: 0},
+		// a second consumer is not reset by the first
+		{store: 1, consumer: "cloudwatch", expected: 6},
+		{store: 0, consumer: "dashboard", expected: 1},
+		// no consumer reports cumulative counts
+		{store: 0, consumer: "", expected: 6},
+	}
+
+	var stored int
+	for i, tc := range testCases {
+		store(tc.store)
+		stored += tc.store
+		ts := report(tc.consumer)
+		for _, key := range []string{
+			"Total/requests",
+			"HTTP/requests",
+			"all/GET/requests",
+			"all/requests",
+			"route/users/GET/requests",
+			"route/users/GET/status/200",
+			"route/users/GET/status/2XX",
+		} {
+			if ts[key] != tc.expected {
+				t.Fatalf("#%d %s: expected %v found %v", i, key, tc.expected, ts[key])
+			}
+		}
+		// latency stats are not deltas
+		if ts["route/users/GET/latency_ms.count"] != float64(stored) {
+			t.Fatalf("#%d latency_ms.count: expected %d found %v",
+				i, stored, ts["route/users/GET/latency_ms.count"])
+		}
+	}
+}
+
+func TestResetCumulativeCounts(t *testing.T) {
+	stats := New(16)
+	stats.Store(APIStatsEntry{Key: "xxx"})
+	stats.ResetCumulativeCounts("c")
+	stats.Store(APIStatsEntry{Key: "xxx"})
+
+	if delta := stats.GetCumulativeCountsDelta("c"); delta.TotalEvents != 1 {
+		t.Fatalf("after reset: expected 1 found %d", delta.TotalEvents)
+	}
+
+	stats.RemoveConsumer("c")
+	if delta := stats.GetCumulativeCountsDelta("c"); delta.TotalEvents != 2 {
+		t.Fatalf("after remove: expected 2 found %d", delta.TotalEvents)
+	}
+	if counts := stats.GetCumulativeCounts(); counts.KeyEvents["xxx"].Events != 2 {
+		t.Fatalf("cumulative: expected 2 found %d", counts.KeyEvents["xxx"].Events)
+	}
+}
+
+func TestMaxConsumers(t *testing.T) {
+	stats := New(16, MaxConsumersOption(2))
+	stats.Store(APIStatsEntry{Key: "xxx"})
+	stats.GetCumulativeCountsDelta("a")
+	stats.GetCumulativeCountsDelta("b")
+	stats.GetCumulativeCountsDelta("a")
+
+	// "b" is the least recently used consumer, its cursor makes room for "c"
+	stats.GetCumulativeCountsDelta("c")
+	if n := len(stats.cursors); n != 2 {
+		t.Fatalf("expected 2 cursors found %d", n)
+	}
+
+	stats.Store(APIStatsEntry{Key: "xxx"})
+	for _, tc := range []struct {
+		consumer string
+		expected int64
+	}{
+		{"a", 1},
+		{"c", 1},
+		{"b", 2}, // from the start
+	} {
+		if delta := stats.GetCumulativeCountsDelta(tc.consumer); delta.TotalEvents != tc.expected {
+			t.Fatalf("%s: expected %d found %d", tc.consumer, tc.expected, delta.TotalEvents)
+		}
+	}
+}
+
+func TestReportMetrics(t *testing.T) {
+	stats := New(16, WindowsOption(time.Minute))
+	for _, entry := range []APIStatsEntry{
+		{Key: "route/users/GET", Transport: subject.EventTransportHTTP, HTTPStatus: 200},
+		{Key: "client/catalog/items", Method: "GET", Transport: subject.EventTransportHTTPClient, HTTPStatus: 200},
+		{Key: "xxx", Transport: subject.EventTransportHTTP, HTTPStatus: 200},
+	} {
+		stats.Store(entry)
+	}
+
+	metrics := make(map[string]string)
+	w := flatjson.NewMetricVisitor(func(key string, metric flatjson.Metric, value interface{}) error {
+		found := metric.Name
+		for _, label := range metric.Labels {
+			found += fmt.Sprintf(" %s=%s", label.Name, label.Value)
+		}
+		metrics[key] = found
+		return nil
+	})
+	// the deltas keep the keys and methods of the counts
+	if err := stats.ReportDelta(w, "c"); err != nil {
+		t.Fatalf("stats.ReportDelta failed: %s", err)
+	}
+
+	for key, expected := range map[string]string{
+		// without a method, GET is part of the route
+		"route/users/GET/requests":                "route_requests route=/users/GET",
+		"route/users/GET/status/2XX":              "route_status route=/users/GET status=2XX",
+		"route/users/GET/1m/latency_ms.p99":       "route_latency_ms_p99 route=/users/GET window=1m",
+		"client/catalog/items/GET/retries":        "client_retries client=catalog/items method=GET",
+		"client/catalog/items/GET/1m/errors.rate": "client_errors_rate client=catalog/items method=GET window=1m",
+		"xxx/requests":                            "endpoint_requests endpoint=xxx",
+		"all/requests":                            "all_requests",
+		"all/1m/requests_per_sec":                 "all_requests_per_sec window=1m",
+		"Total/requests":                          "",
+	} {
+		found, ok := metrics[key]
+		if !ok {
+			t.Fatalf("%s: not reported", key)
+		}
+		if found != expected {
+			t.Fatalf("%s: expected %q found %q", key, expected, found)
+		}
+	}
+}
@@ -0,0 +1,201 @@
+// Copyright 2017 Decipher Technology Studios LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apistats
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// SnapshotVersion is the version of the snapshot format written by
+// WriteSnapshot. Snapshots of other versions are not restored.
+const SnapshotVersion = 1
+
+var (
+	// ErrSnapshotCorrupt is returned when a snapshot fails its checksum
+	// or cannot be decoded
+	ErrSnapshotCorrupt = errors.New("apistats snapshot corrupt")
+
+	// ErrSnapshotVersion is returned when a snapshot has an unknown version
+	ErrSnapshotVersion = errors.New("apistats snapshot version not supported")
+
+	// ErrSnapshotExpired is returned when a snapshot is older than the
+	// maximum age
+	ErrSnapshotExpired = errors.New("apistats snapshot expired")
+)
+
+// snapshotFile is the envelope of a snapshot; Checksum is the hex
+// SHA-256 of Payload
+type snapshotFile struct {
+	Version  int             `json:"version"`
+	Checksum string          `json:"checksum"`
+	Payload  json.RawMessage `json:"payload"`
+}
+
+type snapshotPayload struct {
+	Taken   time.Time        `json:"taken"`
+	Counts  CumulativeCounts `json:"counts"`
+	Entries []snapshotEntry  `json:"entries"`
+}
+
+// snapshotEntry replaces the Err of an entry with its message
+type snapshotEntry struct {
+	APIStatsEntry
+	Err string `json:",omitempty"`
+}
+
+// WriteSnapshot writes the cumulative counts and the cached entries.
+// Time window stats are not included.
+func (st *APIStats) WriteSnapshot(w io.Writer) error {
+	st.Lock()
+	payload := snapshotPayload{
+		Taken:   st.now(),
+		Counts:  copyCumulativeCounts(st.Counts),
+		Entries: make([]snapshotEntry, 0, st.Cache.Size()),
+	}
+	for entry := range st.Cache.Traverse() {
+		se := snapshotEntry{APIStatsEntry: entry}
+		if entry.Err != nil {
+			se.Err = entry.Err.Error()
+		}
+		payload.Entries = append(payload.Entries, se)
+	}
+	st.Unlock()
+
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return errors.Wrap(err, "json.Marshal payload")
+	}
+	checksum := sha256.Sum256(data)
+
+	err = json.NewEncoder(w).Encode(snapshotFile{
+		Version:  SnapshotVersion,
+		Checksum: hex.EncodeToString(checksum[:]),
+		Payload:  data,
+	})
+	if err != nil {
+		return errors.Wrap(err, "Encode")
+	}
+
+	return nil
+}
+
+// ReadSnapshot restores a snapshot written by WriteSnapshot.
+// The restored counts are added to the current counts, and the restored
+// entries are placed in the cache before the current entries; the restored
+// counts are not reported in deltas.
+// If maxAge is not zero, a snapshot older than maxAge is discarded with
+// ErrSnapshotExpired.
+func (st *APIStats) ReadSnapshot(r io.Reader, maxAge time.Duration) error {
+	var file snapshotFile
+	if err := json.NewDecoder(r).Decode(&file); err != nil {
+		return errors.Wrapf(ErrSnapshotCorrupt, "Decode: %s", err)
+	}
+	if file.Version != SnapshotVersion {
+		return errors.Wrapf(ErrSnapshotVersion, "version %d", file.Version)
+	}
+	checksum := sha256.Sum256(file.Payload)
+	if hex.EncodeToString(checksum[:]) != file.Checksum {
+		return errors.Wrap(ErrSnapshotCorrupt, "checksum mismatch")
+	}
+
+	var payload snapshotPayload
+	if err := json.Unmarshal(file.Payload, &payload); err != nil {
+		return errors.Wrapf(ErrSnapshotCorrupt, "Unmarshal payload: %s", err)
+	}
+
+	st.Lock()
+	defer st.Unlock()
+
+	if age := st.now().Sub(payload.Taken); maxAge > 0 && age > maxAge {
+		return errors.Wrapf(ErrSnapshotExpired, "age %s", age)
+	}
+
+	restored := addCumulativeCounts(newCumulativeCounts(), payload.Counts, 1)
+	st.Counts = addCumulativeCounts(st.Counts, restored, 1)
+	st.origin = addCumulativeCounts(st.origin, restored, 1)
+	for consumer, cursor := range st.cursors {
+		st.cursors[consumer] = addCumulativeCounts(cursor, restored, 1)
+	}
+
+	var current []APIStatsEntry
+	for entry := range st.Cache.Traverse() {
+		current = append(current, entry)
+	}
+	st.Cache = NewAPIStatsCache(len(st.Cache.cache))
+	st.endpoints = make(map[string]*endpointAccum)
+	for _, se := range payload.Entries {
+		entry := se.APIStatsEntry
+		if se.Err != "" {
+			entry.Err = errors.New(se.Err)
+		}
+		st.cacheEntry(entry)
+	}
+	for _, entry := range current {
+		st.cacheEntry(entry)
+	}
+
+	return nil
+}
+
+// SaveSnapshot writes a snapshot to path. The file is replaced atomically,
+// so a crash while saving leaves the previous snapshot intact.
+func (st *APIStats) SaveSnapshot(path string) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return errors.Wrap(err, "ioutil.TempFile")
+	}
+	defer os.Remove(tmp.Name())
+
+	if err = st.WriteSnapshot(tmp); err != nil {
+		tmp.Close()
+		return errors.Wrap(err, "WriteSnapshot")
+	}
+	if err = tmp.Close(); err != nil {
+		return errors.Wrap(err, "Close")
+	}
+	if err = os.Rename(tmp.Name(), path); err != nil {
+		return errors.Wrapf(err, "os.Rename %s", path)
+	}
+
+	return nil
+}
+
+// RestoreSnapshot restores the snapshot saved at path, see ReadSnapshot.
+// A missing file is not an error.
+func (st *APIStats) RestoreSnapshot(path string, maxAge time.Duration) error {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return errors.Wrapf(err, "os.Open %s", path)
+	}
+	defer f.Close()
+
+	if err = st.ReadSnapshot(f, maxAge); err != nil {
+		return errors.Wrapf(err, "ReadSnapshot %s", path)
+	}
+
+	return nil
+}
@@ -0,0 +1,185 @@
+// Copyright 2017 Decipher Technology Studios LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apistats
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"google.golang.org/grpc/codes"
+
+	"github.com/deciphernow/gm-fabric-go/metrics/subject"
+)
+
+func snapshotTestStats(now *time.Time) *APIStats {
+	st := newTestStats(now)
+	for _, entry := range []APIStatsEntry{
+		latencyEntry("route/users", 10*time.Millisecond, nil),
+		latencyEntry("route/users", 20*time.Millisecond, errors.New("failed")),
+		latencyEntry("function/Hello", 30*time.Millisecond, nil),
+	} {
+		entry.Method = "GET"
+		entry.Transport = subject.EventTransportHTTP
+		entry.HTTPStatus = 200
+		if entry.Key == "function/Hello" {
+			entry.Method = ""
+			entry.Transport = subject.EventTransportRPC
+			entry.GRPCCode = codes.NotFound
+		}
+		st.Store(entry)
+	}
+	return st
+}
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	now := time.Date(2018, 11, 13, 12, 0, 0, 0, time.UTC)
+	original := snapshotTestStats(&now)
+
+	var buffer bytes.Buffer
+	if err := original.WriteSnapshot(&buffer); err != nil {
+		t.Fatalf("WriteSnapshot failed: %s", err)
+	}
+
+	now = now.Add(time.Minute)
+	restored := newTestStats(&now)
+	restored.Store(latencyEntry("function/Hello", 40*time.Millisecond, nil))
+	if err := restored.ReadSnapshot(&buffer, time.Hour); err != nil {
+		t.Fatalf("ReadSnapshot failed: %s", err)
+	}
+
+	counts := restored.GetCumulativeCounts()
+	if counts.TotalEvents != 4 {
+		t.Fatalf("TotalEvents: expected 4 found %d", counts.TotalEvents)
+	}
+	if n := counts.KeyEvents["route/users/GET"].StatusEvents[200]; n != 2 {
+		t.Fatalf("route/users/GET status 200: expected 2 found %d", n)
+	}
+	if n := counts.GRPCCodeEvents[codes.NotFound]; n != 1 {
+		t.Fatalf("NotFound: expected 1 found %d", n)
+	}
+
+	stats, err := restored.GetEndpointStats()
+	if err != nil {
+		t.Fatalf("GetEndpointStats failed: %s", err)
+	}
+	users := stats["route/users/GET"]
+	if users.Count != 2 || users.Errors != 1 || users.Max != 20 {
+		t.Fatalf("route/users/GET: expected count 2, errors 1, max 20; found %+v", users)
+	}
+	if hello := stats["function/Hello"]; hello.Count != 2 {
+		t.Fatalf("function/Hello: expected count 2 found %d", hello.Count)
+	}
+
+	// the restored entries are older than the current ones
+	var keys []string
+	for entry := range restored.Cache.Traverse() {
+		keys = append(keys, entry.Key)
+	}
+	if keys[len(keys)-1] != "function/Hello" || keys[0] != "route/users/GET" {
+		t.Fatalf("unexpected cache order %v", keys)
+	}
+
+	// restored counts are not reported as deltas
+	if delta := restored.GetCumulativeCountsDelta("c"); delta.TotalEvents != 1 {
+		t.Fatalf("delta: expected 1 found %d", delta.TotalEvents)
+	}
+}
+
+func TestSnapshotRejected(t *testing.T) {
+	now := time.Date(2018, 11, 13, 12, 0, 0, 0, time.UTC)
+	var buffer bytes.Buffer
+	if err := snapshotTestStats(&now).WriteSnapshot(&buffer); err != nil {
+		t.Fatalf("WriteSnapshot failed: %s", err)
+	}
+	snapshot := buffer.String()
+
+	testCases := []struct {
+		name     string
+		data     string
+		age      time.Duration
+		expected error
+	}{
+		{"valid", snapshot, time.Hour, nil},
+		{"expired", snapshot, 2 * time.Hour, ErrSnapshotExpired},
+		{"truncated", snapshot[:len(snapshot)/2], time.Hour, ErrSnapshotCorrupt},
+		{
+			"tampered",
+			string(bytes.Replace([]byte(snapshot), []byte(`"TotalEvents":3`), []byte(`"TotalEvents":9`), 1)),
+			time.Hour,
+			ErrSnapshotCorrupt,
+		},
+		{
+			"version",
+			string(bytes.Replace([]byte(snapshot), []byte(`"version":1`), []byte(`"version":99`), 1)),
+			time.Hour,
+			ErrSnapshotVersion,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			readTime := now.Add(tc.age)
+			st := newTestStats(&readTime)
+			err := st.ReadSnapshot(bytes.NewBufferString(tc.data), 90*time.Minute)
+			if errors.Cause(err) != tc.expected {
+				t.Fatalf("expected %v found %v", tc.expected, err)
+			}
+			if tc.expected != nil && st.GetCumulativeCounts().TotalEvents != 0 {
+				t.Fatalf("rejected snapshot was restored")
+			}
+		})
+	}
+}
+
+func TestSnapshotter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "apistats")
+	if err != nil {
+		t.Fatalf("ioutil.TempDir failed: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "snapshot.json")
+
+	now := time.Now()
+	restored := newTestStats(&now)
+	if err = NewSnapshotter(restored, path).Restore(); err != nil {
+		t.Fatalf("Restore without a snapshot failed: %s", err)
+	}
+
+	s := NewSnapshotter(snapshotTestStats(&now), path, SnapshotIntervalOption(time.Millisecond))
+	go s.Run()
+	if err = s.Close(); err != nil {
+		t.Fatalf("Close failed: %s", err)
+	}
+
+	if err = NewSnapshotter(restored, path).Restore(); err != nil {
+		t.Fatalf("Restore failed: %s", err)
+	}
+	if n := restored.GetCumulativeCounts().TotalEvents; n != 3 {
+		t.Fatalf("TotalEvents: expected 3 found %d", n)
+	}
+
+	if err = ioutil.WriteFile(path, []byte("garbage"), 0644); err != nil {
+		t.Fatalf("ioutil.WriteFile failed: %s", err)
+	}
+	err = NewSnapshotter(New(16), path).Restore()
+	if errors.Cause(err) != ErrSnapshotCorrupt {
+		t.Fatalf("expected ErrSnapshotCorrupt found %v", err)
+	}
+}
@@ -0,0 +1,112 @@
+// Copyright 2017 Decipher Technology Studios LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apistats
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// DefaultSnapshotInterval is the default interval between snapshots
+const DefaultSnapshotInterval = time.Minute
+
+// DefaultSnapshotMaxAge is the default age beyond which a snapshot
+// is not restored
+const DefaultSnapshotMaxAge = 24 * time.Hour
+
+// Snapshotter saves snapshots of APIStats to a file periodically,
+// so that the counts and cached entries survive a restart
+type Snapshotter struct {
+	stats    *APIStats
+	path     string
+	interval time.Duration
+	maxAge   time.Duration
+	stopCtx  context.Context
+	stop     context.CancelFunc
+}
+
+// SnapshotIntervalOption returns a Snapshotter option function that sets
+// the interval between snapshots
+func SnapshotIntervalOption(interval time.Duration) func(*Snapshotter) {
+	return func(s *Snapshotter) {
+		if interval > 0 {
+			s.interval = interval
+		}
+	}
+}
+
+// SnapshotMaxAgeOption returns a Snapshotter option function that sets the
+// age beyond which a snapshot is discarded on Restore; zero means no limit
+func SnapshotMaxAgeOption(maxAge time.Duration) func(*Snapshotter) {
+	return func(s *Snapshotter) {
+		s.maxAge = maxAge
+	}
+}
+
+// NewSnapshotter returns a Snapshotter that saves snapshots of stats to path
+func NewSnapshotter(
+	stats *APIStats,
+	path string,
+	options ...func(*Snapshotter),
+) *Snapshotter {
+	s := Snapshotter{
+		stats:    stats,
+		path:     path,
+		interval: DefaultSnapshotInterval,
+		maxAge:   DefaultSnapshotMaxAge,
+	}
+	s.stopCtx, s.stop = context.WithCancel(context.Background())
+
+	for _, f := range options {
+		f(&s)
+	}
+
+	return &s
+}
+
+// Restore restores the saved snapshot, if any; it should be called on
+// startup, before Run. A corrupted or expired snapshot is not restored
+// and is overwritten by the next save.
+func (s *Snapshotter) Restore() error {
+	return s.stats.RestoreSnapshot(s.path, s.maxAge)
+}
+
+// Run saves a snapshot at every interval. It returns when Close is called
+func (s *Snapshotter) Run() {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stopCtx.Done():
+			return
+		case <-ticker.C:
+		}
+		if err := s.stats.SaveSnapshot(s.path); err != nil {
+			log.Printf("ERROR: SaveSnapshot(%s): %s", s.path, err)
+		}
+	}
+}
+
+// Flush saves a snapshot immediately
+func (s *Snapshotter) Flush() error {
+	return s.stats.SaveSnapshot(s.path)
+}
+
+// Close stops Run, if it is running, and saves a final snapshot
+func (s *Snapshotter) Close() error {
+	s.stop()
+	return s.Flush()
+}
@@ -0,0 +1,221 @@
+// Copyright 2017 Decipher Technology Studios LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apistats
+
+import (
+	"fmt"
+	"time"
+)
+
+// DefaultWindows are the time windows reported when no WindowsOption is given
+var DefaultWindows = []time.Duration{time.Minute, 5 * time.Minute, 15 * time.Minute}
+
+// DefaultWindowResolution is the width of the buckets that make up a window
+const DefaultWindowResolution = 10 * time.Second
+
+// WindowEndpointStats represents stats for a single endpoint, or the total
+// for all, over a time window
+type WindowEndpointStats struct {
+	APIEndpointStats
+
+	// RequestRate is the number of requests per second
+	RequestRate float64 `json:"requests_per_sec"`
+
+	// ErrorRate is the fraction of requests that ended in error
+	ErrorRate float64 `json:"errors.rate"`
+}
+
+// WindowStats reports endpoint stats over the most recent Window
+type WindowStats struct {
+	Window    time.Duration
+	Endpoints map[string]WindowEndpointStats
+}
+
+// windowBucket holds the transactions stored during resolution,
+// starting at start
+type windowBucket struct {
+	start     time.Time
+	endpoints map[string]*endpointAccum
+}
+
+// timeWindows is a ring of buckets covering the largest window
+type timeWindows struct {
+	resolution time.Duration
+	windows    []time.Duration
+	buckets    []windowBucket
+	started    time.Time
+}
+
+func newTimeWindows(
+	windows []time.Duration,
+	resolution time.Duration,
+	now time.Time,
+) *timeWindows {
+	tw := timeWindows{
+		resolution: resolution,
+		windows:    windows,
+		started:    now,
+	}
+	if len(windows) == 0 {
+		return &tw
+	}
+
+	var largest time.Duration
+	for _, window := range windows {
+		if window > largest {
+			largest = window
+		}
+	}
+
+	tw.buckets = make([]windowBucket, bucketCount(largest, resolution))
+
+	return &tw
+}
+
+// bucketCount returns the number of buckets needed to cover window,
+// the current (partial) bucket included
+func bucketCount(window, resolution time.Duration) int {
+	n := int((window + resolution - 1) / resolution)
+	if n < 1 {
+		n = 1
+	}
+	return n
+}
+
+func (tw *timeWindows) store(entry APIStatsEntry, now time.Time) {
+	if len(tw.buckets) == 0 {
+		return
+	}
+
+	start := now.Truncate(tw.resolution)
+	index := int((start.UnixNano() / int64(tw.resolution)) % int64(len(tw.buckets)))
+	bucket := &tw.buckets[index]
+	if !bucket.start.Equal(start) {
+		*bucket = windowBucket{
+			start:     start,
+			endpoints: make(map[string]*endpointAccum),
+		}
+	}
+
+	accum, ok := bucket.endpoints[entry.Key]
+	if !ok {
+		accum = newEndpointAccum()
+		bucket.endpoints[entry.Key] = accum
+	}
+	accum.add(entry)
+}
+
+// snapshot returns a copy of the windows that store does not modify, so
+// that getStats can run without holding the lock of the APIStats. store
+// replaces the past buckets rather than modify them, so only the buckets
+// that may still receive transactions are cloned.
+func (tw *timeWindows) snapshot(now time.Time) *timeWindows {
+	current := now.Truncate(tw.resolution)
+
+	s := *tw
+	s.buckets = make([]windowBucket, len(tw.buckets))
+	for i, bucket := range tw.buckets {
+		if bucket.start.Before(current) {
+			s.buckets[i] = bucket
+			continue
+		}
+		clone := windowBucket{
+			start:     bucket.start,
+			endpoints: make(map[string]*endpointAccum, len(bucket.endpoints)),
+		}
+		for key, accum := range bucket.endpoints {
+			c := newEndpointAccum()
+			c.merge(accum)
+			clone.endpoints[key] = c
+		}
+		s.buckets[i] = clone
+	}
+
+	return &s
+}
+
+// getStats merges the buckets that fall within each window
+func (tw *timeWindows) getStats(now time.Time) []WindowStats {
+	current := now.Truncate(tw.resolution)
+	result := make([]WindowStats, len(tw.windows))
+
+	for i, window := range tw.windows {
+		oldest := current.Add(-time.Duration(bucketCount(window, tw.resolution)-1) * tw.resolution)
+
+		merged := make(map[string]*endpointAccum)
+		all := newEndpointAccum()
+		for _, bucket := range tw.buckets {
+			if bucket.start.Before(oldest) || bucket.start.After(current) {
+				continue
+			}
+			for key, accum := range bucket.endpoints {
+				m, ok := merged[key]
+				if !ok {
+					m = newEndpointAccum()
+					merged[key] = m
+				}
+				m.merge(accum)
+				if !accum.client {
+					all.merge(accum)
+				}
+			}
+		}
+
+		// the rate is over the time the buckets cover: the full buckets and
+		// the current one up to now, and no more than the process has run
+		covered := oldest
+		if tw.started.After(covered) {
+			covered = tw.started
+		}
+		span := now.Sub(covered)
+
+		endpoints := make(map[string]WindowEndpointStats, len(merged)+1)
+		for key, accum := range merged {
+			endpoints[key] = windowEndpointStats(accum, span)
+		}
+		endpoints["all"] = windowEndpointStats(all, span)
+
+		result[i] = WindowStats{Window: window, Endpoints: endpoints}
+	}
+
+	return result
+}
+
+// windowEndpointStats computes the stats of the transactions accumulated
+// during span
+func windowEndpointStats(accum *endpointAccum, span time.Duration) WindowEndpointStats {
+	stats := WindowEndpointStats{APIEndpointStats: accum.endpointStats()}
+
+	if accum.count > 0 {
+		if span > 0 {
+			stats.RequestRate = float64(accum.count) / span.Seconds()
+		}
+		stats.ErrorRate = float64(accum.errors) / float64(accum.count)
+	}
+
+	return stats
+}
+
+// windowLabel returns a short label for a window, e.g. 1m, 15m, 30s
+func windowLabel(window time.Duration) string {
+	switch {
+	case window%time.Hour == 0:
+		return fmt.Sprintf("%dh", window/time.Hour)
+	case window%time.Minute == 0:
+		return fmt.Sprintf("%dm", window/time.Minute)
+	default:
+		return fmt.Sprintf("%ds", window/time.Second)
+	}
+}
@@ -0,0 +1,234 @@
+// Copyright 2017 Decipher Technology Studios LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package apistats
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/deciphernow/gm-fabric-go/metrics/flatjson"
+)
+
+// newTestStats returns APIStats driven by the clock *now
+func newTestStats(now *time.Time, options ...func(*APIStats)) *APIStats {
+	st := New(16, options...)
+	st.now = func() time.Time { return *now }
+	st.windows = newTimeWindows(st.windowDurations, st.windowResolution, *now)
+	return st
+}
+
+func latencyEntry(key string, latency time.Duration, err error) APIStatsEntry {
+	beginTime := time.Now()
+	return APIStatsEntry{
+		Key:         key,
+		BeginTime:   beginTime,
+		RequestTime: beginTime.Add(latency),
+		Err:         err,
+	}
+}
+
+func TestWindowStats(t *testing.T) {
+	const key = "xxx"
+	now := time.Date(2018, 11, 13, 12, 0, 0, 0, time.UTC)
+	st := newTestStats(&now)
+
+	// 15 minutes of traffic: one fast request every 10 seconds,
+	// then 6 slow requests, one failing, during the last minute
+	for i := 0; i < 90; i++ {
+		st.Store(latencyEntry(key, 10*time.Millisecond, nil))
+		now = now.Add(10 * time.Second)
+	}
+	for i := 0; i < 6; i++ {
+		var err error
+		if i == 0 {
+			err = errors.New("failed")
+		}
+		st.Store(latencyEntry(key, 120*time.Millisecond, err))
+	}
+
+	windowStats, err := st.GetWindowStats()
+	if err != nil {
+		t.Fatalf("GetWindowStats failed: %s", err)
+	}
+	if len(windowStats) != len(DefaultWindows) {
+		t.Fatalf("expected %d windows found %d", len(DefaultWindows), len(windowStats))
+	}
+
+	// now is at the start of a bucket, so the buckets of each window cover
+	// one bucket less than the window
+	testCases := []struct {
+		window        time.Duration
+		expectedCount int64
+		expectedP50   int64
+		expectedSpan  time.Duration
+	}{
+		{time.Minute, 6 + 5, 120, 50 * time.Second},
+		{5 * time.Minute, 6 + 29, 10, 290 * time.Second},
+		{15 * time.Minute, 6 + 89, 10, 890 * time.Second},
+	}
+
+	for i, tc := range testCases {
+		t.Run(fmt.Sprintf("%d: %s", i, windowLabel(tc.window)), func(t *testing.T) {
+			ws := windowStats[i]
+			if ws.Window != tc.window {
+				t.Fatalf("window: expected %s found %s", tc.window, ws.Window)
+			}
+			for _, name := range []string{key, "all"} {
+				ep := ws.Endpoints[name]
+				if ep.Count != tc.expectedCount {
+					t.Fatalf("%s count: expected %d found %d", name, tc.expectedCount, ep.Count)
+				}
+				if ep.P50 != tc.expectedP50 {
+					t.Fatalf("%s p50: expected %d found %d", name, tc.expectedP50, ep.P50)
+				}
+				if ep.Max != 120 || ep.Min != 10 {
+					t.Fatalf("%s min/max: expected 10/120 found %d/%d", name, ep.Min, ep.Max)
+				}
+				if ep.Errors != 1 {
+					t.Fatalf("%s errors: expected 1 found %d", name, ep.Errors)
+				}
+				expectedRate := float64(tc.expectedCount) / tc.expectedSpan.Seconds()
+				if ep.RequestRate != expectedRate {
+					t.Fatalf("%s rate: expected %f found %f", name, expectedRate, ep.RequestRate)
+				}
+				expectedErrorRate := 1 / float64(tc.expectedCount)
+				if ep.ErrorRate != expectedErrorRate {
+					t.Fatalf("%s error rate: expected %f found %f",
+						name, expectedErrorRate, ep.ErrorRate)
+				}
+			}
+		})
+	}
+
+	// after 15 idle minutes every window is empty
+	now = now.Add(15 * time.Minute)
+	if windowStats, err = st.GetWindowStats(); err != nil {
+		t.Fatalf("GetWindowStats failed: %s", err)
+	}
+	for _, ws := range windowStats {
+		if n := ws.Endpoints["all"].Count; n != 0 {
+			t.Fatalf("%s: expected 0 requests after idle, found %d", ws.Window, n)
+		}
+	}
+}
+
+func TestWindowRateAtStartup(t *testing.T) {
+	now := time.Date(2018, 11, 13, 12, 0, 0, 0, time.UTC)
+	st := newTestStats(&now, WindowsOption(time.Minute))
+
+	now = now.Add(10 * time.Second)
+	for i := 0; i < 20; i++ {
+		st.Store(latencyEntry("xxx", time.Millisecond, nil))
+	}
+
+	windowStats, err := st.GetWindowStats()
+	if err != nil {
+		t.Fatalf("GetWindowStats failed: %s", err)
+	}
+	if rate := windowStats[0].Endpoints["all"].RequestRate; rate != 2 {
+		t.Fatalf("expected 2 requests/sec found %f", rate)
+	}
+}
+
+func TestWindowRateCoverage(t *testing.T) {
+	now := time.Date(2018, 11, 13, 12, 0, 0, 0, time.UTC)
+	st := newTestStats(&now, WindowsOption(time.Minute))
+
+	// one request a second for two minutes, then 5 idle seconds
+	for i := 0; i < 120; i++ {
+		st.Store(latencyEntry("xxx", time.Millisecond, nil))
+		now = now.Add(time.Second)
+	}
+	now = now.Add(5 * time.Second)
+
+	// the 5 full buckets and 5 seconds of the current one
+	windows := st.windows.snapshot(now)
+	expected := 50.0 / 55.0
+	if rate := windows.getStats(now)[0].Endpoints["all"].RequestRate; rate != expected {
+		t.Fatalf("expected %f requests/sec found %f", expected, rate)
+	}
+
+	// the snapshot does not see the transactions stored after it
+	st.Store(latencyEntry("xxx", time.Millisecond, nil))
+	if n := windows.getStats(now)[0].Endpoints["all"].Count; n != 50 {
+		t.Fatalf("snapshot: expected 50 requests found %d", n)
+	}
+	windowStats, err := st.GetWindowStats()
+	if err != nil {
+		t.Fatalf("GetWindowStats failed: %s", err)
+	}
+	if n := windowStats[0].Endpoints["all"].Count; n != 51 {
+		t.Fatalf("expected 51 requests found %d", n)
+	}
+}
+
+func TestWindowLabel(t *testing.T) {
+	for i, td := range []struct {
+		window   time.Duration
+		expected string
+	}{
+		{time.Minute, "1m"},
+		{15 * time.Minute, "15m"},
+		{2 * time.Hour, "2h"},
+		{30 * time.Second, "30s"},
+	} {
+		if label := windowLabel(td.window); label != td.expected {
+			t.Fatalf("#%d: expected %s found %s", i+1, td.expected, label)
+		}
+	}
+}
+
+func TestWindowReport(t *testing.T) {
+	now := time.Now()
+	st := newTestStats(&now, WindowsOption(time.Minute, 5*time.Minute))
+	st.Store(latencyEntry("xxx", 42*time.Millisecond, nil))
+
+	var buffer bytes.Buffer
+	w, err := flatjson.New(&buffer)
+	if err != nil {
+		t.Fatalf("New failed: %s", err)
+	}
+	if err = st.Report(w); err != nil {
+		t.Fatalf("st.Report failed: %s", err)
+	}
+	if err = w.Flush(); err != nil {
+		t.Fatalf("w.Flush() failed: %s", err)
+	}
+
+	var ts map[string]interface{}
+	data := buffer.Bytes()
+	if err = json.Unmarshal(data, &ts); err != nil {
+		t.Fatalf("json.Unmarshal failed: %s; %s", err, string(data))
+	}
+
+	for key, expected := range map[string]float64{
+		"xxx/1m/requests":       1,
+		"xxx/1m/latency_ms.p99": 42,
+		"all/5m/requests":       1,
+		"all/5m/errors.rate":    0,
+	} {
+		if ts[key] != expected {
+			t.Fatalf("%s: expected %v found %v", key, expected, ts[key])
+		}
+	}
+	if _, ok := ts["all/15m/requests"]; ok {
+		t.Fatalf("unexpected window 15m: %s", string(data))
+	}
+}
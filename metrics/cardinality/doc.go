@@ -0,0 +1,43 @@
+// Copyright 2017 Decipher Technology Studios LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+/*Package cardinality limits the number of distinct metrics keys
+
+Metrics keys come from URL paths and gRPC method names, so a service with
+IDs in its URLs can create an unbounded number of keys. A Guard admits the
+first Limit distinct keys it sees and folds any other key into OtherKey.
+
+A single Guard should be shared by the observers and collectors that report
+the same keys, so that they fold the same keys.
+
+usage:
+    guard := cardinality.New(cardinality.DefaultLimit)
+
+    grpcObserver := grpcobserver.New(
+        cacheSize,
+        apistats.CardinalityGuardOption(guard),
+    )
+    collector, err := prometheus.NewCollector(
+        prometheus.CardinalityGuardOption(guard),
+    )
+    sinkObserver := sinkobserver.New(
+        sink,
+        reportInterval,
+        sinkobserver.CardinalityGuardOption(guard),
+    )
+
+    // report how many keys were folded
+    metricsserver.Start(metricsAddress, nil, grpcObserver.Report, guard.Report)
+*/
+package cardinality
@@ -0,0 +1,129 @@
+// Copyright 2017 Decipher Technology Studios LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cardinality
+
+import (
+	"hash/fnv"
+	"sync"
+
+	"github.com/pkg/errors"
+
+	"github.com/deciphernow/gm-fabric-go/metrics/flatjson"
+)
+
+// OtherKey is the key that excess keys are folded into
+const OtherKey = "__other__"
+
+// DefaultLimit is a reasonable number of distinct keys for a service
+const DefaultLimit = 1000
+
+// maxTrackedFolded bounds the memory used to count distinct folded keys
+const maxTrackedFolded = 1 << 16
+
+// Guard caps the number of distinct keys
+// A nil Guard admits every key.
+type Guard struct {
+	sync.Mutex
+	limit        int
+	keys         map[string]struct{}
+	folded       map[uint64]struct{}
+	foldedEvents uint64
+}
+
+// Stats reports the state of a Guard
+type Stats struct {
+	// Keys is the number of distinct keys admitted
+	Keys int
+
+	// FoldedKeys is the number of distinct keys folded into OtherKey.
+	// It stops growing at 65536.
+	FoldedKeys int
+
+	// FoldedEvents is the number of times a key was folded into OtherKey
+	FoldedEvents uint64
+}
+
+// New returns a Guard that admits up to limit distinct keys
+func New(limit int) *Guard {
+	return &Guard{
+		limit:  limit,
+		keys:   make(map[string]struct{}),
+		folded: make(map[uint64]struct{}),
+	}
+}
+
+// Key returns key if it has been admitted, or if there is room to admit it;
+// otherwise it returns OtherKey
+func (g *Guard) Key(key string) string {
+	if g == nil {
+		return key
+	}
+
+	g.Lock()
+	defer g.Unlock()
+
+	if _, ok := g.keys[key]; ok {
+		return key
+	}
+	if len(g.keys) < g.limit {
+		g.keys[key] = struct{}{}
+		return key
+	}
+
+	g.foldedEvents++
+	if len(g.folded) < maxTrackedFolded {
+		h := fnv.New64a()
+		h.Write([]byte(key))
+		g.folded[h.Sum64()] = struct{}{}
+	}
+
+	return OtherKey
+}
+
+// Stats returns the current state of the Guard
+func (g *Guard) Stats() Stats {
+	if g == nil {
+		return Stats{}
+	}
+
+	g.Lock()
+	defer g.Unlock()
+
+	return Stats{
+		Keys:         len(g.keys),
+		FoldedKeys:   len(g.folded),
+		FoldedEvents: g.foldedEvents,
+	}
+}
+
+// Report implements the metricsserver ReportFunc
+func (g *Guard) Report(jWriter *flatjson.Writer) error {
+	stats := g.Stats()
+
+	for _, x := range []struct {
+		label string
+		val   interface{}
+	}{
+		{"cardinality/keys", stats.Keys},
+		{"cardinality/folded_keys", stats.FoldedKeys},
+		{"cardinality/folded_events", stats.FoldedEvents},
+	} {
+		if err := jWriter.Write(x.label, x.val); err != nil {
+			return errors.Wrapf(err, "jWriter.Write %s", x.label)
+		}
+	}
+
+	return nil
+}
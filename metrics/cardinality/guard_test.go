@@ -0,0 +1,117 @@
+// Copyright 2017 Decipher Technology Studios LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cardinality
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/deciphernow/gm-fabric-go/metrics/flatjson"
+)
+
+func TestGuard(t *testing.T) {
+	g := New(2)
+
+	for i, td := range []struct {
+		key      string
+		expected string
+	}{
+		{"aaa", "aaa"},
+		{"bbb", "bbb"},
+		{"ccc", OtherKey},
+		{"aaa", "aaa"},
+		{"ddd", OtherKey},
+		{"ccc", OtherKey},
+		{"bbb", "bbb"},
+	} {
+		if key := g.Key(td.key); key != td.expected {
+			t.Fatalf("#%d: %s: expected %s found %s", i+1, td.key, td.expected, key)
+		}
+	}
+
+	expected := Stats{Keys: 2, FoldedKeys: 2, FoldedEvents: 3}
+	if stats := g.Stats(); stats != expected {
+		t.Fatalf("expected %+v found %+v", expected, stats)
+	}
+}
+
+func TestNilGuard(t *testing.T) {
+	var g *Guard
+	if key := g.Key("aaa"); key != "aaa" {
+		t.Fatalf("expected aaa found %s", key)
+	}
+	if stats := g.Stats(); stats != (Stats{}) {
+		t.Fatalf("expected zero stats found %+v", stats)
+	}
+}
+
+func TestGuardConcurrent(t *testing.T) {
+	const limit = 10
+	g := New(limit)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(n int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				g.Key(fmt.Sprintf("%d-%d", n, j))
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	stats := g.Stats()
+	if stats.Keys != limit || stats.FoldedKeys != 400-limit {
+		t.Fatalf("expected %d keys, %d folded; found %+v", limit, 400-limit, stats)
+	}
+}
+
+func TestGuardReport(t *testing.T) {
+	g := New(1)
+	g.Key("aaa")
+	g.Key("bbb")
+
+	var buffer bytes.Buffer
+	w, err := flatjson.New(&buffer)
+	if err != nil {
+		t.Fatalf("New failed: %s", err)
+	}
+	if err = g.Report(w); err != nil {
+		t.Fatalf("g.Report failed: %s", err)
+	}
+	if err = w.Flush(); err != nil {
+		t.Fatalf("w.Flush() failed: %s", err)
+	}
+
+	var ts map[string]interface{}
+	data := buffer.Bytes()
+	if err = json.Unmarshal(data, &ts); err != nil {
+		t.Fatalf("json.Unmarshal failed: %s; %s", err, string(data))
+	}
+
+	for key, expected := range map[string]float64{
+		"cardinality/keys":          1,
+		"cardinality/folded_keys":   1,
+		"cardinality/folded_events": 1,
+	} {
+		if ts[key] != expected {
+			t.Fatalf("%s: expected %v found %v", key, expected, ts[key])
+		}
+	}
+}
@@ -0,0 +1,284 @@
+// Copyright 2017 Decipher Technology Studios LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cgroup
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultRoot is where the cgroup filesystem of the container is mounted
+const DefaultRoot = "/sys/fs/cgroup"
+
+// ProcSelfCgroup lists the cgroups of the process, one line per hierarchy
+const ProcSelfCgroup = "/proc/self/cgroup"
+
+// unlimited is the threshold above which a cgroup v1 limit means no limit;
+// the kernel reports "no limit" as the largest page aligned int64
+const unlimited = 1 << 62
+
+// Limits holds the limits of the cgroup of the process; a limit is 0 if
+// there is none
+type Limits struct {
+	CPUCores    float64 // CPU quota in cores, e.g. 0.5 for 50ms per 100ms
+	MemoryBytes uint64
+}
+
+// Stats holds the limits and memory usage of the cgroup of the process
+type Stats struct {
+	Limits
+
+	// Version is the cgroup version, 1 or 2, or 0 if there is no cgroup
+	Version int
+
+	// MemoryUsageBytes is the working set of the cgroup: its memory usage,
+	// less the inactive file cache that the kernel reclaims before reaching
+	// the limit
+	MemoryUsageBytes uint64
+}
+
+// ReadStats reads the limits and usage of the cgroup of the process, as
+// listed in ProcSelfCgroup, in the cgroup filesystem mounted at root.
+// It returns zero Stats, and no error, if there is no cgroup filesystem.
+func ReadStats(root string) (Stats, error) {
+	return ReadStatsOf(root, ProcSelfCgroup)
+}
+
+// ReadStatsOf reads the limits and usage of the cgroup listed in
+// procCgroup, a file in the format of ProcSelfCgroup, in the cgroup
+// filesystem mounted at root.
+// A cgroup that is not found under root, as when the container has its own
+// cgroup namespace and root is its cgroup, is read from root itself.
+func ReadStatsOf(root, procCgroup string) (Stats, error) {
+	paths, err := readProcCgroup(procCgroup)
+	if err != nil {
+		return Stats{}, err
+	}
+
+	switch {
+	case exists(filepath.Join(root, "cgroup.controllers")):
+		return readV2Stats(cgroupDir(root, paths[""]))
+	case exists(filepath.Join(root, "memory")) || exists(filepath.Join(root, "cpu")):
+		return readV1Stats(
+			cgroupDir(filepath.Join(root, "cpu"), paths["cpu"]),
+			cgroupDir(filepath.Join(root, "memory"), paths["memory"]),
+		)
+	}
+
+	return Stats{}, nil
+}
+
+// readProcCgroup returns the cgroup paths of the process by controller,
+// from lines of the form <hierarchy ID>:<controllers>:<path>, e.g.
+//     4:memory:/kubepods/pod1/abc
+//     3:cpu,cpuacct:/kubepods/pod1/abc
+//     0::/kubepods/pod1/abc
+// The path of the cgroup v2 hierarchy, which has no controllers, is under "".
+// A missing file lists no cgroups.
+func readProcCgroup(path string) (map[string]string, error) {
+	paths := make(map[string]string)
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return paths, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "ioutil.ReadFile")
+	}
+
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.SplitN(line, ":", 3)
+		if len(fields) != 3 {
+			continue
+		}
+		if fields[1] == "" {
+			paths[""] = fields[2]
+			continue
+		}
+		for _, controller := range strings.Split(fields[1], ",") {
+			paths[controller] = fields[2]
+		}
+	}
+
+	return paths, nil
+}
+
+// cgroupDir returns the directory of the cgroup path in the hierarchy
+// mounted at mount, or mount itself if there is no such directory
+func cgroupDir(mount, path string) string {
+	if dir := filepath.Join(mount, path); path != "" && exists(dir) {
+		return dir
+	}
+	return mount
+}
+
+// readV2Stats reads cpu.max ("max 100000" or "<quota> <period>"),
+// memory.max ("max" or bytes), memory.current and memory.stat in dir
+func readV2Stats(dir string) (Stats, error) {
+	stats := Stats{Version: 2}
+
+	fields, err := readFields(filepath.Join(dir, "cpu.max"))
+	if err != nil {
+		return Stats{}, err
+	}
+	if len(fields) == 2 && fields[0] != "max" {
+		stats.CPUCores, err = quotaCores(fields[0], fields[1])
+		if err != nil {
+			return Stats{}, errors.Wrap(err, "cpu.max")
+		}
+	}
+
+	fields, err = readFields(filepath.Join(dir, "memory.max"))
+	if err != nil {
+		return Stats{}, err
+	}
+	if len(fields) == 1 && fields[0] != "max" {
+		stats.MemoryBytes, err = strconv.ParseUint(fields[0], 10, 64)
+		if err != nil {
+			return Stats{}, errors.Wrap(err, "memory.max")
+		}
+	}
+
+	stats.MemoryUsageBytes, err = readWorkingSet(
+		filepath.Join(dir, "memory.current"),
+		filepath.Join(dir, "memory.stat"),
+		"inactive_file",
+	)
+	if err != nil {
+		return Stats{}, err
+	}
+
+	return stats, nil
+}
+
+// readV1Stats reads cpu.cfs_quota_us (-1 for no limit) and cpu.cfs_period_us
+// in cpuDir, and memory.limit_in_bytes, memory.usage_in_bytes and
+// memory.stat in memoryDir
+func readV1Stats(cpuDir, memoryDir string) (Stats, error) {
+	stats := Stats{Version: 1}
+
+	quota, err := readFields(filepath.Join(cpuDir, "cpu.cfs_quota_us"))
+	if err != nil {
+		return Stats{}, err
+	}
+	period, err := readFields(filepath.Join(cpuDir, "cpu.cfs_period_us"))
+	if err != nil {
+		return Stats{}, err
+	}
+	if len(quota) == 1 && len(period) == 1 && quota[0] != "-1" {
+		stats.CPUCores, err = quotaCores(quota[0], period[0])
+		if err != nil {
+			return Stats{}, errors.Wrap(err, "cpu.cfs_quota_us")
+		}
+	}
+
+	fields, err := readFields(filepath.Join(memoryDir, "memory.limit_in_bytes"))
+	if err != nil {
+		return Stats{}, err
+	}
+	if len(fields) == 1 {
+		memory, err := strconv.ParseUint(fields[0], 10, 64)
+		if err != nil {
+			return Stats{}, errors.Wrap(err, "memory.limit_in_bytes")
+		}
+		if memory < unlimited {
+			stats.MemoryBytes = memory
+		}
+	}
+
+	stats.MemoryUsageBytes, err = readWorkingSet(
+		filepath.Join(memoryDir, "memory.usage_in_bytes"),
+		filepath.Join(memoryDir, "memory.stat"),
+		"total_inactive_file",
+	)
+	if err != nil {
+		return Stats{}, err
+	}
+
+	return stats, nil
+}
+
+// readWorkingSet reads the memory usage from usagePath, less the value of
+// inactiveKey in the "key value" lines of statPath
+func readWorkingSet(usagePath, statPath, inactiveKey string) (uint64, error) {
+	fields, err := readFields(usagePath)
+	if err != nil || len(fields) != 1 {
+		return 0, err
+	}
+	usage, err := strconv.ParseUint(fields[0], 10, 64)
+	if err != nil {
+		return 0, errors.Wrap(err, filepath.Base(usagePath))
+	}
+
+	fields, err = readFields(statPath)
+	if err != nil {
+		return 0, err
+	}
+	for i := 0; i+1 < len(fields); i += 2 {
+		if fields[i] != inactiveKey {
+			continue
+		}
+		inactive, err := strconv.ParseUint(fields[i+1], 10, 64)
+		if err != nil {
+			return 0, errors.Wrap(err, filepath.Base(statPath))
+		}
+		if inactive < usage {
+			usage -= inactive
+		}
+		break
+	}
+
+	return usage, nil
+}
+
+func quotaCores(quota, period string) (float64, error) {
+	q, err := strconv.ParseFloat(quota, 64)
+	if err != nil {
+		return 0, err
+	}
+	p, err := strconv.ParseFloat(period, 64)
+	if err != nil {
+		return 0, err
+	}
+	if q <= 0 || p <= 0 {
+		return 0, nil
+	}
+
+	return q / p, nil
+}
+
+// readFields returns the whitespace separated fields of a file, or none if
+// the file does not exist
+func readFields(path string) ([]string, error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "ioutil.ReadFile")
+	}
+
+	return strings.Fields(string(data)), nil
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
@@ -0,0 +1,169 @@
+// Copyright 2017 Decipher Technology Studios LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cgroup
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadStats(t *testing.T) {
+	testCases := []struct {
+		name       string
+		procCgroup string
+		files      map[string]string
+		expected   Stats
+	}{
+		{
+			name:     "no cgroup",
+			files:    nil,
+			expected: Stats{},
+		},
+		{
+			name: "v1 limited",
+			files: map[string]string{
+				"cpu/cpu.cfs_quota_us":         "50000\n",
+				"cpu/cpu.cfs_period_us":        "100000\n",
+				"memory/memory.limit_in_bytes": "536870912\n",
+				"memory/memory.usage_in_bytes": "300000000\n",
+				"memory/memory.stat":           "cache 150000000\ntotal_inactive_file 100000000\n",
+			},
+			expected: Stats{
+				Limits:           Limits{CPUCores: 0.5, MemoryBytes: 536870912},
+				Version:          1,
+				MemoryUsageBytes: 200000000,
+			},
+		},
+		{
+			name: "v1 unlimited",
+			files: map[string]string{
+				"cpu/cpu.cfs_quota_us":         "-1\n",
+				"cpu/cpu.cfs_period_us":        "100000\n",
+				"memory/memory.limit_in_bytes": "9223372036854771712\n",
+			},
+			expected: Stats{Version: 1},
+		},
+		{
+			name: "v2 limited",
+			files: map[string]string{
+				"cgroup.controllers": "cpu memory\n",
+				"cpu.max":            "200000 100000\n",
+				"memory.max":         "1073741824\n",
+				"memory.current":     "600000000\n",
+				"memory.stat":        "anon 400000000\ninactive_file 50000000\n",
+			},
+			expected: Stats{
+				Limits:           Limits{CPUCores: 2, MemoryBytes: 1073741824},
+				Version:          2,
+				MemoryUsageBytes: 550000000,
+			},
+		},
+		{
+			name: "v1 nested",
+			procCgroup: "9:name=systemd:/\n" +
+				"4:memory:/docker/abc\n" +
+				"3:cpu,cpuacct:/docker/abc\n",
+			files: map[string]string{
+				"cpu/cpu.cfs_quota_us":                    "-1\n",
+				"cpu/cpu.cfs_period_us":                   "100000\n",
+				"memory/memory.limit_in_bytes":            "9223372036854771712\n",
+				"cpu/docker/abc/cpu.cfs_quota_us":         "25000\n",
+				"cpu/docker/abc/cpu.cfs_period_us":        "100000\n",
+				"memory/docker/abc/memory.limit_in_bytes": "268435456\n",
+				"memory/docker/abc/memory.usage_in_bytes": "100000000\n",
+			},
+			expected: Stats{
+				Limits:           Limits{CPUCores: 0.25, MemoryBytes: 268435456},
+				Version:          1,
+				MemoryUsageBytes: 100000000,
+			},
+		},
+		{
+			name:       "v2 nested",
+			procCgroup: "0::/kubepods/pod1/abc\n",
+			files: map[string]string{
+				"cgroup.controllers":            "cpu memory\n",
+				"cpu.max":                       "max 100000\n",
+				"memory.max":                    "max\n",
+				"kubepods/pod1/abc/cpu.max":     "50000 100000\n",
+				"kubepods/pod1/abc/memory.max":  "536870912\n",
+				"kubepods/pod1/abc/memory.stat": "anon 1000\n",
+			},
+			expected: Stats{
+				Limits:  Limits{CPUCores: 0.5, MemoryBytes: 536870912},
+				Version: 2,
+			},
+		},
+		{
+			// with a cgroup namespace, the cgroup of the container is
+			// mounted at the root
+			name:       "v2 namespaced",
+			procCgroup: "0::/kubepods/pod1/abc\n",
+			files: map[string]string{
+				"cgroup.controllers": "cpu memory\n",
+				"cpu.max":            "100000 100000\n",
+				"memory.max":         "max\n",
+			},
+			expected: Stats{Limits: Limits{CPUCores: 1}, Version: 2},
+		},
+		{
+			name: "v2 unlimited",
+			files: map[string]string{
+				"cgroup.controllers": "cpu memory\n",
+				"cpu.max":            "max 100000\n",
+				"memory.max":         "max\n",
+			},
+			expected: Stats{Version: 2},
+		},
+	}
+
+	for i, tc := range testCases {
+		t.Run(fmt.Sprintf("%d: %s", i, tc.name), func(t *testing.T) {
+			root, err := ioutil.TempDir("", "cgroup")
+			if err != nil {
+				t.Fatalf("ioutil.TempDir failed: %s", err)
+			}
+			defer os.RemoveAll(root)
+
+			for name, content := range tc.files {
+				path := filepath.Join(root, name)
+				if err = os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+					t.Fatalf("os.MkdirAll failed: %s", err)
+				}
+				if err = ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+					t.Fatalf("ioutil.WriteFile failed: %s", err)
+				}
+			}
+
+			procCgroup := filepath.Join(root, "proc-self-cgroup")
+			if tc.procCgroup != "" {
+				if err = ioutil.WriteFile(procCgroup, []byte(tc.procCgroup), 0644); err != nil {
+					t.Fatalf("ioutil.WriteFile failed: %s", err)
+				}
+			}
+
+			stats, err := ReadStatsOf(root, procCgroup)
+			if err != nil {
+				t.Fatalf("ReadStatsOf failed: %s", err)
+			}
+			if stats != tc.expected {
+				t.Fatalf("expected %+v found %+v", tc.expected, stats)
+			}
+		})
+	}
+}
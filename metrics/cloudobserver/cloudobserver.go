@@ -3,6 +3,7 @@
 package cloudobserver
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"regexp"
@@ -22,6 +23,10 @@ import (
 	"github.com/aws/aws-sdk-go/service/cloudwatch"
 )
 
+// DeltaConsumer is the consumer name the CWReporter uses to get
+// per-interval counts from a Getter that implements apistats.DeltaCountsGetter
+const DeltaConsumer = "cloudwatch"
+
 // The CWReporter struct can be used to define the AWS namespace and dimensions under which the defined metrics will reside
 type CWReporter struct {
 	CWClient     *cloudwatch.CloudWatch
@@ -32,6 +37,12 @@ type CWReporter struct {
 	routesRegexp *regexp.Regexp
 	datumFuncs   []datumFuncType
 	Debug        bool
+	stopCtx      context.Context
+	stop         context.CancelFunc
+
+	// reportRequests is set if the "requests" value is requested;
+	// it is reported from the deltas of the cumulative counts
+	reportRequests bool
 }
 
 type sessAndType struct {
@@ -278,6 +289,8 @@ func New(
 		Bool("debug", cwReporter.Debug).
 		Msg("cloudobserver.New")
 
+	cwReporter.stopCtx, cwReporter.stop = context.WithCancel(context.Background())
+
 	cwReporter.routesRegexp, err = regexp.Compile(routes)
 	if err != nil {
 		return nil, errors.Wrapf(err, "regexp.Compile(%s) failed", routes)
@@ -292,9 +305,16 @@ func New(
 		if datumKey == "" {
 			continue
 		}
+		if datumKey == "requests" {
+			if _, ok := cwReporter.Getter.(apistats.DeltaCountsGetter); !ok {
+				return nil, errors.Errorf("value 'requests' requires a Getter that implements apistats.DeltaCountsGetter")
+			}
+			cwReporter.reportRequests = true
+			continue
+		}
 		datumKeys = append(datumKeys, datumKey)
 	}
-	if len(datumKeys) == 0 {
+	if len(datumKeys) == 0 && !cwReporter.reportRequests {
 		return nil, errors.Errorf("No CW data values specified '%v'", values)
 	}
 
@@ -313,11 +333,23 @@ func New(
 }
 
 // ReportToCloudWatch will report metrics to AWS CloudWatch at the given interval
+// It returns when Close is called; with a non-positive interval it reports
+// nothing and returns at once, as sinkobserver does
 func (co *CWReporter) ReportToCloudWatch(reportInterval time.Duration) {
 	co.Logger.Debug().Msgf("ReportToCloudWatch: interval %s", reportInterval)
-	tickChan := time.Tick(reportInterval)
+	if reportInterval <= 0 {
+		co.Logger.Error().Msgf("ReportToCloudWatch: invalid interval %s", reportInterval)
+		return
+	}
+	ticker := time.NewTicker(reportInterval)
+	defer ticker.Stop()
 	for {
-		<-tickChan
+		select {
+		case <-co.stopCtx.Done():
+			co.Logger.Debug().Msg("ReportToCloudWatch: stopped")
+			return
+		case <-ticker.C:
+		}
 		if err := co.AddAWSMetrics(); err != nil {
 			co.Logger.Error().AnErr("AddAWSMetrics", err).Msg("")
 			continue
@@ -332,6 +364,18 @@ func (co *CWReporter) ReportToCloudWatch(reportInterval time.Duration) {
 	}
 }
 
+// Flush pushes the current metrics to CloudWatch immediately,
+// for example to report final values during shutdown.
+func (co *CWReporter) Flush() error {
+	return co.AddAWSMetrics()
+}
+
+// Close stops ReportToCloudWatch. It is safe to call more than once.
+func (co *CWReporter) Close() error {
+	co.stop()
+	return nil
+}
+
 // AddAWSMetrics handles the actual push of the metric up to cloudwatch.
 func (co *CWReporter) AddAWSMetrics() error {
 	stats, err := co.Getter.GetEndpointStats()
@@ -339,6 +383,11 @@ func (co *CWReporter) AddAWSMetrics() error {
 		return errors.Wrap(err, "GetEndpointStats()")
 	}
 
+	var deltas apistats.CumulativeCounts
+	if co.reportRequests {
+		deltas = co.Getter.(apistats.DeltaCountsGetter).GetCumulativeCountsDelta(DeltaConsumer)
+	}
+
 KEY_LOOP:
 	for key := range stats {
 
@@ -372,7 +421,7 @@ KEY_LOOP:
 				Msg("AddAWSMetrics")
 		}
 
-		metricData := make([]*cloudwatch.MetricDatum, len(co.datumFuncs))
+		metricData := make([]*cloudwatch.MetricDatum, len(co.datumFuncs), len(co.datumFuncs)+1)
 		for i := 0; i < len(co.datumFuncs); i++ {
 			datum := co.datumFuncs[i](stats, co.Dimensions, key, timestamp)
 			if err := datum.Validate(); err != nil {
@@ -380,6 +429,13 @@ KEY_LOOP:
 			}
 			metricData[i] = datum
 		}
+		if co.reportRequests {
+			datum := requestsDatum(deltas, co.Dimensions, key, timestamp)
+			if err := datum.Validate(); err != nil {
+				return errors.Wrapf(err, "requests datum invalid %s", datum.String())
+			}
+			metricData = append(metricData, datum)
+		}
 
 		output, err := co.CWClient.PutMetricData(
 			&cloudwatch.PutMetricDataInput{
@@ -1,10 +1,15 @@
 package cloudobserver
 
 import (
+	"context"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -162,3 +167,13 @@ func setAndTestEnv(t *testing.T, configFile string) {
 	assert.Equal(t, os.Getenv("AWS_SHARED_CREDENTIALS_FILE"), configFile)
 
 }
+
+func TestReportToCloudWatchInvalidInterval(t *testing.T) {
+	for _, interval := range []time.Duration{0, -time.Second} {
+		co := CWReporter{Logger: zerolog.New(ioutil.Discard)}
+		co.stopCtx, co.stop = context.WithCancel(context.Background())
+		// returns at once instead of waiting for Close
+		co.ReportToCloudWatch(interval)
+		co.stop()
+	}
+}
@@ -8,8 +8,8 @@ import (
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/cloudwatch"
 	"github.com/deciphernow/gm-fabric-go/metrics/apistats"
+	"github.com/deciphernow/gm-fabric-go/metrics/cpuvalues"
 	"github.com/deciphernow/gm-fabric-go/metrics/memvalues"
-	"github.com/shirou/gopsutil/cpu"
 )
 
 type datumFuncType func(
@@ -40,6 +40,7 @@ var datumFuncMap = map[string]datumFuncType{
 	"system/memory/used":         systemMemoryUsed,
 	"system/memory/used_percent": systemMemoryUsedPercent,
 	"process/memory/used":        processMemoryUsed,
+	"process/cpu.pct":            processCPUPct,
 }
 
 func latencyMSAvg(
@@ -258,14 +259,29 @@ func systemCPUPct(
 	key string,
 	timestamp time.Time,
 ) *cloudwatch.MetricDatum {
-	cpuPercent, err := cpu.Percent(time.Second, false)
-	if err != nil {
-		cpuPercent = []float64{0.0}
-	}
+	// ignore error, just report the latest values
+	cv, _ := cpuvalues.Default().Values()
 	return &cloudwatch.MetricDatum{
 		MetricName: aws.String(fmt.Sprintf("%s/%s", key, "system/cpu.pct")),
 		Unit:       aws.String("Percent"),
-		Value:      aws.Float64(cpuPercent[0]),
+		Value:      aws.Float64(cv.SystemPercent),
+		Dimensions: dimensions,
+		Timestamp:  aws.Time(timestamp),
+	}
+}
+
+func processCPUPct(
+	_ map[string]apistats.APIEndpointStats,
+	dimensions []*cloudwatch.Dimension,
+	key string,
+	timestamp time.Time,
+) *cloudwatch.MetricDatum {
+	// ignore error, just report the latest values
+	cv, _ := cpuvalues.Default().Values()
+	return &cloudwatch.MetricDatum{
+		MetricName: aws.String(fmt.Sprintf("%s/%s", key, "process/cpu.pct")),
+		Unit:       aws.String("Percent"),
+		Value:      aws.Float64(cv.ProcessPercent),
 		Dimensions: dimensions,
 		Timestamp:  aws.Time(timestamp),
 	}
@@ -353,3 +369,24 @@ func processMemoryUsed(
 		Timestamp:  aws.Time(timestamp),
 	}
 }
+
+// requestsDatum reports the requests since the previous report;
+// deltas are the counts since then, as returned by GetCumulativeCountsDelta
+func requestsDatum(
+	deltas apistats.CumulativeCounts,
+	dimensions []*cloudwatch.Dimension,
+	key string,
+	timestamp time.Time,
+) *cloudwatch.MetricDatum {
+	requests := deltas.KeyEvents[key].Events
+	if key == "all" {
+		requests = deltas.TotalEvents
+	}
+	return &cloudwatch.MetricDatum{
+		MetricName: aws.String(fmt.Sprintf("%s/%s", key, "requests")),
+		Unit:       aws.String("Count"),
+		Value:      aws.Float64(float64(requests)),
+		Dimensions: dimensions,
+		Timestamp:  aws.Time(timestamp),
+	}
+}
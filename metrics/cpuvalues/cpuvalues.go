@@ -0,0 +1,204 @@
+// Copyright 2017 Decipher Technology Studios LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cpuvalues
+
+import (
+	"context"
+	"math"
+	"os"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/shirou/gopsutil/cpu"
+	"github.com/shirou/gopsutil/process"
+)
+
+// DefaultSampleInterval is the default interval between samples
+const DefaultSampleInterval = 5 * time.Second
+
+// CPUValues holds CPU values for reporting. The percentages cover the
+// interval between the last two samples.
+type CPUValues struct {
+	SystemPercent  float64   // CPU in use by the system, percent of all cores
+	ProcessPercent float64   // CPU in use by this process, percent of one core
+	Cores          int       // logical cores of the host
+	Sampled        time.Time // time of the last sample; zero before the first
+}
+
+// Sampler samples CPU usage at an interval and keeps the latest values
+type Sampler struct {
+	sync.Mutex
+	interval time.Duration
+	proc     *process.Process
+	values   CPUValues
+	err      error
+
+	// the previous sample, the start of the next interval
+	lastTime    time.Time
+	lastSystem  cpu.TimesStat
+	lastProcess float64
+
+	stopCtx context.Context
+	stop    context.CancelFunc
+}
+
+// SampleIntervalOption sets the interval between samples; the default is
+// DefaultSampleInterval. A non-positive interval is ignored.
+func SampleIntervalOption(interval time.Duration) func(*Sampler) {
+	return func(s *Sampler) {
+		if interval > 0 {
+			s.interval = interval
+		}
+	}
+}
+
+// NewSampler returns a sampler that has taken its first sample;
+// call Run to keep sampling
+func NewSampler(options ...func(*Sampler)) *Sampler {
+	s := Sampler{
+		interval: DefaultSampleInterval,
+		values:   CPUValues{Cores: runtime.NumCPU()},
+	}
+	for _, o := range options {
+		o(&s)
+	}
+	s.stopCtx, s.stop = context.WithCancel(context.Background())
+
+	s.proc, s.err = process.NewProcess(int32(os.Getpid()))
+	if s.err == nil {
+		s.sample()
+	}
+
+	return &s
+}
+
+// Run samples at the interval until Close is called
+func (s *Sampler) Run() {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stopCtx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		s.sample()
+	}
+}
+
+// Close stops Run
+func (s *Sampler) Close() error {
+	s.stop()
+	return nil
+}
+
+// Values returns the latest values, and the error of the last sample,
+// if it failed
+func (s *Sampler) Values() (CPUValues, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.values, s.err
+}
+
+func (s *Sampler) sample() {
+	now := time.Now()
+
+	var err error
+	defer func() {
+		s.Lock()
+		s.err = err
+		s.Unlock()
+	}()
+
+	if s.proc == nil {
+		err = errors.New("no process to sample")
+		return
+	}
+
+	systemTimes, err := cpu.Times(false)
+	if err != nil {
+		err = errors.Wrap(err, "cpu.Times")
+		return
+	}
+	if len(systemTimes) == 0 {
+		err = errors.New("cpu.Times returned no times")
+		return
+	}
+	processTimes, err := s.proc.Times()
+	if err != nil {
+		err = errors.Wrap(err, "process.Times")
+		return
+	}
+
+	system := systemTimes[0]
+	processSecs := processTimes.User + processTimes.System
+
+	s.Lock()
+	defer s.Unlock()
+
+	if !s.lastTime.IsZero() {
+		s.values.SystemPercent = busyPercent(s.lastSystem, system)
+		if elapsed := now.Sub(s.lastTime).Seconds(); elapsed > 0 {
+			s.values.ProcessPercent = (processSecs - s.lastProcess) / elapsed * 100
+		}
+		s.values.Sampled = now
+	}
+
+	s.lastTime = now
+	s.lastSystem = system
+	s.lastProcess = processSecs
+}
+
+// busyPercent returns the percentage of the CPU time between two samples
+// that was not idle, computed as gopsutil's cpu.Percent does
+func busyPercent(t1, t2 cpu.TimesStat) float64 {
+	busy1, all1 := busyTimes(t1)
+	busy2, all2 := busyTimes(t2)
+
+	if busy2 <= busy1 {
+		return 0
+	}
+	if all2 <= all1 {
+		return 100
+	}
+
+	return math.Min((busy2-busy1)/(all2-all1)*100, 100)
+}
+
+func busyTimes(t cpu.TimesStat) (busy, all float64) {
+	busy = t.User + t.System + t.Nice + t.Iowait + t.Irq + t.Softirq + t.Steal
+	return busy, busy + t.Idle
+}
+
+var (
+	defaultSampler *Sampler
+	defaultOnce    sync.Once
+)
+
+// Default returns the sampler shared by the reporters that are not given
+// one, sampling at DefaultSampleInterval from its first use
+func Default() *Sampler {
+	defaultOnce.Do(func() {
+		defaultSampler = NewSampler()
+		go defaultSampler.Run()
+	})
+
+	return defaultSampler
+}
@@ -0,0 +1,89 @@
+// Copyright 2017 Decipher Technology Studios LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cpuvalues
+
+import (
+	"testing"
+	"time"
+
+	"github.com/shirou/gopsutil/cpu"
+)
+
+func TestSampler(t *testing.T) {
+	s := NewSampler(SampleIntervalOption(10 * time.Millisecond))
+
+	values, err := s.Values()
+	if err != nil {
+		t.Fatalf("Values failed: %s", err)
+	}
+	if !values.Sampled.IsZero() {
+		t.Fatalf("expected no sample before the first interval, found %v", values.Sampled)
+	}
+
+	go s.Run()
+	defer s.Close()
+
+	// keep the process busy so that it uses measurable CPU time
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		for i := 0; i < 1000000; i++ {
+			values.Cores += i & 1
+		}
+		if values, err = s.Values(); err != nil {
+			t.Fatalf("Values failed: %s", err)
+		}
+		if values.ProcessPercent > 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for process CPU, found %+v", values)
+		}
+	}
+
+	if values.Sampled.IsZero() {
+		t.Fatal("expected a sample time")
+	}
+	if values.SystemPercent < 0 || values.SystemPercent > 100 {
+		t.Fatalf("system percent out of range: %f", values.SystemPercent)
+	}
+	if values.Cores < 1 {
+		t.Fatalf("expected at least 1 core, found %d", values.Cores)
+	}
+}
+
+func TestSampleIntervalOption(t *testing.T) {
+	for _, interval := range []time.Duration{0, -time.Second} {
+		s := NewSampler(SampleIntervalOption(interval))
+		if s.interval != DefaultSampleInterval {
+			t.Fatalf("%s: expected %s found %s", interval, DefaultSampleInterval, s.interval)
+		}
+	}
+}
+
+func TestBusyPercent(t *testing.T) {
+	t1 := cpuTimes(100, 300)
+	t2 := cpuTimes(150, 350)
+
+	if p := busyPercent(t1, t2); p != 50 {
+		t.Fatalf("expected 50 found %f", p)
+	}
+	if p := busyPercent(t2, t1); p != 0 {
+		t.Fatalf("expected 0 found %f", p)
+	}
+}
+
+func cpuTimes(user, idle float64) cpu.TimesStat {
+	return cpu.TimesStat{User: user, Idle: idle}
+}
@@ -0,0 +1,26 @@
+// Copyright 2017 Decipher Technology Studios LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+/*Package cpuvalues samples CPU usage in the background, so that reporters
+can read the latest values without waiting for a measurement interval.
+
+    sampler := cpuvalues.NewSampler(cpuvalues.SampleIntervalOption(10 * time.Second))
+    go sampler.Run()
+    defer sampler.Close()
+
+    values, err := sampler.Values()
+
+Reporters that are not given a sampler share the one returned by Default.
+*/
+package cpuvalues
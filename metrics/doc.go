@@ -40,10 +40,12 @@ Sources generate metrics data and send MetricsEvent notifications through
 the metrics event channel
     * gmfabricsink a go-metrics MetricSink https://github.com/armon/go-metrics#sinks that converts events into MetricsEvent
     * grpcmetrics implements the gRpc stats.Handler https://godoc.org/google.golang.org/grpc/stats#Handler interface to report gRpc events as MetricsEvent
+    * httpclient implements the go http.RoundTripper interface to report outgoing HTTP requests as MetricsEvent
     * httpmetrics implements the go http.Handler](https://golang.org/pkg/net/http/#Handler interface to capture MetricsEvent by wrapping an http.Handler
 
 Subject
 The subject is from the observer pattern https://en.wikipedia.org/wiki/Observer_pattern. It maintains a list of observers and notifies each one of incoming MetricsEvent
+Each observer has its own buffer, so a slow observer does not block the sources; see subject.NewSubject
 
 Observer
 Each Observer:
@@ -55,6 +57,7 @@ observers:
     * gometricsobserver stores and reports go-metrics events
     * grpcobserver stores and reports gRpc and HTTP events
     * logobserver dumps events to the log
+    * spanobserver exports sampled gRpc and HTTP transactions as trace spans
     * sinkobserver feeds events to a go-metrics MetricSink
         * We are particularly interested in using the go-metrics StatsiteSink
         which passes our metrics to a statsd server. See the sample Setup below.
@@ -13,5 +13,13 @@
 // limitations under the License.
 
 /*Package flatjson is used by the metrics server to convert key/value pairs to JSON
- */
+
+By default the keys are written as the members of a single flat object:
+
+    {"route/users/GET/requests": 2}
+
+With ModeOption(NestedMode), the keys are split at '/' into nested objects:
+
+    {"route": {"users": {"GET": {"requests": 2}}}}
+*/
 package flatjson
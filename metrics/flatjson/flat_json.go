@@ -16,24 +16,103 @@ package flatjson
 
 import (
 	"bufio"
-	"fmt"
 	"io"
+
+	"github.com/pkg/errors"
 )
 
+// Mode selects how a Writer lays out the keys
+type Mode int
+
+const (
+	// FlatMode writes every key as a member of a single object, e.g.
+	//     {"a/b": 1, "a/c": 2}
+	// The members are streamed as they are written.
+	FlatMode Mode = iota
+
+	// NestedMode splits the keys at KeySeparator into nested objects, e.g.
+	//     {"a": {"b": 1, "c": 2}}
+	// The members are held until Flush.
+	NestedMode
+)
+
+// KeySeparator separates the levels of a key in NestedMode
+const KeySeparator = "/"
+
+// NestedValueKey is the member that holds the value of a key that is also
+// the prefix of other keys in NestedMode: "a": 1 and "a/b": 2 are written
+// as {"a": {"_value": 1, "b": 2}}
+const NestedValueKey = "_value"
+
+// String returns the name of the mode, as accepted by ParseMode
+func (m Mode) String() string {
+	switch m {
+	case FlatMode:
+		return "flat"
+	case NestedMode:
+		return "nested"
+	}
+	return "unknown"
+}
+
+// ParseMode returns the mode named s; an empty string is FlatMode
+func ParseMode(s string) (Mode, error) {
+	switch s {
+	case "", "flat":
+		return FlatMode, nil
+	case "nested":
+		return NestedMode, nil
+	}
+	return FlatMode, errors.Errorf("unknown mode %q", s)
+}
+
 // Writer holds intermediate content for producing JSON
 type Writer struct {
-	writer    *bufio.Writer
-	count     int
-	prevKey   string
-	prevValue string
+	writer *bufio.Writer
+	mode   Mode
+	count  int
+	root   *node
+	visit  func(key string, metric Metric, value interface{}) error
+}
+
+// Label is a name and value that qualifies a Metric
+type Label struct {
+	Name  string
+	Value string
+}
+
+// Metric describes a key to formats that have metric names and labels,
+// such as the Prometheus text format, e.g. route/users/GET/requests is
+//     Metric{Name: "route_requests", Labels: []Label{{"route", "/users"}, {"method", "GET"}}}
+// The zero Metric means that the key has no structure beyond its text.
+type Metric struct {
+	Name   string
+	Labels []Label
+}
+
+// ModeOption returns a Writer option function that sets the output mode;
+// the default is FlatMode
+func ModeOption(mode Mode) func(*Writer) {
+	return func(w *Writer) {
+		w.mode = mode
+	}
 }
 
 // New creates a Flat JSON Writer
-func New(writer io.Writer) (*Writer, error) {
+func New(writer io.Writer, options ...func(*Writer)) (*Writer, error) {
 	w := &Writer{
 		writer: bufio.NewWriter(writer),
 	}
 
+	for _, f := range options {
+		f(w)
+	}
+
+	if w.mode == NestedMode {
+		w.root = newNode()
+		return w, nil
+	}
+
 	if _, err := w.writer.WriteString("{\n"); err != nil {
 		return nil, err
 	}
@@ -41,55 +120,86 @@ func New(writer io.Writer) (*Writer, error) {
 	return w, nil
 }
 
-// Write stores a value as JSON
+// NewVisitor creates a Writer that passes every key and value to visit
+// instead of writing JSON, for example to render reports in another format
+func NewVisitor(visit func(key string, value interface{}) error) *Writer {
+	return NewMetricVisitor(func(key string, _ Metric, value interface{}) error {
+		return visit(key, value)
+	})
+}
+
+// NewMetricVisitor creates a Writer like NewVisitor that also passes the
+// Metric given to WriteMetric; the Metric of a key given to Write is zero
+func NewMetricVisitor(visit func(key string, metric Metric, value interface{}) error) *Writer {
+	return &Writer{visit: visit}
+}
+
+// Write stores a value as JSON.
+// The value may be nil, a string, a bool, any integer or floating point
+// type, or a time.Duration, which is written in seconds. NaN and infinite
+// values are written as null, since JSON cannot represent them.
 func (w *Writer) Write(key string, value interface{}) error {
-	if err := w.writePrev(); err != nil {
-		return err
+	return w.WriteMetric(key, Metric{}, value)
+}
+
+// WriteMetric stores a value like Write. The JSON is the same, but a
+// Writer created by NewMetricVisitor passes metric to its visit function,
+// so that the structure of the key does not have to be parsed back out.
+func (w *Writer) WriteMetric(key string, metric Metric, value interface{}) error {
+	if w.visit != nil {
+		return w.visit(key, metric, value)
 	}
 
-	w.prevKey = key
-
-	switch n := value.(type) {
-	case string:
-		w.prevValue = fmt.Sprintf("\"%s\"", n)
-	case int, int32, int64, uint64:
-		w.prevValue = fmt.Sprintf("%d", n)
-	case float32, float64:
-		w.prevValue = fmt.Sprintf("%f", n)
-	default:
-		return fmt.Errorf("unwriteable type %T; %v", value, value)
+	encoded, err := appendValue(nil, value)
+	if err != nil {
+		return errors.Wrapf(err, "key %q", key)
 	}
 
-	return nil
+	if w.mode == NestedMode {
+		w.root.insert(key, encoded)
+		return nil
+	}
+
+	line := make([]byte, 0, len(key)+len(encoded)+8)
+	if w.count > 0 {
+		line = append(line, ",\n"...)
+	}
+	line = append(line, '\t')
+	line = appendString(line, key)
+	line = append(line, ": "...)
+	line = append(line, encoded...)
+	w.count++
+
+	_, err = w.writer.Write(line)
+	return err
 }
 
-// Flush writes the last line of JSON (if any) and the closing bracket
+// Flush writes the remaining JSON and the closing bracket
 func (w *Writer) Flush() error {
 	var err error
 
-	if w.count > 0 {
-		_, err = w.writer.WriteString(fmt.Sprintf("\t\"%s\": %s\n",
-			w.prevKey, w.prevValue))
-		if err != nil {
+	if w.visit != nil {
+		return nil
+	}
+
+	if w.mode == NestedMode {
+		if err = w.root.writeTo(w.writer, 0); err != nil {
+			return err
+		}
+	} else {
+		if w.count > 0 {
+			if err = w.writer.WriteByte('\n'); err != nil {
+				return err
+			}
+		}
+		if err = w.writer.WriteByte('}'); err != nil {
 			return err
 		}
 	}
 
-	if _, err = w.writer.WriteString("}\n"); err != nil {
+	if err = w.writer.WriteByte('\n'); err != nil {
 		return err
 	}
 
 	return w.writer.Flush()
 }
-
-func (w *Writer) writePrev() error {
-	if w.count > 0 {
-		_, err := w.writer.WriteString(fmt.Sprintf("\t\"%s\": %s,\n",
-			w.prevKey, w.prevValue))
-		if err != nil {
-			return err
-		}
-	}
-	w.count++
-	return nil
-}
@@ -17,7 +17,11 @@ package flatjson
 import (
 	"bytes"
 	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
 	"testing"
+	"time"
 )
 
 func TestFlatJSONWriter(t *testing.T) {
@@ -51,3 +55,185 @@ func TestFlatJSONWriter(t *testing.T) {
 		t.Fatalf("ts.bbb = %v", ts)
 	}
 }
+
+type namedCode uint32
+
+func TestWriteValues(t *testing.T) {
+	testCases := []struct {
+		value    interface{}
+		expected string
+	}{
+		{nil, `null`},
+		{true, `true`},
+		{int8(-8), `-8`},
+		{uint32(32), `32`},
+		{uint64(math.MaxUint64), `18446744073709551615`},
+		{float32(0.1), `0.1`},
+		{1e21, `1e+21`},
+		{1.5e-7, `1.5e-07`},
+		{math.NaN(), `null`},
+		{math.Inf(-1), `null`},
+		{1500 * time.Millisecond, `1.5`},
+		{namedCode(5), `5`},
+		{`say "hi"\`, `"say \"hi\"\\"`},
+		{"tab\tnewline\nbell\a", `"tab\tnewline\nbell\u0007"`},
+		{"bad \xff utf8", `"bad \ufffd utf8"`},
+	}
+
+	for i, tc := range testCases {
+		t.Run(fmt.Sprintf("%d: %T", i, tc.value), func(t *testing.T) {
+			encoded, err := appendValue(nil, tc.value)
+			if err != nil {
+				t.Fatalf("appendValue failed: %s", err)
+			}
+			if string(encoded) != tc.expected {
+				t.Fatalf("expected %s found %s", tc.expected, encoded)
+			}
+		})
+	}
+
+	if _, err := appendValue(nil, struct{}{}); err == nil {
+		t.Fatalf("expected error for struct")
+	}
+}
+
+func TestFlatJSONEscaping(t *testing.T) {
+	var buffer bytes.Buffer
+
+	w, err := New(&buffer)
+	if err != nil {
+		t.Fatalf("New failed: %s", err)
+	}
+	if err = w.Write(`route/"quoted"\path`, `C:\dir`); err != nil {
+		t.Fatalf("Write failed: %s", err)
+	}
+	if err = w.Flush(); err != nil {
+		t.Fatalf("Flush failed: %s", err)
+	}
+
+	var ts map[string]interface{}
+	if err = json.Unmarshal(buffer.Bytes(), &ts); err != nil {
+		t.Fatalf("json.Unmarshal failed: %s; %s", err, buffer.String())
+	}
+	if ts[`route/"quoted"\path`] != `C:\dir` {
+		t.Fatalf("unexpected %v", ts)
+	}
+}
+
+func TestNestedMode(t *testing.T) {
+	var buffer bytes.Buffer
+
+	w, err := New(&buffer, ModeOption(NestedMode))
+	if err != nil {
+		t.Fatalf("New failed: %s", err)
+	}
+	for _, kv := range []struct {
+		key   string
+		value interface{}
+	}{
+		{"Total/requests", 3},
+		{"route/users/GET/requests", 2},
+		{"route/users/GET/latency_ms.p99", 1.5},
+		{"route/users", "prefix"},
+		{"route/orders/POST/requests", 1},
+	} {
+		if err = w.Write(kv.key, kv.value); err != nil {
+			t.Fatalf("Write %s failed: %s", kv.key, err)
+		}
+	}
+	if err = w.Flush(); err != nil {
+		t.Fatalf("Flush failed: %s", err)
+	}
+
+	var ts map[string]interface{}
+	if err = json.Unmarshal(buffer.Bytes(), &ts); err != nil {
+		t.Fatalf("json.Unmarshal failed: %s; %s", err, buffer.String())
+	}
+	expected := map[string]interface{}{
+		"Total": map[string]interface{}{"requests": 3.0},
+		"route": map[string]interface{}{
+			"users": map[string]interface{}{
+				NestedValueKey: "prefix",
+				"GET": map[string]interface{}{
+					"requests":       2.0,
+					"latency_ms.p99": 1.5,
+				},
+			},
+			"orders": map[string]interface{}{
+				"POST": map[string]interface{}{"requests": 1.0},
+			},
+		},
+	}
+	if !reflect.DeepEqual(ts, expected) {
+		t.Fatalf("expected %v found %v", expected, ts)
+	}
+}
+
+func TestEmptyWriter(t *testing.T) {
+	for _, mode := range []Mode{FlatMode, NestedMode} {
+		var buffer bytes.Buffer
+		w, err := New(&buffer, ModeOption(mode))
+		if err != nil {
+			t.Fatalf("%s: New failed: %s", mode, err)
+		}
+		if err = w.Flush(); err != nil {
+			t.Fatalf("%s: Flush failed: %s", mode, err)
+		}
+		var ts map[string]interface{}
+		if err = json.Unmarshal(buffer.Bytes(), &ts); err != nil {
+			t.Fatalf("%s: json.Unmarshal failed: %s; %s", mode, err, buffer.String())
+		}
+	}
+}
+
+func TestParseMode(t *testing.T) {
+	for _, mode := range []Mode{FlatMode, NestedMode} {
+		parsed, err := ParseMode(mode.String())
+		if err != nil || parsed != mode {
+			t.Fatalf("%s: found %s, %v", mode, parsed, err)
+		}
+	}
+	if _, err := ParseMode("tree"); err == nil {
+		t.Fatalf("expected error for unknown mode")
+	}
+}
+
+func TestVisitor(t *testing.T) {
+	visited := make(map[string]interface{})
+	w := NewVisitor(func(key string, value interface{}) error {
+		visited[key] = value
+		return nil
+	})
+	if err := w.Write("aaa", 42); err != nil {
+		t.Fatalf("Write failed: %s", err)
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatalf("Flush failed: %s", err)
+	}
+	if visited["aaa"] != 42 {
+		t.Fatalf("expected 42 found %v", visited["aaa"])
+	}
+}
+
+func TestMetricVisitor(t *testing.T) {
+	metric := Metric{Name: "route_requests", Labels: []Label{{"route", "/users"}}}
+	visited := make(map[string]Metric)
+	w := NewMetricVisitor(func(key string, m Metric, value interface{}) error {
+		visited[key] = m
+		return nil
+	})
+	if err := w.WriteMetric("route/users/requests", metric, 2); err != nil {
+		t.Fatalf("WriteMetric failed: %s", err)
+	}
+	if err := w.Write("aaa", 42); err != nil {
+		t.Fatalf("Write failed: %s", err)
+	}
+
+	if m := visited["route/users/requests"]; m.Name != metric.Name ||
+		len(m.Labels) != 1 || m.Labels[0] != metric.Labels[0] {
+		t.Fatalf("expected %v found %v", metric, m)
+	}
+	if m, ok := visited["aaa"]; !ok || m.Name != "" || m.Labels != nil {
+		t.Fatalf("expected zero Metric found %v", m)
+	}
+}
@@ -0,0 +1,91 @@
+// Copyright 2017 Decipher Technology Studios LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package flatjson
+
+import (
+	"bufio"
+	"strings"
+)
+
+// node is an object in NestedMode; its members are kept in the order
+// they were first written
+type node struct {
+	value    []byte // the encoded value of the key ending here, if any
+	names    []string
+	children map[string]*node
+}
+
+func newNode() *node {
+	return &node{children: make(map[string]*node)}
+}
+
+// insert stores the value of key; a key written twice keeps the last value
+func (n *node) insert(key string, value []byte) {
+	for _, name := range strings.Split(key, KeySeparator) {
+		child, ok := n.children[name]
+		if !ok {
+			child = newNode()
+			n.children[name] = child
+			n.names = append(n.names, name)
+		}
+		n = child
+	}
+	n.value = value
+}
+
+func (n *node) writeTo(w *bufio.Writer, depth int) error {
+	if len(n.names) == 0 && n.value != nil {
+		_, err := w.Write(n.value)
+		return err
+	}
+
+	indent := strings.Repeat("\t", depth+1)
+	var line []byte
+
+	line = append(line, "{\n"...)
+	count := 0
+	if n.value != nil {
+		line = append(line, indent...)
+		line = appendString(line, NestedValueKey)
+		line = append(line, ": "...)
+		line = append(line, n.value...)
+		count++
+	}
+	for _, name := range n.names {
+		if count > 0 {
+			line = append(line, ",\n"...)
+		}
+		line = append(line, indent...)
+		line = appendString(line, name)
+		line = append(line, ": "...)
+		if _, err := w.Write(line); err != nil {
+			return err
+		}
+		line = line[:0]
+
+		if err := n.children[name].writeTo(w, depth+1); err != nil {
+			return err
+		}
+		count++
+	}
+	if count > 0 {
+		line = append(line, '\n')
+		line = append(line, indent[1:]...)
+	}
+	line = append(line, '}')
+
+	_, err := w.Write(line)
+	return err
+}
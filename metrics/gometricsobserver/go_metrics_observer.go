@@ -15,7 +15,6 @@
 package gometricsobserver
 
 import (
-	"strings"
 	"sync"
 	"time"
 
@@ -69,12 +68,13 @@ func New() *GoMetricsObserver {
 	}
 }
 
+// EventTypes implements the subject.FilteredObserver interface
+func (g *GoMetricsObserver) EventTypes() []string {
+	return []string{"go-metrics.*"}
+}
+
 // Observe implements the Observer interface
 func (g *GoMetricsObserver) Observe(event subject.MetricsEvent) {
-	if !strings.HasPrefix(event.EventType, "go-metrics.") {
-		return
-	}
-
 	g.Lock()
 	defer g.Unlock()
 
@@ -15,7 +15,8 @@
 /*Package grpcclient contains code for wrapping a grpc client
 
 It is used to preserve HTTP headers that we are interested in,
-particularly "x-request-id".
+particularly "x-request-id", and to pass the trace context span of the
+caller to the callee.
 
 Usage:
     opts := []grpc.DialOption{
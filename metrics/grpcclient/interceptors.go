@@ -21,6 +21,7 @@ import (
 	"google.golang.org/grpc/metadata"
 
 	"github.com/deciphernow/gm-fabric-go/metrics/headers"
+	"github.com/deciphernow/gm-fabric-go/metrics/tracecontext"
 )
 
 // UnaryClientInterceptor intercepts the execution of a unary RPC on the client.
@@ -52,11 +53,10 @@ func StreamClientInterceptor(
 func adjustContext(ctx oldcontext.Context) oldcontext.Context {
 	metaMap := make(map[string]string)
 
-	if inMD, ok := metadata.FromIncomingContext(ctx); ok {
-		for _, hkey := range headers.HeadersOfInterest {
-			if hval, ok := inMD[hkey]; ok {
-				metaMap[hkey] = hval[0]
-			}
+	inMD, _ := metadata.FromIncomingContext(ctx)
+	for _, hkey := range headers.HeadersOfInterest {
+		if hval, ok := inMD[hkey]; ok {
+			metaMap[hkey] = hval[0]
 		}
 	}
 
@@ -65,5 +65,13 @@ func adjustContext(ctx oldcontext.Context) oldcontext.Context {
 		metaMap[headers.RequestIDHeader] = requestID
 	}
 
+	// the span of the caller is the parent of the callee's span
+	span, ok := tracecontext.FromContext(ctx)
+	if !ok {
+		span = tracecontext.StartSpanFromMetadata(inMD)
+	}
+	span.SetMetadata(metaMap)
+	span.SetB3Metadata(metaMap)
+
 	return metadata.NewOutgoingContext(ctx, metadata.New(metaMap))
 }
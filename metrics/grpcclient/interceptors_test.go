@@ -21,8 +21,10 @@ import (
 	oldcontext "golang.org/x/net/context"
 
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
 
 	"github.com/deciphernow/gm-fabric-go/metrics/headers"
+	"github.com/deciphernow/gm-fabric-go/metrics/tracecontext"
 )
 
 // invoker implements grpc.UnaryInvoker
@@ -97,3 +99,85 @@ func TestStreamClientInterceptor(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestTraceContextPropagation(t *testing.T) {
+	parent := tracecontext.GenerateTraceParent()
+	span := tracecontext.StartSpan(parent.String(), "a=b")
+
+	testCases := []struct {
+		name          string
+		ctx           oldcontext.Context
+		expectedTrace [16]byte
+		expectedSpan  string
+	}{
+		{
+			name:          "span in context",
+			ctx:           tracecontext.NewContext(oldcontext.Background(), span),
+			expectedTrace: span.TraceID,
+			expectedSpan:  span.SpanIDAsString(),
+		},
+		{
+			name: "incoming metadata",
+			ctx: metadata.NewIncomingContext(
+				oldcontext.Background(),
+				metadata.Pairs(tracecontext.TraceParentMetadataKey, parent.String()),
+			),
+			expectedTrace: parent.TraceID,
+		},
+		{
+			name: "incoming b3 metadata",
+			ctx: metadata.NewIncomingContext(
+				oldcontext.Background(),
+				metadata.Pairs(
+					tracecontext.B3TraceIDHeaderName, parent.TraceIDAsString(),
+					tracecontext.B3SpanIDHeaderName, parent.SpanIDAsString(),
+					tracecontext.B3SampledHeaderName, "1",
+				),
+			),
+			expectedTrace: parent.TraceID,
+		},
+	}
+
+	for i, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var found string
+			var foundB3 tracecontext.B3
+			captureInvoker := func(
+				ctx oldcontext.Context,
+				method string,
+				req, reply interface{},
+				cc *grpc.ClientConn,
+				opts ...grpc.CallOption,
+			) error {
+				md, _ := metadata.FromOutgoingContext(ctx)
+				if values := md[tracecontext.TraceParentMetadataKey]; len(values) == 1 {
+					found = values[0]
+				}
+				foundB3, _ = tracecontext.ParseB3Metadata(md)
+				return nil
+			}
+
+			err := UnaryClientInterceptor(tc.ctx, "", nil, nil, nil, captureInvoker)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			tp, err := tracecontext.ParseTraceParent(found)
+			if err != nil {
+				t.Fatalf("%d: ParseTraceParent(%s) failed: %s", i, found, err)
+			}
+			if tp.TraceID != tc.expectedTrace {
+				t.Fatalf("%d: TraceID mismatch: %s", i, tp.TraceIDAsString())
+			}
+			if tc.expectedSpan != "" && tp.SpanIDAsString() != tc.expectedSpan {
+				t.Fatalf("%d: SpanID: expected %s found %s",
+					i, tc.expectedSpan, tp.SpanIDAsString())
+			}
+
+			// the B3 metadata pass on the same span
+			if foundB3.TraceID != tp.TraceID || foundB3.SpanID != tp.SpanID {
+				t.Fatalf("%d: B3 mismatch: %s", i, foundB3)
+			}
+		})
+	}
+}
@@ -23,5 +23,13 @@ usage:
 
     grpcServer := grpc.NewServer(opts...)
 
+The StatsHandler stores the trace context span of each RPC in its context.
+Servers without it can use the interceptors instead:
+
+    opts := []grpc.ServerOption{
+        grpc.UnaryInterceptor(grpcmetrics.UnaryServerInterceptor),
+        grpc.StreamInterceptor(grpcmetrics.StreamServerInterceptor),
+    }
+
 */
 package grpcmetrics
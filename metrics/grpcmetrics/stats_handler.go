@@ -26,6 +26,7 @@ import (
 
 	"github.com/deciphernow/gm-fabric-go/metrics/headers"
 	"github.com/deciphernow/gm-fabric-go/metrics/subject"
+	"github.com/deciphernow/gm-fabric-go/metrics/tracecontext"
 )
 
 // StatsHandler implements the stats.Handler interface
@@ -99,7 +100,10 @@ func (h *StatsHandler) TagRPC(
 	}
 
 	// return a context with the values set
-	return headers.SetRequestID(headers.SetPrevRoute(ctx, prevRoute), requestID)
+	return headers.SetRequestID(
+		headers.SetPrevRoute(contextWithSpan(ctx), prevRoute),
+		requestID,
+	)
 }
 
 // HandleConn processes the Conn stats.
@@ -120,6 +124,12 @@ func (h *StatsHandler) HandleRPC(
 	event.PrevRoute = headers.GetPrevRoute(ctx)
 	event.Tags = h.tags
 
+	if span, ok := tracecontext.FromContext(ctx); ok {
+		event.TraceID = span.TraceIDAsString()
+		event.SpanID = span.SpanIDAsString()
+		event.ParentSpanID = span.ParentSpanIDAsString()
+	}
+
 	switch st := s.(type) {
 	case *stats.InHeader:
 		event.EventType = "rpc.InHeader"
@@ -16,7 +16,58 @@ package grpcmetrics
 
 import (
 	"testing"
+	"time"
+
+	oldcontext "golang.org/x/net/context"
+
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/stats"
+
+	"github.com/deciphernow/gm-fabric-go/metrics/subject"
+	"github.com/deciphernow/gm-fabric-go/metrics/tracecontext"
 )
 
 func TestStatsHandler(t *testing.T) {
+	parent := tracecontext.GenerateTraceParent()
+
+	metricsChan := make(chan subject.MetricsEvent, 2)
+	h := NewStatsHandler(metricsChan)
+
+	ctx := metadata.NewIncomingContext(
+		oldcontext.Background(),
+		metadata.Pairs(tracecontext.TraceParentMetadataKey, parent.String()),
+	)
+	ctx = h.TagRPC(ctx, &stats.RPCTagInfo{FullMethodName: "/test.Test/Method"})
+
+	span, ok := tracecontext.FromContext(ctx)
+	if !ok {
+		t.Fatal("no span in the RPC context")
+	}
+	if span.TraceID != parent.TraceID || span.ParentSpanID != parent.SpanID {
+		t.Fatalf("span %s is not a child of %s", span.TraceParent, parent)
+	}
+
+	// the interceptors must not start a second span
+	if spanCtx := contextWithSpan(ctx); spanCtx != ctx {
+		t.Fatal("contextWithSpan replaced the span of the StatsHandler")
+	}
+
+	h.HandleRPC(ctx, &stats.Begin{BeginTime: time.Now()})
+	h.HandleRPC(ctx, &stats.End{EndTime: time.Now()})
+
+	for i := 0; i < 2; i++ {
+		event := <-metricsChan
+		if event.TraceID != parent.TraceIDAsString() {
+			t.Fatalf("%s: TraceID: expected %s found %s",
+				event.EventType, parent.TraceIDAsString(), event.TraceID)
+		}
+		if event.SpanID != span.SpanIDAsString() {
+			t.Fatalf("%s: SpanID: expected %s found %s",
+				event.EventType, span.SpanIDAsString(), event.SpanID)
+		}
+		if event.ParentSpanID != parent.SpanIDAsString() {
+			t.Fatalf("%s: ParentSpanID: expected %s found %s",
+				event.EventType, parent.SpanIDAsString(), event.ParentSpanID)
+		}
+	}
 }
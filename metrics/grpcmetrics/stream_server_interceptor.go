@@ -15,15 +15,37 @@
 package grpcmetrics
 
 import (
+	oldcontext "golang.org/x/net/context"
+
 	"google.golang.org/grpc"
 )
 
-// streamMetricsInterceptor implements the type grpc.UnaryServerInterceptor
-func streamMetricsInterceptor(
+// StreamServerInterceptor implements the type grpc.StreamServerInterceptor
+// It passes the span of the RPC to handler in the stream's context, see
+// package tracecontext. It is only needed when the server has no
+// StatsHandler, which does the same.
+func StreamServerInterceptor(
 	srv interface{},
 	ss grpc.ServerStream,
 	info *grpc.StreamServerInfo,
 	handler grpc.StreamHandler,
 ) error {
+	ctx := ss.Context()
+	spanCtx := contextWithSpan(ctx)
+	if spanCtx != ctx {
+		ss = spanServerStream{ServerStream: ss, ctx: spanCtx}
+	}
+
 	return handler(srv, ss)
 }
+
+// spanServerStream is a grpc.ServerStream whose context carries a span
+type spanServerStream struct {
+	grpc.ServerStream
+	ctx oldcontext.Context
+}
+
+// Context implements the grpc.ServerStream interface
+func (s spanServerStream) Context() oldcontext.Context {
+	return s.ctx
+}
@@ -0,0 +1,40 @@
+// Copyright 2017 Decipher Technology Studios LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package grpcmetrics
+
+import (
+	oldcontext "golang.org/x/net/context"
+
+	"google.golang.org/grpc/metadata"
+
+	"github.com/deciphernow/gm-fabric-go/metrics/tracecontext"
+)
+
+// contextWithSpan returns a context carrying the span of an incoming RPC.
+// If ctx already carries a span, set by the StatsHandler or an interceptor,
+// ctx is returned; otherwise a span is started from the trace context, W3C
+// or B3, in the incoming metadata.
+func contextWithSpan(ctx oldcontext.Context) oldcontext.Context {
+	if _, ok := tracecontext.FromContext(ctx); ok {
+		return ctx
+	}
+
+	inMD, _ := metadata.FromIncomingContext(ctx)
+
+	return tracecontext.NewContext(
+		ctx,
+		tracecontext.StartSpanFromMetadata(inMD),
+	)
+}
@@ -20,12 +20,15 @@ import (
 	"google.golang.org/grpc"
 )
 
-// unaryMetricsInterceptor implements the type grpc.UnaryServerInterceptor
-func unaryMetricsInterceptor(
+// UnaryServerInterceptor implements the type grpc.UnaryServerInterceptor
+// It passes the span of the RPC to handler in its context, see package
+// tracecontext. It is only needed when the server has no StatsHandler,
+// which does the same.
+func UnaryServerInterceptor(
 	ctx oldcontext.Context,
 	req interface{},
 	info *grpc.UnaryServerInfo,
 	handler grpc.UnaryHandler,
 ) (resp interface{}, err error) {
-	return handler(ctx, req)
+	return handler(contextWithSpan(ctx), req)
 }
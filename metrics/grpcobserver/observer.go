@@ -15,10 +15,11 @@
 package grpcobserver
 
 import (
-	"strings"
 	"sync"
 	"time"
 
+	"google.golang.org/grpc/status"
+
 	"github.com/deciphernow/gm-fabric-go/metrics/apistats"
 	"github.com/deciphernow/gm-fabric-go/metrics/subject"
 )
@@ -31,11 +32,22 @@ func Accumulate(entry apistats.APIStatsEntry, event subject.MetricsEvent) (apist
 	switch event.EventType {
 	case "rpc.InHeader":
 		entry.Key = event.Key
+		entry.Method = event.Method
 		entry.Transport = event.Transport
 		entry.PrevRoute = event.PrevRoute
 		entry.InWireLength += numericEventValue(event.Value)
+	case "rpc.OutHeader":
+		// the header of an outgoing request identifies the transaction
+		if event.Transport.IsClient() {
+			entry.Key = event.Key
+			entry.Method = event.Method
+			entry.Transport = event.Transport
+			entry.PrevRoute = event.PrevRoute
+		}
 	case "rpc.Begin":
 		entry.BeginTime = event.Timestamp
+	case "rpc.Retry":
+		entry.Retries++
 	case "rpc.InPayload":
 		entry.InWireLength += numericEventValue(event.Value)
 	case "rpc.InTrailer":
@@ -50,6 +62,10 @@ func Accumulate(entry apistats.APIStatsEntry, event subject.MetricsEvent) (apist
 		if event.Value != nil {
 			entry.Err = event.Value.(error)
 		}
+		if entry.Transport == subject.EventTransportRPC ||
+			entry.Transport == subject.EventTransportRPCWithTLS {
+			entry.GRPCCode = status.Code(entry.Err)
+		}
 		end = true
 	}
 
@@ -69,22 +85,31 @@ type GRPCObserver struct {
 
 // New returns an entity that supports the Observer interface and which
 // can register HTTP handler functions
+// The options are passed to apistats.New
 func New(
 	cacheSize int,
+	options ...func(*apistats.APIStats),
 ) *GRPCObserver {
 	return &GRPCObserver{
 		active:   make(map[string]apistats.APIStatsEntry),
-		apiStats: apistats.New(cacheSize),
+		apiStats: apistats.New(cacheSize, options...),
 	}
 }
 
+// APIStats returns the stats accumulated by the observer, for example to
+// save and restore snapshots with an apistats.Snapshotter
+func (obs *GRPCObserver) APIStats() *apistats.APIStats {
+	return obs.apiStats
+}
+
+// EventTypes implements the subject.FilteredObserver interface
+func (obs *GRPCObserver) EventTypes() []string {
+	return []string{"rpc.*"}
+}
+
 // Observe implements the subject.Observer interface, an instance of
 // the observer design pattern
 func (obs *GRPCObserver) Observe(event subject.MetricsEvent) {
-	if !strings.HasPrefix(event.EventType, "rpc.") {
-		return
-	}
-
 	obs.Lock()
 	defer obs.Unlock()
 
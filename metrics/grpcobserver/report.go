@@ -0,0 +1,45 @@
+// Copyright 2017 Decipher Technology Studios LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package grpcobserver
+
+import (
+	"github.com/deciphernow/gm-fabric-go/metrics/apistats"
+	"github.com/deciphernow/gm-fabric-go/metrics/flatjson"
+)
+
+// Report implements the metricsserver ReportFunc
+func (obs *GRPCObserver) Report(jWriter *flatjson.Writer) error {
+	return obs.apiStats.Report(jWriter)
+}
+
+// ReportDelta implements the metricsserver DeltaReportFunc
+func (obs *GRPCObserver) ReportDelta(jWriter *flatjson.Writer, consumer string) error {
+	return obs.apiStats.ReportDelta(jWriter, consumer)
+}
+
+// GetEndpointStats implements the apistats.EndpointStatsGetter interface
+func (obs *GRPCObserver) GetEndpointStats() (map[string]apistats.APIEndpointStats, error) {
+	return obs.apiStats.GetEndpointStats()
+}
+
+// GetCumulativeCounts implements the apistats.EndpointStatsGetter interface
+func (obs *GRPCObserver) GetCumulativeCounts() apistats.CumulativeCounts {
+	return obs.apiStats.GetCumulativeCounts()
+}
+
+// GetCumulativeCountsDelta implements the apistats.DeltaCountsGetter interface
+func (obs *GRPCObserver) GetCumulativeCountsDelta(consumer string) apistats.CumulativeCounts {
+	return obs.apiStats.GetCumulativeCountsDelta(consumer)
+}
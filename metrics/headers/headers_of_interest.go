@@ -38,9 +38,10 @@ const (
 var HeadersOfInterest = []string{
 	"x-ot-span-context",
 	RequestIDHeader,
+	"b3",
 	"x-b3-traceid",
 	"x-b3-spanid",
-	"x-bs-parentspanid",
+	"x-b3-parentspanid",
 	"x-b3-sampled",
 	"x-b3-flags",
 	"x-client-trace-id",
@@ -0,0 +1,50 @@
+// Copyright 2017 Decipher Technology Studios LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+/*Package httpclient instruments outgoing HTTP requests
+
+Transport is an http.RoundTripper that passes the request ID and the trace
+context of the request being handled on to the requests it makes, like
+grpcclient does for gRPC calls, and reports each outgoing request:
+
+    - as MetricsEvents with a client transport (subject.EventTransportHTTPClient
+      or subject.EventTransportHTTPSClient), which grpcobserver keeps under
+      keys of the form client/<host><path>, apart from the totals of the
+      requests received, and spanobserver exports as client spans
+    - to a Collector such as a prometheus.CollectorType, which records it in
+      the http_client_* metrics if created with HTTPClientMetricsOption
+
+The request ID and trace context are taken from the context of the request,
+as set by httpmetrics, httpmeta or grpcmetrics. Each request is a span of
+its own, a child of the span in the context.
+
+Transport can retry requests that failed without a response, or with a
+502, 503 or 504 status, if they are idempotent. The retries are reported
+with the request, which is reported once, when the caller closes the
+response body or reads it to the end.
+
+usage:
+    client := &http.Client{
+        Transport: httpclient.NewTransport(
+            metricsChan,
+            httpclient.CollectorOption(collector),
+            httpclient.RetryOption(2, httpclient.DefaultRetryBackoff),
+        ),
+    }
+
+    req, err := http.NewRequest("GET", "http://catalog/items", nil)
+    ...
+    resp, err := client.Do(req.WithContext(inReq.Context()))
+*/
+package httpclient
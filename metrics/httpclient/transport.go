@@ -0,0 +1,444 @@
+// Copyright 2017 Decipher Technology Studios LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package httpclient
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc/metadata"
+
+	"github.com/deciphernow/gm-fabric-go/metrics/apistats"
+	"github.com/deciphernow/gm-fabric-go/metrics/headers"
+	"github.com/deciphernow/gm-fabric-go/metrics/keyfunc"
+	"github.com/deciphernow/gm-fabric-go/metrics/subject"
+	"github.com/deciphernow/gm-fabric-go/metrics/tracecontext"
+)
+
+// DefaultRetryBackoff is the wait before the first retry; it doubles
+// with each retry
+const DefaultRetryBackoff = 100 * time.Millisecond
+
+// Collector records outgoing requests, for example a prometheus.CollectorType
+type Collector interface {
+	Collect(entry apistats.APIStatsEntry, rawKey string, method string) error
+}
+
+// Transport implements the http.RoundTripper interface
+// It passes the request ID and trace context on to outgoing requests and
+// reports them as MetricsEvents and to a Collector
+type Transport struct {
+	next         http.RoundTripper
+	metricsChan  chan<- subject.MetricsEvent
+	collector    Collector
+	keyFunc      keyfunc.HTTPKeyFunc
+	tags         []string
+	maxRetries   int
+	retryBackoff time.Duration
+	logger       *log.Logger
+}
+
+// NextOption returns a transport option function that sets the
+// RoundTripper that makes the requests; the default is http.DefaultTransport
+func NextOption(next http.RoundTripper) func(*Transport) {
+	return func(t *Transport) {
+		t.next = next
+	}
+}
+
+// CollectorOption returns a transport option function that reports
+// each request to collector as well
+func CollectorOption(collector Collector) func(*Transport) {
+	return func(t *Transport) {
+		t.collector = collector
+	}
+}
+
+// KeyFuncOption returns a transport option function that sets the key
+// function; the metrics key of a request is client/<host><key>.
+// The default is keyfunc.DefaultHTTPKeyFunc, the path of the request.
+func KeyFuncOption(keyFunc keyfunc.HTTPKeyFunc) func(*Transport) {
+	return func(t *Transport) {
+		t.keyFunc = keyFunc
+	}
+}
+
+// TagsOption returns a transport option function that sets the tags of
+// the MetricsEvents
+func TagsOption(tags []string) func(*Transport) {
+	return func(t *Transport) {
+		t.tags = tags
+	}
+}
+
+// RetryOption returns a transport option function that retries an
+// idempotent request up to maxRetries times, waiting backoff before the
+// first retry and twice as long before each of the next; the default is
+// no retries
+func RetryOption(maxRetries int, backoff time.Duration) func(*Transport) {
+	return func(t *Transport) {
+		t.maxRetries = maxRetries
+		t.retryBackoff = backoff
+	}
+}
+
+// LoggerOption returns a transport option function that sets the logger
+// for Collector errors; the default logs to stderr
+func LoggerOption(logger *log.Logger) func(*Transport) {
+	return func(t *Transport) {
+		t.logger = logger
+	}
+}
+
+// NewTransport returns a RoundTripper that reports outgoing requests to
+// metricsChan, which may be nil if they are only reported to a Collector
+func NewTransport(
+	metricsChan chan<- subject.MetricsEvent,
+	options ...func(*Transport),
+) *Transport {
+	t := Transport{
+		next:        http.DefaultTransport,
+		metricsChan: metricsChan,
+		keyFunc:     keyfunc.DefaultHTTPKeyFunc,
+	}
+	for _, f := range options {
+		f(&t)
+	}
+	if t.logger == nil {
+		t.logger = log.New(os.Stderr, "", log.LstdFlags)
+	}
+
+	return &t
+}
+
+// clientRequest is an outgoing request being reported
+type clientRequest struct {
+	t     *Transport
+	event subject.MetricsEvent
+	entry apistats.APIStatsEntry
+	once  sync.Once
+}
+
+// RoundTrip implements the http.RoundTripper interface
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	md := contextMetadata(ctx)
+
+	// the request of the caller may not be modified
+	outReq := new(http.Request)
+	*outReq = *req
+	outReq.Header = make(http.Header, len(req.Header)+8)
+	for name, values := range req.Header {
+		outReq.Header[name] = values
+	}
+
+	if outReq.Header.Get(headers.RequestIDHeader) == "" {
+		if requestID := headers.GetRequestID(ctx); requestID != "" {
+			outReq.Header.Set(headers.RequestIDHeader, requestID)
+		}
+	}
+	for _, hkey := range headers.HeadersOfInterest {
+		if values := md[hkey]; len(values) > 0 && outReq.Header.Get(hkey) == "" {
+			outReq.Header.Set(hkey, values[0])
+		}
+	}
+	if outReq.Header.Get(headers.RequestIDHeader) == "" {
+		outReq.Header.Set(headers.RequestIDHeader, headers.NewRequestID())
+	}
+
+	// the request is a span of its own, the parent of the callee's span
+	var span tracecontext.Span
+	if parent, ok := tracecontext.FromContext(ctx); ok {
+		span = parent.NewChild()
+	} else {
+		span = tracecontext.StartSpanFromMetadata(md)
+	}
+	span.SetHeader(outReq.Header)
+	span.SetB3Header(outReq.Header)
+
+	cr := t.begin(outReq, span)
+
+	resp, outBytes, err := cr.roundTrip(outReq)
+
+	cr.entry.OutWireLength = outBytes
+	cr.send("rpc.OutPayload", outBytes)
+
+	if err != nil {
+		cr.end(nil, 0, err)
+		return nil, err
+	}
+
+	if resp.Body == nil || resp.Body == http.NoBody {
+		cr.end(resp, 0, nil)
+		return resp, nil
+	}
+
+	resp.Body = &responseBody{next: resp.Body, cr: cr, resp: resp}
+
+	return resp, nil
+}
+
+// begin reports the start of a request
+func (t *Transport) begin(req *http.Request, span tracecontext.Span) *clientRequest {
+	transport := subject.EventTransportHTTPClient
+	if req.URL.Scheme == "https" {
+		transport = subject.EventTransportHTTPSClient
+	}
+
+	method := req.Method
+	if method == "" {
+		method = "GET"
+	}
+
+	cr := clientRequest{
+		t: t,
+		event: subject.MetricsEvent{
+			// each request is a transaction of its own, whatever the
+			// x-request-id of the request
+			RequestID:    headers.NewRequestID(),
+			TraceID:      span.TraceIDAsString(),
+			SpanID:       span.SpanIDAsString(),
+			ParentSpanID: span.ParentSpanIDAsString(),
+			Tags:         t.tags,
+		},
+		entry: apistats.APIStatsEntry{
+			Key:       fmt.Sprintf("client/%s%s", req.URL.Host, t.keyFunc(req)),
+			Transport: transport,
+			Method:    method,
+			PrevRoute: headers.GetPrevRoute(req.Context()),
+			BeginTime: time.Now(),
+		},
+	}
+
+	cr.sendEvent(subject.MetricsEvent{
+		EventType: "rpc.Begin",
+		Timestamp: cr.entry.BeginTime,
+	})
+	cr.sendEvent(subject.MetricsEvent{
+		EventType: "rpc.OutHeader",
+		Transport: cr.entry.Transport,
+		Key:       cr.entry.Key,
+		Method:    cr.entry.Method,
+		PrevRoute: cr.entry.PrevRoute,
+		Timestamp: cr.entry.BeginTime,
+	})
+
+	return &cr
+}
+
+// roundTrip makes the request, retrying it if need be; it returns the
+// response and the number of bytes of request body sent
+func (cr *clientRequest) roundTrip(req *http.Request) (*http.Response, int64, error) {
+	t := cr.t
+	var outBytes int64
+
+	for attempt := 0; ; attempt++ {
+		attemptReq := req
+		if attempt > 0 && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, outBytes, err
+			}
+			attemptReq = new(http.Request)
+			*attemptReq = *req
+			attemptReq.Body = body
+		}
+
+		var counter *requestBody
+		if attemptReq.Body != nil && attemptReq.Body != http.NoBody {
+			counter = &requestBody{next: attemptReq.Body}
+			if attemptReq == req {
+				attemptReq = new(http.Request)
+				*attemptReq = *req
+			}
+			attemptReq.Body = counter
+		}
+
+		resp, err := t.next.RoundTrip(attemptReq)
+		if counter != nil {
+			outBytes += atomic.LoadInt64(&counter.bytes)
+		}
+
+		if attempt >= t.maxRetries || !retryable(req, resp, err) {
+			return resp, outBytes, err
+		}
+
+		if resp != nil {
+			// read the body so the connection can be reused
+			io.Copy(ioutil.Discard, resp.Body)
+			resp.Body.Close()
+		}
+
+		timer := time.NewTimer(t.retryBackoff << uint(attempt))
+		select {
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, outBytes, req.Context().Err()
+		case <-timer.C:
+		}
+
+		cr.entry.Retries++
+		cr.send("rpc.Retry", cr.entry.Retries)
+	}
+}
+
+// retryable returns true if a request may be made again after it failed
+// with err or resp
+func retryable(req *http.Request, resp *http.Response, err error) bool {
+	if req.Context().Err() != nil {
+		return false
+	}
+
+	switch req.Method {
+	case "", "GET", "HEAD", "OPTIONS", "TRACE", "PUT", "DELETE":
+	default:
+		if req.Header.Get("Idempotency-Key") == "" {
+			return false
+		}
+	}
+
+	// the body must be sent again
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		return false
+	}
+
+	if err != nil {
+		return true
+	}
+
+	switch resp.StatusCode {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+
+	return false
+}
+
+// contextMetadata returns the gRPC metadata of the request being handled:
+// the outgoing metadata set by httpmeta, or the metadata of an incoming RPC
+func contextMetadata(ctx context.Context) metadata.MD {
+	if md, ok := metadata.FromOutgoingContext(ctx); ok {
+		return md
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+
+	return md
+}
+
+// sendEvent sends an event of the request to the metrics channel
+func (cr *clientRequest) sendEvent(event subject.MetricsEvent) {
+	if cr.t.metricsChan == nil {
+		return
+	}
+
+	event.RequestID = cr.event.RequestID
+	event.TraceID = cr.event.TraceID
+	event.SpanID = cr.event.SpanID
+	event.ParentSpanID = cr.event.ParentSpanID
+	event.Tags = cr.event.Tags
+	if event.Timestamp.IsZero() {
+		event.Timestamp = time.Now()
+	}
+
+	cr.t.metricsChan <- event
+}
+
+func (cr *clientRequest) send(eventType string, value int64) {
+	cr.sendEvent(subject.MetricsEvent{EventType: eventType, Value: value})
+}
+
+// end reports the end of the request, once
+func (cr *clientRequest) end(resp *http.Response, inBytes int64, err error) {
+	cr.once.Do(func() {
+		cr.entry.EndTime = time.Now()
+		cr.entry.InWireLength = inBytes
+		cr.entry.Err = err
+		if resp != nil {
+			cr.entry.HTTPStatus = resp.StatusCode
+		}
+
+		cr.send("rpc.InPayload", inBytes)
+		cr.sendEvent(subject.MetricsEvent{
+			EventType:  "rpc.End",
+			Timestamp:  cr.entry.EndTime,
+			HTTPStatus: cr.entry.HTTPStatus,
+			Value:      err,
+		})
+
+		if cr.t.collector != nil {
+			method := strings.ToUpper(cr.entry.Method)
+			if err := cr.t.collector.Collect(cr.entry, cr.entry.Key, method); err != nil {
+				cr.t.logger.Printf("httpclient: Collect: %s", err)
+			}
+		}
+	})
+}
+
+// requestBody counts the bytes of a request body sent; the RoundTripper
+// may still be sending it when it returns the response
+type requestBody struct {
+	next  io.ReadCloser
+	bytes int64
+}
+
+// Read implements the io.Reader interface
+func (b *requestBody) Read(p []byte) (int, error) {
+	n, err := b.next.Read(p)
+	atomic.AddInt64(&b.bytes, int64(n))
+
+	return n, err
+}
+
+// Close implements the io.Closer interface
+func (b *requestBody) Close() error {
+	return b.next.Close()
+}
+
+// responseBody counts the bytes read from a response body, and reports
+// the end of the request when it is read to the end or closed
+type responseBody struct {
+	next  io.ReadCloser
+	cr    *clientRequest
+	resp  *http.Response
+	bytes int64
+}
+
+// Read implements the io.Reader interface
+func (b *responseBody) Read(p []byte) (int, error) {
+	n, err := b.next.Read(p)
+	bytes := atomic.AddInt64(&b.bytes, int64(n))
+	if err == io.EOF {
+		b.cr.end(b.resp, bytes, nil)
+	}
+
+	return n, err
+}
+
+// Close implements the io.Closer interface
+func (b *responseBody) Close() error {
+	err := b.next.Close()
+	b.cr.end(b.resp, atomic.LoadInt64(&b.bytes), nil)
+
+	return err
+}
@@ -0,0 +1,330 @@
+// Copyright 2017 Decipher Technology Studios LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package httpclient
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/deciphernow/gm-fabric-go/metrics/apistats"
+	"github.com/deciphernow/gm-fabric-go/metrics/headers"
+	"github.com/deciphernow/gm-fabric-go/metrics/subject"
+	"github.com/deciphernow/gm-fabric-go/metrics/tracecontext"
+)
+
+// fakeCollector records the entries it collects
+type fakeCollector struct {
+	sync.Mutex
+	entries []apistats.APIStatsEntry
+	methods []string
+}
+
+func (c *fakeCollector) Collect(entry apistats.APIStatsEntry, rawKey string, method string) error {
+	c.Lock()
+	defer c.Unlock()
+	c.entries = append(c.entries, entry)
+	c.methods = append(c.methods, method)
+	return nil
+}
+
+// statusServer returns the statuses in turn, then 200, and records the
+// headers of the requests it receives
+type statusServer struct {
+	sync.Mutex
+	statuses []int
+	headers  []http.Header
+}
+
+func (s *statusServer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	ioutil.ReadAll(req.Body)
+
+	s.Lock()
+	s.headers = append(s.headers, req.Header)
+	status := http.StatusOK
+	if len(s.statuses) > 0 {
+		status, s.statuses = s.statuses[0], s.statuses[1:]
+	}
+	s.Unlock()
+
+	w.WriteHeader(status)
+	fmt.Fprint(w, "0123456789")
+}
+
+func newTestTransport(
+	metricsChan chan<- subject.MetricsEvent,
+	options ...func(*Transport),
+) *Transport {
+	options = append(
+		[]func(*Transport){
+			RetryOption(0, time.Millisecond),
+			LoggerOption(log.New(ioutil.Discard, "", 0)),
+		},
+		options...,
+	)
+	return NewTransport(metricsChan, options...)
+}
+
+func TestHeaderPropagation(t *testing.T) {
+	server := &statusServer{}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	parent := tracecontext.StartSpan("", "")
+	ctx := headers.SetRequestID(context.Background(), "req-1")
+	ctx = tracecontext.NewContext(ctx, parent)
+
+	req, err := http.NewRequest("GET", ts.URL+"/items", nil)
+	if err != nil {
+		t.Fatalf("http.NewRequest failed: %s", err)
+	}
+	req.Header.Set("X-Caller", "test")
+
+	client := http.Client{Transport: newTestTransport(nil)}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		t.Fatalf("client.Do failed: %s", err)
+	}
+	resp.Body.Close()
+
+	if len(req.Header) != 1 {
+		t.Fatalf("the caller's request was modified: %v", req.Header)
+	}
+
+	header := server.headers[0]
+	if requestID := header.Get(headers.RequestIDHeader); requestID != "req-1" {
+		t.Fatalf("request ID: expected req-1 found %q", requestID)
+	}
+	if caller := header.Get("X-Caller"); caller != "test" {
+		t.Fatalf("X-Caller: expected test found %q", caller)
+	}
+
+	span := tracecontext.StartSpanFromHeader(header)
+	if span.TraceID != parent.TraceID {
+		t.Fatalf("trace ID: expected %s found %s",
+			parent.TraceIDAsString(), span.TraceIDAsString())
+	}
+	// the callee's parent is the span of the outgoing request, a child of
+	// the span in the context
+	if span.ParentSpanID == parent.SpanID {
+		t.Fatal("the outgoing request has no span of its own")
+	}
+
+	b3, err := tracecontext.ParseB3Header(header)
+	if err != nil {
+		t.Fatalf("ParseB3Header failed: %s", err)
+	}
+	if b3.SpanID != span.ParentSpanID || b3.ParentSpanID != parent.SpanID {
+		t.Fatalf("b3 %s does not match traceparent %s",
+			b3, header.Get(tracecontext.TraceParentHeaderName))
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	testCases := []struct {
+		name            string
+		method          string
+		statuses        []int
+		maxRetries      int
+		expectedStatus  int
+		expectedRetries int64
+		expectedOut     int64
+	}{
+		{
+			name:           "ok",
+			method:         "GET",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "post",
+			method:         "POST",
+			expectedStatus: http.StatusOK,
+			expectedOut:    5,
+		},
+		{
+			name:            "retried",
+			method:          "GET",
+			statuses:        []int{http.StatusServiceUnavailable, http.StatusBadGateway},
+			maxRetries:      2,
+			expectedStatus:  http.StatusOK,
+			expectedRetries: 2,
+		},
+		{
+			name:            "retries exhausted",
+			method:          "PUT",
+			statuses:        []int{http.StatusServiceUnavailable, http.StatusServiceUnavailable},
+			maxRetries:      1,
+			expectedStatus:  http.StatusServiceUnavailable,
+			expectedRetries: 1,
+			expectedOut:     10,
+		},
+		{
+			name:           "not idempotent",
+			method:         "POST",
+			statuses:       []int{http.StatusServiceUnavailable},
+			maxRetries:     2,
+			expectedStatus: http.StatusServiceUnavailable,
+			expectedOut:    5,
+		},
+		{
+			name:           "not retryable",
+			method:         "GET",
+			statuses:       []int{http.StatusInternalServerError},
+			maxRetries:     2,
+			expectedStatus: http.StatusInternalServerError,
+		},
+	}
+
+	for i, tc := range testCases {
+		t.Run(fmt.Sprintf("%d: %s", i, tc.name), func(t *testing.T) {
+			server := &statusServer{statuses: tc.statuses}
+			ts := httptest.NewServer(server)
+			defer ts.Close()
+
+			metricsChan := make(chan subject.MetricsEvent, 100)
+			collector := &fakeCollector{}
+			transport := newTestTransport(
+				metricsChan,
+				CollectorOption(collector),
+				RetryOption(tc.maxRetries, time.Millisecond),
+			)
+
+			var body io.Reader
+			if tc.method != "GET" {
+				body = strings.NewReader("abcde")
+			}
+			req, err := http.NewRequest(tc.method, ts.URL+"/items", body)
+			if err != nil {
+				t.Fatalf("http.NewRequest failed: %s", err)
+			}
+
+			client := http.Client{Transport: transport}
+			resp, err := client.Do(req)
+			if err != nil {
+				t.Fatalf("client.Do failed: %s", err)
+			}
+			if resp.StatusCode != tc.expectedStatus {
+				t.Fatalf("status: expected %d found %d", tc.expectedStatus, resp.StatusCode)
+			}
+			if len(collector.entries) != 0 {
+				t.Fatal("request reported before the body was read")
+			}
+			ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+
+			if len(collector.entries) != 1 {
+				t.Fatalf("expected 1 entry, found %d", len(collector.entries))
+			}
+			entry := collector.entries[0]
+			expectedKey := "client/" + strings.TrimPrefix(ts.URL, "http://") + "/items"
+			if entry.Key != expectedKey {
+				t.Fatalf("key: expected %s found %s", expectedKey, entry.Key)
+			}
+			if entry.Transport != subject.EventTransportHTTPClient {
+				t.Fatalf("transport: expected %d found %d",
+					subject.EventTransportHTTPClient, entry.Transport)
+			}
+			if collector.methods[0] != tc.method {
+				t.Fatalf("method: expected %s found %s", tc.method, collector.methods[0])
+			}
+			if entry.HTTPStatus != tc.expectedStatus {
+				t.Fatalf("entry status: expected %d found %d", tc.expectedStatus, entry.HTTPStatus)
+			}
+			if entry.Retries != tc.expectedRetries {
+				t.Fatalf("retries: expected %d found %d", tc.expectedRetries, entry.Retries)
+			}
+			if entry.OutWireLength != tc.expectedOut {
+				t.Fatalf("out bytes: expected %d found %d", tc.expectedOut, entry.OutWireLength)
+			}
+			if entry.InWireLength != 10 {
+				t.Fatalf("in bytes: expected 10 found %d", entry.InWireLength)
+			}
+			if entry.Err != nil {
+				t.Fatalf("unexpected error: %s", entry.Err)
+			}
+
+			events := drain(metricsChan)
+			if first := events[0].EventType; first != "rpc.Begin" {
+				t.Fatalf("first event: expected rpc.Begin found %s", first)
+			}
+			if last := events[len(events)-1]; last.EventType != "rpc.End" ||
+				last.HTTPStatus != tc.expectedStatus {
+				t.Fatalf("unexpected last event %+v", last)
+			}
+			for _, event := range events {
+				if event.RequestID != events[0].RequestID {
+					t.Fatalf("request ID: expected %s found %s",
+						events[0].RequestID, event.RequestID)
+				}
+			}
+		})
+	}
+}
+
+func TestRoundTripError(t *testing.T) {
+	ts := httptest.NewServer(&statusServer{})
+	url := ts.URL
+	ts.Close()
+
+	metricsChan := make(chan subject.MetricsEvent, 100)
+	collector := &fakeCollector{}
+	client := http.Client{
+		Transport: newTestTransport(
+			metricsChan,
+			CollectorOption(collector),
+			RetryOption(1, time.Millisecond),
+		),
+	}
+
+	if _, err := client.Get(url + "/items"); err == nil {
+		t.Fatal("expected an error")
+	}
+
+	if len(collector.entries) != 1 {
+		t.Fatalf("expected 1 entry, found %d", len(collector.entries))
+	}
+	entry := collector.entries[0]
+	if entry.Err == nil {
+		t.Fatal("expected an entry error")
+	}
+	if entry.Retries != 1 {
+		t.Fatalf("retries: expected 1 found %d", entry.Retries)
+	}
+
+	events := drain(metricsChan)
+	if last := events[len(events)-1]; last.EventType != "rpc.End" || last.Value == nil {
+		t.Fatalf("unexpected last event %+v", last)
+	}
+}
+
+func drain(metricsChan chan subject.MetricsEvent) []subject.MetricsEvent {
+	var events []subject.MetricsEvent
+	for {
+		select {
+		case event := <-metricsChan:
+			events = append(events, event)
+		default:
+			return events
+		}
+	}
+}
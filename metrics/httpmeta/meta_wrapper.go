@@ -21,6 +21,7 @@ import (
 	"google.golang.org/grpc/metadata"
 
 	"github.com/deciphernow/gm-fabric-go/metrics/headers"
+	"github.com/deciphernow/gm-fabric-go/metrics/tracecontext"
 )
 
 // wrappedMeta wraps a single Handler
@@ -56,6 +57,11 @@ func (wm wrappedMeta) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	metaMap[headers.PrevRouteHeader] =
 		fmt.Sprintf("%s/%s", req.URL.EscapedPath(), req.Method)
 
+	// the span of this request is the parent of the gRPC calls it makes
+	span, req := tracecontext.RequestSpan(req)
+	span.SetMetadata(metaMap)
+	span.SetB3Metadata(metaMap)
+
 	inMd, _ := metadata.FromIncomingContext(req.Context())
 	outMd := metadata.Join(inMd, metadata.New(metaMap))
 	outCtx := metadata.NewOutgoingContext(req.Context(), outMd)
@@ -22,6 +22,7 @@ import (
 	"github.com/deciphernow/gm-fabric-go/metrics/headers"
 	"github.com/deciphernow/gm-fabric-go/metrics/keyfunc"
 	"github.com/deciphernow/gm-fabric-go/metrics/subject"
+	"github.com/deciphernow/gm-fabric-go/metrics/tracecontext"
 )
 
 var (
@@ -111,31 +112,49 @@ func (wm wrappedMetrics) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 		req.Header.Add(headers.RequestIDHeader, requestID)
 	}
 
+	// the handlers below see the request ID and the span of this request
+	// in its context, to pass them on to the requests they make
+	req = req.WithContext(headers.SetRequestID(req.Context(), requestID))
+	span, req := tracecontext.RequestSpan(req)
+	traceID := span.TraceIDAsString()
+	spanID := span.SpanIDAsString()
+	parentSpanID := span.ParentSpanIDAsString()
+
 	if wm.keyFunc == nil {
 		wm.keyFunc = keyfunc.DefaultHTTPKeyFunc
 	}
 	key := wm.keyFunc(req)
 
 	wm.h.metricsChan <- subject.MetricsEvent{
-		EventType: "rpc.InHeader",
-		Transport: transport,
-		RequestID: requestID,
-		Timestamp: time.Now(),
-		Key:       fmt.Sprintf("route%s/%s", key, req.Method),
-		Tags:      wm.h.tags,
+		EventType:    "rpc.InHeader",
+		Transport:    transport,
+		RequestID:    requestID,
+		TraceID:      traceID,
+		SpanID:       spanID,
+		ParentSpanID: parentSpanID,
+		Timestamp:    time.Now(),
+		Key:          fmt.Sprintf("route%s", key),
+		Method:       req.Method,
+		Tags:         wm.h.tags,
 	}
 	wm.h.metricsChan <- subject.MetricsEvent{
-		EventType: "rpc.Begin",
-		RequestID: requestID,
-		Timestamp: time.Now(),
-		Tags:      wm.h.tags,
+		EventType:    "rpc.Begin",
+		RequestID:    requestID,
+		TraceID:      traceID,
+		SpanID:       spanID,
+		ParentSpanID: parentSpanID,
+		Timestamp:    time.Now(),
+		Tags:         wm.h.tags,
 	}
 	wm.h.metricsChan <- subject.MetricsEvent{
-		EventType: "rpc.InPayload",
-		RequestID: requestID,
-		Timestamp: time.Now(),
-		Value:     req.ContentLength,
-		Tags:      wm.h.tags,
+		EventType:    "rpc.InPayload",
+		RequestID:    requestID,
+		TraceID:      traceID,
+		SpanID:       spanID,
+		ParentSpanID: parentSpanID,
+		Timestamp:    time.Now(),
+		Value:        req.ContentLength,
+		Tags:         wm.h.tags,
 	}
 
 	c := CountWriter{Next: w}
@@ -146,18 +165,24 @@ func (wm wrappedMetrics) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	}
 
 	wm.h.metricsChan <- subject.MetricsEvent{
-		EventType: "rpc.OutPayload",
-		RequestID: requestID,
-		Timestamp: time.Now(),
-		Value:     c.BytesWritten,
-		Tags:      wm.h.tags,
+		EventType:    "rpc.OutPayload",
+		RequestID:    requestID,
+		TraceID:      traceID,
+		SpanID:       spanID,
+		ParentSpanID: parentSpanID,
+		Timestamp:    time.Now(),
+		Value:        c.BytesWritten,
+		Tags:         wm.h.tags,
 	}
 
 	wm.h.metricsChan <- subject.MetricsEvent{
-		EventType:  "rpc.End",
-		RequestID:  requestID,
-		Timestamp:  time.Now(),
-		HTTPStatus: status,
-		Tags:       wm.h.tags,
+		EventType:    "rpc.End",
+		RequestID:    requestID,
+		TraceID:      traceID,
+		SpanID:       spanID,
+		ParentSpanID: parentSpanID,
+		Timestamp:    time.Now(),
+		HTTPStatus:   status,
+		Tags:         wm.h.tags,
 	}
 }
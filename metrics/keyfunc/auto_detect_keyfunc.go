@@ -0,0 +1,66 @@
+package keyfunc
+
+// Copyright 2017 Decipher Technology Studios LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+import (
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// ParamPlaceholder replaces the path segments that AutoDetectKeyFunc
+// recognizes as identifiers
+const ParamPlaceholder = "{id}"
+
+var (
+	numericRegex = regexp.MustCompile(`^[0-9]+$`)
+	uuidRegex    = regexp.MustCompile(
+		`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+	hexRegex = regexp.MustCompile(`^[0-9a-fA-F]{8,}$`)
+)
+
+// AutoDetectKeyFunc returns the URI as the metrics key, with segments that
+// look like identifiers replaced by ParamPlaceholder, so that
+// "/users/123/orders/0f8fad5b-d9cb-469f-a165-70867728950e" becomes
+// "/users/{id}/orders/{id}". A segment is an identifier if it is numeric,
+// a UUID, or at least 8 hex digits including a decimal digit.
+func AutoDetectKeyFunc(req *http.Request) string {
+	if req.URL == nil {
+		return ""
+	}
+
+	segments := strings.Split(req.URL.EscapedPath(), "/")
+	for i, segment := range segments {
+		if isIdentifier(segment) {
+			segments[i] = ParamPlaceholder
+		}
+	}
+
+	return strings.Join(segments, "/")
+}
+
+func isIdentifier(segment string) bool {
+	switch {
+	case numericRegex.MatchString(segment):
+		return true
+	case uuidRegex.MatchString(segment):
+		return true
+	case hexRegex.MatchString(segment):
+		// don't mistake words such as "deadbeef" for identifiers
+		return strings.ContainsAny(segment, "0123456789")
+	}
+
+	return false
+}
@@ -0,0 +1,45 @@
+package keyfunc
+
+// Copyright 2017 Decipher Technology Studios LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestAutoDetectKeyFunc(t *testing.T) {
+	tests := []struct {
+		name   string
+		rawURL string
+		want   string
+	}{
+		{name: "empty request", rawURL: "", want: ""},
+		{name: "no identifiers", rawURL: "/users/me", want: "/users/me"},
+		{name: "numeric", rawURL: "/users/123/orders/456", want: "/users/{id}/orders/{id}"},
+		{name: "uuid", rawURL: "/orders/0f8fad5b-d9cb-469f-a165-70867728950e", want: "/orders/{id}"},
+		{name: "object id", rawURL: "/objects/507f1f77bcf86cd799439011/props", want: "/objects/{id}/props"},
+		{name: "short hex", rawURL: "/colors/ff00aa", want: "/colors/ff00aa"},
+		{name: "hex word", rawURL: "/cafebabe", want: "/cafebabe"},
+		{name: "version", rawURL: "/v1/users", want: "/v1/users"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := http.Request{URL: parseURL(tt.rawURL)}
+			if got := AutoDetectKeyFunc(&req); got != tt.want {
+				t.Errorf("AutoDetectKeyFunc() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,58 @@
+package keyfunc
+
+// Copyright 2017 Decipher Technology Studios LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/grpc-ecosystem/grpc-gateway/runtime"
+)
+
+// GatewayKeyFunc returns a key function that uses the grpc-gateway pattern
+// matching the request path as the key, e.g. "/v1/{name=shelves/*}/books".
+// Patterns are tried in order, as runtime.ServeMux does.
+// Requests that match no pattern are keyed by fallback; if fallback is nil,
+// DefaultHTTPKeyFunc is used.
+//
+// Patterns can also be given as strings to TemplateKeyFunc.
+func GatewayKeyFunc(patterns []runtime.Pattern, fallback HTTPKeyFunc) HTTPKeyFunc {
+	if fallback == nil {
+		fallback = DefaultHTTPKeyFunc
+	}
+
+	return func(req *http.Request) string {
+		if req.URL == nil {
+			return fallback(req)
+		}
+
+		// split the path and verb as runtime.ServeMux does
+		components := strings.Split(strings.TrimPrefix(req.URL.Path, "/"), "/")
+		var verb string
+		last := len(components) - 1
+		if idx := strings.LastIndex(components[last], ":"); idx > 0 {
+			c := components[last]
+			components[last], verb = c[:idx], c[idx+1:]
+		}
+
+		for _, pattern := range patterns {
+			if _, err := pattern.Match(components, verb); err == nil {
+				return pattern.String()
+			}
+		}
+
+		return fallback(req)
+	}
+}
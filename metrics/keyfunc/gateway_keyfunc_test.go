@@ -0,0 +1,63 @@
+package keyfunc
+
+// Copyright 2017 Decipher Technology Studios LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/grpc-ecosystem/grpc-gateway/protoc-gen-grpc-gateway/httprule"
+	"github.com/grpc-ecosystem/grpc-gateway/runtime"
+)
+
+func mustGatewayPattern(t *testing.T, template string) runtime.Pattern {
+	compiler, err := httprule.Parse(template)
+	if err != nil {
+		t.Fatalf("httprule.Parse(%s) failed: %s", template, err)
+	}
+	tmpl := compiler.Compile()
+	pattern, err := runtime.NewPattern(tmpl.Version, tmpl.OpCodes, tmpl.Pool, tmpl.Verb)
+	if err != nil {
+		t.Fatalf("runtime.NewPattern(%s) failed: %s", template, err)
+	}
+	return pattern
+}
+
+func TestGatewayKeyFunc(t *testing.T) {
+	keyFunc := GatewayKeyFunc([]runtime.Pattern{
+		mustGatewayPattern(t, "/v1/users/{id}"),
+		mustGatewayPattern(t, "/v1/{name=shelves/*}/books"),
+		mustGatewayPattern(t, "/v1/{name=operations/*}:cancel"),
+	}, nil)
+
+	tests := []struct {
+		name   string
+		rawURL string
+		want   string
+	}{
+		{name: "single param", rawURL: "/v1/users/123", want: "/v1/users/{id=*}"},
+		{name: "nested variable", rawURL: "/v1/shelves/7/books", want: "/v1/{name=shelves/*}/books"},
+		{name: "verb", rawURL: "/v1/operations/9:cancel", want: "/v1/{name=operations/*}:cancel"},
+		{name: "no match", rawURL: "/v1/racks/7", want: "/v1/racks/7"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := http.Request{URL: parseURL(tt.rawURL)}
+			if got := keyFunc(&req); got != tt.want {
+				t.Errorf("GatewayKeyFunc() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
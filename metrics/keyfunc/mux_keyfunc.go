@@ -0,0 +1,49 @@
+package keyfunc
+
+// Copyright 2017 Decipher Technology Studios LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// MuxKeyFunc returns a key function that uses the path template of the
+// gorilla/mux route matching the request as the key,
+// e.g. "/users/{id}/orders/{oid}". Requests that match no route, or a route
+// without a path template, are keyed by fallback; if fallback is nil,
+// DefaultHTTPKeyFunc is used.
+//
+// The route is looked up in router, so the key function works whether the
+// metrics handler wraps the router or the handlers it routes to.
+func MuxKeyFunc(router *mux.Router, fallback HTTPKeyFunc) HTTPKeyFunc {
+	if fallback == nil {
+		fallback = DefaultHTTPKeyFunc
+	}
+
+	return func(req *http.Request) string {
+		var match mux.RouteMatch
+		if req.URL == nil || !router.Match(req, &match) || match.Route == nil {
+			return fallback(req)
+		}
+
+		template, err := match.Route.GetPathTemplate()
+		if err != nil {
+			return fallback(req)
+		}
+
+		return template
+	}
+}
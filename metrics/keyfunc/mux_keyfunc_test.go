@@ -0,0 +1,55 @@
+package keyfunc
+
+// Copyright 2017 Decipher Technology Studios LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestMuxKeyFunc(t *testing.T) {
+	handler := func(http.ResponseWriter, *http.Request) {}
+
+	router := mux.NewRouter()
+	router.HandleFunc("/users/{id}", handler)
+	router.HandleFunc("/users/{id}/orders/{oid:[0-9]+}", handler).Methods("GET")
+
+	keyFunc := MuxKeyFunc(router, nil)
+
+	tests := []struct {
+		name   string
+		method string
+		rawURL string
+		want   string
+	}{
+		{name: "single param", method: "GET", rawURL: "/users/123", want: "/users/{id}"},
+		{name: "two params", method: "GET", rawURL: "/users/123/orders/456", want: "/users/{id}/orders/{oid:[0-9]+}"},
+		{name: "method mismatch", method: "POST", rawURL: "/users/123/orders/456", want: "/users/123/orders/456"},
+		{name: "no route", method: "GET", rawURL: "/other", want: "/other"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(tt.method, tt.rawURL, nil)
+			if err != nil {
+				t.Fatalf("http.NewRequest failed: %s", err)
+			}
+			if got := keyFunc(req); got != tt.want {
+				t.Errorf("MuxKeyFunc() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
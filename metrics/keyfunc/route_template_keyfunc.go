@@ -0,0 +1,240 @@
+package keyfunc
+
+// Copyright 2017 Decipher Technology Studios LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+import (
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+type segmentKind int
+
+const (
+	literalSegment segmentKind = iota // matches itself
+	paramSegment                      // matches any single segment
+	restSegment                       // matches all remaining segments
+)
+
+type templateSegment struct {
+	kind    segmentKind
+	literal string
+	re      *regexp.Regexp
+}
+
+// routeTemplate is a parsed route template
+type routeTemplate struct {
+	template string
+	segments []templateSegment
+	literals int
+}
+
+// TemplateKeyFunc returns a key function that uses the route template
+// matching the request path as the key, so that all requests to a logical
+// endpoint share one key. A template is a path whose segments may be:
+//     {name}          any single segment (gorilla/mux, OpenAPI)
+//     {name:regexp}   a single segment matching regexp (gorilla/mux)
+//     *               any single segment (grpc-gateway)
+//     **              all remaining segments (grpc-gateway)
+//     {name=a/*}      the segments of the pattern after '=' (grpc-gateway)
+// for example "/users/{id}/orders/{oid}" or "/v1/{name=shelves/*}/books/*".
+//
+// If several templates match, the one with the most literal segments is used.
+// Requests that match no template are keyed by fallback; if fallback is nil,
+// DefaultHTTPKeyFunc is used.
+func TemplateKeyFunc(templates []string, fallback HTTPKeyFunc) (HTTPKeyFunc, error) {
+	if fallback == nil {
+		fallback = DefaultHTTPKeyFunc
+	}
+
+	parsed := make([]routeTemplate, len(templates))
+	for i, template := range templates {
+		rt, err := parseRouteTemplate(template)
+		if err != nil {
+			return nil, errors.Wrapf(err, "template %q", template)
+		}
+		parsed[i] = rt
+	}
+
+	return func(req *http.Request) string {
+		if req.URL == nil {
+			return fallback(req)
+		}
+
+		path := splitPath(req.URL.EscapedPath())
+
+		var best *routeTemplate
+		for i := range parsed {
+			if parsed[i].match(path) &&
+				(best == nil || parsed[i].literals > best.literals) {
+				best = &parsed[i]
+			}
+		}
+		if best == nil {
+			return fallback(req)
+		}
+
+		return best.template
+	}, nil
+}
+
+// splitPath returns the segments of a path, ignoring leading and
+// trailing slashes
+func splitPath(path string) []string {
+	path = strings.Trim(path, "/")
+	if path == "" {
+		return nil
+	}
+
+	return strings.Split(path, "/")
+}
+
+func parseRouteTemplate(template string) (routeTemplate, error) {
+	rt := routeTemplate{template: template}
+
+	rawSegments, err := splitTemplate(strings.Trim(template, "/"))
+	if err != nil {
+		return routeTemplate{}, err
+	}
+
+	for _, raw := range rawSegments {
+		segments, err := parseSegment(raw)
+		if err != nil {
+			return routeTemplate{}, err
+		}
+		rt.segments = append(rt.segments, segments...)
+	}
+
+	for i, segment := range rt.segments {
+		switch segment.kind {
+		case literalSegment:
+			rt.literals++
+		case restSegment:
+			if i != len(rt.segments)-1 {
+				return routeTemplate{}, errors.New("'**' must be the last segment")
+			}
+		}
+	}
+
+	return rt, nil
+}
+
+// splitTemplate splits a template at the slashes that are not within braces
+func splitTemplate(template string) ([]string, error) {
+	if template == "" {
+		return nil, nil
+	}
+
+	var segments []string
+	var depth, start int
+	for i, c := range template {
+		switch c {
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth < 0 {
+				return nil, errors.New("unbalanced braces")
+			}
+		case '/':
+			if depth == 0 {
+				segments = append(segments, template[start:i])
+				start = i + 1
+			}
+		}
+	}
+	if depth != 0 {
+		return nil, errors.New("unbalanced braces")
+	}
+
+	return append(segments, template[start:]), nil
+}
+
+// parseSegment parses a single template segment, which may expand to
+// several segments for a grpc-gateway variable
+func parseSegment(raw string) ([]templateSegment, error) {
+	switch {
+	case raw == "*":
+		return []templateSegment{{kind: paramSegment}}, nil
+	case raw == "**":
+		return []templateSegment{{kind: restSegment}}, nil
+	case strings.HasPrefix(raw, "{") && strings.HasSuffix(raw, "}"):
+		return parseVariable(raw[1 : len(raw)-1])
+	case strings.ContainsAny(raw, "{}*"):
+		return nil, errors.Errorf("unsupported segment %q", raw)
+	case raw == "":
+		return nil, errors.New("empty segment")
+	}
+
+	return []templateSegment{{kind: literalSegment, literal: raw}}, nil
+}
+
+func parseVariable(variable string) ([]templateSegment, error) {
+	colon := strings.Index(variable, ":")
+	equals := strings.Index(variable, "=")
+
+	switch {
+	case colon > 0 && (equals < 0 || colon < equals):
+		// gorilla/mux {name:regexp}
+		re, err := regexp.Compile("^(?:" + variable[colon+1:] + ")$")
+		if err != nil {
+			return nil, errors.Wrapf(err, "variable %q", variable)
+		}
+		return []templateSegment{{kind: paramSegment, re: re}}, nil
+	case equals > 0:
+		// grpc-gateway {name=pattern}
+		var segments []templateSegment
+		for _, raw := range strings.Split(variable[equals+1:], "/") {
+			if strings.HasPrefix(raw, "{") {
+				return nil, errors.Errorf("nested variable %q", variable)
+			}
+			s, err := parseSegment(raw)
+			if err != nil {
+				return nil, errors.Wrapf(err, "variable %q", variable)
+			}
+			segments = append(segments, s...)
+		}
+		return segments, nil
+	case variable == "":
+		return nil, errors.New("empty variable")
+	}
+
+	return []templateSegment{{kind: paramSegment}}, nil
+}
+
+func (rt routeTemplate) match(path []string) bool {
+	for i, segment := range rt.segments {
+		if segment.kind == restSegment {
+			return true
+		}
+		if i >= len(path) {
+			return false
+		}
+		switch segment.kind {
+		case literalSegment:
+			if path[i] != segment.literal {
+				return false
+			}
+		case paramSegment:
+			if segment.re != nil && !segment.re.MatchString(path[i]) {
+				return false
+			}
+		}
+	}
+
+	return len(path) == len(rt.segments)
+}
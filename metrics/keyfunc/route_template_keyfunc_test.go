@@ -0,0 +1,87 @@
+package keyfunc
+
+// Copyright 2017 Decipher Technology Studios LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestTemplateKeyFunc(t *testing.T) {
+	keyFunc, err := TemplateKeyFunc([]string{
+		"/users/{id}",
+		"/users/{id}/orders/{oid}",
+		"/users/me/orders/{oid}",
+		"/items/{id:[0-9]+}",
+		"/v1/{name=shelves/*}/books/*",
+		"/files/**",
+	}, nil)
+	if err != nil {
+		t.Fatalf("TemplateKeyFunc failed: %s", err)
+	}
+
+	tests := []struct {
+		name   string
+		rawURL string
+		want   string
+	}{
+		{name: "single param", rawURL: "/users/123", want: "/users/{id}"},
+		{name: "trailing slash", rawURL: "/users/123/", want: "/users/{id}"},
+		{name: "two params", rawURL: "/users/123/orders/456?x=1", want: "/users/{id}/orders/{oid}"},
+		{name: "most literal wins", rawURL: "/users/me/orders/456", want: "/users/me/orders/{oid}"},
+		{name: "regexp match", rawURL: "/items/42", want: "/items/{id:[0-9]+}"},
+		{name: "regexp mismatch", rawURL: "/items/abc", want: "/items/abc"},
+		{name: "gateway variable", rawURL: "/v1/shelves/1/books/2", want: "/v1/{name=shelves/*}/books/*"},
+		{name: "gateway literal mismatch", rawURL: "/v1/racks/1/books/2", want: "/v1/racks/1/books/2"},
+		{name: "rest", rawURL: "/files/a/b/c.txt", want: "/files/**"},
+		{name: "too long", rawURL: "/users/123/orders", want: "/users/123/orders"},
+		{name: "no match", rawURL: "/other", want: "/other"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := http.Request{URL: parseURL(tt.rawURL)}
+			if got := keyFunc(&req); got != tt.want {
+				t.Errorf("TemplateKeyFunc() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTemplateKeyFuncFallback(t *testing.T) {
+	keyFunc, err := TemplateKeyFunc([]string{"/users/{id}"}, AutoDetectKeyFunc)
+	if err != nil {
+		t.Fatalf("TemplateKeyFunc failed: %s", err)
+	}
+	req := http.Request{URL: parseURL("/accounts/123")}
+	if got := keyFunc(&req); got != "/accounts/{id}" {
+		t.Errorf("TemplateKeyFunc() = %v, want /accounts/{id}", got)
+	}
+}
+
+func TestInvalidTemplates(t *testing.T) {
+	for _, template := range []string{
+		"/users/{id",
+		"/users/id}",
+		"/users/{id:[0-9}",
+		"/files/**/x",
+		"/files/x{id}",
+		"/users//x",
+		"/users/{}",
+	} {
+		if _, err := TemplateKeyFunc([]string{template}, nil); err == nil {
+			t.Errorf("%s: expected error", template)
+		}
+	}
+}
@@ -44,11 +44,15 @@ func (lo logobs) Observe(event subject.MetricsEvent) {
 	lo.l.Printf(
 		"EventType=%s "+
 			"RequestID=%s "+
+			"TraceID=%s "+
+			"SpanID=%s "+
 			"Key=%s "+
 			"Timestamp=%s "+
 			"Value=%+v",
 		event.EventType,
 		event.RequestID,
+		event.TraceID,
+		event.SpanID,
 		event.Key,
 		event.Timestamp,
 		event.Value,
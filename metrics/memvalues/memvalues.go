@@ -19,18 +19,37 @@ import (
 	"runtime"
 
 	"github.com/shirou/gopsutil/mem"
+
+	"github.com/deciphernow/gm-fabric-go/metrics/cgroup"
 )
 
-// MemValues holds memory values for reporting
+// CgroupRoot is where GetMemValues looks for the cgroup filesystem of the
+// container; change it before reporting starts if it is mounted elsewhere
+var CgroupRoot = cgroup.DefaultRoot
+
+// MemValues holds memory values for reporting.
+// In a cgroup with a memory limit, the system values are those of the
+// container: its working set, the rest of its limit and the percentage of
+// the limit used. Otherwise they are the values of the host.
 type MemValues struct {
-	SystemMemoryAvailable   uint64
-	SystemMemoryUsed        uint64
-	SystemMemoryUsedPercent float64
-	ProcessMemoryUsed       uint64
+	SystemMemoryAvailable    uint64
+	SystemMemoryUsed         uint64
+	SystemMemoryUsedPercent  float64
+	ProcessMemoryUsed        uint64
+	ProcessMemoryLimit       uint64  // cgroup memory limit, 0 if not limited
+	ProcessMemoryUsedPercent float64 // percent of ProcessMemoryLimit, 0 if not limited
+	CPULimitCores            float64 // cgroup CPU quota in cores, 0 if not limited
+	CgroupVersion            int     // 1 or 2, 0 if there is no cgroup
 }
 
-// GetMemValues returns current memory values
+// GetMemValues returns current memory values, reading the cgroup at CgroupRoot
 func GetMemValues() (MemValues, error) {
+	return GetMemValuesAt(CgroupRoot)
+}
+
+// GetMemValuesAt returns current memory values, reading the cgroup
+// filesystem mounted at cgroupRoot
+func GetMemValuesAt(cgroupRoot string) (MemValues, error) {
 	var vmStats *mem.VirtualMemoryStat
 	var memStats runtime.MemStats
 	var err error
@@ -42,10 +61,43 @@ func GetMemValues() (MemValues, error) {
 
 	runtime.ReadMemStats(&memStats)
 
-	return MemValues{
+	stats, err := cgroup.ReadStats(cgroupRoot)
+	if err != nil {
+		return MemValues{}, fmt.Errorf("cgroup.ReadStats() failed: %v", err)
+	}
+
+	return newMemValues(vmStats, memStats.Sys, stats), nil
+}
+
+func newMemValues(
+	vmStats *mem.VirtualMemoryStat,
+	processUsed uint64,
+	stats cgroup.Stats,
+) MemValues {
+	values := MemValues{
 		SystemMemoryAvailable:   vmStats.Available,
 		SystemMemoryUsed:        vmStats.Used,
 		SystemMemoryUsedPercent: vmStats.UsedPercent,
-		ProcessMemoryUsed:       memStats.Sys,
-	}, nil
+		ProcessMemoryUsed:       processUsed,
+		ProcessMemoryLimit:      stats.MemoryBytes,
+		CPULimitCores:           stats.CPUCores,
+		CgroupVersion:           stats.Version,
+	}
+
+	if limit := stats.MemoryBytes; limit > 0 {
+		used := stats.MemoryUsageBytes
+		values.SystemMemoryUsed = used
+		values.SystemMemoryAvailable = 0
+		if used < limit {
+			values.SystemMemoryAvailable = limit - used
+		}
+		values.SystemMemoryUsedPercent = percent(used, limit)
+		values.ProcessMemoryUsedPercent = percent(processUsed, limit)
+	}
+
+	return values
+}
+
+func percent(value, total uint64) float64 {
+	return float64(value) / float64(total) * 100
 }
@@ -0,0 +1,103 @@
+// Copyright 2017 Decipher Technology Studios LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package memvalues
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestGetMemValuesAt(t *testing.T) {
+	testCases := []struct {
+		root              string
+		expectedVersion   int
+		expectedLimit     uint64
+		expectedCPU       float64
+		expectedUsed      uint64 // 0 for host values
+		expectedPercent   float64
+		expectedAvailable uint64
+	}{
+		{
+			root:              "testdata/cgroupv1",
+			expectedVersion:   1,
+			expectedLimit:     268435456,
+			expectedCPU:       1.5,
+			expectedUsed:      134217728,
+			expectedPercent:   50,
+			expectedAvailable: 134217728,
+		},
+		{
+			root:              "testdata/cgroupv2",
+			expectedVersion:   2,
+			expectedLimit:     536870912,
+			expectedCPU:       0.5,
+			expectedUsed:      268435456,
+			expectedPercent:   50,
+			expectedAvailable: 268435456,
+		},
+		{
+			root:            "testdata/cgroupv2-unlimited",
+			expectedVersion: 2,
+		},
+		{
+			root: "testdata/none",
+		},
+	}
+
+	for i, tc := range testCases {
+		t.Run(fmt.Sprintf("%d: %s", i, tc.root), func(t *testing.T) {
+			values, err := GetMemValuesAt(tc.root)
+			if err != nil {
+				t.Fatalf("GetMemValuesAt failed: %s", err)
+			}
+
+			if values.CgroupVersion != tc.expectedVersion {
+				t.Fatalf("version: expected %d found %d",
+					tc.expectedVersion, values.CgroupVersion)
+			}
+			if values.ProcessMemoryLimit != tc.expectedLimit {
+				t.Fatalf("limit: expected %d found %d",
+					tc.expectedLimit, values.ProcessMemoryLimit)
+			}
+			if values.CPULimitCores != tc.expectedCPU {
+				t.Fatalf("cpu: expected %f found %f", tc.expectedCPU, values.CPULimitCores)
+			}
+
+			if tc.expectedUsed == 0 {
+				// host values
+				if values.SystemMemoryUsed == 0 || values.ProcessMemoryUsedPercent != 0 {
+					t.Fatalf("expected host values, found %+v", values)
+				}
+				return
+			}
+
+			if values.SystemMemoryUsed != tc.expectedUsed {
+				t.Fatalf("used: expected %d found %d", tc.expectedUsed, values.SystemMemoryUsed)
+			}
+			if values.SystemMemoryUsedPercent != tc.expectedPercent {
+				t.Fatalf("percent: expected %f found %f",
+					tc.expectedPercent, values.SystemMemoryUsedPercent)
+			}
+			if values.SystemMemoryAvailable != tc.expectedAvailable {
+				t.Fatalf("available: expected %d found %d",
+					tc.expectedAvailable, values.SystemMemoryAvailable)
+			}
+			if values.ProcessMemoryUsedPercent <= 0 {
+				t.Fatalf("expected a process percentage, found %f",
+					values.ProcessMemoryUsedPercent)
+			}
+		})
+	}
+}
@@ -24,24 +24,130 @@ import (
 // GreyMatterMetricsVersion is the version of metrics avaialble from this handler
 const GreyMatterMetricsVersion = "1.0.0"
 
+// DeltaQueryParam is the dashboard query parameter that requests counts
+// as deltas since the previous request by the same consumer, e.g.
+//     /metrics?delta=statsd
+// An empty value uses DefaultDeltaConsumer. Other consumers must be
+// registered with NewDeltaConsumersDashboardHandler; a request naming an
+// unknown consumer fails with 400 Bad Request.
+const DeltaQueryParam = "delta"
+
+// DeltaConsumerPrefix is prepended to the consumer names of delta requests
+// before they are passed to the reporters, so that HTTP callers cannot
+// reach the cursors of internal consumers such as cloudobserver.DeltaConsumer
+const DeltaConsumerPrefix = "http/"
+
+// FormatQueryParam is the dashboard query parameter that selects the
+// layout of the JSON, "flat" (the default) or "nested", e.g.
+//     /metrics?format=nested
+// See flatjson.Mode.
+const FormatQueryParam = "format"
+
+// DefaultDeltaConsumer is the consumer for a delta request that does not
+// name one
+const DefaultDeltaConsumer = "dashboard"
+
 // ReportFunc reports dashboard metrics
 type ReportFunc func(*flatjson.Writer) error
 
+// DeltaReportFunc reports dashboard metrics.
+// If consumer is not empty, counts are reported as deltas since the
+// consumer's previous report.
+type DeltaReportFunc func(jWriter *flatjson.Writer, consumer string) error
+
+// CumulativeReportFunc adapts a ReportFunc without a delta mode
+// to a DeltaReportFunc that always reports cumulative values
+func CumulativeReportFunc(reporter ReportFunc) DeltaReportFunc {
+	return func(jWriter *flatjson.Writer, _ string) error {
+		return reporter(jWriter)
+	}
+}
+
 type dashboardHandler struct {
-	reporters []ReportFunc
+	reporters []DeltaReportFunc
+
+	// consumers are the names accepted in DeltaQueryParam;
+	// nil if the reporters have no delta mode
+	consumers map[string]bool
 }
 
 // NewDashboardHandler returns an object that implments the http.Handler interface
 // It returns a flat JSON map suitable for Fabric Dashboard metrics
+// If the Accept header asks for the Prometheus or OpenMetrics text format,
+// the numeric values of the reporters are served in that format instead.
 func NewDashboardHandler(reporters ...ReportFunc) http.Handler {
-	return dashboardHandler{reporters: reporters}
+	return dashboardHandler{reporters: cumulativeReportFuncs(reporters)}
+}
+
+func cumulativeReportFuncs(reporters []ReportFunc) []DeltaReportFunc {
+	deltaReporters := make([]DeltaReportFunc, len(reporters))
+	for i, reporter := range reporters {
+		deltaReporters[i] = CumulativeReportFunc(reporter)
+	}
+	return deltaReporters
+}
+
+// NewDeltaDashboardHandler returns a dashboard handler whose reporters
+// support DeltaQueryParam, so that push based backends polling the
+// dashboard get per-interval counts. Only DefaultDeltaConsumer is accepted.
+func NewDeltaDashboardHandler(reporters ...DeltaReportFunc) http.Handler {
+	return NewDeltaConsumersDashboardHandler(nil, reporters...)
+}
+
+// NewDeltaConsumersDashboardHandler is NewDeltaDashboardHandler for a fixed
+// set of consumers, accepted in DeltaQueryParam along with DefaultDeltaConsumer
+func NewDeltaConsumersDashboardHandler(
+	consumers []string,
+	reporters ...DeltaReportFunc,
+) http.Handler {
+	dh := dashboardHandler{
+		reporters: reporters,
+		consumers: map[string]bool{DefaultDeltaConsumer: true},
+	}
+	for _, consumer := range consumers {
+		dh.consumers[consumer] = true
+	}
+
+	return dh
 }
 
 func (dh dashboardHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	var consumer string
+	if values, ok := req.URL.Query()[DeltaQueryParam]; ok && dh.consumers != nil {
+		consumer = values[0]
+		if consumer == "" {
+			consumer = DefaultDeltaConsumer
+		}
+		if !dh.consumers[consumer] {
+			http.Error(
+				w,
+				fmt.Sprintf("unknown %s consumer %q", DeltaQueryParam, consumer),
+				http.StatusBadRequest,
+			)
+			return
+		}
+		consumer = DeltaConsumerPrefix + consumer
+	}
+
+	if format := negotiateFormat(req.Header.Get("Accept")); format != jsonFormat {
+		serveExposition(w, format, nil, dh.reporters, consumer)
+		return
+	}
+
+	mode, err := flatjson.ParseMode(req.URL.Query().Get(FormatQueryParam))
+	if err != nil {
+		http.Error(
+			w,
+			fmt.Sprintf("invalid %s: %v", FormatQueryParam, err),
+			http.StatusBadRequest,
+		)
+		return
+	}
+
 	headers := w.Header()
 	headers.Add("content-type", "application/json")
 
-	jWriter, err := flatjson.New(w)
+	jWriter, err := flatjson.New(w, flatjson.ModeOption(mode))
 	if err != nil {
 		http.Error(
 			w,
@@ -62,7 +168,7 @@ func (dh dashboardHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	}
 
 	for n, reporter := range dh.reporters {
-		if err = reporter(jWriter); err != nil {
+		if err = reporter(jWriter, consumer); err != nil {
 			http.Error(
 				w,
 				fmt.Sprintf("reporter.Report failed: #%d; %v", n, err),
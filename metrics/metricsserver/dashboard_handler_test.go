@@ -0,0 +1,114 @@
+// Copyright 2017 Decipher Technology Studios LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metricsserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/deciphernow/gm-fabric-go/metrics/flatjson"
+)
+
+func TestDashboardDeltaQuery(t *testing.T) {
+	var consumers []string
+	reporter := func(jWriter *flatjson.Writer, consumer string) error {
+		consumers = append(consumers, consumer)
+		return jWriter.Write("consumer", consumer)
+	}
+
+	testCases := []struct {
+		target         string
+		expectedStatus int
+		expected       string
+	}{
+		{"/metrics", http.StatusOK, ""},
+		{"/metrics?delta", http.StatusOK, DeltaConsumerPrefix + DefaultDeltaConsumer},
+		{"/metrics?delta=", http.StatusOK, DeltaConsumerPrefix + DefaultDeltaConsumer},
+		{"/metrics?delta=statsd", http.StatusOK, DeltaConsumerPrefix + "statsd"},
+		{"/metrics?delta=cloudwatch", http.StatusBadRequest, ""},
+		{"/metrics?delta=x1", http.StatusBadRequest, ""},
+	}
+
+	handler := NewDeltaConsumersDashboardHandler([]string{"statsd"}, reporter)
+	for i, tc := range testCases {
+		t.Run(fmt.Sprintf("%d: %s", i, tc.target), func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, httptest.NewRequest("GET", tc.target, nil))
+			if recorder.Code != tc.expectedStatus {
+				t.Fatalf("status: expected %d found %d", tc.expectedStatus, recorder.Code)
+			}
+			if tc.expectedStatus != http.StatusOK {
+				return
+			}
+
+			var ts map[string]interface{}
+			if err := json.Unmarshal(recorder.Body.Bytes(), &ts); err != nil {
+				t.Fatalf("json.Unmarshal failed: %s; %s", err, recorder.Body.String())
+			}
+			if ts["consumer"] != tc.expected {
+				t.Fatalf("expected consumer %q found %v", tc.expected, ts["consumer"])
+			}
+		})
+	}
+}
+
+func TestDashboardCumulativeReporter(t *testing.T) {
+	reporter := func(jWriter *flatjson.Writer) error {
+		return jWriter.Write("value", 1)
+	}
+
+	recorder := httptest.NewRecorder()
+	NewDashboardHandler(reporter).ServeHTTP(
+		recorder,
+		httptest.NewRequest("GET", "/metrics?delta=statsd", nil),
+	)
+
+	var ts map[string]interface{}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &ts); err != nil {
+		t.Fatalf("json.Unmarshal failed: %s; %s", err, recorder.Body.String())
+	}
+	if ts["value"] != float64(1) {
+		t.Fatalf("expected 1 found %v", ts["value"])
+	}
+}
+
+func TestDashboardFormatQuery(t *testing.T) {
+	reporter := func(jWriter *flatjson.Writer) error {
+		return jWriter.Write("all/requests", 2)
+	}
+	handler := NewDashboardHandler(reporter)
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics?format=nested", nil))
+
+	var ts struct {
+		All map[string]interface{} `json:"all"`
+	}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &ts); err != nil {
+		t.Fatalf("json.Unmarshal failed: %s; %s", err, recorder.Body.String())
+	}
+	if ts.All["requests"] != float64(2) {
+		t.Fatalf("expected nested all/requests, found %s", recorder.Body.String())
+	}
+
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics?format=tree", nil))
+	if recorder.Code != http.StatusBadRequest {
+		t.Fatalf("expected status %d found %d", http.StatusBadRequest, recorder.Code)
+	}
+}
@@ -13,5 +13,23 @@
 // limitations under the License.
 
 /*Package metricsserver implements a small HTTP(S) server that reports accumulated statistics.
- */
+
+    server := metricsserver.NewServer(
+        metricsAddress,
+        metricsserver.ReportersOption(grpcObserver.Report, metricsserver.NewMiscReporter().Report),
+        metricsserver.ReadinessRegistryOption(registry),
+        metricsserver.DrainDelayOption(5*time.Second),
+        metricsserver.PprofOption(),
+    )
+    if err := server.Start(); err != nil {
+        log.Fatalf("server.Start failed: %v", err)
+    }
+    defer server.Shutdown(ctx)
+
+The server reports metrics on /metrics, the reports of its health and readiness
+registries (see package health) on /health and /ready (200 unless a critical check
+fails, 503 otherwise), and optionally the pprof profiles and expvar variables under
+/debug. Readiness fails as soon as Shutdown is called, which then waits for the drain
+delay before it stops the server.
+*/
 package metricsserver
@@ -0,0 +1,276 @@
+// Copyright 2017 Decipher Technology Studios LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metricsserver
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+
+	"github.com/deciphernow/gm-fabric-go/metrics/flatjson"
+)
+
+// sample is a single Prometheus sample converted from a dashboard key
+type sample struct {
+	name   string
+	labels []labelPair
+	value  float64
+}
+
+type labelPair struct {
+	name  string
+	value string
+}
+
+// family is the samples that share a metric name
+type family struct {
+	name    string
+	samples []sample
+}
+
+var invalidNameRegexp = regexp.MustCompile(`[^a-zA-Z0-9_:]`)
+
+// sanitizeName returns a valid Prometheus metric name
+func sanitizeName(name string) string {
+	name = invalidNameRegexp.ReplaceAllLiteralString(name, "_")
+	if name == "" || (name[0] >= '0' && name[0] <= '9') {
+		name = "_" + name
+	}
+	return name
+}
+
+// keyToSample converts a dashboard key, its metric and value to a sample.
+// Reporters such as apistats give the metric name and labels of their keys,
+// e.g. route/users/GET/5m/latency_ms.p99 is
+//     route_latency_ms_p99{route="/users",method="GET",window="5m"}
+// Keys without a metric become the metric name, e.g. system/cpu.pct is
+// system_cpu_pct. Values that are not numbers or booleans are not samples.
+func keyToSample(key string, metric flatjson.Metric, value interface{}) (sample, bool) {
+	v, ok := sampleValue(value)
+	if !ok {
+		return sample{}, false
+	}
+
+	if metric.Name == "" {
+		return sample{name: sanitizeName(key), value: v}, true
+	}
+
+	labels := make([]labelPair, len(metric.Labels))
+	for i, label := range metric.Labels {
+		labels[i] = labelPair{name: sanitizeName(label.Name), value: label.Value}
+	}
+
+	return sample{name: sanitizeName(metric.Name), labels: labels, value: v}, true
+}
+
+func sampleValue(value interface{}) (float64, bool) {
+	if value == nil {
+		return 0, false
+	}
+
+	rv := reflect.ValueOf(value)
+	switch rv.Kind() {
+	case reflect.Bool:
+		if rv.Bool() {
+			return 1, true
+		}
+		return 0, true
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(rv.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(rv.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return rv.Float(), true
+	}
+
+	return 0, false
+}
+
+// collectFamilies runs the reporters and groups their numeric values
+// into families, sorted by name. A sample repeating the name and labels of
+// an earlier one is dropped.
+func collectFamilies(reporters []DeltaReportFunc, consumer string) ([]family, error) {
+	index := make(map[string]int)
+	seen := make(map[string]struct{})
+	var families []family
+
+	jWriter := flatjson.NewMetricVisitor(func(key string, metric flatjson.Metric, value interface{}) error {
+		s, ok := keyToSample(key, metric, value)
+		if !ok {
+			return nil
+		}
+
+		id := s.name + labelString(s.labels)
+		if _, ok := seen[id]; ok {
+			return nil
+		}
+		seen[id] = struct{}{}
+
+		i, ok := index[s.name]
+		if !ok {
+			i = len(families)
+			index[s.name] = i
+			families = append(families, family{name: s.name})
+		}
+		families[i].samples = append(families[i].samples, s)
+		return nil
+	})
+
+	for n, reporter := range reporters {
+		if err := reporter(jWriter, consumer); err != nil {
+			return nil, errors.Wrapf(err, "reporter #%d", n)
+		}
+	}
+
+	sort.Slice(families, func(i, j int) bool {
+		return families[i].name < families[j].name
+	})
+
+	return families, nil
+}
+
+// writeFamilies writes families in the Prometheus text format or, if
+// openMetrics is set, the OpenMetrics text format without the final # EOF.
+// Their type is not known, so they are untyped (unknown in OpenMetrics).
+func writeFamilies(w *bufio.Writer, families []family, openMetrics bool) error {
+	typeName := "untyped"
+	if openMetrics {
+		typeName = "unknown"
+	}
+
+	for _, f := range families {
+		if _, err := w.WriteString("# TYPE " + f.name + " " + typeName + "\n"); err != nil {
+			return err
+		}
+		for _, s := range f.samples {
+			if err := writeSample(w, s.name, s.labels, s.value); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func writeSample(w io.Writer, name string, labels []labelPair, value float64) error {
+	_, err := io.WriteString(
+		w,
+		name+labelString(labels)+" "+formatSampleValue(value)+"\n",
+	)
+	return err
+}
+
+// labelString returns labels in the form {a="x",b="y"}
+func labelString(labels []labelPair) string {
+	if len(labels) == 0 {
+		return ""
+	}
+
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, label := range labels {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(label.name)
+		b.WriteString(`="`)
+		b.WriteString(labelValueReplacer.Replace(label.value))
+		b.WriteByte('"')
+	}
+	b.WriteByte('}')
+
+	return b.String()
+}
+
+var labelValueReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatSampleValue(value float64) string {
+	switch {
+	case math.IsNaN(value):
+		return "NaN"
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// serveExposition serves the families gathered from a Prometheus registry,
+// if any, and the values of the reporters in the Prometheus or OpenMetrics
+// text format. Reporter families named like a gathered family are dropped.
+func serveExposition(
+	w http.ResponseWriter,
+	format contentFormat,
+	gathered []*dto.MetricFamily,
+	reporters []DeltaReportFunc,
+	consumer string,
+) {
+	families, err := collectFamilies(reporters, consumer)
+	if err != nil {
+		http.Error(
+			w,
+			fmt.Sprintf("collectFamilies failed: %v", err),
+			http.StatusInternalServerError,
+		)
+		return
+	}
+
+	w.Header().Set("content-type", format.contentType())
+	bw := bufio.NewWriter(w)
+	openMetrics := format == openMetricsFormat
+
+	gatheredNames := make(map[string]struct{}, len(gathered))
+	for _, mf := range gathered {
+		gatheredNames[mf.GetName()] = struct{}{}
+		if openMetrics {
+			err = writeOpenMetricsFamily(bw, mf)
+		} else {
+			_, err = expfmt.MetricFamilyToText(bw, mf)
+		}
+		if err != nil {
+			return
+		}
+	}
+
+	unique := families[:0]
+	for _, f := range families {
+		if _, ok := gatheredNames[f.name]; !ok {
+			unique = append(unique, f)
+		}
+	}
+	if err = writeFamilies(bw, unique, openMetrics); err != nil {
+		return
+	}
+
+	if openMetrics {
+		if _, err = bw.WriteString("# EOF\n"); err != nil {
+			return
+		}
+	}
+
+	bw.Flush()
+}
@@ -0,0 +1,213 @@
+// Copyright 2017 Decipher Technology Studios LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metricsserver
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/deciphernow/gm-fabric-go/metrics/apistats"
+	"github.com/deciphernow/gm-fabric-go/metrics/flatjson"
+	"github.com/deciphernow/gm-fabric-go/metrics/subject"
+)
+
+func TestKeyToSample(t *testing.T) {
+	label := func(name, value string) flatjson.Label {
+		return flatjson.Label{Name: name, Value: value}
+	}
+
+	testCases := []struct {
+		key      string
+		metric   flatjson.Metric
+		value    interface{}
+		expected string
+	}{
+		{
+			"route/users/GET/5m/latency_ms.p99",
+			flatjson.Metric{
+				Name:   "route_latency_ms_p99",
+				Labels: []flatjson.Label{label("route", "/users"), label("method", "GET"), label("window", "5m")},
+			},
+			1.5,
+			`route_latency_ms_p99{route="/users",method="GET",window="5m"} 1.5`,
+		},
+		{
+			"route/a\"b/requests",
+			flatjson.Metric{Name: "route_requests", Labels: []flatjson.Label{label("route", "/a\"b")}},
+			1,
+			`route_requests{route="/a\"b"} 1`,
+		},
+		{
+			"x/y",
+			flatjson.Metric{Name: "x.y", Labels: []flatjson.Label{label("a-b", "c")}},
+			int64(7),
+			`x_y{a_b="c"} 7`,
+		},
+		{"route/users/GET/requests", flatjson.Metric{}, 2, `route_users_GET_requests 2`},
+		{"Total/requests", flatjson.Metric{}, uint64(9), `Total_requests 9`},
+		{"system/cpu.pct", flatjson.Metric{}, 12.5, `system_cpu_pct 12.5`},
+		{"subject/observer/0/delivered", flatjson.Metric{}, 4, `subject_observer_0_delivered 4`},
+		{"flag", flatjson.Metric{}, true, `flag 1`},
+		{"1st", flatjson.Metric{}, 1, `_1st 1`},
+	}
+
+	for i, tc := range testCases {
+		t.Run(fmt.Sprintf("%d: %s", i, tc.key), func(t *testing.T) {
+			s, ok := keyToSample(tc.key, tc.metric, tc.value)
+			if !ok {
+				t.Fatalf("not a sample")
+			}
+			found := s.name + labelString(s.labels) + " " + formatSampleValue(s.value)
+			if found != tc.expected {
+				t.Fatalf("expected %s found %s", tc.expected, found)
+			}
+		})
+	}
+
+	for _, value := range []interface{}{"text", nil} {
+		if _, ok := keyToSample("os", flatjson.Metric{}, value); ok {
+			t.Fatalf("%v: expected no sample", value)
+		}
+	}
+}
+
+func TestNegotiateFormat(t *testing.T) {
+	testCases := []struct {
+		accept   string
+		expected contentFormat
+	}{
+		{"", jsonFormat},
+		{"*/*", jsonFormat},
+		{"application/json", jsonFormat},
+		{"text/plain;version=0.0.4;q=0.3,*/*;q=0.2", prometheusTextFormat},
+		{"application/openmetrics-text;version=1.0.0,application/openmetrics-text;version=0.0.1;q=0.75,text/plain;version=0.0.4;q=0.5,*/*;q=0.1", openMetricsFormat},
+		{"text/html,application/xhtml+xml,*/*;q=0.8", jsonFormat},
+		{"text/plain;q=0", jsonFormat},
+	}
+
+	for i, tc := range testCases {
+		if format := negotiateFormat(tc.accept); format != tc.expected {
+			t.Fatalf("#%d %q: expected %d found %d", i, tc.accept, tc.expected, format)
+		}
+	}
+}
+
+func serveTest(t *testing.T, handler http.Handler, accept string) (string, string) {
+	recorder := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/metrics", nil)
+	req.Header.Set("Accept", accept)
+	handler.ServeHTTP(recorder, req)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", recorder.Code, recorder.Body.String())
+	}
+	return recorder.Header().Get("content-type"), recorder.Body.String()
+}
+
+func TestDashboardExposition(t *testing.T) {
+	stats := apistats.New(16, apistats.WindowsOption())
+	for _, entry := range []apistats.APIStatsEntry{
+		{Key: "route/users", Method: "GET", Transport: subject.EventTransportHTTP, HTTPStatus: 200},
+		{Key: "route/users", Method: "GET", Transport: subject.EventTransportHTTP, HTTPStatus: 404},
+		// segments that look like a method, a window or a status are
+		// part of the route
+		{Key: "route/GET/5m/status", Method: "POST", Transport: subject.EventTransportHTTP, HTTPStatus: 200},
+		{Key: "function/Hello", Transport: subject.EventTransportRPC},
+	} {
+		stats.Store(entry)
+	}
+	handler := NewDashboardHandler(stats.Report)
+
+	contentType, body := serveTest(t, handler, "text/plain;version=0.0.4")
+	if contentType != PrometheusTextContentType {
+		t.Fatalf("unexpected content type %s", contentType)
+	}
+	for _, line := range []string{
+		"# TYPE route_requests untyped\n",
+		`route_requests{route="/users",method="GET"} 2` + "\n",
+		`route_requests{route="/GET/5m/status",method="POST"} 1` + "\n",
+		`route_status{route="/users",method="GET",status="404"} 1` + "\n",
+		`route_status{route="/users",method="GET",status="2XX"} 1` + "\n",
+		`route_status{route="/GET/5m/status",method="POST",status="200"} 1` + "\n",
+		`function_grpc_status{function="Hello",grpc_status="OK"} 1` + "\n",
+		`all_requests{method="GET"} 2` + "\n",
+		"all_requests 4\n",
+		"Total_requests 4\n",
+	} {
+		if !strings.Contains(body, line) {
+			t.Fatalf("expected %q in\n%s", line, body)
+		}
+	}
+
+	contentType, body = serveTest(t, handler, "application/openmetrics-text")
+	if contentType != OpenMetricsContentType {
+		t.Fatalf("unexpected content type %s", contentType)
+	}
+	if !strings.Contains(body, "# TYPE route_requests unknown\n") ||
+		!strings.HasSuffix(body, "# EOF\n") {
+		t.Fatalf("unexpected OpenMetrics\n%s", body)
+	}
+
+	contentType, _ = serveTest(t, handler, "application/json")
+	if contentType != JSONContentType {
+		t.Fatalf("unexpected content type %s", contentType)
+	}
+}
+
+func TestPrometheusHandler(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	counter := prometheus.NewCounterVec(
+		prometheus.CounterOpts{Name: "http_requests_total", Help: "Requests."},
+		[]string{"code"},
+	)
+	registry.MustRegister(counter)
+	counter.WithLabelValues("200").Add(5)
+
+	handler := prometheusHandler{
+		gatherer: registry,
+		reporters: cumulativeReportFuncs([]ReportFunc{
+			func(jWriter *flatjson.Writer) error {
+				return jWriter.Write("system/cpu_cores", 8)
+			},
+		}),
+	}
+
+	_, body := serveTest(t, handler, "")
+	for _, line := range []string{
+		"# TYPE http_requests_total counter\n",
+		`http_requests_total{code="200"} 5` + "\n",
+		"system_cpu_cores 8\n",
+	} {
+		if !strings.Contains(body, line) {
+			t.Fatalf("expected %q in\n%s", line, body)
+		}
+	}
+
+	_, body = serveTest(t, handler, "application/openmetrics-text; version=1.0.0")
+	expected := `# HELP http_requests Requests.
+# TYPE http_requests counter
+http_requests_total{code="200"} 5
+# TYPE system_cpu_cores unknown
+system_cpu_cores 8
+# EOF
+`
+	if body != expected {
+		t.Fatalf("expected\n%s\nfound\n%s", expected, body)
+	}
+}
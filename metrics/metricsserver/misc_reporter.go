@@ -20,19 +20,34 @@ import (
 
 	"github.com/pkg/errors"
 
+	"github.com/deciphernow/gm-fabric-go/metrics/cpuvalues"
 	"github.com/deciphernow/gm-fabric-go/metrics/flatjson"
 	"github.com/deciphernow/gm-fabric-go/metrics/memvalues"
-	"github.com/shirou/gopsutil/cpu"
+	"github.com/deciphernow/gm-fabric-go/metrics/runtimevalues"
 )
 
 // MiscReporter implements the ReporterFunc interface
 type MiscReporter struct {
 	startTime time.Time
+	sampler   *cpuvalues.Sampler
+}
+
+// CPUSamplerOption sets the sampler whose latest values are reported;
+// the default is cpuvalues.Default()
+func CPUSamplerOption(sampler *cpuvalues.Sampler) func(*MiscReporter) {
+	return func(m *MiscReporter) {
+		m.sampler = sampler
+	}
 }
 
 // NewMiscReporter initializes a MiscReporter object
-func NewMiscReporter() MiscReporter {
-	return MiscReporter{startTime: time.Now().UTC()}
+func NewMiscReporter(options ...func(*MiscReporter)) MiscReporter {
+	m := MiscReporter{startTime: time.Now().UTC()}
+	for _, o := range options {
+		o(&m)
+	}
+
+	return m
 }
 
 // Report implements the Reporter interface it is called by the metrics server
@@ -42,10 +57,19 @@ func (m MiscReporter) Report(jWriter *flatjson.Writer) error {
 		return errors.Wrap(err, "memvalues.GetMemValues()")
 	}
 
-	var cpuPercent []float64
-	cpuPercent, err = cpu.Percent(time.Second, false)
+	sampler := m.sampler
+	if sampler == nil {
+		sampler = cpuvalues.Default()
+	}
+
+	cpuValues, err := sampler.Values()
 	if err != nil {
-		return errors.Wrap(err, "cpu.Percent failed")
+		return errors.Wrap(err, "sampler.Values()")
+	}
+
+	runtimeValues, err := runtimevalues.GetRuntimeValues()
+	if err != nil {
+		return errors.Wrap(err, "runtimevalues.GetRuntimeValues()")
 	}
 
 	for _, x := range []struct {
@@ -53,23 +77,54 @@ func (m MiscReporter) Report(jWriter *flatjson.Writer) error {
 		value interface{}
 	}{
 		{"system/start_time", duration2ms(time.Duration(m.startTime.UnixNano()))},
-		{"system/cpu.pct", cpuPercent[0]},
-		{"system/cpu_cores", runtime.NumCPU()},
+		{"system/cpu.pct", cpuValues.SystemPercent},
+		{"system/cpu_cores", cpuValues.Cores},
 		{"os", runtime.GOOS},
 		{"os_arch", runtime.GOARCH},
 		{"system/memory/available", memValues.SystemMemoryAvailable},
 		{"system/memory/used", memValues.SystemMemoryUsed},
 		{"system/memory/used_percent", memValues.SystemMemoryUsedPercent},
 		{"process/memory/used", memValues.ProcessMemoryUsed},
+		{"process/memory/limit", memValues.ProcessMemoryLimit},
+		{"process/cpu.pct", cpuValues.ProcessPercent},
+		{"process/cpu_limit_cores", memValues.CPULimitCores},
+		{"process/memory/used_percent", memValues.ProcessMemoryUsedPercent},
+		{"process/open_fds", runtimeValues.OpenFDs},
+		{"process/threads", runtimeValues.Threads},
+		{"process/cpu/user_secs", runtimeValues.CPUUserSeconds},
+		{"process/cpu/system_secs", runtimeValues.CPUSystemSeconds},
+		{"runtime/goroutines", runtimeValues.Goroutines},
+		{"runtime/cgo_calls", runtimeValues.CgoCalls},
+		{"runtime/heap/alloc", runtimeValues.HeapAlloc},
+		{"runtime/heap/inuse", runtimeValues.HeapInuse},
+		{"runtime/heap/objects", runtimeValues.HeapObjects},
+		{"runtime/gc/cycles", runtimeValues.GCCycles},
+		{"runtime/gc/pause_total_ms", duration2fms(runtimeValues.GCPauseTotal)},
 	} {
 		if err = jWriter.Write(x.key, x.value); err != nil {
 			return errors.Wrap(err, "jWriter.Write")
 		}
 	}
 
+	for i, pause := range runtimeValues.GCPauseQuantiles {
+		key := "runtime/gc/pause_ms/" + pauseQuantileNames[i]
+		if err = jWriter.Write(key, duration2fms(pause)); err != nil {
+			return errors.Wrap(err, "jWriter.Write")
+		}
+	}
+
 	return nil
 }
 
+// pauseQuantileNames name the keys of runtimevalues.PauseQuantiles
+var pauseQuantileNames = []string{"min", "p25", "p50", "p75", "max"}
+
+// duration2fms converts a duration to fractional milliseconds, for values
+// such as GC pauses that are usually below a millisecond
+func duration2fms(d time.Duration) float64 {
+	return d.Seconds() * 1000
+}
+
 func duration2ms(d time.Duration) int64 {
 	const nsPerMs = 1000000
 
@@ -0,0 +1,88 @@
+// Copyright 2017 Decipher Technology Studios LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metricsserver
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Content types of the formats served on /metrics
+const (
+	JSONContentType           = "application/json"
+	PrometheusTextContentType = "text/plain; version=0.0.4; charset=utf-8"
+	OpenMetricsContentType    = "application/openmetrics-text; version=1.0.0; charset=utf-8"
+)
+
+// contentFormat is a format that metrics can be served in
+type contentFormat int
+
+const (
+	jsonFormat contentFormat = iota
+	prometheusTextFormat
+	openMetricsFormat
+)
+
+func (f contentFormat) contentType() string {
+	switch f {
+	case prometheusTextFormat:
+		return PrometheusTextContentType
+	case openMetricsFormat:
+		return OpenMetricsContentType
+	}
+	return JSONContentType
+}
+
+// negotiateFormat returns the format preferred by an Accept header;
+// among media ranges of equal quality the first one wins.
+// A missing header or a wildcard selects JSON.
+func negotiateFormat(accept string) contentFormat {
+	best := jsonFormat
+	bestQuality := -1.0
+
+	for _, mediaRange := range strings.Split(accept, ",") {
+		params := strings.Split(mediaRange, ";")
+		mediaType := strings.ToLower(strings.TrimSpace(params[0]))
+
+		var format contentFormat
+		switch mediaType {
+		case "application/openmetrics-text":
+			format = openMetricsFormat
+		case "text/plain", "text/*":
+			format = prometheusTextFormat
+		case "application/json", "application/*", "*/*":
+			format = jsonFormat
+		default:
+			continue
+		}
+
+		quality := 1.0
+		for _, param := range params[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if q, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					quality = q
+				}
+			}
+		}
+
+		if quality > 0 && quality > bestQuality {
+			best = format
+			bestQuality = quality
+		}
+	}
+
+	return best
+}
@@ -0,0 +1,137 @@
+// Copyright 2017 Decipher Technology Studios LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metricsserver
+
+import (
+	"bufio"
+	"math"
+	"strings"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+// writeOpenMetricsFamily writes a family gathered from a Prometheus
+// registry in the OpenMetrics text format
+func writeOpenMetricsFamily(w *bufio.Writer, mf *dto.MetricFamily) error {
+	name := mf.GetName()
+
+	var typeName string
+	switch mf.GetType() {
+	case dto.MetricType_COUNTER:
+		// OpenMetrics counter families are named without the _total suffix
+		name = strings.TrimSuffix(name, "_total")
+		typeName = "counter"
+	case dto.MetricType_GAUGE:
+		typeName = "gauge"
+	case dto.MetricType_SUMMARY:
+		typeName = "summary"
+	case dto.MetricType_HISTOGRAM:
+		typeName = "histogram"
+	default:
+		typeName = "unknown"
+	}
+
+	if mf.Help != nil {
+		_, err := w.WriteString("# HELP " + name + " " + helpReplacer.Replace(mf.GetHelp()) + "\n")
+		if err != nil {
+			return err
+		}
+	}
+	if _, err := w.WriteString("# TYPE " + name + " " + typeName + "\n"); err != nil {
+		return err
+	}
+
+	for _, m := range mf.GetMetric() {
+		labels := make([]labelPair, 0, len(m.GetLabel())+1)
+		for _, lp := range m.GetLabel() {
+			labels = append(labels, labelPair{name: lp.GetName(), value: lp.GetValue()})
+		}
+
+		var err error
+		switch mf.GetType() {
+		case dto.MetricType_COUNTER:
+			err = writeSample(w, name+"_total", labels, m.GetCounter().GetValue())
+		case dto.MetricType_GAUGE:
+			err = writeSample(w, name, labels, m.GetGauge().GetValue())
+		case dto.MetricType_SUMMARY:
+			err = writeOpenMetricsSummary(w, name, labels, m.GetSummary())
+		case dto.MetricType_HISTOGRAM:
+			err = writeOpenMetricsHistogram(w, name, labels, m.GetHistogram())
+		default:
+			err = writeSample(w, name, labels, m.GetUntyped().GetValue())
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+var helpReplacer = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+
+func writeOpenMetricsSummary(
+	w *bufio.Writer,
+	name string,
+	labels []labelPair,
+	summary *dto.Summary,
+) error {
+	for _, q := range summary.GetQuantile() {
+		quantileLabels := append(labels[:len(labels):len(labels)], labelPair{
+			name:  "quantile",
+			value: formatSampleValue(q.GetQuantile()),
+		})
+		if err := writeSample(w, name, quantileLabels, q.GetValue()); err != nil {
+			return err
+		}
+	}
+	if err := writeSample(w, name+"_sum", labels, summary.GetSampleSum()); err != nil {
+		return err
+	}
+	return writeSample(w, name+"_count", labels, float64(summary.GetSampleCount()))
+}
+
+func writeOpenMetricsHistogram(
+	w *bufio.Writer,
+	name string,
+	labels []labelPair,
+	histogram *dto.Histogram,
+) error {
+	infSeen := false
+	for _, b := range histogram.GetBucket() {
+		if math.IsInf(b.GetUpperBound(), 1) {
+			infSeen = true
+		}
+		bucketLabels := append(labels[:len(labels):len(labels)], labelPair{
+			name:  "le",
+			value: formatSampleValue(b.GetUpperBound()),
+		})
+		err := writeSample(w, name+"_bucket", bucketLabels, float64(b.GetCumulativeCount()))
+		if err != nil {
+			return err
+		}
+	}
+	if !infSeen {
+		bucketLabels := append(labels[:len(labels):len(labels)], labelPair{name: "le", value: "+Inf"})
+		err := writeSample(w, name+"_bucket", bucketLabels, float64(histogram.GetSampleCount()))
+		if err != nil {
+			return err
+		}
+	}
+	if err := writeSample(w, name+"_sum", labels, histogram.GetSampleSum()); err != nil {
+		return err
+	}
+	return writeSample(w, name+"_count", labels, float64(histogram.GetSampleCount()))
+}
@@ -15,13 +15,44 @@
 package metricsserver
 
 import (
+	"fmt"
 	"net/http"
 
-	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/client_golang/prometheus"
 )
 
+type prometheusHandler struct {
+	gatherer  prometheus.Gatherer
+	reporters []DeltaReportFunc
+}
+
 // NewPrometheusHandler returns an object that implments the http.Handler interface
-// It enables Prometheus to scrape accumulated stats
+// It enables Prometheus to scrape accumulated stats: the metrics registered
+// with the default Prometheus registry and the values of the reporters,
+// in the Prometheus text format or, if the Accept header asks for it,
+// the OpenMetrics text format
 func NewPrometheusHandler(reporters ...ReportFunc) http.Handler {
-	return promhttp.Handler()
+	return prometheusHandler{
+		gatherer:  prometheus.DefaultGatherer,
+		reporters: cumulativeReportFuncs(reporters),
+	}
+}
+
+func (ph prometheusHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	format := negotiateFormat(req.Header.Get("Accept"))
+	if format == jsonFormat {
+		format = prometheusTextFormat
+	}
+
+	gathered, err := ph.gatherer.Gather()
+	if err != nil {
+		http.Error(
+			w,
+			fmt.Sprintf("Gather failed: %v", err),
+			http.StatusInternalServerError,
+		)
+		return
+	}
+
+	serveExposition(w, format, gathered, ph.reporters, "")
 }
@@ -16,37 +16,22 @@ package metricsserver
 
 import (
 	"crypto/tls"
-	"log"
-	"net/http"
 )
 
 // Start starts the metrics server
 // This function runs an internal goroutine
-// This function is deprecated: retained for compatibility
+// Errors binding the address are returned
+// This function is deprecated: use NewServer, which can be shut down
 func Start(
 	metricsAddress string,
 	tlsConf *tls.Config,
 	reporters ...ReportFunc,
 ) error {
-	mux := http.NewServeMux()
-	mux.Handle("/metrics", NewDashboardHandler(reporters...))
+	server := NewServer(
+		metricsAddress,
+		TLSOption(tlsConf),
+		ReportersOption(reporters...),
+	)
 
-	server := NewMetricsServer(metricsAddress, tlsConf)
-	server.Handler = mux
-
-	if server.TLSConfig == nil {
-		go func() {
-			if err := server.ListenAndServe(); err != nil {
-				log.Printf("server.ListenAndServe() failed: %v", err)
-			}
-		}()
-	} else {
-		go func() {
-			if err := server.ListenAndServeTLS("", ""); err != nil {
-				log.Printf("server.ListenAndServeTLS() failed: %v", err)
-			}
-		}()
-	}
-
-	return nil
+	return server.Start()
 }
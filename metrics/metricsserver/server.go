@@ -0,0 +1,338 @@
+// Copyright 2017 Decipher Technology Studios LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metricsserver
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"expvar"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+
+	"github.com/deciphernow/gm-fabric-go/health"
+	"github.com/deciphernow/gm-fabric-go/listauth"
+	"github.com/deciphernow/gm-fabric-go/listauth/auth"
+)
+
+// Paths served by Server
+const (
+	MetricsPath = "/metrics"
+	HealthPath  = "/health"
+	ReadyPath   = "/ready"
+	PprofPath   = "/debug/pprof/"
+	ExpvarPath  = "/debug/vars"
+)
+
+// Server serves metrics, health and readiness, and optionally the
+// pprof and expvar debugging endpoints
+type Server struct {
+	address    string
+	tlsConf    *tls.Config
+	reporters  []DeltaReportFunc
+	consumers  []string
+	pprof      bool
+	expvar     bool
+	authorizor *auth.Authorizor
+	logger     zerolog.Logger
+	health     *health.Registry
+	readiness  *health.Registry
+	drainDelay time.Duration
+
+	sync.Mutex
+	server       *http.Server
+	listener     net.Listener
+	shuttingDown bool
+	done         chan struct{} // closed when Serve returns
+	serveErr     error
+}
+
+// TLSOption returns a Server option function that serves HTTPS
+func TLSOption(tlsConf *tls.Config) func(*Server) {
+	return func(s *Server) {
+		s.tlsConf = tlsConf
+	}
+}
+
+// ReportersOption returns a Server option function that adds reporters
+// to the metrics endpoint
+func ReportersOption(reporters ...ReportFunc) func(*Server) {
+	return func(s *Server) {
+		s.reporters = append(s.reporters, cumulativeReportFuncs(reporters)...)
+	}
+}
+
+// DeltaReportersOption returns a Server option function that adds
+// reporters supporting DeltaQueryParam to the metrics endpoint
+func DeltaReportersOption(reporters ...DeltaReportFunc) func(*Server) {
+	return func(s *Server) {
+		s.reporters = append(s.reporters, reporters...)
+	}
+}
+
+// DeltaConsumersOption returns a Server option function that accepts the
+// consumers in DeltaQueryParam, along with DefaultDeltaConsumer
+func DeltaConsumersOption(consumers ...string) func(*Server) {
+	return func(s *Server) {
+		s.consumers = append(s.consumers, consumers...)
+	}
+}
+
+// PprofOption returns a Server option function that serves the
+// net/http/pprof profiles under PprofPath
+func PprofOption() func(*Server) {
+	return func(s *Server) {
+		s.pprof = true
+	}
+}
+
+// ExpvarOption returns a Server option function that serves the expvar
+// variables at ExpvarPath
+func ExpvarOption() func(*Server) {
+	return func(s *Server) {
+		s.expvar = true
+	}
+}
+
+// ClientAuthOption returns a Server option function that authorizes
+// requests by the distinguished name of the client certificate, see
+// listauth.HTTPAuthenticate. The server must use a TLS config that
+// requests client certificates, e.g. from tlsutil.BuildServerTLSConfig.
+// The health and readiness endpoints are not authorized, so that
+// orchestrators can probe them.
+func ClientAuthOption(authorizor auth.Authorizor) func(*Server) {
+	return func(s *Server) {
+		s.authorizor = &authorizor
+	}
+}
+
+// LoggerOption returns a Server option function that sets the logger
+func LoggerOption(logger zerolog.Logger) func(*Server) {
+	return func(s *Server) {
+		s.logger = logger
+	}
+}
+
+// HealthRegistryOption returns a Server option function that serves the
+// checks of registry on HealthPath; by default there are none
+func HealthRegistryOption(registry *health.Registry) func(*Server) {
+	return func(s *Server) {
+		if registry != nil {
+			s.health = registry
+		}
+	}
+}
+
+// ReadinessRegistryOption returns a Server option function that serves the
+// checks of registry on ReadyPath; by default there are none. The same
+// registry may serve health and readiness.
+func ReadinessRegistryOption(registry *health.Registry) func(*Server) {
+	return func(s *Server) {
+		if registry != nil {
+			s.readiness = registry
+		}
+	}
+}
+
+// DrainDelayOption returns a Server option function that sets the time
+// Shutdown waits between marking the server not ready and stopping it,
+// so that load balancers polling ReadyPath stop sending requests first.
+// By default there is no delay.
+func DrainDelayOption(delay time.Duration) func(*Server) {
+	return func(s *Server) {
+		if delay > 0 {
+			s.drainDelay = delay
+		}
+	}
+}
+
+// NewServer returns a Server that will listen on address
+func NewServer(address string, options ...func(*Server)) *Server {
+	s := Server{
+		address:   address,
+		logger:    zerolog.New(os.Stderr).With().Timestamp().Logger(),
+		health:    health.NewRegistry(),
+		readiness: health.NewRegistry(),
+	}
+
+	for _, f := range options {
+		f(&s)
+	}
+
+	return &s
+}
+
+// Handler returns the handler for all the endpoints of the server
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+
+	protect := func(h http.Handler) http.Handler { return h }
+	if s.authorizor != nil {
+		protect = listauth.HTTPAuthenticate(s.logger, *s.authorizor)
+	}
+
+	mux.Handle(
+		MetricsPath,
+		protect(NewDeltaConsumersDashboardHandler(s.consumers, s.reporters...)),
+	)
+	mux.Handle(HealthPath, s.health.Handler())
+	mux.Handle(ReadyPath, readinessHandler{
+		checks: s.readiness.Handler(),
+		down:   s.isShuttingDown,
+	})
+
+	if s.pprof {
+		mux.Handle(PprofPath, protect(http.HandlerFunc(pprof.Index)))
+		mux.Handle(PprofPath+"cmdline", protect(http.HandlerFunc(pprof.Cmdline)))
+		mux.Handle(PprofPath+"profile", protect(http.HandlerFunc(pprof.Profile)))
+		mux.Handle(PprofPath+"symbol", protect(http.HandlerFunc(pprof.Symbol)))
+		mux.Handle(PprofPath+"trace", protect(http.HandlerFunc(pprof.Trace)))
+	}
+	if s.expvar {
+		mux.Handle(ExpvarPath, protect(expvar.Handler()))
+	}
+
+	return mux
+}
+
+// Start listens on the server address and serves in a goroutine.
+// Errors binding the address are returned; later errors are returned
+// by Shutdown.
+func (s *Server) Start() error {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.server != nil {
+		return errors.New("server already started")
+	}
+	if s.authorizor != nil && s.tlsConf == nil {
+		return errors.New("client authorization requires TLS")
+	}
+
+	listener, err := net.Listen("tcp", s.address)
+	if err != nil {
+		return errors.Wrapf(err, "net.Listen %s", s.address)
+	}
+	if s.tlsConf != nil {
+		listener = tls.NewListener(listener, s.tlsConf)
+	}
+
+	s.listener = listener
+	s.server = NewMetricsServer(s.address, s.tlsConf)
+	s.server.Handler = s.Handler()
+	s.done = make(chan struct{})
+
+	go func(server *http.Server, done chan struct{}) {
+		err := server.Serve(listener)
+		if err != http.ErrServerClosed {
+			s.serveErr = err
+		}
+		close(done)
+	}(s.server, s.done)
+
+	return nil
+}
+
+// Addr returns the address the server is listening on, which differs
+// from the configured address if that has port 0
+func (s *Server) Addr() string {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.listener == nil {
+		return s.address
+	}
+	return s.listener.Addr().String()
+}
+
+// Shutdown marks the server not ready, waits for the DrainDelayOption,
+// then stops it gracefully, waiting for active requests until ctx is done.
+// It returns the error that stopped the server, if any.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.Lock()
+	server := s.server
+	done := s.done
+	draining := !s.shuttingDown
+	s.shuttingDown = true
+	s.Unlock()
+
+	if server == nil {
+		return nil
+	}
+
+	if draining && s.drainDelay > 0 {
+		timer := time.NewTimer(s.drainDelay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+		}
+	}
+
+	if err := server.Shutdown(ctx); err != nil {
+		return errors.Wrap(err, "server.Shutdown")
+	}
+
+	select {
+	case <-done:
+		return errors.Wrap(s.serveErr, "server.Serve")
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s *Server) isShuttingDown() bool {
+	s.Lock()
+	defer s.Unlock()
+	return s.shuttingDown
+}
+
+// ShutdownCheckName is the name of the failed check reported on ReadyPath
+// once Shutdown is called
+const ShutdownCheckName = "shutdown"
+
+// readinessHandler serves the readiness checks, or fails without running
+// them once the server is shutting down
+type readinessHandler struct {
+	checks http.Handler
+	down   func() bool
+}
+
+func (rh readinessHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if !rh.down() {
+		rh.checks.ServeHTTP(w, req)
+		return
+	}
+
+	w.Header().Set("content-type", JSONContentType)
+	w.WriteHeader(http.StatusServiceUnavailable)
+	json.NewEncoder(w).Encode(health.Report{
+		Status: health.StatusFail,
+		Checks: []health.Result{{
+			Name:     ShutdownCheckName,
+			Status:   health.StatusFail,
+			Critical: true,
+			Error:    "server is shutting down",
+			Checked:  time.Now(),
+		}},
+	})
+}
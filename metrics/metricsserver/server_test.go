@@ -0,0 +1,254 @@
+// Copyright 2017 Decipher Technology Studios LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metricsserver
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+
+	"github.com/deciphernow/gm-fabric-go/health"
+	"github.com/deciphernow/gm-fabric-go/listauth/auth"
+	"github.com/deciphernow/gm-fabric-go/metrics/flatjson"
+)
+
+func TestServerStartShutdown(t *testing.T) {
+	reporter := func(jWriter *flatjson.Writer) error {
+		return jWriter.Write("value", 1)
+	}
+	s := NewServer("127.0.0.1:0", ReportersOption(reporter))
+	if err := s.Start(); err != nil {
+		t.Fatalf("Start failed: %s", err)
+	}
+
+	// the address is in use, so a second server must fail to start
+	if err := NewServer(s.Addr()).Start(); err == nil {
+		t.Fatalf("expected bind error")
+	}
+
+	resp, err := http.Get(fmt.Sprintf("http://%s%s", s.Addr(), MetricsPath))
+	if err != nil {
+		t.Fatalf("http.Get failed: %s", err)
+	}
+	var ts map[string]interface{}
+	err = json.NewDecoder(resp.Body).Decode(&ts)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatalf("Decode failed: %s", err)
+	}
+	if ts["value"] != float64(1) {
+		t.Fatalf("expected value 1 found %v", ts["value"])
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err = s.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown failed: %s", err)
+	}
+	if err = s.Shutdown(ctx); err != nil {
+		t.Fatalf("second Shutdown failed: %s", err)
+	}
+	if _, err = http.Get(fmt.Sprintf("http://%s%s", s.Addr(), MetricsPath)); err == nil {
+		t.Fatalf("expected error after Shutdown")
+	}
+}
+
+func serveCheck(t *testing.T, handler http.Handler, path string) (int, health.Report) {
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("GET", path, nil))
+
+	var report health.Report
+	if err := json.Unmarshal(recorder.Body.Bytes(), &report); err != nil {
+		t.Fatalf("json.Unmarshal failed: %s; %s", err, recorder.Body.String())
+	}
+	return recorder.Code, report
+}
+
+func TestServerChecks(t *testing.T) {
+	var dbErr error
+	healthRegistry := health.NewRegistry()
+	readiness := health.NewRegistry()
+	for _, c := range []struct {
+		registry *health.Registry
+		name     string
+		check    health.CheckFunc
+	}{
+		{healthRegistry, "db", func(context.Context) error { return dbErr }},
+		{readiness, "warm", func(context.Context) error { return nil }},
+	} {
+		if err := c.registry.Register(c.name, c.check); err != nil {
+			t.Fatalf("Register %s failed: %s", c.name, err)
+		}
+	}
+	s := NewServer(
+		"127.0.0.1:0",
+		HealthRegistryOption(healthRegistry),
+		ReadinessRegistryOption(readiness),
+	)
+	handler := s.Handler()
+
+	if code, report := serveCheck(t, handler, HealthPath); code != http.StatusOK ||
+		report.Checks[0].Status != health.StatusPass {
+		t.Fatalf("healthy: unexpected %d %+v", code, report)
+	}
+
+	dbErr = errors.New("connection refused")
+	if code, report := serveCheck(t, handler, HealthPath); code != http.StatusServiceUnavailable ||
+		report.Checks[0].Error != "connection refused" {
+		t.Fatalf("unhealthy: unexpected %d %+v", code, report)
+	}
+
+	// checks registered while serving are run
+	err := readiness.Register("slow", func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}, health.TimeoutOption(10*time.Millisecond))
+	if err != nil {
+		t.Fatalf("Register slow failed: %s", err)
+	}
+	if code, report := serveCheck(t, handler, ReadyPath); code != http.StatusServiceUnavailable ||
+		len(report.Checks) != 2 || report.Checks[1].Status != health.StatusPass {
+		t.Fatalf("slow: unexpected %d %+v", code, report)
+	}
+	readiness.Unregister("slow")
+
+	if err = s.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown failed: %s", err)
+	}
+	if code, report := serveCheck(t, handler, ReadyPath); code != http.StatusServiceUnavailable ||
+		len(report.Checks) != 1 || report.Checks[0].Name != ShutdownCheckName {
+		t.Fatalf("shutting down: unexpected %d %+v", code, report)
+	}
+}
+
+func TestServerDrainDelay(t *testing.T) {
+	const delay = 200 * time.Millisecond
+
+	s := NewServer("127.0.0.1:0", DrainDelayOption(delay))
+	if err := s.Start(); err != nil {
+		t.Fatalf("Start failed: %s", err)
+	}
+	get := func(path string) (int, error) {
+		resp, err := http.Get(fmt.Sprintf("http://%s%s", s.Addr(), path))
+		if err != nil {
+			return 0, err
+		}
+		resp.Body.Close()
+		return resp.StatusCode, nil
+	}
+
+	if code, err := get(ReadyPath); err != nil || code != http.StatusOK {
+		t.Fatalf("before Shutdown: unexpected %d %v", code, err)
+	}
+
+	start := time.Now()
+	shutdownErr := make(chan error, 1)
+	go func() {
+		shutdownErr <- s.Shutdown(context.Background())
+	}()
+	for !s.isShuttingDown() {
+		time.Sleep(time.Millisecond)
+	}
+
+	// during the delay the server is not ready, but still serves
+	if code, err := get(ReadyPath); err != nil || code != http.StatusServiceUnavailable {
+		t.Fatalf("draining: unexpected %d %v", code, err)
+	}
+	if code, err := get(MetricsPath); err != nil || code != http.StatusOK {
+		t.Fatalf("draining metrics: unexpected %d %v", code, err)
+	}
+
+	if err := <-shutdownErr; err != nil {
+		t.Fatalf("Shutdown failed: %s", err)
+	}
+	if elapsed := time.Since(start); elapsed < delay {
+		t.Fatalf("expected Shutdown to wait %s, it took %s", delay, elapsed)
+	}
+	if _, err := get(MetricsPath); err == nil {
+		t.Fatalf("expected error after Shutdown")
+	}
+}
+
+func TestServerOptionalRoutes(t *testing.T) {
+	testCases := []struct {
+		options  []func(*Server)
+		path     string
+		expected int
+	}{
+		{nil, PprofPath, http.StatusNotFound},
+		{[]func(*Server){PprofOption()}, PprofPath, http.StatusOK},
+		{nil, ExpvarPath, http.StatusNotFound},
+		{[]func(*Server){ExpvarOption()}, ExpvarPath, http.StatusOK},
+	}
+
+	for i, tc := range testCases {
+		recorder := httptest.NewRecorder()
+		NewServer("", tc.options...).Handler().ServeHTTP(
+			recorder,
+			httptest.NewRequest("GET", tc.path, nil),
+		)
+		if recorder.Code != tc.expected {
+			t.Fatalf("#%d %s: expected %d found %d", i, tc.path, tc.expected, recorder.Code)
+		}
+	}
+}
+
+func TestServerClientAuth(t *testing.T) {
+	authorizor, err := auth.NewFromLists(nil, []string{"dc=deciphernow,dc=com"})
+	if err != nil {
+		t.Fatalf("auth.NewFromLists failed: %s", err)
+	}
+	s := NewServer("", ClientAuthOption(authorizor), LoggerOption(zerolog.Nop()))
+	handler := s.Handler()
+
+	if err = s.Start(); err == nil {
+		t.Fatalf("expected error starting client authorization without TLS")
+	}
+
+	testCases := []struct {
+		path     string
+		cn       string
+		expected int
+	}{
+		{MetricsPath, "", http.StatusUnauthorized},
+		{MetricsPath, "alec.holmes", http.StatusOK},
+		{HealthPath, "", http.StatusOK},
+	}
+
+	for i, tc := range testCases {
+		req := httptest.NewRequest("GET", tc.path, nil)
+		if tc.cn != "" {
+			req.Header.Set("USER_DN", fmt.Sprintf("cn=%s,dc=deciphernow,dc=com", tc.cn))
+			req.TLS = &tls.ConnectionState{
+				PeerCertificates: []*x509.Certificate{{Subject: pkix.Name{CommonName: tc.cn}}},
+			}
+		}
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, req)
+		if recorder.Code != tc.expected {
+			t.Fatalf("#%d %s: expected %d found %d", i, tc.path, tc.expected, recorder.Code)
+		}
+	}
+}
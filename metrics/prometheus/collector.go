@@ -16,9 +16,14 @@ package prometheus
 
 import (
 	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
 	"time"
 
 	"github.com/deciphernow/gm-fabric-go/metrics/apistats"
+	"github.com/deciphernow/gm-fabric-go/metrics/cardinality"
+	"github.com/deciphernow/gm-fabric-go/metrics/runtimevalues"
 	"github.com/pkg/errors"
 	prom "github.com/prometheus/client_golang/prometheus"
 )
@@ -34,6 +39,22 @@ type SystemMetricsEntry struct {
 	SystemMemoryUsed        float64 // "system_memory_used"
 	SystemMemoryUsedPercent float64 // "system_memory_used_percent"
 	ProcessMemoryUsed       float64 // "process_memory_used"
+	ProcessMemoryLimit      float64 // "process_memory_limit", 0 if not limited
+	ProcessCPUPercent       float64 // "process_cpu_pct"
+	ProcessCPULimitCores    float64 // "process_cpu_limit_cores", 0 if not limited
+
+	Goroutines       float64   // "runtime_goroutines"
+	Threads          float64   // "runtime_threads"
+	OpenFDs          float64   // "runtime_open_fds"
+	CgoCalls         float64   // "runtime_cgo_calls_total"
+	CPUUserSeconds   float64   // "runtime_cpu_user_seconds_total"
+	CPUSystemSeconds float64   // "runtime_cpu_system_seconds_total"
+	HeapAlloc        float64   // "runtime_heap_alloc_bytes"
+	HeapInuse        float64   // "runtime_heap_inuse_bytes"
+	HeapObjects      float64   // "runtime_heap_objects"
+	GCCycles         float64   // "runtime_gc_cycles_total"
+	GCPauseTotal     float64   // "runtime_gc_pause_seconds_total"
+	GCPauseQuantiles []float64 // "runtime_gc_pause_seconds", indexed like runtimevalues.PauseQuantiles
 }
 
 // Collector collects Prometheus stats
@@ -47,6 +68,19 @@ type Collector interface {
 	CollectSystemMetrics(SystemMetricsEntry)
 }
 
+// RequestCollector is a Collector that can derive labels from the HTTP
+// request; the HTTP handler calls CollectRequest instead of Collect
+type RequestCollector interface {
+	Collector
+
+	CollectRequest(
+		entry apistats.APIStatsEntry,
+		rawKey string,
+		method string,
+		req *http.Request,
+	) error
+}
+
 // CollectorType implements the Collector interface
 type CollectorType struct {
 	requestDurationVec           *prom.HistogramVec
@@ -61,50 +95,295 @@ type CollectorType struct {
 	systemMemoryUsedGauge        prom.Gauge
 	systemMemoryUsedPercentGauge prom.Gauge
 	processMemoryUsedGauge       prom.Gauge
+	processMemoryLimitGauge      prom.Gauge
+	processCPUPercentGauge       prom.Gauge
+	processCPULimitCoresGauge    prom.Gauge
+	goroutinesGauge              prom.Gauge
+	threadsGauge                 prom.Gauge
+	openFDsGauge                 prom.Gauge
+	heapAllocGauge               prom.Gauge
+	heapInuseGauge               prom.Gauge
+	heapObjectsGauge             prom.Gauge
+	runtimeCounterFuncs          []prom.CounterFunc
+	gcPauseVec                   *prom.GaugeVec
+	guard                        *cardinality.Guard
+	registerer                   prom.Registerer
+	buckets                      []float64
+	constLabels                  prom.Labels
+	extraLabelNames              []string
+	requestLabelFunc             RequestLabelFunc
+	grpcMetrics                  *grpcServerMetrics
+	clientMetrics                *httpClientMetrics
+	enableGRPCMetrics            bool
+	enableClientMetrics          bool
+}
+
+// runtimeCounters holds the latest values of the runtime counters, in the
+// order of runtimeCounterOpts, for the CounterFuncs to read when collected.
+// The values are those of the process, so collectors that share a
+// registerer, and so the CounterFuncs, share them as well.
+var runtimeCounters runtimeCounterValues
+
+type runtimeCounterValues struct {
+	sync.Mutex
+	values []float64
+}
+
+func (rc *runtimeCounterValues) set(values ...float64) {
+	rc.Lock()
+	defer rc.Unlock()
+	rc.values = values
+}
+
+func (rc *runtimeCounterValues) value(i int) float64 {
+	rc.Lock()
+	defer rc.Unlock()
+	if i >= len(rc.values) || rc.values[i] < 0 {
+		// not collected yet, or not readable on the platform
+		return 0
+	}
+	return rc.values[i]
+}
+
+// runtimeCounterOpts are the runtime values that only grow, in the order of
+// runtimeCounterValues.values
+var runtimeCounterOpts = []prom.CounterOpts{
+	{Name: "runtime_cgo_calls_total", Help: "The number of cgo calls made by this process."},
+	{Name: "runtime_cpu_user_seconds_total", Help: "The user CPU time spent by this process."},
+	{Name: "runtime_cpu_system_seconds_total", Help: "The system CPU time spent by this process."},
+	{Name: "runtime_gc_cycles_total", Help: "The number of completed GC cycles."},
+	{Name: "runtime_gc_pause_seconds_total", Help: "The total GC pause time since the process started."},
+}
+
+// RequestLabelFunc returns the values of the extra labels of the request
+// metrics, by label name, derived from the request.
+// A label that is missing from the result has an empty value.
+type RequestLabelFunc func(req *http.Request) prom.Labels
+
+// CardinalityGuardOption returns a CollectorType option function that folds
+// keys rejected by guard into cardinality.OtherKey
+func CardinalityGuardOption(guard *cardinality.Guard) func(*CollectorType) {
+	return func(c *CollectorType) {
+		c.guard = guard
+	}
+}
+
+// RegistererOption returns a CollectorType option function that registers
+// the metrics with registerer instead of the default Prometheus registerer,
+// e.g. to create several collectors in tests
+func RegistererOption(registerer prom.Registerer) func(*CollectorType) {
+	return func(c *CollectorType) {
+		c.registerer = registerer
+	}
 }
 
-// NewCollector returns an object that implements the Collector interface
-func NewCollector() (*CollectorType, error) {
+// BucketsOption returns a CollectorType option function that sets the
+// buckets of the request duration histogram, in seconds; the default is
+// prom.DefBuckets. A collector whose registerer already has the histogram,
+// from an earlier collector, fails unless the buckets are the same.
+func BucketsOption(buckets []float64) func(*CollectorType) {
+	return func(c *CollectorType) {
+		c.buckets = buckets
+	}
+}
+
+// ExponentialBucketsOption returns a CollectorType option function that
+// sets count request duration buckets, the first with upper bound start and
+// each following one factor times wider, as prom.ExponentialBuckets does;
+// the buckets must match as for BucketsOption
+func ExponentialBucketsOption(start, factor float64, count int) func(*CollectorType) {
+	return func(c *CollectorType) {
+		c.buckets = prom.ExponentialBuckets(start, factor, count)
+	}
+}
+
+// ConstLabelsOption returns a CollectorType option function that adds
+// labels with fixed values, such as the service name and version, to every
+// metric of the collector
+func ConstLabelsOption(labels prom.Labels) func(*CollectorType) {
+	return func(c *CollectorType) {
+		c.constLabels = labels
+	}
+}
+
+// RequestLabelsOption returns a CollectorType option function that adds
+// labels named names to the request metrics, with values derived from each
+// HTTP request by labelFunc. Requests collected without an HTTP request,
+// such as gRPC calls, have empty values.
+func RequestLabelsOption(names []string, labelFunc RequestLabelFunc) func(*CollectorType) {
+	return func(c *CollectorType) {
+		c.extraLabelNames = names
+		c.requestLabelFunc = labelFunc
+	}
+}
+
+// GRPCServerMetricsOption returns a CollectorType option function that
+// enables the grpc_server_* metrics of GRPCCollector
+func GRPCServerMetricsOption() func(*CollectorType) {
+	return func(c *CollectorType) {
+		c.enableGRPCMetrics = true
+	}
+}
+
+// HTTPClientMetricsOption returns a CollectorType option function that
+// enables the http_client_* metrics of outgoing requests; without it,
+// outgoing requests are not collected
+func HTTPClientMetricsOption() func(*CollectorType) {
+	return func(c *CollectorType) {
+		c.enableClientMetrics = true
+	}
+}
+
+// NewCollector returns an object that implements the Collector interface.
+// Metrics that are already registered with the registerer, e.g. by an
+// earlier collector with the same options, are shared with it; a histogram
+// already registered with other buckets is an error.
+func NewCollector(options ...func(*CollectorType)) (*CollectorType, error) {
 	collector := CollectorType{
-		requestDurationVec:           createRequestDurationHistogram(),
-		requestSizeVec:               createRequestSizeVector(),
-		responseSizeVec:              createResponseSizeVector(),
-		tlsCount:                     createTLSCounter(),
-		nonTLSCount:                  createNonTLSCounter(),
-		systemStartTimeGauge:         createSystemStartTimeGauge(),
-		systemCPUPercentGauge:        createSystemCPUPercentGauge(),
-		systemCPUCoresGauge:          createSystemCPUCoresGauge(),
-		systemMemoryAvailableGauge:   createSystemMemoryAvailableGauge(),
-		systemMemoryUsedGauge:        createSystemMemoryUsedGauge(),
-		systemMemoryUsedPercentGauge: createSystemMemoryUsedPercentGauge(),
-		processMemoryUsedGauge:       createProcessMemoryUsedGauge(),
-	}
-
-	for i, c := range []prom.Collector{
-		collector.requestDurationVec,
-		collector.requestSizeVec,
-		collector.responseSizeVec,
-		collector.tlsCount,
-		collector.nonTLSCount,
-		collector.systemStartTimeGauge,
-		collector.systemCPUPercentGauge,
-		collector.systemCPUCoresGauge,
-		collector.systemMemoryAvailableGauge,
-		collector.systemMemoryUsedGauge,
-		collector.systemMemoryUsedPercentGauge,
-		collector.processMemoryUsedGauge,
-	} {
-		if err := prom.Register(c); err != nil {
+		registerer: prom.DefaultRegisterer,
+		buckets:    prom.DefBuckets,
+	}
+
+	for _, f := range options {
+		f(&collector)
+	}
+
+	for _, name := range collector.extraLabelNames {
+		for _, reserved := range LabelNames {
+			if name == reserved {
+				return nil, errors.Errorf("extra label %q is reserved", name)
+			}
+		}
+	}
+
+	constLabels := collector.constLabels
+	labelNames := append(append([]string(nil), LabelNames...), collector.extraLabelNames...)
+
+	collector.requestDurationVec = createRequestDurationHistogram(
+		collector.buckets,
+		constLabels,
+		labelNames,
+	)
+	collector.requestSizeVec = createRequestSizeVector(constLabels, labelNames)
+	collector.responseSizeVec = createResponseSizeVector(constLabels, labelNames)
+	collector.tlsCount = createTLSCounter(constLabels)
+	collector.nonTLSCount = createNonTLSCounter(constLabels)
+	collector.systemStartTimeGauge = createSystemStartTimeGauge(constLabels)
+	collector.systemCPUPercentGauge = createSystemCPUPercentGauge(constLabels)
+	collector.systemCPUCoresGauge = createSystemCPUCoresGauge(constLabels)
+	collector.systemMemoryAvailableGauge = createSystemMemoryAvailableGauge(constLabels)
+	collector.systemMemoryUsedGauge = createSystemMemoryUsedGauge(constLabels)
+	collector.systemMemoryUsedPercentGauge = createSystemMemoryUsedPercentGauge(constLabels)
+	collector.processMemoryUsedGauge = createProcessMemoryUsedGauge(constLabels)
+	collector.processMemoryLimitGauge = createProcessMemoryLimitGauge(constLabels)
+	collector.processCPUPercentGauge = createProcessCPUPercentGauge(constLabels)
+	collector.processCPULimitCoresGauge = createProcessCPULimitCoresGauge(constLabels)
+	collector.goroutinesGauge = createRuntimeGauge(
+		"runtime_goroutines",
+		"The number of goroutines that currently exist.",
+		constLabels,
+	)
+	collector.threadsGauge = createRuntimeGauge(
+		"runtime_threads",
+		"The number of OS threads of this process.",
+		constLabels,
+	)
+	collector.openFDsGauge = createRuntimeGauge(
+		"runtime_open_fds",
+		"The number of file descriptors open by this process.",
+		constLabels,
+	)
+	collector.heapAllocGauge = createRuntimeGauge(
+		"runtime_heap_alloc_bytes",
+		"The bytes of allocated heap objects.",
+		constLabels,
+	)
+	collector.heapInuseGauge = createRuntimeGauge(
+		"runtime_heap_inuse_bytes",
+		"The bytes in in-use heap spans.",
+		constLabels,
+	)
+	collector.heapObjectsGauge = createRuntimeGauge(
+		"runtime_heap_objects",
+		"The number of allocated heap objects.",
+		constLabels,
+	)
+	collector.gcPauseVec = createGCPauseVector(constLabels)
+	for _, opts := range runtimeCounterOpts {
+		collector.runtimeCounterFuncs = append(
+			collector.runtimeCounterFuncs,
+			createRuntimeCounter(opts, constLabels, len(collector.runtimeCounterFuncs)),
+		)
+	}
+
+	fields := []interface{}{
+		&collector.requestDurationVec,
+		&collector.requestSizeVec,
+		&collector.responseSizeVec,
+		&collector.tlsCount,
+		&collector.nonTLSCount,
+		&collector.systemStartTimeGauge,
+		&collector.systemCPUPercentGauge,
+		&collector.systemCPUCoresGauge,
+		&collector.systemMemoryAvailableGauge,
+		&collector.systemMemoryUsedGauge,
+		&collector.systemMemoryUsedPercentGauge,
+		&collector.processMemoryUsedGauge,
+		&collector.processMemoryLimitGauge,
+		&collector.processCPUPercentGauge,
+		&collector.processCPULimitCoresGauge,
+		&collector.goroutinesGauge,
+		&collector.threadsGauge,
+		&collector.openFDsGauge,
+		&collector.heapAllocGauge,
+		&collector.heapInuseGauge,
+		&collector.heapObjectsGauge,
+		&collector.gcPauseVec,
+	}
+	for i := range collector.runtimeCounterFuncs {
+		fields = append(fields, &collector.runtimeCounterFuncs[i])
+	}
+	if collector.enableGRPCMetrics {
+		m := newGRPCServerMetrics(collector.buckets, constLabels)
+		collector.grpcMetrics = &m
+		fields = append(fields, m.fields()...)
+	}
+	if collector.enableClientMetrics {
+		m := newHTTPClientMetrics(collector.buckets, constLabels)
+		collector.clientMetrics = &m
+		fields = append(fields, m.fields()...)
+	}
+
+	startTimeRegistered := false
+	for i, field := range fields {
+		registered, err := registerField(collector.registerer, field)
+		if err != nil {
+			// the histograms left are not registered
+			for _, rest := range fields[i:] {
+				if h, ok := rest.(**prom.HistogramVec); ok {
+					forgetHistogram(*h)
+				}
+			}
 			return nil, errors.Wrapf(err, "#%d:prometheus.Register", i)
 		}
+		if field == &collector.systemStartTimeGauge {
+			startTimeRegistered = registered
+		}
 	}
 
-	collector.systemStartTimeGauge.SetToCurrentTime()
+	// a start time gauge shared with an earlier collector keeps its time
+	if startTimeRegistered {
+		collector.systemStartTimeGauge.SetToCurrentTime()
+	}
 
 	return &collector, nil
 }
 
-func createRequestDurationHistogram() *prom.HistogramVec {
+func createRequestDurationHistogram(
+	buckets []float64,
+	constLabels prom.Labels,
+	labelNames []string,
+) *prom.HistogramVec {
 	// from github.com/prometheus/client_golang/prometheus/histogram.go
 	// see also LinearBuckets and ExponentialBuckets in the same file
 	//
@@ -116,111 +395,334 @@ func createRequestDurationHistogram() *prom.HistogramVec {
 	//	DefBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
 	//
 
-	return prom.NewHistogramVec(
+	return newHistogramVec(
 		prom.HistogramOpts{
-			Name:    "http_request_duration_seconds",
-			Help:    "duration of a single http request",
-			Buckets: prom.DefBuckets,
+			Name:        "http_request_duration_seconds",
+			Help:        "duration of a single http request",
+			Buckets:     buckets,
+			ConstLabels: constLabels,
 		},
-		LabelNames,
+		labelNames,
 	)
 }
 
-func createRequestSizeVector() *prom.CounterVec {
+func createRequestSizeVector(constLabels prom.Labels, labelNames []string) *prom.CounterVec {
 	return prom.NewCounterVec(
 		prom.CounterOpts{
-			Name: "http_request_size_bytes",
-			Help: "number of bytes read from the request",
+			Name:        "http_request_size_bytes",
+			Help:        "number of bytes read from the request",
+			ConstLabels: constLabels,
 		},
-		LabelNames,
+		labelNames,
 	)
 }
 
-func createResponseSizeVector() *prom.CounterVec {
+func createResponseSizeVector(constLabels prom.Labels, labelNames []string) *prom.CounterVec {
 	return prom.NewCounterVec(
 		prom.CounterOpts{
-			Name: "http_response_size_bytes",
-			Help: "number of bytes written to the response",
+			Name:        "http_response_size_bytes",
+			Help:        "number of bytes written to the response",
+			ConstLabels: constLabels,
 		},
-		LabelNames,
+		labelNames,
 	)
 }
 
-func createTLSCounter() prom.Counter {
+func createTLSCounter(constLabels prom.Labels) prom.Counter {
 	return prom.NewCounter(prom.CounterOpts{
-		Name: "tls_requests",
-		Help: "Number of requests using TLS.",
+		Name:        "tls_requests",
+		Help:        "Number of requests using TLS.",
+		ConstLabels: constLabels,
 	})
 }
 
-func createNonTLSCounter() prom.Counter {
+func createNonTLSCounter(constLabels prom.Labels) prom.Counter {
 	return prom.NewCounter(prom.CounterOpts{
-		Name: "non_tls_requests",
-		Help: "Number of requests not using TLS.",
+		Name:        "non_tls_requests",
+		Help:        "Number of requests not using TLS.",
+		ConstLabels: constLabels,
 	})
 }
 
-func createSystemStartTimeGauge() prom.Gauge {
+func createSystemStartTimeGauge(constLabels prom.Labels) prom.Gauge {
 	return prom.NewGauge(prom.GaugeOpts{
-		Name: "system_start_time_seconds",
-		Help: "The time the system started running.",
+		Name:        "system_start_time_seconds",
+		Help:        "The time the system started running.",
+		ConstLabels: constLabels,
 	})
 }
 
-func createSystemCPUPercentGauge() prom.Gauge {
+func createSystemCPUPercentGauge(constLabels prom.Labels) prom.Gauge {
 	return prom.NewGauge(prom.GaugeOpts{
-		Name: "system_cpu_pct",
-		Help: "Percent of CPU time in use by the system.",
+		Name:        "system_cpu_pct",
+		Help:        "Percent of CPU time in use by the system.",
+		ConstLabels: constLabels,
 	})
 }
 
-func createSystemCPUCoresGauge() prom.Gauge {
+func createSystemCPUCoresGauge(constLabels prom.Labels) prom.Gauge {
 	return prom.NewGauge(prom.GaugeOpts{
-		Name: "system_cpu_cores",
-		Help: "The number of CPU cores avaialble.",
+		Name:        "system_cpu_cores",
+		Help:        "The number of CPU cores avaialble.",
+		ConstLabels: constLabels,
 	})
 }
 
-func createSystemMemoryAvailableGauge() prom.Gauge {
+func createSystemMemoryAvailableGauge(constLabels prom.Labels) prom.Gauge {
 	return prom.NewGauge(prom.GaugeOpts{
-		Name: "system_memory_available",
-		Help: "The amount of memory available on the system.",
+		Name:        "system_memory_available",
+		Help:        "The amount of memory available on the system.",
+		ConstLabels: constLabels,
 	})
 }
 
-func createSystemMemoryUsedGauge() prom.Gauge {
+func createSystemMemoryUsedGauge(constLabels prom.Labels) prom.Gauge {
 	return prom.NewGauge(prom.GaugeOpts{
-		Name: "system_memory_used",
-		Help: "The amount of memory currently used by the system.",
+		Name:        "system_memory_used",
+		Help:        "The amount of memory currently used by the system.",
+		ConstLabels: constLabels,
 	})
 }
 
-func createSystemMemoryUsedPercentGauge() prom.Gauge {
+func createSystemMemoryUsedPercentGauge(constLabels prom.Labels) prom.Gauge {
 	return prom.NewGauge(prom.GaugeOpts{
-		Name: "system_memory_used_percent",
-		Help: "The of percentage of available memory currently used by the system.",
+		Name:        "system_memory_used_percent",
+		Help:        "The of percentage of available memory currently used by the system.",
+		ConstLabels: constLabels,
 	})
 }
 
-func createProcessMemoryUsedGauge() prom.Gauge {
+func createProcessMemoryUsedGauge(constLabels prom.Labels) prom.Gauge {
 	return prom.NewGauge(prom.GaugeOpts{
-		Name: "process_memory_used",
-		Help: "The amount of memory currently used by this process.",
+		Name:        "process_memory_used",
+		Help:        "The amount of memory currently used by this process.",
+		ConstLabels: constLabels,
 	})
 }
 
+func createProcessMemoryLimitGauge(constLabels prom.Labels) prom.Gauge {
+	return prom.NewGauge(prom.GaugeOpts{
+		Name:        "process_memory_limit",
+		Help:        "The container memory limit of this process, 0 if not limited.",
+		ConstLabels: constLabels,
+	})
+}
+
+func createProcessCPUPercentGauge(constLabels prom.Labels) prom.Gauge {
+	return prom.NewGauge(prom.GaugeOpts{
+		Name:        "process_cpu_pct",
+		Help:        "Percent of one CPU core in use by this process.",
+		ConstLabels: constLabels,
+	})
+}
+
+func createProcessCPULimitCoresGauge(constLabels prom.Labels) prom.Gauge {
+	return prom.NewGauge(prom.GaugeOpts{
+		Name:        "process_cpu_limit_cores",
+		Help:        "The container CPU quota of this process in cores, 0 if not limited.",
+		ConstLabels: constLabels,
+	})
+}
+
+// createRuntimeGauge creates a gauge for a runtime value. The names differ
+// from those of the Go and process collectors of the default registry,
+// so that both can be registered.
+func createRuntimeGauge(name, help string, constLabels prom.Labels) prom.Gauge {
+	return prom.NewGauge(prom.GaugeOpts{
+		Name:        name,
+		Help:        help,
+		ConstLabels: constLabels,
+	})
+}
+
+// createRuntimeCounter creates a counter that reads the value of runtime
+// counter i when it is collected
+func createRuntimeCounter(opts prom.CounterOpts, constLabels prom.Labels, i int) prom.CounterFunc {
+	opts.ConstLabels = constLabels
+	return prom.NewCounterFunc(opts, func() float64 {
+		return runtimeCounters.value(i)
+	})
+}
+
+// histogramOpts holds the name and buckets of the histograms created by
+// newHistogramVec until they are registered, and of the registered ones,
+// so that a collector sharing a registered histogram can check that it
+// asked for the same buckets
+var histogramOpts = struct {
+	sync.Mutex
+	m map[*prom.HistogramVec]prom.HistogramOpts
+}{m: make(map[*prom.HistogramVec]prom.HistogramOpts)}
+
+// newHistogramVec creates a HistogramVec and records its options
+func newHistogramVec(opts prom.HistogramOpts, labelNames []string) *prom.HistogramVec {
+	if len(opts.Buckets) == 0 {
+		opts.Buckets = prom.DefBuckets
+	}
+	h := prom.NewHistogramVec(opts, labelNames)
+
+	histogramOpts.Lock()
+	defer histogramOpts.Unlock()
+	histogramOpts.m[h] = opts
+
+	return h
+}
+
+// shareHistogram checks that the histogram existing, already registered,
+// has the buckets asked for when h was created. The options of h are
+// forgotten, as h is not registered.
+func shareHistogram(h, existing *prom.HistogramVec) error {
+	histogramOpts.Lock()
+	defer histogramOpts.Unlock()
+
+	opts, ok := histogramOpts.m[h]
+	delete(histogramOpts.m, h)
+	existingOpts, existingOK := histogramOpts.m[existing]
+	if !ok || !existingOK {
+		// registered by something else, the buckets are unknown
+		return nil
+	}
+
+	if !equalBuckets(opts.Buckets, existingOpts.Buckets) {
+		return errors.Errorf("%s is already registered with buckets %v, not %v",
+			opts.Name, existingOpts.Buckets, opts.Buckets)
+	}
+
+	return nil
+}
+
+func equalBuckets(a, b []float64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// forgetHistogram forgets the options of a histogram that was not registered
+func forgetHistogram(h *prom.HistogramVec) {
+	histogramOpts.Lock()
+	defer histogramOpts.Unlock()
+
+	delete(histogramOpts.m, h)
+}
+
+// registerField registers the metric that field points to, returning
+// whether it was registered. If an equal metric is already registered, as
+// by an earlier collector with the same registerer, field is set to that
+// one so that both collectors record to it; a histogram is shared only if
+// it has the same buckets.
+func registerField(registerer prom.Registerer, field interface{}) (bool, error) {
+	var c prom.Collector
+	switch f := field.(type) {
+	case *prom.Counter:
+		c = *f
+	case *prom.Gauge:
+		c = *f
+	case *prom.CounterFunc:
+		c = *f
+	case **prom.CounterVec:
+		c = *f
+	case **prom.GaugeVec:
+		c = *f
+	case **prom.HistogramVec:
+		c = *f
+	default:
+		return false, errors.Errorf("unexpected metric %T", field)
+	}
+
+	err := registerer.Register(c)
+	are, ok := err.(prom.AlreadyRegisteredError)
+	if !ok {
+		if h, isHistogram := c.(*prom.HistogramVec); isHistogram && err != nil {
+			forgetHistogram(h)
+		}
+		return err == nil, err
+	}
+
+	switch f := field.(type) {
+	case *prom.Counter:
+		*f, ok = are.ExistingCollector.(prom.Counter)
+	case *prom.Gauge:
+		*f, ok = are.ExistingCollector.(prom.Gauge)
+	case *prom.CounterFunc:
+		*f, ok = are.ExistingCollector.(prom.CounterFunc)
+	case **prom.CounterVec:
+		*f, ok = are.ExistingCollector.(*prom.CounterVec)
+	case **prom.GaugeVec:
+		*f, ok = are.ExistingCollector.(*prom.GaugeVec)
+	case **prom.HistogramVec:
+		var existing *prom.HistogramVec
+		if existing, ok = are.ExistingCollector.(*prom.HistogramVec); ok {
+			if shareErr := shareHistogram(*f, existing); shareErr != nil {
+				return false, shareErr
+			}
+			*f = existing
+		} else {
+			forgetHistogram(*f)
+		}
+	}
+	if !ok {
+		// registered under the same name by something else
+		return false, err
+	}
+
+	return false, nil
+}
+
+func createGCPauseVector(constLabels prom.Labels) *prom.GaugeVec {
+	return prom.NewGaugeVec(
+		prom.GaugeOpts{
+			Name:        "runtime_gc_pause_seconds",
+			Help:        "Quantiles of the recent GC pause durations.",
+			ConstLabels: constLabels,
+		},
+		[]string{"quantile"},
+	)
+}
+
 // Collect statistics by sending them to Prometheus
 func (c *CollectorType) Collect(
 	entry apistats.APIStatsEntry,
 	rawKey string,
 	method string,
 ) error {
+	return c.CollectRequest(entry, rawKey, method, nil)
+}
+
+// CollectRequest implements the RequestCollector interface; the values of
+// the extra labels are derived from req, which may be nil.
+// Outgoing requests, whose entries have a client transport, are recorded
+// in the http_client_* metrics instead, if HTTPClientMetricsOption enabled
+// them.
+func (c *CollectorType) CollectRequest(
+	entry apistats.APIStatsEntry,
+	rawKey string,
+	method string,
+	req *http.Request,
+) error {
+	if entry.Transport.IsClient() {
+		c.collectClient(entry, rawKey, method)
+		return nil
+	}
 
 	// we compute elapsed time from the very start of the transaction
 	// to the time the response headers have been sent.
 	// gm-data could continue for a long time after the response
-	elapsed := computeElapsed(entry.BeginTime, entry.ResponseTime)
+	// The HTTP and gRPC handlers only record the end of the transaction.
+	endTime := entry.ResponseTime
+	if endTime.IsZero() {
+		endTime = entry.EndTime
+	}
+	elapsed := computeElapsed(entry.BeginTime, endTime)
 	if elapsed > 0 {
+		rawKey = c.guard.Key(rawKey)
+
+		extraLabels := c.extraLabels(req)
+
 		for _, labels := range []prom.Labels{
 			prom.Labels{
 				"key":    rawKey,
@@ -233,6 +735,9 @@ func (c *CollectorType) Collect(
 				"status": fmt.Sprintf("%d", entry.HTTPStatus),
 			},
 		} {
+			for name, value := range extraLabels {
+				labels[name] = value
+			}
 			requestDuration, err := c.requestDurationVec.GetMetricWith(labels)
 			if err != nil {
 				return errors.Wrapf(err, "requestDurationVec.GetMetricWith(%s)", labels)
@@ -260,6 +765,25 @@ func (c *CollectorType) Collect(
 	return nil
 }
 
+// extraLabels returns the values of the extra labels for a request
+func (c *CollectorType) extraLabels(req *http.Request) prom.Labels {
+	if len(c.extraLabelNames) == 0 {
+		return nil
+	}
+
+	var derived prom.Labels
+	if req != nil && c.requestLabelFunc != nil {
+		derived = c.requestLabelFunc(req)
+	}
+
+	labels := make(prom.Labels, len(c.extraLabelNames))
+	for _, name := range c.extraLabelNames {
+		labels[name] = derived[name]
+	}
+
+	return labels
+}
+
 // CollectSystemMetrics sends system metrics to Prometheus
 func (c *CollectorType) CollectSystemMetrics(entry SystemMetricsEntry) {
 	for _, d := range []struct {
@@ -272,9 +796,35 @@ func (c *CollectorType) CollectSystemMetrics(entry SystemMetricsEntry) {
 		{c.systemMemoryUsedGauge, entry.SystemMemoryUsed},
 		{c.systemMemoryUsedPercentGauge, entry.SystemMemoryUsedPercent},
 		{c.processMemoryUsedGauge, entry.ProcessMemoryUsed},
+		{c.processMemoryLimitGauge, entry.ProcessMemoryLimit},
+		{c.processCPUPercentGauge, entry.ProcessCPUPercent},
+		{c.processCPULimitCoresGauge, entry.ProcessCPULimitCores},
+		{c.goroutinesGauge, entry.Goroutines},
+		{c.threadsGauge, entry.Threads},
+		{c.openFDsGauge, entry.OpenFDs},
+		{c.heapAllocGauge, entry.HeapAlloc},
+		{c.heapInuseGauge, entry.HeapInuse},
+		{c.heapObjectsGauge, entry.HeapObjects},
 	} {
 		d.gauge.Set(d.value)
 	}
+
+	// in the order of runtimeCounterOpts
+	runtimeCounters.set(
+		entry.CgoCalls,
+		entry.CPUUserSeconds,
+		entry.CPUSystemSeconds,
+		entry.GCCycles,
+		entry.GCPauseTotal,
+	)
+
+	for i, pause := range entry.GCPauseQuantiles {
+		if i >= len(runtimevalues.PauseQuantiles) {
+			break
+		}
+		quantile := strconv.FormatFloat(runtimevalues.PauseQuantiles[i], 'g', -1, 64)
+		c.gcPauseVec.WithLabelValues(quantile).Set(pause)
+	}
 }
 
 func computeElapsed(startTime, endTime time.Time) time.Duration {
@@ -0,0 +1,325 @@
+// Copyright 2017 Decipher Technology Studios LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prometheus
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/deciphernow/gm-fabric-go/metrics/apistats"
+	"github.com/deciphernow/gm-fabric-go/metrics/subject"
+	"github.com/pkg/errors"
+	prom "github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func findFamily(t *testing.T, registry *prom.Registry, name string) *dto.MetricFamily {
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatalf("registry.Gather failed: %s", err)
+	}
+	for _, family := range families {
+		if family.GetName() == name {
+			return family
+		}
+	}
+	t.Fatalf("metric %s not found", name)
+	return nil
+}
+
+func labelValues(metric *dto.Metric) map[string]string {
+	values := make(map[string]string)
+	for _, pair := range metric.GetLabel() {
+		values[pair.GetName()] = pair.GetValue()
+	}
+	return values
+}
+
+func TestCollectorOptions(t *testing.T) {
+	registry := prom.NewRegistry()
+	collector, err := NewCollector(
+		RegistererOption(registry),
+		BucketsOption([]float64{0.1, 1}),
+		ConstLabelsOption(prom.Labels{"service": "catalog"}),
+		RequestLabelsOption(
+			[]string{"tenant"},
+			func(req *http.Request) prom.Labels {
+				return prom.Labels{"tenant": req.Header.Get("X-Tenant")}
+			},
+		),
+	)
+	if err != nil {
+		t.Fatalf("NewCollector failed: %s", err)
+	}
+
+	// a second collector must not conflict with the first
+	if _, err = NewCollector(RegistererOption(prom.NewRegistry())); err != nil {
+		t.Fatalf("second NewCollector failed: %s", err)
+	}
+
+	inner := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte("hello"))
+	})
+	req := httptest.NewRequest("GET", "/books", nil)
+	req.Header.Set("X-Tenant", "acme")
+	NewHandler(collector, inner).ServeHTTP(httptest.NewRecorder(), req)
+
+	family := findFamily(t, registry, "http_request_duration_seconds")
+	if len(family.GetMetric()) != 2 {
+		t.Fatalf("expected 2 series found %d", len(family.GetMetric()))
+	}
+	for _, metric := range family.GetMetric() {
+		labels := labelValues(metric)
+		if labels["service"] != "catalog" || labels["tenant"] != "acme" {
+			t.Fatalf("unexpected labels %v", labels)
+		}
+		if n := len(metric.GetHistogram().GetBucket()); n != 2 {
+			t.Fatalf("expected 2 buckets found %d", n)
+		}
+	}
+
+	family = findFamily(t, registry, "runtime_goroutines")
+	if labels := labelValues(family.GetMetric()[0]); labels["service"] != "catalog" {
+		t.Fatalf("unexpected labels %v", labels)
+	}
+}
+
+func TestReservedRequestLabel(t *testing.T) {
+	_, err := NewCollector(
+		RegistererOption(prom.NewRegistry()),
+		RequestLabelsOption([]string{"key"}, nil),
+	)
+	if err == nil {
+		t.Fatal("expected an error for a reserved label name")
+	}
+}
+
+func TestClientMetrics(t *testing.T) {
+	registry := prom.NewRegistry()
+	collector, err := NewCollector(RegistererOption(registry), HTTPClientMetricsOption())
+	if err != nil {
+		t.Fatalf("NewCollector failed: %s", err)
+	}
+
+	now := time.Now()
+	for _, entry := range []apistats.APIStatsEntry{
+		{
+			Transport:     subject.EventTransportHTTPClient,
+			BeginTime:     now,
+			EndTime:       now.Add(time.Millisecond),
+			HTTPStatus:    200,
+			OutWireLength: 5,
+			InWireLength:  10,
+			Retries:       2,
+		},
+		{
+			Transport: subject.EventTransportHTTPClient,
+			BeginTime: now,
+			EndTime:   now.Add(time.Millisecond),
+			Err:       errors.New("connection refused"),
+		},
+	} {
+		if err = collector.Collect(entry, "client/catalog/items", "GET"); err != nil {
+			t.Fatalf("Collect failed: %s", err)
+		}
+	}
+
+	family := findFamily(t, registry, "http_client_request_duration_seconds")
+	if len(family.GetMetric()) != 2 {
+		t.Fatalf("expected 2 series found %d", len(family.GetMetric()))
+	}
+	for name, expected := range map[string]float64{
+		"http_client_request_size_bytes":  5,
+		"http_client_response_size_bytes": 10,
+		"http_client_retries_total":       2,
+		"http_client_errors_total":        1,
+	} {
+		var found float64
+		for _, metric := range findFamily(t, registry, name).GetMetric() {
+			found += metric.GetCounter().GetValue()
+		}
+		if found != expected {
+			t.Fatalf("%s: expected %v found %v", name, expected, found)
+		}
+	}
+
+	// outgoing requests are kept apart from the requests received
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatalf("registry.Gather failed: %s", err)
+	}
+	for _, family := range families {
+		if family.GetName() == "http_request_duration_seconds" {
+			t.Fatalf("unexpected %s", family.GetName())
+		}
+	}
+}
+
+func TestRuntimeCounters(t *testing.T) {
+	registry := prom.NewRegistry()
+	collector, err := NewCollector(RegistererOption(registry))
+	if err != nil {
+		t.Fatalf("NewCollector failed: %s", err)
+	}
+
+	collector.CollectSystemMetrics(SystemMetricsEntry{
+		CgoCalls:         3,
+		CPUUserSeconds:   1.5,
+		CPUSystemSeconds: -1, // not readable
+		GCCycles:         2,
+		GCPauseTotal:     0.25,
+		Goroutines:       7,
+	})
+
+	for name, expected := range map[string]float64{
+		"runtime_cgo_calls_total":          3,
+		"runtime_cpu_user_seconds_total":   1.5,
+		"runtime_cpu_system_seconds_total": 0,
+		"runtime_gc_cycles_total":          2,
+		"runtime_gc_pause_seconds_total":   0.25,
+	} {
+		family := findFamily(t, registry, name)
+		if family.GetType() != dto.MetricType_COUNTER {
+			t.Fatalf("%s: expected a counter found %s", name, family.GetType())
+		}
+		if found := family.GetMetric()[0].GetCounter().GetValue(); found != expected {
+			t.Fatalf("%s: expected %v found %v", name, expected, found)
+		}
+	}
+
+	family := findFamily(t, registry, "runtime_goroutines")
+	if family.GetType() != dto.MetricType_GAUGE || family.GetMetric()[0].GetGauge().GetValue() != 7 {
+		t.Fatalf("runtime_goroutines: unexpected %v", family)
+	}
+}
+
+func TestSharedRegisterer(t *testing.T) {
+	registry := prom.NewRegistry()
+	first, err := NewCollector(RegistererOption(registry))
+	if err != nil {
+		t.Fatalf("NewCollector failed: %s", err)
+	}
+	// a second collector with the same registerer records to the same
+	// metrics, and may enable metrics that the first did not
+	second, err := NewCollector(
+		RegistererOption(registry),
+		GRPCServerMetricsOption(),
+		HTTPClientMetricsOption(),
+	)
+	if err != nil {
+		t.Fatalf("second NewCollector failed: %s", err)
+	}
+
+	now := time.Now()
+	entry := apistats.APIStatsEntry{BeginTime: now, EndTime: now.Add(time.Millisecond), HTTPStatus: 200}
+	for _, c := range []*CollectorType{first, second} {
+		if err = c.Collect(entry, "route/books", "GET"); err != nil {
+			t.Fatalf("Collect failed: %s", err)
+		}
+	}
+	family := findFamily(t, registry, "http_request_duration_seconds")
+	for _, metric := range family.GetMetric() {
+		if n := metric.GetHistogram().GetSampleCount(); n != 2 {
+			t.Fatalf("expected 2 samples found %d", n)
+		}
+	}
+	second.CollectGRPC(GRPCEvent{Kind: GRPCStarted, Type: GRPCTypeUnary, Service: "s", Method: "m"})
+	findFamily(t, registry, "grpc_server_started_total")
+
+	// a metric of the same name and another type is a conflict
+	conflicting := prom.NewRegistry()
+	conflicting.MustRegister(prom.NewCounter(prom.CounterOpts{Name: "system_cpu_pct", Help: "x"}))
+	if _, err = NewCollector(RegistererOption(conflicting)); err == nil {
+		t.Fatalf("expected a registration error")
+	}
+}
+
+func TestSharedBuckets(t *testing.T) {
+	testCases := []struct {
+		first  []func(*CollectorType)
+		second []func(*CollectorType)
+		err    bool
+	}{
+		{nil, nil, false},
+		{nil, []func(*CollectorType){BucketsOption(prom.DefBuckets)}, false},
+		{
+			[]func(*CollectorType){BucketsOption([]float64{0.1, 1})},
+			[]func(*CollectorType){BucketsOption([]float64{0.1, 1})},
+			false,
+		},
+		{[]func(*CollectorType){BucketsOption([]float64{0.1, 1})}, nil, true},
+		{
+			[]func(*CollectorType){ExponentialBucketsOption(0.001, 2, 8)},
+			[]func(*CollectorType){ExponentialBucketsOption(0.001, 2, 16)},
+			true,
+		},
+		{
+			[]func(*CollectorType){HTTPClientMetricsOption()},
+			[]func(*CollectorType){HTTPClientMetricsOption(), BucketsOption([]float64{0.1, 1})},
+			true,
+		},
+	}
+
+	for i, tc := range testCases {
+		registry := prom.NewRegistry()
+		if _, err := NewCollector(append(tc.first, RegistererOption(registry))...); err != nil {
+			t.Fatalf("#%d: first NewCollector failed: %s", i, err)
+		}
+		_, err := NewCollector(append(tc.second, RegistererOption(registry))...)
+		if tc.err && err == nil {
+			t.Fatalf("#%d: expected an error for different buckets", i)
+		}
+		if !tc.err && err != nil {
+			t.Fatalf("#%d: second NewCollector failed: %s", i, err)
+		}
+	}
+}
+
+func TestOptionalMetrics(t *testing.T) {
+	registry := prom.NewRegistry()
+	collector, err := NewCollector(RegistererOption(registry))
+	if err != nil {
+		t.Fatalf("NewCollector failed: %s", err)
+	}
+
+	now := time.Now()
+	collector.CollectGRPC(GRPCEvent{Kind: GRPCStarted, Type: GRPCTypeUnary, Service: "s", Method: "m"})
+	err = collector.Collect(
+		apistats.APIStatsEntry{
+			Transport: subject.EventTransportHTTPClient,
+			BeginTime: now,
+			EndTime:   now.Add(time.Millisecond),
+		},
+		"client/catalog/items",
+		"GET",
+	)
+	if err != nil {
+		t.Fatalf("Collect failed: %s", err)
+	}
+
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatalf("registry.Gather failed: %s", err)
+	}
+	for _, family := range families {
+		if name := family.GetName(); strings.HasPrefix(name, "grpc_server_") ||
+			strings.HasPrefix(name, "http_client_") {
+			t.Fatalf("unexpected %s", name)
+		}
+	}
+}
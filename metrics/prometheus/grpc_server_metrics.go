@@ -0,0 +1,225 @@
+// Copyright 2017 Decipher Technology Studios LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prometheus
+
+import (
+	"strings"
+	"time"
+
+	prom "github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc/codes"
+)
+
+// gRPC types, the values of the grpc_type label
+const (
+	GRPCTypeUnary        = "unary"
+	GRPCTypeClientStream = "client_stream"
+	GRPCTypeServerStream = "server_stream"
+	GRPCTypeBidiStream   = "bidi_stream"
+)
+
+// GRPCEventKind identifies what happened to an RPC
+type GRPCEventKind int
+
+const (
+	// GRPCStarted is the start of an RPC
+	GRPCStarted GRPCEventKind = iota
+
+	// GRPCMsgReceived is a message received from the client
+	GRPCMsgReceived
+
+	// GRPCMsgSent is a message sent to the client
+	GRPCMsgSent
+
+	// GRPCHandled is the end of an RPC
+	GRPCHandled
+)
+
+// GRPCEvent describes one event of a server RPC
+type GRPCEvent struct {
+	Kind    GRPCEventKind
+	Type    string        // one of the GRPCType constants
+	Service string        // e.g. "metricstester.MetricsTester"
+	Method  string        // e.g. "CatalogStream"
+	Size    int           // the wire length of the message, for messages
+	Code    codes.Code    // the status of the RPC, for GRPCHandled
+	Elapsed time.Duration // the duration of the RPC, for GRPCHandled
+}
+
+// GRPCCollector is a Collector that also collects per-RPC, per-message and
+// per-code metrics of a gRPC server (see GRPCServerMetricsOption), named as
+// by go-grpc-prometheus:
+//     grpc_server_started_total
+//     grpc_server_handled_total
+//     grpc_server_msg_received_total
+//     grpc_server_msg_sent_total
+//     grpc_server_handling_seconds
+// along with
+//     grpc_server_in_flight
+//     grpc_server_msg_received_bytes
+//     grpc_server_msg_sent_bytes
+type GRPCCollector interface {
+	Collector
+
+	CollectGRPC(event GRPCEvent)
+}
+
+// grpcLabelNames are the labels of the gRPC server metrics, except
+// grpc_server_handled_total, which adds grpc_code
+var grpcLabelNames = []string{"grpc_type", "grpc_service", "grpc_method"}
+
+// grpcMessageBuckets are the buckets of the message size histograms,
+// from 64 bytes to 1MB
+var grpcMessageBuckets = prom.ExponentialBuckets(64, 4, 8)
+
+type grpcServerMetrics struct {
+	started          *prom.CounterVec
+	handled          *prom.CounterVec
+	msgReceived      *prom.CounterVec
+	msgSent          *prom.CounterVec
+	inFlight         *prom.GaugeVec
+	handlingSeconds  *prom.HistogramVec
+	msgReceivedBytes *prom.HistogramVec
+	msgSentBytes     *prom.HistogramVec
+}
+
+func newGRPCServerMetrics(buckets []float64, constLabels prom.Labels) grpcServerMetrics {
+	counter := func(name, help string, labelNames []string) *prom.CounterVec {
+		return prom.NewCounterVec(
+			prom.CounterOpts{Name: name, Help: help, ConstLabels: constLabels},
+			labelNames,
+		)
+	}
+	histogram := func(name, help string, buckets []float64) *prom.HistogramVec {
+		return newHistogramVec(
+			prom.HistogramOpts{
+				Name:        name,
+				Help:        help,
+				Buckets:     buckets,
+				ConstLabels: constLabels,
+			},
+			grpcLabelNames,
+		)
+	}
+
+	return grpcServerMetrics{
+		started: counter(
+			"grpc_server_started_total",
+			"Total number of RPCs started on the server.",
+			grpcLabelNames,
+		),
+		handled: counter(
+			"grpc_server_handled_total",
+			"Total number of RPCs completed on the server, regardless of success or failure.",
+			append(append([]string(nil), grpcLabelNames...), "grpc_code"),
+		),
+		msgReceived: counter(
+			"grpc_server_msg_received_total",
+			"Total number of RPC stream messages received on the server.",
+			grpcLabelNames,
+		),
+		msgSent: counter(
+			"grpc_server_msg_sent_total",
+			"Total number of gRPC stream messages sent by the server.",
+			grpcLabelNames,
+		),
+		inFlight: prom.NewGaugeVec(
+			prom.GaugeOpts{
+				Name:        "grpc_server_in_flight",
+				Help:        "Number of RPCs currently being handled by the server.",
+				ConstLabels: constLabels,
+			},
+			grpcLabelNames,
+		),
+		handlingSeconds: histogram(
+			"grpc_server_handling_seconds",
+			"Histogram of response latency (seconds) of gRPC that had been application-level handled by the server.",
+			buckets,
+		),
+		msgReceivedBytes: histogram(
+			"grpc_server_msg_received_bytes",
+			"Histogram of the wire size of the messages received on the server.",
+			grpcMessageBuckets,
+		),
+		msgSentBytes: histogram(
+			"grpc_server_msg_sent_bytes",
+			"Histogram of the wire size of the messages sent by the server.",
+			grpcMessageBuckets,
+		),
+	}
+}
+
+// fields returns pointers to the metrics, for registerField
+func (m *grpcServerMetrics) fields() []interface{} {
+	return []interface{}{
+		&m.started,
+		&m.handled,
+		&m.msgReceived,
+		&m.msgSent,
+		&m.inFlight,
+		&m.handlingSeconds,
+		&m.msgReceivedBytes,
+		&m.msgSentBytes,
+	}
+}
+
+// CollectGRPC implements the GRPCCollector interface; it does nothing
+// unless GRPCServerMetricsOption enabled the metrics
+func (c *CollectorType) CollectGRPC(event GRPCEvent) {
+	m := c.grpcMetrics
+	if m == nil {
+		return
+	}
+	labels := []string{event.Type, event.Service, event.Method}
+
+	switch event.Kind {
+	case GRPCStarted:
+		m.started.WithLabelValues(labels...).Inc()
+		m.inFlight.WithLabelValues(labels...).Inc()
+	case GRPCMsgReceived:
+		m.msgReceived.WithLabelValues(labels...).Inc()
+		m.msgReceivedBytes.WithLabelValues(labels...).Observe(float64(event.Size))
+	case GRPCMsgSent:
+		m.msgSent.WithLabelValues(labels...).Inc()
+		m.msgSentBytes.WithLabelValues(labels...).Observe(float64(event.Size))
+	case GRPCHandled:
+		m.inFlight.WithLabelValues(labels...).Dec()
+		m.handled.WithLabelValues(append(labels, event.Code.String())...).Inc()
+		m.handlingSeconds.WithLabelValues(labels...).Observe(event.Elapsed.Seconds())
+	}
+}
+
+// grpcType returns the grpc_type of an RPC from its streaming flags
+func grpcType(isClientStream, isServerStream bool) string {
+	switch {
+	case isClientStream && isServerStream:
+		return GRPCTypeBidiStream
+	case isClientStream:
+		return GRPCTypeClientStream
+	case isServerStream:
+		return GRPCTypeServerStream
+	}
+	return GRPCTypeUnary
+}
+
+// splitMethodName splits '/metricstester.MetricsTester/CatalogStream'
+// into 'metricstester.MetricsTester' and 'CatalogStream'
+func splitMethodName(fullMethod string) (string, string) {
+	fullMethod = strings.TrimPrefix(fullMethod, "/")
+	if i := strings.LastIndex(fullMethod, "/"); i >= 0 {
+		return fullMethod[:i], fullMethod[i+1:]
+	}
+	return "unknown", "unknown"
+}
@@ -15,14 +15,17 @@
 package prometheus
 
 import (
+	"context"
 	"fmt"
 	"strings"
 	"sync"
+	"time"
 
 	oldcontext "golang.org/x/net/context"
 
 	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/stats"
+	"google.golang.org/grpc/status"
 
 	"github.com/pkg/errors"
 
@@ -39,6 +42,17 @@ type StatsEntry struct {
 	method string
 }
 
+// grpcRPCKey is the context key of the grpcRPC of an RPC
+type grpcRPCKey struct{}
+
+// grpcRPC identifies an RPC for the gRPC server metrics
+type grpcRPC struct {
+	rpcType string
+	service string
+	method  string
+	begin   time.Time
+}
+
 // StatsHandler implements the stats.Handler interface
 // https://godoc.org/google.golang.org/grpc/stats#Handler
 type StatsHandler struct {
@@ -55,21 +69,34 @@ func GRPCLoggerOption(logger zerolog.Logger) func(*StatsHandler) {
 	}
 }
 
+// GRPCCollectorOption returns a StatsHandler option function that sets the
+// collector, e.g. one shared with an HTTP handler
+func GRPCCollectorOption(collector Collector) func(*StatsHandler) {
+	return func(s *StatsHandler) {
+		s.Collector = collector
+	}
+}
+
 // NewStatsHandler returns an object that implements the stats.Handler interface
 // https://godoc.org/google.golang.org/grpc/stats#Handler
+// Without GRPCCollectorOption, it collects with a new collector that has the
+// grpc_server_* metrics, registered with the default Prometheus registerer.
 func NewStatsHandler(options ...func(*StatsHandler)) (*StatsHandler, error) {
 	var s StatsHandler
 	var err error
 
 	s.StatsData = make(map[string]StatsEntry)
-	if s.Collector, err = NewCollector(); err != nil {
-		return nil, errors.Wrap(err, "NewCollector")
-	}
 
 	for _, f := range options {
 		f(&s)
 	}
 
+	if s.Collector == nil {
+		if s.Collector, err = NewCollector(GRPCServerMetricsOption()); err != nil {
+			return nil, errors.Wrap(err, "NewCollector")
+		}
+	}
+
 	return &s, nil
 }
 
@@ -113,6 +140,9 @@ func (h *StatsHandler) TagRPC(
 		requestID = headers.NewRequestID()
 	}
 
+	service, method := splitMethodName(info.FullMethodName)
+	ctx = context.WithValue(ctx, grpcRPCKey{}, &grpcRPC{service: service, method: method})
+
 	// return a context with the values set
 	return headers.SetRequestID(headers.SetPrevRoute(ctx, prevRoute), requestID)
 }
@@ -129,6 +159,10 @@ func (h *StatsHandler) HandleRPC(
 	ctx oldcontext.Context,
 	s stats.RPCStats,
 ) {
+	if collector, ok := h.Collector.(GRPCCollector); ok && !s.IsClient() {
+		h.collectGRPC(ctx, collector, s)
+	}
+
 	requestID := headers.GetRequestID(ctx)
 	if len(requestID) == 0 {
 		h.Logger.Error().Str("method", "HandleRPC").
@@ -174,6 +208,44 @@ func (h *StatsHandler) HandleRPC(
 
 }
 
+// collectGRPC reports the events of a server RPC to collector
+func (h *StatsHandler) collectGRPC(
+	ctx oldcontext.Context,
+	collector GRPCCollector,
+	s stats.RPCStats,
+) {
+	rpc, ok := ctx.Value(grpcRPCKey{}).(*grpcRPC)
+	if !ok {
+		return
+	}
+
+	event := GRPCEvent{Service: rpc.service, Method: rpc.method}
+
+	switch st := s.(type) {
+	case *stats.Begin:
+		// Begin precedes the other events of the RPC, so rpc is only
+		// written before it is shared
+		rpc.rpcType = grpcType(st.IsClientStream, st.IsServerStream)
+		rpc.begin = st.BeginTime
+		event.Kind = GRPCStarted
+	case *stats.InPayload:
+		event.Kind = GRPCMsgReceived
+		event.Size = st.WireLength
+	case *stats.OutPayload:
+		event.Kind = GRPCMsgSent
+		event.Size = st.WireLength
+	case *stats.End:
+		event.Kind = GRPCHandled
+		event.Code = status.Code(st.Error)
+		event.Elapsed = st.EndTime.Sub(rpc.begin)
+	default:
+		return
+	}
+
+	event.Type = rpc.rpcType
+	collector.CollectGRPC(event)
+}
+
 // constructKey takes the full grpc method name, of the form
 // '/metricstester.MetricsTester/CatalogStream'
 // and returns
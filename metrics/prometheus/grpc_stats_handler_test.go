@@ -0,0 +1,146 @@
+// Copyright 2017 Decipher Technology Studios LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prometheus
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	prom "github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+func TestGRPCServerMetrics(t *testing.T) {
+	registry := prom.NewRegistry()
+	collector, err := NewCollector(RegistererOption(registry), GRPCServerMetricsOption())
+	if err != nil {
+		t.Fatalf("NewCollector failed: %s", err)
+	}
+	statsHandler, err := NewStatsHandler(GRPCCollectorOption(collector))
+	if err != nil {
+		t.Fatalf("NewStatsHandler failed: %s", err)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen failed: %s", err)
+	}
+	server := grpc.NewServer(grpc.StatsHandler(statsHandler))
+	healthpb.RegisterHealthServer(server, health.NewServer())
+	go server.Serve(listener)
+	defer server.Stop()
+
+	conn, err := grpc.Dial(listener.Addr().String(), grpc.WithInsecure())
+	if err != nil {
+		t.Fatalf("grpc.Dial failed: %s", err)
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	client := healthpb.NewHealthClient(conn)
+	if _, err = client.Check(ctx, &healthpb.HealthCheckRequest{}); err != nil {
+		t.Fatalf("Check failed: %s", err)
+	}
+	client.Check(ctx, &healthpb.HealthCheckRequest{Service: "unknown"})
+
+	stream, err := client.Watch(ctx, &healthpb.HealthCheckRequest{})
+	if err != nil {
+		t.Fatalf("Watch failed: %s", err)
+	}
+	if _, err = stream.Recv(); err != nil {
+		t.Fatalf("stream.Recv failed: %s", err)
+	}
+
+	// the server ends its stats after the client has its response
+	expected := []struct {
+		family string
+		labels map[string]string
+		value  float64
+	}{
+		{"grpc_server_started_total", map[string]string{"grpc_method": "Check", "grpc_type": "unary"}, 2},
+		{"grpc_server_handled_total", map[string]string{"grpc_method": "Check", "grpc_code": "OK"}, 1},
+		{"grpc_server_handled_total", map[string]string{"grpc_method": "Check", "grpc_code": "NotFound"}, 1},
+		{"grpc_server_msg_received_total", map[string]string{"grpc_method": "Check"}, 2},
+		{"grpc_server_msg_sent_total", map[string]string{"grpc_method": "Check"}, 1},
+		{"grpc_server_in_flight", map[string]string{"grpc_method": "Check"}, 0},
+		{"grpc_server_started_total", map[string]string{"grpc_method": "Watch", "grpc_type": "server_stream"}, 1},
+		{"grpc_server_in_flight", map[string]string{"grpc_method": "Watch"}, 1},
+	}
+
+	waitFor := func(family string, labels map[string]string, value float64) float64 {
+		var found float64
+		for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); {
+			found = sumMetric(t, registry, family, labels)
+			if found == value {
+				break
+			}
+			time.Sleep(time.Millisecond)
+		}
+		return found
+	}
+
+	for i, e := range expected {
+		if found := waitFor(e.family, e.labels, e.value); found != e.value {
+			t.Fatalf("%d: %s%v: expected %v found %v", i, e.family, e.labels, e.value, found)
+		}
+	}
+
+	family := findFamily(t, registry, "grpc_server_handling_seconds")
+	for _, metric := range family.GetMetric() {
+		labels := labelValues(metric)
+		if labels["grpc_service"] != "grpc.health.v1.Health" {
+			t.Fatalf("unexpected labels %v", labels)
+		}
+	}
+}
+
+// sumMetric returns the sum of the counter or gauge values of the series of
+// family that have labels, 0 if there are none
+func sumMetric(
+	t *testing.T,
+	registry *prom.Registry,
+	family string,
+	labels map[string]string,
+) float64 {
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatalf("registry.Gather failed: %s", err)
+	}
+
+	var sum float64
+	for _, f := range families {
+		if f.GetName() != family {
+			continue
+		}
+	METRIC_LOOP:
+		for _, metric := range f.GetMetric() {
+			values := labelValues(metric)
+			for name, value := range labels {
+				if values[name] != value {
+					continue METRIC_LOOP
+				}
+			}
+			sum += metric.GetCounter().GetValue() + metric.GetGauge().GetValue()
+		}
+	}
+
+	return sum
+}
@@ -0,0 +1,118 @@
+// Copyright 2017 Decipher Technology Studios LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prometheus
+
+import (
+	"fmt"
+
+	prom "github.com/prometheus/client_golang/prometheus"
+
+	"github.com/deciphernow/gm-fabric-go/metrics/apistats"
+)
+
+// httpClientMetrics are the metrics of outgoing HTTP requests:
+//     http_client_request_duration_seconds
+//     http_client_request_size_bytes
+//     http_client_response_size_bytes
+//     http_client_retries_total
+//     http_client_errors_total
+// labelled by LabelNames, except the retry and error counters, which have
+// no status. They are kept apart from the metrics of the requests received,
+// so that outgoing requests do not count towards the "all" key.
+type httpClientMetrics struct {
+	requestDuration *prom.HistogramVec
+	requestSize     *prom.CounterVec
+	responseSize    *prom.CounterVec
+	retries         *prom.CounterVec
+	errors          *prom.CounterVec
+}
+
+func newHTTPClientMetrics(buckets []float64, constLabels prom.Labels) httpClientMetrics {
+	counter := func(name, help string, labelNames []string) *prom.CounterVec {
+		return prom.NewCounterVec(
+			prom.CounterOpts{Name: name, Help: help, ConstLabels: constLabels},
+			labelNames,
+		)
+	}
+
+	return httpClientMetrics{
+		requestDuration: newHistogramVec(
+			prom.HistogramOpts{
+				Name:        "http_client_request_duration_seconds",
+				Help:        "duration of a single outgoing http request, including retries",
+				Buckets:     buckets,
+				ConstLabels: constLabels,
+			},
+			LabelNames,
+		),
+		requestSize: counter(
+			"http_client_request_size_bytes",
+			"number of bytes sent in the body of outgoing requests",
+			LabelNames,
+		),
+		responseSize: counter(
+			"http_client_response_size_bytes",
+			"number of bytes read from the body of the responses to outgoing requests",
+			LabelNames,
+		),
+		retries: counter(
+			"http_client_retries_total",
+			"number of times outgoing requests were retried",
+			[]string{"key", "method"},
+		),
+		errors: counter(
+			"http_client_errors_total",
+			"number of outgoing requests that failed without a response",
+			[]string{"key", "method"},
+		),
+	}
+}
+
+// fields returns pointers to the metrics, for registerField
+func (m *httpClientMetrics) fields() []interface{} {
+	return []interface{}{
+		&m.requestDuration,
+		&m.requestSize,
+		&m.responseSize,
+		&m.retries,
+		&m.errors,
+	}
+}
+
+// collectClient records an outgoing request
+func (c *CollectorType) collectClient(
+	entry apistats.APIStatsEntry,
+	rawKey string,
+	method string,
+) {
+	m := c.clientMetrics
+	if m == nil {
+		return
+	}
+	rawKey = c.guard.Key(rawKey)
+	labels := []string{rawKey, method, fmt.Sprintf("%d", entry.HTTPStatus)}
+
+	m.requestDuration.WithLabelValues(labels...).Observe(
+		computeElapsed(entry.BeginTime, entry.EndTime).Seconds(),
+	)
+	m.requestSize.WithLabelValues(labels...).Add(float64(entry.OutWireLength))
+	m.responseSize.WithLabelValues(labels...).Add(float64(entry.InWireLength))
+	if entry.Retries > 0 {
+		m.retries.WithLabelValues(rawKey, method).Add(float64(entry.Retries))
+	}
+	if entry.Err != nil {
+		m.errors.WithLabelValues(rawKey, method).Inc()
+	}
+}
@@ -100,7 +100,13 @@ func (hState *HandlerState) ServeHTTP(w http.ResponseWriter, req *http.Request)
 		entry.Transport = subject.EventTransportHTTP
 	}
 
-	if err := hState.collector.Collect(entry, rawKey, method); err != nil {
+	var err error
+	if requestCollector, ok := hState.collector.(RequestCollector); ok {
+		err = requestCollector.CollectRequest(entry, rawKey, method, req)
+	} else {
+		err = hState.collector.Collect(entry, rawKey, method)
+	}
+	if err != nil {
 		hState.logger.Error().Err(err).Msg("Collect")
 	}
 }
@@ -25,15 +25,22 @@ import (
 	"github.com/pkg/errors"
 	"github.com/prometheus/client_golang/api"
 	"github.com/prometheus/client_golang/api/prometheus/v1"
+	prom "github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/common/model"
 )
 
 // PromReporter implements the Reporter interface
 // JobName is 'job_name' from 'scrape_configs' in the Prometheus config file
+//
+// If Gatherer is set, the metrics are gathered from it in process, usually
+// the registry the Collector registers with, and PrometheusURI and JobName
+// are ignored. Otherwise they are queried from the Prometheus server at
+// PrometheusURI.
 type PromReporter struct {
 	PrometheusURI string
 	JobName       string
 	IsGRPC        bool
+	Gatherer      prom.Gatherer
 }
 
 // Report implements the Reporter interface
@@ -61,6 +68,10 @@ func (rpt *PromReporter) Report(jWriter *flatjson.Writer) error {
 func (rpt *PromReporter) accumulateMetrics(reportMap reportMapType) (uint64, uint64, error) {
 	var err error
 
+	if rpt.Gatherer != nil {
+		return rpt.gatherMetrics(reportMap)
+	}
+
 	client, err := api.NewClient(
 		api.Config{Address: rpt.PrometheusURI},
 	)
@@ -224,23 +235,24 @@ func countSampleFunc(m *model.Sample, e *reportEntry) error {
 	}
 	cleanStatus := httpStatus(fmt.Sprintf("%03d", numericStatus))
 
-	e.statusCount[cleanStatus] = uint64(m.Value)
+	// several series, differing in other labels, may have the same status
+	e.statusCount[cleanStatus] += uint64(m.Value)
 
 	return nil
 }
 
 func sumSampleFunc(m *model.Sample, e *reportEntry) error {
-	e.latencyMsSum = float64(m.Value) * 1000
+	e.latencyMsSum += float64(m.Value) * 1000
 	return nil
 }
 
 func inSampleFunc(m *model.Sample, e *reportEntry) error {
-	e.inThroughput = uint64(m.Value)
+	e.inThroughput += uint64(m.Value)
 	return nil
 }
 
 func outSampleFunc(m *model.Sample, e *reportEntry) error {
-	e.outThroughput = uint64(m.Value)
+	e.outThroughput += uint64(m.Value)
 	return nil
 }
 
@@ -272,20 +284,33 @@ VECTOR_LOOP:
 		if string(value.Metric["job"]) != rpt.JobName {
 			continue VECTOR_LOOP
 		}
-		rk := computeReportKey(value)
-		rm, ok := reportMap[rk]
-		if !ok {
-			rm.statusCount = make(map[httpStatus]uint64)
-		}
-		if err = accumulateRequest.sampleFunc(value, &rm); err != nil {
-			return errors.Wrap(err, "sampleFunc")
+		if err = accumulateSample(reportMap, value, accumulateRequest.sampleFunc); err != nil {
+			return err
 		}
-		reportMap[rk] = rm
 	}
 
 	return nil
 }
 
+// accumulateSample applies sampleFunc to the report entry of the sample
+func accumulateSample(
+	reportMap reportMapType,
+	value *model.Sample,
+	sampleFunc sampleFuncType,
+) error {
+	rk := computeReportKey(value)
+	rm, ok := reportMap[rk]
+	if !ok {
+		rm.statusCount = make(map[httpStatus]uint64)
+	}
+	if err := sampleFunc(value, &rm); err != nil {
+		return errors.Wrap(err, "sampleFunc")
+	}
+	reportMap[rk] = rm
+
+	return nil
+}
+
 func (rpt *PromReporter) getCount(
 	promAPI v1.API,
 	timestamp time.Time,
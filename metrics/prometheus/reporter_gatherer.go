@@ -0,0 +1,196 @@
+// Copyright 2017 Decipher Technology Studios LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prometheus
+
+import (
+	"math"
+	"sort"
+	"strconv"
+
+	"github.com/pkg/errors"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/model"
+)
+
+// reportQuantiles are the latency quantiles of the report, computed from
+// the request duration histogram
+var reportQuantiles = []float64{0.5, 0.9, 0.95, 0.99, 0.999, 0.9999}
+
+// bucketCounts maps the upper bound of each histogram bucket to its
+// cumulative count; the +Inf bucket holds the total count
+type bucketCounts map[float64]uint64
+
+// gatherMetrics accumulates the report from the metric families of
+// rpt.Gatherer; it returns the TLS and non TLS request counts
+func (rpt *PromReporter) gatherMetrics(reportMap reportMapType) (uint64, uint64, error) {
+	var tlsCount float64
+	var nonTLSCount float64
+	var err error
+
+	families, err := rpt.Gatherer.Gather()
+	if err != nil {
+		return 0, 0, errors.Wrap(err, "Gather")
+	}
+
+	buckets := make(map[reportKey]bucketCounts)
+
+	for _, family := range families {
+		switch family.GetName() {
+		case "http_request_duration_seconds":
+			err = accumulateHistogram(reportMap, buckets, family)
+		case "http_request_size_bytes":
+			err = accumulateCounter(reportMap, family, inSampleFunc)
+		case "http_response_size_bytes":
+			err = accumulateCounter(reportMap, family, outSampleFunc)
+		case "tls_requests":
+			tlsCount += sumCounters(family)
+		case "non_tls_requests":
+			nonTLSCount += sumCounters(family)
+		}
+		if err != nil {
+			return 0, 0, errors.Wrap(err, family.GetName())
+		}
+	}
+
+	for rk, counts := range buckets {
+		rm := reportMap[rk]
+		for _, q := range reportQuantiles {
+			value := &model.Sample{
+				Metric: model.Metric{
+					"quantile": model.LabelValue(strconv.FormatFloat(q, 'g', -1, 64)),
+				},
+				Value: model.SampleValue(bucketQuantile(q, counts)),
+			}
+			if err = durationSampleFunc(value, &rm); err != nil {
+				return 0, 0, errors.Wrap(err, "durationSampleFunc")
+			}
+		}
+		reportMap[rk] = rm
+	}
+
+	return uint64(tlsCount), uint64(nonTLSCount), nil
+}
+
+// accumulateHistogram accumulates the counts and sums of the request
+// duration histograms and merges their buckets by report key
+func accumulateHistogram(
+	reportMap reportMapType,
+	buckets map[reportKey]bucketCounts,
+	family *dto.MetricFamily,
+) error {
+	var err error
+
+	for _, metric := range family.GetMetric() {
+		histogram := metric.GetHistogram()
+		if histogram == nil {
+			continue
+		}
+
+		count := metricSample(metric, float64(histogram.GetSampleCount()))
+		if err = accumulateSample(reportMap, count, countSampleFunc); err != nil {
+			return err
+		}
+		sum := metricSample(metric, histogram.GetSampleSum())
+		if err = accumulateSample(reportMap, sum, sumSampleFunc); err != nil {
+			return err
+		}
+
+		rk := computeReportKey(count)
+		counts, ok := buckets[rk]
+		if !ok {
+			counts = make(bucketCounts)
+			buckets[rk] = counts
+		}
+		for _, bucket := range histogram.GetBucket() {
+			if !math.IsInf(bucket.GetUpperBound(), 1) {
+				counts[bucket.GetUpperBound()] += bucket.GetCumulativeCount()
+			}
+		}
+		counts[math.Inf(1)] += histogram.GetSampleCount()
+	}
+
+	return nil
+}
+
+// accumulateCounter applies sampleFunc to each counter of family
+func accumulateCounter(
+	reportMap reportMapType,
+	family *dto.MetricFamily,
+	sampleFunc sampleFuncType,
+) error {
+	for _, metric := range family.GetMetric() {
+		value := metricSample(metric, metric.GetCounter().GetValue())
+		if err := accumulateSample(reportMap, value, sampleFunc); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func sumCounters(family *dto.MetricFamily) float64 {
+	var sum float64
+	for _, metric := range family.GetMetric() {
+		sum += metric.GetCounter().GetValue()
+	}
+	return sum
+}
+
+// metricSample converts a gathered metric to the sample a query returns
+func metricSample(metric *dto.Metric, value float64) *model.Sample {
+	labels := make(model.Metric, len(metric.GetLabel()))
+	for _, pair := range metric.GetLabel() {
+		labels[model.LabelName(pair.GetName())] = model.LabelValue(pair.GetValue())
+	}
+
+	return &model.Sample{Metric: labels, Value: model.SampleValue(value)}
+}
+
+// bucketQuantile estimates the q quantile from histogram buckets by linear
+// interpolation within the bucket holding it, as Prometheus'
+// histogram_quantile does
+func bucketQuantile(q float64, counts bucketCounts) float64 {
+	total := counts[math.Inf(1)]
+	if total == 0 {
+		return 0
+	}
+
+	bounds := make([]float64, 0, len(counts))
+	for bound := range counts {
+		bounds = append(bounds, bound)
+	}
+	sort.Float64s(bounds)
+
+	rank := q * float64(total)
+
+	var lower float64
+	var below uint64
+	for _, upper := range bounds {
+		count := counts[upper]
+		if float64(count) >= rank {
+			// values above the highest bucket are reported as its bound
+			if math.IsInf(upper, 1) {
+				return lower
+			}
+			if count == below {
+				return upper
+			}
+			return lower + (upper-lower)*(rank-float64(below))/float64(count-below)
+		}
+		lower, below = upper, count
+	}
+
+	return lower
+}
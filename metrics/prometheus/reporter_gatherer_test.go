@@ -0,0 +1,130 @@
+// Copyright 2017 Decipher Technology Studios LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prometheus
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/deciphernow/gm-fabric-go/metrics/apistats"
+	"github.com/deciphernow/gm-fabric-go/metrics/flatjson"
+	prom "github.com/prometheus/client_golang/prometheus"
+)
+
+func TestGathererReport(t *testing.T) {
+	registry := prom.NewRegistry()
+	collector, err := NewCollector(
+		RegistererOption(registry),
+		BucketsOption([]float64{0.1, 0.2, 0.4}),
+	)
+	if err != nil {
+		t.Fatalf("NewCollector failed: %s", err)
+	}
+
+	begin := time.Now()
+	for _, r := range []struct {
+		status  int
+		latency time.Duration
+	}{
+		{http.StatusOK, 50 * time.Millisecond},
+		{http.StatusOK, 50 * time.Millisecond},
+		{http.StatusOK, 50 * time.Millisecond},
+		{http.StatusInternalServerError, 300 * time.Millisecond},
+	} {
+		entry := apistats.APIStatsEntry{
+			BeginTime:     begin,
+			EndTime:       begin.Add(r.latency),
+			HTTPStatus:    r.status,
+			InWireLength:  10,
+			OutWireLength: 100,
+		}
+		if err = collector.Collect(entry, "/books", "GET"); err != nil {
+			t.Fatalf("Collect failed: %s", err)
+		}
+	}
+
+	var buffer bytes.Buffer
+	w, err := flatjson.New(&buffer)
+	if err != nil {
+		t.Fatalf("New failed: %s", err)
+	}
+	rpt := PromReporter{Gatherer: registry}
+	if err = rpt.Report(w); err != nil {
+		t.Fatalf("rpt.Report failed: %s", err)
+	}
+	if err = w.Flush(); err != nil {
+		t.Fatalf("w.Flush() failed: %s", err)
+	}
+
+	var report map[string]interface{}
+	data := buffer.Bytes()
+	if err = json.Unmarshal(data, &report); err != nil {
+		t.Fatalf("json.Unmarshal failed: %s; %s", err, string(data))
+	}
+
+	for key, expected := range map[string]float64{
+		"Total/requests":                   4,
+		"HTTP/requests":                    4,
+		"HTTPS/requests":                   0,
+		"route/books/GET/requests":         4,
+		"route/books/GET/status/200":       3,
+		"route/books/GET/status/2XX":       3,
+		"route/books/GET/status/500":       1,
+		"route/books/GET/status/5XX":       1,
+		"route/books/GET/latency_ms.count": 4,
+		"route/books/GET/latency_ms.sum":   450,
+		"route/books/GET/latency_ms.p50":   66,
+		"route/books/GET/in_throughput":    40,
+		"route/books/GET/out_throughput":   400,
+		"all/requests":                     4,
+	} {
+		if report[key] != expected {
+			t.Fatalf("%s: expected %v found %v", key, expected, report[key])
+		}
+	}
+}
+
+func TestBucketQuantile(t *testing.T) {
+	counts := bucketCounts{0.1: 3, 0.2: 3, 0.4: 4, math.Inf(1): 5}
+
+	testCases := []struct {
+		q        float64
+		expected float64
+	}{
+		{0, 0},
+		{0.3, 0.05},
+		{0.6, 0.1},
+		{0.7, 0.3},
+		{0.8, 0.4},
+		{0.99, 0.4},
+	}
+
+	for i, tc := range testCases {
+		t.Run(fmt.Sprintf("%d: %v", i, tc.q), func(t *testing.T) {
+			if found := bucketQuantile(tc.q, counts); math.Abs(found-tc.expected) > 1e-9 {
+				t.Fatalf("expected %v found %v", tc.expected, found)
+			}
+		})
+	}
+
+	if found := bucketQuantile(0.5, bucketCounts{}); found != 0 {
+		t.Fatalf("empty: expected 0 found %v", found)
+	}
+}
@@ -15,48 +15,80 @@
 package prometheus
 
 import (
-	"runtime"
 	"time"
 
+	"github.com/deciphernow/gm-fabric-go/metrics/cpuvalues"
 	"github.com/deciphernow/gm-fabric-go/metrics/memvalues"
+	"github.com/deciphernow/gm-fabric-go/metrics/runtimevalues"
+	"github.com/pkg/errors"
 	"github.com/rs/zerolog"
-	"github.com/shirou/gopsutil/cpu"
 )
 
-// ReportSystemMetrics periodically reports system metrics to Prometheus
+// ReportSystemMetrics periodically reports system metrics to Prometheus.
+// The CPU values are the latest of cpuvalues.Default(), so reporting does
+// not wait for a CPU measurement.
 func ReportSystemMetrics(
 	collector Collector,
 	interval time.Duration,
 	logger zerolog.Logger,
 ) {
 	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
 
-METRICS_LOOP:
 	for {
-		var entry SystemMetricsEntry
-
-		memValues, err := memvalues.GetMemValues()
+		entry, err := systemMetricsEntry(cpuvalues.Default())
 		if err != nil {
-			logger.Error().AnErr("memvalues.GetMemValues()", err).Msg("")
-			continue METRICS_LOOP
+			logger.Error().AnErr("systemMetricsEntry", err).Msg("")
+		} else {
+			collector.CollectSystemMetrics(entry)
 		}
 
-		var cpuPercent []float64
-		cpuPercent, err = cpu.Percent(time.Second, false)
-		if err != nil {
-			logger.Error().AnErr("cpu.Percent", err).Msg("")
-			continue METRICS_LOOP
-		}
+		<-ticker.C
+	}
+}
+
+func systemMetricsEntry(sampler *cpuvalues.Sampler) (SystemMetricsEntry, error) {
+	var entry SystemMetricsEntry
 
-		entry.SystemCPUPercent = cpuPercent[0]
-		entry.SystemCPUCores = float64(runtime.NumCPU())
-		entry.SystemMemoryAvailable = float64(memValues.SystemMemoryAvailable)
-		entry.SystemMemoryUsed = float64(memValues.SystemMemoryUsed)
-		entry.SystemMemoryUsedPercent = memValues.SystemMemoryUsedPercent
-		entry.ProcessMemoryUsed = float64(memValues.ProcessMemoryUsed)
+	memValues, err := memvalues.GetMemValues()
+	if err != nil {
+		return entry, errors.Wrap(err, "memvalues.GetMemValues()")
+	}
 
-		collector.CollectSystemMetrics(entry)
+	cpuValues, err := sampler.Values()
+	if err != nil {
+		return entry, errors.Wrap(err, "sampler.Values()")
+	}
 
-		<-ticker.C
+	runtimeValues, err := runtimevalues.GetRuntimeValues()
+	if err != nil {
+		return entry, errors.Wrap(err, "runtimevalues.GetRuntimeValues()")
 	}
+
+	entry.SystemCPUPercent = cpuValues.SystemPercent
+	entry.SystemCPUCores = float64(cpuValues.Cores)
+	entry.SystemMemoryAvailable = float64(memValues.SystemMemoryAvailable)
+	entry.SystemMemoryUsed = float64(memValues.SystemMemoryUsed)
+	entry.SystemMemoryUsedPercent = memValues.SystemMemoryUsedPercent
+	entry.ProcessMemoryUsed = float64(memValues.ProcessMemoryUsed)
+	entry.ProcessMemoryLimit = float64(memValues.ProcessMemoryLimit)
+	entry.ProcessCPUPercent = cpuValues.ProcessPercent
+	entry.ProcessCPULimitCores = memValues.CPULimitCores
+
+	entry.Goroutines = float64(runtimeValues.Goroutines)
+	entry.Threads = float64(runtimeValues.Threads)
+	entry.OpenFDs = float64(runtimeValues.OpenFDs)
+	entry.CgoCalls = float64(runtimeValues.CgoCalls)
+	entry.CPUUserSeconds = runtimeValues.CPUUserSeconds
+	entry.CPUSystemSeconds = runtimeValues.CPUSystemSeconds
+	entry.HeapAlloc = float64(runtimeValues.HeapAlloc)
+	entry.HeapInuse = float64(runtimeValues.HeapInuse)
+	entry.HeapObjects = float64(runtimeValues.HeapObjects)
+	entry.GCCycles = float64(runtimeValues.GCCycles)
+	entry.GCPauseTotal = runtimeValues.GCPauseTotal.Seconds()
+	for _, pause := range runtimeValues.GCPauseQuantiles {
+		entry.GCPauseQuantiles = append(entry.GCPauseQuantiles, pause.Seconds())
+	}
+
+	return entry, nil
 }
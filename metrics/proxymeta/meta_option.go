@@ -25,6 +25,7 @@ import (
 	"github.com/grpc-ecosystem/grpc-gateway/runtime"
 
 	"github.com/deciphernow/gm-fabric-go/metrics/headers"
+	"github.com/deciphernow/gm-fabric-go/metrics/tracecontext"
 )
 
 // MetaOption returns a ServeMuxOption that captures headers we are interested
@@ -43,6 +44,11 @@ func MetaOption() runtime.ServeMuxOption {
 		metaMap[headers.PrevRouteHeader] =
 			fmt.Sprintf("%s/%s", req.URL.EscapedPath(), req.Method)
 
+		// the span of this request is the parent of the gRPC call
+		span, _ := tracecontext.RequestSpan(req)
+		span.SetMetadata(metaMap)
+		span.SetB3Metadata(metaMap)
+
 		return metadata.New(metaMap)
 	}
 
@@ -0,0 +1,90 @@
+// Copyright 2017 Decipher Technology Studios LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runtimevalues
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"time"
+
+	"github.com/shirou/gopsutil/process"
+)
+
+// PauseQuantiles are the quantiles of the recent GC pauses held in
+// RuntimeValues.GCPauseQuantiles
+var PauseQuantiles = []float64{0, 0.25, 0.5, 0.75, 1}
+
+// RuntimeValues holds Go runtime and process values for reporting.
+// The process values that cannot be read on the platform are -1.
+type RuntimeValues struct {
+	Goroutines       int
+	CgoCalls         int64
+	HeapAlloc        uint64
+	HeapInuse        uint64
+	HeapObjects      uint64
+	GCCycles         int64
+	GCPauseTotal     time.Duration
+	GCPauseQuantiles []time.Duration // indexed like PauseQuantiles
+	OpenFDs          int32
+	Threads          int32
+	CPUUserSeconds   float64
+	CPUSystemSeconds float64
+}
+
+// GetRuntimeValues returns current runtime values
+func GetRuntimeValues() (RuntimeValues, error) {
+	var memStats runtime.MemStats
+	runtime.ReadMemStats(&memStats)
+
+	gcStats := debug.GCStats{
+		PauseQuantiles: make([]time.Duration, len(PauseQuantiles)),
+	}
+	debug.ReadGCStats(&gcStats)
+
+	values := RuntimeValues{
+		Goroutines:       runtime.NumGoroutine(),
+		CgoCalls:         runtime.NumCgoCall(),
+		HeapAlloc:        memStats.HeapAlloc,
+		HeapInuse:        memStats.HeapInuse,
+		HeapObjects:      memStats.HeapObjects,
+		GCCycles:         gcStats.NumGC,
+		GCPauseTotal:     gcStats.PauseTotal,
+		GCPauseQuantiles: gcStats.PauseQuantiles,
+		OpenFDs:          -1,
+		Threads:          -1,
+		CPUUserSeconds:   -1,
+		CPUSystemSeconds: -1,
+	}
+
+	p, err := process.NewProcess(int32(os.Getpid()))
+	if err != nil {
+		return RuntimeValues{}, fmt.Errorf("process.NewProcess() failed: %v", err)
+	}
+
+	if fds, err := p.NumFDs(); err == nil {
+		values.OpenFDs = fds
+	}
+	if threads, err := p.NumThreads(); err == nil {
+		values.Threads = threads
+	}
+	if times, err := p.Times(); err == nil {
+		values.CPUUserSeconds = times.User
+		values.CPUSystemSeconds = times.System
+	}
+
+	return values, nil
+}
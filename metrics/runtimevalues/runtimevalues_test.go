@@ -0,0 +1,53 @@
+// Copyright 2017 Decipher Technology Studios LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runtimevalues
+
+import (
+	"runtime"
+	"testing"
+)
+
+func TestGetRuntimeValues(t *testing.T) {
+	runtime.GC()
+
+	values, err := GetRuntimeValues()
+	if err != nil {
+		t.Fatalf("GetRuntimeValues failed: %s", err)
+	}
+
+	if values.Goroutines < 1 {
+		t.Fatalf("expected at least 1 goroutine, found %d", values.Goroutines)
+	}
+	if values.GCCycles < 1 {
+		t.Fatalf("expected at least 1 GC cycle, found %d", values.GCCycles)
+	}
+	if values.HeapObjects == 0 || values.HeapAlloc == 0 {
+		t.Fatalf("expected heap values, found %+v", values)
+	}
+
+	if len(values.GCPauseQuantiles) != len(PauseQuantiles) {
+		t.Fatalf("expected %d pause quantiles, found %d",
+			len(PauseQuantiles), len(values.GCPauseQuantiles))
+	}
+	for i := 1; i < len(values.GCPauseQuantiles); i++ {
+		if values.GCPauseQuantiles[i] < values.GCPauseQuantiles[i-1] {
+			t.Fatalf("pause quantiles out of order: %v", values.GCPauseQuantiles)
+		}
+	}
+
+	if runtime.GOOS == "linux" && (values.OpenFDs < 1 || values.Threads < 1) {
+		t.Fatalf("expected open fds and threads, found %+v", values)
+	}
+}
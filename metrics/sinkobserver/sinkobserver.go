@@ -24,6 +24,8 @@ import (
 	gometrics "github.com/armon/go-metrics"
 
 	"github.com/deciphernow/gm-fabric-go/metrics/apistats"
+	"github.com/deciphernow/gm-fabric-go/metrics/cardinality"
+	"github.com/deciphernow/gm-fabric-go/metrics/cpuvalues"
 	"github.com/deciphernow/gm-fabric-go/metrics/grpcobserver"
 	"github.com/deciphernow/gm-fabric-go/metrics/memvalues"
 	"github.com/deciphernow/gm-fabric-go/metrics/subject"
@@ -46,23 +48,80 @@ type activeEntry struct {
 
 type sinkObs struct {
 	sync.Mutex
-	active map[string]activeEntry
-	sink   gometrics.MetricSink
+	active    map[string]activeEntry
+	sink      gometrics.MetricSink
+	stop      chan struct{}
+	closeOnce sync.Once
+	guard     *cardinality.Guard
+	sampler   *cpuvalues.Sampler
+}
+
+// CardinalityGuardOption returns an observer option function that folds
+// keys rejected by guard into cardinality.OtherKey
+func CardinalityGuardOption(guard *cardinality.Guard) func(*sinkObs) {
+	return func(so *sinkObs) {
+		so.guard = guard
+	}
+}
+
+// CPUSamplerOption returns an observer option function that sets the
+// sampler whose latest CPU values are reported; the default is
+// cpuvalues.Default()
+func CPUSamplerOption(sampler *cpuvalues.Sampler) func(*sinkObs) {
+	return func(so *sinkObs) {
+		so.sampler = sampler
+	}
 }
 
 // New return an observer that feeds the go-metrics sink
+// It reports memory and CPU every reportInterval; with a non-positive
+// interval it reports none, as CWReporter.ReportToCloudWatch does.
+// The observer implements subject.Closer to stop reporting memory and CPU
+// and shut down the sink
 func New(
 	sink gometrics.MetricSink,
 	reportInterval time.Duration,
+	options ...func(*sinkObs),
 ) subject.Observer {
 	obs := sinkObs{
 		sink:   sink,
 		active: make(map[string]activeEntry),
+		stop:   make(chan struct{}),
+	}
+	for _, f := range options {
+		f(&obs)
 	}
-	go obs.reportMemory(reportInterval)
+	if obs.sampler == nil {
+		obs.sampler = cpuvalues.Default()
+	}
+	go obs.reportSystem(reportInterval)
 	return &obs
 }
 
+// Close implements the subject.Closer interface
+// It stops the memory and CPU reporter and, if the sink supports it
+// (e.g. StatsiteSink), shuts down the sink
+func (so *sinkObs) Close() error {
+	so.closeOnce.Do(func() {
+		close(so.stop)
+
+		if shutdowner, ok := so.sink.(interface {
+			Shutdown()
+		}); ok {
+			so.Lock()
+			shutdowner.Shutdown()
+			so.Unlock()
+		}
+	})
+
+	return nil
+}
+
+// EventTypes implements the subject.FilteredObserver interface
+func (so *sinkObs) EventTypes() []string {
+	return []string{"rpc.*"}
+}
+
 // Observe implements the Observer pattern
 func (so *sinkObs) Observe(event subject.MetricsEvent) {
 	so.Lock()
@@ -81,10 +140,11 @@ func (so *sinkObs) Observe(event subject.MetricsEvent) {
 	updateTagMap(entry.tagMap, event)
 
 	if end {
+		entry.stats.Key = so.guard.Key(entry.stats.Key)
 		key := []string{
 			entry.tagMap["service"],
 			entry.tagMap["host"],
-			fixEntryKey(entry.stats.Key),
+			fixEntryKey(apistats.EndpointKey(entry.stats)),
 		}
 		elapsed := entry.stats.EndTime.Sub(entry.stats.BeginTime)
 		so.sink.IncrCounter(
@@ -108,37 +168,70 @@ func (so *sinkObs) Observe(event subject.MetricsEvent) {
 	}
 }
 
-func (so *sinkObs) reportMemory(reportInterval time.Duration) {
-	tickChan := time.Tick(reportInterval)
-MEM_LOOP:
+// reportSystem reports memory and CPU until Close is called; with a
+// non-positive interval it returns at once
+func (so *sinkObs) reportSystem(reportInterval time.Duration) {
+	if reportInterval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(reportInterval)
+	defer ticker.Stop()
 	for {
-		<-tickChan
-		memValues, err := memvalues.GetMemValues()
-		if err != nil {
-			log.Printf("ERROR: memvalues.GetMemValues(): %s", err)
-			continue MEM_LOOP
+		select {
+		case <-so.stop:
+			return
+		case <-ticker.C:
 		}
-		so.Lock()
-		so.sink.AddSample(
-			[]string{"memory", "system", "available"},
-			float32(memValues.SystemMemoryAvailable),
-		)
-		so.sink.AddSample(
-			[]string{"memory", "system", "used"},
-			float32(memValues.SystemMemoryUsed),
-		)
-		so.sink.AddSample(
-			[]string{"memory", "system", "used-percent"},
-			float32(memValues.SystemMemoryUsedPercent),
-		)
-		so.sink.AddSample(
-			[]string{"memory", "process", "used"},
-			float32(memValues.ProcessMemoryUsed),
-		)
-		so.Unlock()
+		so.reportMemory()
+		so.reportCPU()
 	}
 }
 
+func (so *sinkObs) reportMemory() {
+	memValues, err := memvalues.GetMemValues()
+	if err != nil {
+		log.Printf("ERROR: memvalues.GetMemValues(): %s", err)
+		return
+	}
+	so.Lock()
+	so.sink.AddSample(
+		[]string{"memory", "system", "available"},
+		float32(memValues.SystemMemoryAvailable),
+	)
+	so.sink.AddSample(
+		[]string{"memory", "system", "used"},
+		float32(memValues.SystemMemoryUsed),
+	)
+	so.sink.AddSample(
+		[]string{"memory", "system", "used-percent"},
+		float32(memValues.SystemMemoryUsedPercent),
+	)
+	so.sink.AddSample(
+		[]string{"memory", "process", "used"},
+		float32(memValues.ProcessMemoryUsed),
+	)
+	so.Unlock()
+}
+
+func (so *sinkObs) reportCPU() {
+	cpuValues, err := so.sampler.Values()
+	if err != nil {
+		log.Printf("ERROR: sampler.Values(): %s", err)
+		return
+	}
+	so.Lock()
+	so.sink.AddSample(
+		[]string{"cpu", "system", "percent"},
+		float32(cpuValues.SystemPercent),
+	)
+	so.sink.AddSample(
+		[]string{"cpu", "process", "percent"},
+		float32(cpuValues.ProcessPercent),
+	)
+	so.Unlock()
+}
+
 func duration2ms(d time.Duration) float32 {
 	const nsPerMs = 1000000
 
@@ -14,7 +14,10 @@
 
 package sinkobserver
 
-import "testing"
+import (
+	"testing"
+	"time"
+)
 
 func Test_fixEntryKey(t *testing.T) {
 	type args struct {
@@ -64,3 +67,11 @@ func Test_fixEntryKey(t *testing.T) {
 		})
 	}
 }
+
+func TestReportSystemInvalidInterval(t *testing.T) {
+	for _, interval := range []time.Duration{0, -time.Second} {
+		so := sinkObs{stop: make(chan struct{})}
+		// returns at once instead of panicking in time.NewTicker
+		so.reportSystem(interval)
+	}
+}
@@ -0,0 +1,51 @@
+// Copyright 2017 Decipher Technology Studios LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+/*Package spanobserver implements an observer that records completed HTTP and
+gRPC transactions as spans of distributed traces, and exports them to a
+tracing backend such as Zipkin or an OpenTelemetry collector.
+
+The trace, span and parent span IDs come from the trace context of each
+MetricsEvent, see package tracecontext. A transaction without a trace
+context starts a trace of its own. Outgoing requests made through
+httpclient.Transport are client spans, children of the span of the
+transaction that made them.
+
+usage:
+    exporter := spanobserver.NewZipkinExporter(
+        spanobserver.DefaultZipkinURL,
+        "catalog",
+    )
+    spanObserver := spanobserver.New(
+        exporter,
+        spanobserver.HeadSampleRateOption(0.01),
+        spanobserver.SlowThresholdOption(time.Second),
+    )
+
+    metricsChan := subject.New(ctx, grpcObserver, spanObserver)
+
+Sampling is decided when a transaction completes:
+
+    - head sampling keeps the spans of a fraction of the traces; the decision
+      depends only on the trace ID, so every service keeps the same traces
+    - tail sampling keeps every span that failed, and every span slower than
+      the slow threshold, whatever the head sampling decision
+
+Spans are exported in batches in the background; Flush and Close, called by
+subject.Flush and subject.Close, export the spans that are still queued.
+A transaction that goes without an event for longer than MaxActiveAgeOption,
+e.g. because its end event was dropped, is discarded and counted in
+Stats.Abandoned.
+*/
+package spanobserver
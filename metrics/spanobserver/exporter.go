@@ -0,0 +1,104 @@
+// Copyright 2017 Decipher Technology Studios LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package spanobserver
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/pkg/errors"
+)
+
+// HTTPExporter implements the Exporter interface
+// It posts each batch of spans, encoded for the backend, to a collector URL
+type HTTPExporter struct {
+	url         string
+	contentType string
+	encode      func([]Span) ([]byte, error)
+	client      *http.Client
+	header      http.Header
+}
+
+// HTTPClientOption returns an exporter option function that sets the HTTP
+// client, for example to use TLS; the default is http.DefaultClient
+func HTTPClientOption(client *http.Client) func(*HTTPExporter) {
+	return func(e *HTTPExporter) {
+		e.client = client
+	}
+}
+
+// HeaderOption returns an exporter option function that adds a header to
+// each request, for example for authorization
+func HeaderOption(name, value string) func(*HTTPExporter) {
+	return func(e *HTTPExporter) {
+		e.header.Add(name, value)
+	}
+}
+
+func newHTTPExporter(
+	url string,
+	contentType string,
+	encode func([]Span) ([]byte, error),
+	options []func(*HTTPExporter),
+) *HTTPExporter {
+	e := HTTPExporter{
+		url:         url,
+		contentType: contentType,
+		encode:      encode,
+		client:      http.DefaultClient,
+		header:      make(http.Header),
+	}
+	for _, f := range options {
+		f(&e)
+	}
+
+	return &e
+}
+
+// Export implements the Exporter interface
+func (e *HTTPExporter) Export(ctx context.Context, spans []Span) error {
+	body, err := e.encode(spans)
+	if err != nil {
+		return errors.Wrap(err, "encode")
+	}
+
+	req, err := http.NewRequest("POST", e.url, bytes.NewReader(body))
+	if err != nil {
+		return errors.Wrapf(err, "http.NewRequest(%s)", e.url)
+	}
+	req = req.WithContext(ctx)
+	for name, values := range e.header {
+		req.Header[name] = values
+	}
+	req.Header.Set("Content-Type", e.contentType)
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return errors.Wrapf(err, "POST %s", e.url)
+	}
+	defer resp.Body.Close()
+
+	// read the body so the connection can be reused
+	io.Copy(ioutil.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return errors.Errorf("POST %s: %s", e.url, resp.Status)
+	}
+
+	return nil
+}
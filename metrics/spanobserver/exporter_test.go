@@ -0,0 +1,289 @@
+// Copyright 2017 Decipher Technology Studios LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package spanobserver
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"github.com/deciphernow/gm-fabric-go/metrics/subject"
+)
+
+// collector is a stand-in for a tracing backend
+type collector struct {
+	*httptest.Server
+	contentType   string
+	authorization string
+	body          []byte
+}
+
+func newCollector(t *testing.T, status int) *collector {
+	c := &collector{}
+	c.Server = httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, req *http.Request) {
+			body, err := ioutil.ReadAll(req.Body)
+			if err != nil {
+				t.Fatalf("ReadAll failed: %s", err)
+			}
+			c.contentType = req.Header.Get("Content-Type")
+			c.authorization = req.Header.Get("Authorization")
+			c.body = body
+			w.WriteHeader(status)
+		},
+	))
+	return c
+}
+
+func testSpans() []Span {
+	start := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	span := Span{
+		Kind:      SpanKindServer,
+		Name:      "function/Check",
+		RequestID: "req-1",
+		Transport: subject.EventTransportRPC,
+		PrevRoute: "route/acme",
+		Start:     start,
+		End:       start.Add(1500 * time.Microsecond),
+		GRPCCode:  codes.Unavailable,
+		Tags:      map[string]string{"zone": "east"},
+	}
+	hex.Decode(span.TraceID[:], []byte(testTraceID))
+	hex.Decode(span.SpanID[:], []byte(testSpanID))
+	hex.Decode(span.ParentSpanID[:], []byte(testParentSpanID))
+
+	root := span
+	root.ParentSpanID = [8]byte{}
+	root.Transport = subject.EventTransportHTTP
+	root.Method = "GET"
+	root.HTTPStatus = 200
+
+	return []Span{span, root}
+}
+
+func TestZipkinExporter(t *testing.T) {
+	c := newCollector(t, http.StatusAccepted)
+	defer c.Close()
+
+	exporter := NewZipkinExporter(
+		c.URL,
+		"catalog",
+		HeaderOption("Authorization", "Bearer xyz"),
+	)
+	if err := exporter.Export(context.Background(), testSpans()); err != nil {
+		t.Fatalf("Export failed: %s", err)
+	}
+
+	if c.contentType != "application/json" {
+		t.Fatalf("content type: found %q", c.contentType)
+	}
+	if c.authorization != "Bearer xyz" {
+		t.Fatalf("authorization: found %q", c.authorization)
+	}
+
+	var spans []zipkinSpan
+	if err := json.Unmarshal(c.body, &spans); err != nil {
+		t.Fatalf("json.Unmarshal failed: %s; %s", err, string(c.body))
+	}
+	if len(spans) != 2 {
+		t.Fatalf("expected 2 spans, found %d", len(spans))
+	}
+
+	span := spans[0]
+	if span.TraceID != testTraceID || span.ID != testSpanID ||
+		span.ParentID != testParentSpanID {
+		t.Fatalf("unexpected IDs %+v", span)
+	}
+	if span.Kind != "SERVER" || span.LocalEndpoint.ServiceName != "catalog" {
+		t.Fatalf("unexpected span %+v", span)
+	}
+	if span.Timestamp != 1483228800000000 || span.Duration != 1500 {
+		t.Fatalf("timestamp, duration: found %d, %d", span.Timestamp, span.Duration)
+	}
+	for name, expected := range map[string]string{
+		"grpc.status_code": "Unavailable",
+		"error":            "Unavailable",
+		"gm.request_id":    "req-1",
+		"gm.prev_route":    "route/acme",
+		"zone":             "east",
+	} {
+		if span.Tags[name] != expected {
+			t.Fatalf("tag %s: expected %q found %q", name, expected, span.Tags[name])
+		}
+	}
+
+	root := spans[1]
+	if root.ParentID != "" {
+		t.Fatalf("expected no parent, found %q", root.ParentID)
+	}
+	if root.Tags["http.status_code"] != "200" || root.Tags["http.method"] != "GET" {
+		t.Fatalf("unexpected tags %v", root.Tags)
+	}
+	if _, ok := root.Tags["error"]; ok {
+		t.Fatalf("unexpected error tag %v", root.Tags)
+	}
+}
+
+func TestExporterStatus(t *testing.T) {
+	c := newCollector(t, http.StatusServiceUnavailable)
+	defer c.Close()
+
+	exporter := NewZipkinExporter(c.URL, "catalog")
+	if err := exporter.Export(context.Background(), testSpans()); err == nil {
+		t.Fatal("expected an error")
+	}
+}
+
+// protoFields decodes a protobuf message into its fields by number;
+// length delimited fields are returned as bytes, others as uint64
+func protoFields(t *testing.T, b []byte) map[protowire.Number][]interface{} {
+	fields := make(map[protowire.Number][]interface{})
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			t.Fatalf("ConsumeTag failed: %s", protowire.ParseError(n))
+		}
+		b = b[n:]
+
+		var value interface{}
+		switch typ {
+		case protowire.BytesType:
+			value, n = protowire.ConsumeBytes(b)
+		case protowire.VarintType:
+			value, n = protowire.ConsumeVarint(b)
+		case protowire.Fixed64Type:
+			value, n = protowire.ConsumeFixed64(b)
+		default:
+			t.Fatalf("unexpected wire type %d", typ)
+		}
+		if n < 0 {
+			t.Fatalf("field %d: %s", num, protowire.ParseError(n))
+		}
+		b = b[n:]
+
+		fields[num] = append(fields[num], value)
+	}
+	return fields
+}
+
+// protoMessage returns the single message in field num
+func protoMessage(t *testing.T, fields map[protowire.Number][]interface{}, num protowire.Number) map[protowire.Number][]interface{} {
+	if len(fields[num]) != 1 {
+		t.Fatalf("field %d: expected 1 value, found %d", num, len(fields[num]))
+	}
+	return protoFields(t, fields[num][0].([]byte))
+}
+
+// protoAttributes decodes the KeyValue attributes in field num
+func protoAttributes(t *testing.T, fields map[protowire.Number][]interface{}, num protowire.Number) map[string]interface{} {
+	attributes := make(map[string]interface{})
+	for _, value := range fields[num] {
+		keyValue := protoFields(t, value.([]byte))
+		key := string(keyValue[keyValueKey][0].([]byte))
+		anyValue := protoMessage(t, keyValue, keyValueValue)
+		switch {
+		case len(anyValue[anyValueString]) == 1:
+			attributes[key] = string(anyValue[anyValueString][0].([]byte))
+		case len(anyValue[anyValueInt]) == 1:
+			attributes[key] = int64(anyValue[anyValueInt][0].(uint64))
+		}
+	}
+	return attributes
+}
+
+func TestOTLPExporter(t *testing.T) {
+	c := newCollector(t, http.StatusOK)
+	defer c.Close()
+
+	exporter := NewOTLPExporter(c.URL, "catalog")
+	if err := exporter.Export(context.Background(), testSpans()); err != nil {
+		t.Fatalf("Export failed: %s", err)
+	}
+
+	if c.contentType != "application/x-protobuf" {
+		t.Fatalf("content type: found %q", c.contentType)
+	}
+
+	request := protoFields(t, c.body)
+	resourceSpans := protoMessage(t, request, exportResourceSpans)
+
+	resource := protoMessage(t, resourceSpans, resourceSpansResource)
+	if name := protoAttributes(t, resource, resourceAttributes)["service.name"]; name != "catalog" {
+		t.Fatalf("service.name: found %v", name)
+	}
+
+	scopeSpans := protoMessage(t, resourceSpans, resourceSpansScopeSpans)
+	if len(scopeSpans[scopeSpansSpans]) != 2 {
+		t.Fatalf("expected 2 spans, found %d", len(scopeSpans[scopeSpansSpans]))
+	}
+
+	span := protoFields(t, scopeSpans[scopeSpansSpans][0].([]byte))
+	for num, expected := range map[protowire.Number]string{
+		spanTraceID:      testTraceID,
+		spanSpanID:       testSpanID,
+		spanParentSpanID: testParentSpanID,
+	} {
+		if found := hex.EncodeToString(span[num][0].([]byte)); found != expected {
+			t.Fatalf("field %d: expected %s found %s", num, expected, found)
+		}
+	}
+	if name := string(span[spanName][0].([]byte)); name != "function/Check" {
+		t.Fatalf("name: found %q", name)
+	}
+	if kind := span[spanKind][0].(uint64); kind != otlpSpanKindServer {
+		t.Fatalf("kind: found %d", kind)
+	}
+	start := span[spanStartTime][0].(uint64)
+	end := span[spanEndTime][0].(uint64)
+	if start != 1483228800000000000 || end-start != 1500000 {
+		t.Fatalf("start, end: found %d, %d", start, end)
+	}
+
+	attributes := protoAttributes(t, span, spanAttributes)
+	for name, expected := range map[string]interface{}{
+		"rpc.system":           "grpc",
+		"rpc.grpc.status_code": int64(codes.Unavailable),
+		"gm.request_id":        "req-1",
+		"gm.prev_route":        "route/acme",
+		"zone":                 "east",
+	} {
+		if attributes[name] != expected {
+			t.Fatalf("attribute %s: expected %v found %v", name, expected, attributes[name])
+		}
+	}
+
+	spanStatusFields := protoMessage(t, span, spanStatus)
+	if code := spanStatusFields[statusCode][0].(uint64); code != otlpStatusCodeError {
+		t.Fatalf("status code: found %d", code)
+	}
+
+	root := protoFields(t, scopeSpans[scopeSpansSpans][1].([]byte))
+	if len(root[spanParentSpanID]) != 0 || len(root[spanStatus]) != 0 {
+		t.Fatalf("unexpected parent or status in root span")
+	}
+	attributes = protoAttributes(t, root, spanAttributes)
+	if attributes["http.request.method"] != "GET" ||
+		attributes["http.response.status_code"] != int64(200) {
+		t.Fatalf("unexpected attributes %v", attributes)
+	}
+}
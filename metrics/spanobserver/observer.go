@@ -0,0 +1,378 @@
+// Copyright 2017 Decipher Technology Studios LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package spanobserver
+
+import (
+	"context"
+	"hash/fnv"
+	"log"
+	"math"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/deciphernow/gm-fabric-go/metrics/grpcobserver"
+	"github.com/deciphernow/gm-fabric-go/metrics/subject"
+)
+
+const (
+	// DefaultBatchSize is the largest number of spans exported at once
+	DefaultBatchSize = 100
+
+	// DefaultFlushInterval is the longest time a span waits to be exported
+	DefaultFlushInterval = 5 * time.Second
+
+	// DefaultMaxQueueSize is the number of spans that may wait to be
+	// exported; spans beyond it are dropped
+	DefaultMaxQueueSize = 2048
+
+	// DefaultExportTimeout bounds each call to Exporter.Export
+	DefaultExportTimeout = 10 * time.Second
+
+	// DefaultMaxActiveAge is the longest time a transaction may go without
+	// an event before it is discarded as abandoned
+	DefaultMaxActiveAge = 10 * time.Minute
+)
+
+// Exporter sends spans to a tracing backend
+type Exporter interface {
+	Export(ctx context.Context, spans []Span) error
+}
+
+// Stats are counts of the spans handled by a SpanObserver
+type Stats struct {
+	// Completed is the number of completed transactions
+	Completed uint64
+
+	// Sampled is the number of spans kept by sampling
+	Sampled uint64
+
+	// Exported is the number of spans exported successfully
+	Exported uint64
+
+	// Failed is the number of spans the exporter failed to export
+	Failed uint64
+
+	// Dropped is the number of spans dropped because the queue was full
+	Dropped uint64
+
+	// Abandoned is the number of transactions discarded because they did
+	// not complete within the max active age
+	Abandoned uint64
+}
+
+// SpanObserver implements the subject.Observer interface
+// It turns completed transactions into spans and exports the sampled ones
+type SpanObserver struct {
+	sync.Mutex
+
+	exporter      Exporter
+	logger        *log.Logger
+	headRate      float64
+	slowThreshold time.Duration
+	keepErrors    bool
+	batchSize     int
+	flushInterval time.Duration
+	maxQueueSize  int
+	maxActiveAge  time.Duration
+	now           func() time.Time
+
+	active map[string]activeSpan
+	queue  []Span
+	stats  Stats
+
+	// exportLock serializes exports, which are made without the lock
+	exportLock sync.Mutex
+	kick       chan struct{}
+	stop       chan struct{}
+	done       chan struct{}
+	closeOnce  sync.Once
+}
+
+// HeadSampleRateOption returns an observer option function that sets the
+// fraction of traces, between 0 and 1, whose spans are kept; the default is 1
+func HeadSampleRateOption(rate float64) func(*SpanObserver) {
+	return func(o *SpanObserver) {
+		o.headRate = rate
+	}
+}
+
+// SlowThresholdOption returns an observer option function that keeps every
+// span lasting at least threshold; the default, 0, keeps none
+func SlowThresholdOption(threshold time.Duration) func(*SpanObserver) {
+	return func(o *SpanObserver) {
+		o.slowThreshold = threshold
+	}
+}
+
+// KeepErrorsOption returns an observer option function that determines
+// whether every failed span is kept; the default is true
+func KeepErrorsOption(keepErrors bool) func(*SpanObserver) {
+	return func(o *SpanObserver) {
+		o.keepErrors = keepErrors
+	}
+}
+
+// BatchSizeOption returns an observer option function that sets the
+// largest number of spans exported at once
+func BatchSizeOption(size int) func(*SpanObserver) {
+	return func(o *SpanObserver) {
+		o.batchSize = size
+	}
+}
+
+// FlushIntervalOption returns an observer option function that sets the
+// longest time a span waits to be exported
+func FlushIntervalOption(interval time.Duration) func(*SpanObserver) {
+	return func(o *SpanObserver) {
+		if interval > 0 {
+			o.flushInterval = interval
+		}
+	}
+}
+
+// MaxQueueSizeOption returns an observer option function that sets the
+// number of spans that may wait to be exported
+func MaxQueueSizeOption(size int) func(*SpanObserver) {
+	return func(o *SpanObserver) {
+		if size > 0 {
+			o.maxQueueSize = size
+		}
+	}
+}
+
+// MaxActiveAgeOption returns an observer option function that sets the
+// longest time a transaction may go without an event, e.g. because its
+// end event was dropped, before it is discarded
+func MaxActiveAgeOption(age time.Duration) func(*SpanObserver) {
+	return func(o *SpanObserver) {
+		if age > 0 {
+			o.maxActiveAge = age
+		}
+	}
+}
+
+// LoggerOption returns an observer option function that sets the logger
+// for export errors; the default logs to stderr
+func LoggerOption(logger *log.Logger) func(*SpanObserver) {
+	return func(o *SpanObserver) {
+		o.logger = logger
+	}
+}
+
+// New returns an observer that exports spans with exporter
+// The observer implements subject.Flusher and subject.Closer to export the
+// queued spans and stop exporting in the background
+func New(exporter Exporter, options ...func(*SpanObserver)) *SpanObserver {
+	o := SpanObserver{
+		exporter:      exporter,
+		headRate:      1,
+		keepErrors:    true,
+		batchSize:     DefaultBatchSize,
+		flushInterval: DefaultFlushInterval,
+		maxQueueSize:  DefaultMaxQueueSize,
+		maxActiveAge:  DefaultMaxActiveAge,
+		now:           time.Now,
+		active:        make(map[string]activeSpan),
+		kick:          make(chan struct{}, 1),
+		stop:          make(chan struct{}),
+		done:          make(chan struct{}),
+	}
+	for _, f := range options {
+		f(&o)
+	}
+	if o.logger == nil {
+		o.logger = log.New(os.Stderr, "", log.LstdFlags)
+	}
+	if o.batchSize < 1 {
+		o.batchSize = DefaultBatchSize
+	}
+
+	go o.run()
+
+	return &o
+}
+
+// EventTypes implements the subject.FilteredObserver interface
+func (o *SpanObserver) EventTypes() []string {
+	return []string{"rpc.*"}
+}
+
+// Observe implements the subject.Observer interface
+func (o *SpanObserver) Observe(event subject.MetricsEvent) {
+	o.Lock()
+	defer o.Unlock()
+
+	a := o.active[event.RequestID]
+	var end bool
+	a.entry, end = grpcobserver.Accumulate(a.entry, event)
+	a.accumulate(event)
+	a.lastSeen = o.now()
+
+	if !end {
+		o.active[event.RequestID] = a
+		return
+	}
+	delete(o.active, event.RequestID)
+
+	a.entry.RequestID = event.RequestID
+	span := a.span()
+
+	o.stats.Completed++
+	if !o.sample(span) {
+		return
+	}
+	o.stats.Sampled++
+
+	if len(o.queue) >= o.maxQueueSize {
+		o.stats.Dropped++
+		return
+	}
+	o.queue = append(o.queue, span)
+
+	if len(o.queue) >= o.batchSize {
+		select {
+		case o.kick <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// sample returns true if span is to be exported
+func (o *SpanObserver) sample(span Span) bool {
+	if o.keepErrors && span.IsError() {
+		return true
+	}
+	if o.slowThreshold > 0 && span.Duration() >= o.slowThreshold {
+		return true
+	}
+
+	return headSampled(span.TraceID, o.headRate)
+}
+
+// headSampled returns true for a fraction rate of trace IDs; the trace ID
+// is hashed because generated IDs are not uniformly random in every byte
+func headSampled(traceID [16]byte, rate float64) bool {
+	switch {
+	case rate >= 1:
+		return true
+	case rate <= 0:
+		return false
+	}
+
+	h := fnv.New64a()
+	h.Write(traceID[:])
+
+	return float64(h.Sum64()) < rate*math.MaxUint64
+}
+
+// Stats returns the counts of the spans handled by the observer
+func (o *SpanObserver) Stats() Stats {
+	o.Lock()
+	defer o.Unlock()
+
+	return o.stats
+}
+
+func (o *SpanObserver) run() {
+	defer close(o.done)
+
+	ticker := time.NewTicker(o.flushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-o.stop:
+			return
+		case <-o.kick:
+		case <-ticker.C:
+			o.evictAbandoned()
+		}
+		if err := o.export(); err != nil {
+			o.logger.Printf("spanobserver: %s", err)
+		}
+	}
+}
+
+// evictAbandoned discards the transactions that have gone without an event
+// for longer than the max active age, so that transactions whose end event
+// never arrives do not accumulate
+func (o *SpanObserver) evictAbandoned() {
+	o.Lock()
+	defer o.Unlock()
+
+	oldest := o.now().Add(-o.maxActiveAge)
+	for requestID, a := range o.active {
+		if a.lastSeen.Before(oldest) {
+			delete(o.active, requestID)
+			o.stats.Abandoned++
+		}
+	}
+}
+
+// export exports the queued spans in batches
+func (o *SpanObserver) export() error {
+	o.exportLock.Lock()
+	defer o.exportLock.Unlock()
+
+	o.Lock()
+	queue := o.queue
+	o.queue = nil
+	o.Unlock()
+
+	var exportErr error
+	for len(queue) > 0 {
+		n := len(queue)
+		if n > o.batchSize {
+			n = o.batchSize
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), DefaultExportTimeout)
+		err := o.exporter.Export(ctx, queue[:n])
+		cancel()
+
+		o.Lock()
+		if err != nil {
+			o.stats.Failed += uint64(n)
+			exportErr = errors.Wrapf(err, "exporting %d spans", n)
+		} else {
+			o.stats.Exported += uint64(n)
+		}
+		o.Unlock()
+
+		queue = queue[n:]
+	}
+
+	return exportErr
+}
+
+// Flush implements the subject.Flusher interface
+// It exports the queued spans
+func (o *SpanObserver) Flush() error {
+	return o.export()
+}
+
+// Close implements the subject.Closer interface
+// It stops exporting in the background and exports the queued spans
+func (o *SpanObserver) Close() error {
+	o.closeOnce.Do(func() {
+		close(o.stop)
+		<-o.done
+	})
+
+	return o.export()
+}
@@ -0,0 +1,532 @@
+// Copyright 2017 Decipher Technology Studios LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package spanobserver
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/deciphernow/gm-fabric-go/metrics/subject"
+)
+
+const (
+	testTraceID      = "4bf92f3577b34da6a3ce929d0e0e4736"
+	testSpanID       = "00f067aa0ba902b7"
+	testParentSpanID = "b7ad6b7169203331"
+)
+
+// fakeExporter records the spans it exports
+type fakeExporter struct {
+	sync.Mutex
+	batches [][]Span
+	err     error
+}
+
+func (e *fakeExporter) Export(ctx context.Context, spans []Span) error {
+	e.Lock()
+	defer e.Unlock()
+	if e.err != nil {
+		return e.err
+	}
+	e.batches = append(e.batches, append([]Span(nil), spans...))
+	return nil
+}
+
+func (e *fakeExporter) spans() []Span {
+	e.Lock()
+	defer e.Unlock()
+	var spans []Span
+	for _, batch := range e.batches {
+		spans = append(spans, batch...)
+	}
+	return spans
+}
+
+// transaction describes the events of a test transaction
+type transaction struct {
+	requestID  string
+	transport  subject.EventTransport
+	traceID    string
+	duration   time.Duration
+	httpStatus int
+	err        error
+}
+
+func (tr transaction) events() []subject.MetricsEvent {
+	begin := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	base := subject.MetricsEvent{
+		RequestID:    tr.requestID,
+		Transport:    tr.transport,
+		Key:          "route/acme",
+		Method:       "GET",
+		TraceID:      tr.traceID,
+		SpanID:       testSpanID,
+		ParentSpanID: testParentSpanID,
+		Tags:         []string{"zone:east"},
+	}
+	if tr.traceID == "" {
+		base.SpanID = ""
+		base.ParentSpanID = ""
+	}
+
+	inHeader := base
+	inHeader.EventType = "rpc.InHeader"
+	inHeader.Timestamp = begin
+	inHeader.Value = int64(100)
+
+	beginEvent := base
+	beginEvent.EventType = "rpc.Begin"
+	beginEvent.Timestamp = begin
+
+	end := base
+	end.EventType = "rpc.End"
+	end.Timestamp = begin.Add(tr.duration)
+	end.HTTPStatus = tr.httpStatus
+	if tr.err != nil {
+		end.Value = tr.err
+	}
+
+	return []subject.MetricsEvent{inHeader, beginEvent, end}
+}
+
+func newTestObserver(exporter Exporter, options ...func(*SpanObserver)) *SpanObserver {
+	options = append(
+		[]func(*SpanObserver){
+			FlushIntervalOption(time.Hour),
+			LoggerOption(log.New(ioutil.Discard, "", 0)),
+		},
+		options...,
+	)
+	return New(exporter, options...)
+}
+
+func TestObserveSpan(t *testing.T) {
+	exporter := &fakeExporter{}
+	o := newTestObserver(exporter)
+
+	tr := transaction{
+		requestID:  "req-1",
+		transport:  subject.EventTransportHTTP,
+		traceID:    testTraceID,
+		duration:   5 * time.Millisecond,
+		httpStatus: 200,
+	}
+	for _, event := range tr.events() {
+		o.Observe(event)
+	}
+	if err := o.Close(); err != nil {
+		t.Fatalf("Close failed: %s", err)
+	}
+
+	spans := exporter.spans()
+	if len(spans) != 1 {
+		t.Fatalf("expected 1 span, found %d", len(spans))
+	}
+	span := spans[0]
+
+	for _, check := range []struct{ name, expected, found string }{
+		{"trace ID", testTraceID, hex.EncodeToString(span.TraceID[:])},
+		{"span ID", testSpanID, hex.EncodeToString(span.SpanID[:])},
+		{"parent span ID", testParentSpanID, hex.EncodeToString(span.ParentSpanID[:])},
+		{"name", "route/acme", span.Name},
+		{"request ID", "req-1", span.RequestID},
+		{"method", "GET", span.Method},
+		{"tag", "east", span.Tags["zone"]},
+	} {
+		if check.found != check.expected {
+			t.Fatalf("%s: expected %q found %q", check.name, check.expected, check.found)
+		}
+	}
+	if span.Kind != SpanKindServer {
+		t.Fatalf("kind: expected %d found %d", SpanKindServer, span.Kind)
+	}
+	if span.Duration() != 5*time.Millisecond {
+		t.Fatalf("duration: expected 5ms found %s", span.Duration())
+	}
+	if span.InBytes != 100 {
+		t.Fatalf("in bytes: expected 100 found %d", span.InBytes)
+	}
+	if span.IsError() {
+		t.Fatalf("unexpected error: %s", span.ErrorMessage())
+	}
+
+	stats := o.Stats()
+	if stats.Completed != 1 || stats.Sampled != 1 || stats.Exported != 1 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+}
+
+func TestObserveNewTrace(t *testing.T) {
+	exporter := &fakeExporter{}
+	o := newTestObserver(exporter)
+
+	tr := transaction{
+		requestID:  "req-1",
+		transport:  subject.EventTransportHTTP,
+		httpStatus: 200,
+	}
+	for _, event := range tr.events() {
+		o.Observe(event)
+	}
+	o.Close()
+
+	spans := exporter.spans()
+	if len(spans) != 1 {
+		t.Fatalf("expected 1 span, found %d", len(spans))
+	}
+	if spans[0].TraceID == [16]byte{} || spans[0].SpanID == [8]byte{} {
+		t.Fatalf("expected generated IDs: %+v", spans[0])
+	}
+	if spans[0].ParentSpanID != [8]byte{} {
+		t.Fatalf("expected root span: %+v", spans[0])
+	}
+}
+
+func TestObserveClientSpan(t *testing.T) {
+	exporter := &fakeExporter{}
+	o := newTestObserver(exporter)
+
+	begin := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	base := subject.MetricsEvent{
+		RequestID:    "req-1",
+		TraceID:      testTraceID,
+		SpanID:       testSpanID,
+		ParentSpanID: testParentSpanID,
+		Timestamp:    begin,
+	}
+	for _, eventType := range []string{"rpc.Begin", "rpc.OutHeader", "rpc.Retry", "rpc.End"} {
+		event := base
+		event.EventType = eventType
+		if eventType == "rpc.OutHeader" {
+			event.Transport = subject.EventTransportHTTPClient
+			event.Key = "client/catalog/items"
+			event.Method = "GET"
+		}
+		if eventType == "rpc.End" {
+			event.HTTPStatus = 200
+		}
+		o.Observe(event)
+	}
+	o.Close()
+
+	spans := exporter.spans()
+	if len(spans) != 1 {
+		t.Fatalf("expected 1 span, found %d", len(spans))
+	}
+	span := spans[0]
+	if span.Kind != SpanKindClient {
+		t.Fatalf("kind: expected %d found %d", SpanKindClient, span.Kind)
+	}
+	if span.Name != "client/catalog/items" {
+		t.Fatalf("name: expected client/catalog/items found %s", span.Name)
+	}
+	if span.Retries != 1 {
+		t.Fatalf("retries: expected 1 found %d", span.Retries)
+	}
+}
+
+func TestSampling(t *testing.T) {
+	testCases := []struct {
+		name     string
+		options  []func(*SpanObserver)
+		tr       transaction
+		expected bool
+	}{
+		{
+			name: "default keeps all",
+			tr: transaction{
+				transport:  subject.EventTransportHTTP,
+				httpStatus: 200,
+			},
+			expected: true,
+		},
+		{
+			name:    "head sampling drops",
+			options: []func(*SpanObserver){HeadSampleRateOption(0)},
+			tr: transaction{
+				transport:  subject.EventTransportHTTP,
+				httpStatus: 200,
+			},
+			expected: false,
+		},
+		{
+			name:    "HTTP server error kept",
+			options: []func(*SpanObserver){HeadSampleRateOption(0)},
+			tr: transaction{
+				transport:  subject.EventTransportHTTP,
+				httpStatus: 503,
+			},
+			expected: true,
+		},
+		{
+			name:    "HTTP client error dropped",
+			options: []func(*SpanObserver){HeadSampleRateOption(0)},
+			tr: transaction{
+				transport:  subject.EventTransportHTTP,
+				httpStatus: 404,
+			},
+			expected: false,
+		},
+		{
+			name:    "gRPC error kept",
+			options: []func(*SpanObserver){HeadSampleRateOption(0)},
+			tr: transaction{
+				transport: subject.EventTransportRPC,
+				err:       status.Error(codes.NotFound, "no such thing"),
+			},
+			expected: true,
+		},
+		{
+			name: "errors not kept",
+			options: []func(*SpanObserver){
+				HeadSampleRateOption(0),
+				KeepErrorsOption(false),
+			},
+			tr: transaction{
+				transport: subject.EventTransportRPC,
+				err:       status.Error(codes.NotFound, "no such thing"),
+			},
+			expected: false,
+		},
+		{
+			name: "slow kept",
+			options: []func(*SpanObserver){
+				HeadSampleRateOption(0),
+				SlowThresholdOption(time.Second),
+			},
+			tr: transaction{
+				transport:  subject.EventTransportHTTP,
+				httpStatus: 200,
+				duration:   2 * time.Second,
+			},
+			expected: true,
+		},
+		{
+			name: "fast dropped",
+			options: []func(*SpanObserver){
+				HeadSampleRateOption(0),
+				SlowThresholdOption(time.Second),
+			},
+			tr: transaction{
+				transport:  subject.EventTransportHTTP,
+				httpStatus: 200,
+				duration:   time.Millisecond,
+			},
+			expected: false,
+		},
+	}
+
+	for i, tc := range testCases {
+		t.Run(fmt.Sprintf("%d: %s", i, tc.name), func(t *testing.T) {
+			exporter := &fakeExporter{}
+			o := newTestObserver(exporter, tc.options...)
+			tc.tr.requestID = "req"
+			for _, event := range tc.tr.events() {
+				o.Observe(event)
+			}
+			o.Close()
+
+			if found := len(exporter.spans()) == 1; found != tc.expected {
+				t.Fatalf("expected sampled %t found %t", tc.expected, found)
+			}
+		})
+	}
+}
+
+func TestHeadSampled(t *testing.T) {
+	const traces = 10000
+
+	var sampled int
+	for i := 0; i < traces; i++ {
+		var traceID [16]byte
+		traceID[0] = byte(i)
+		traceID[1] = byte(i >> 8)
+		if headSampled(traceID, 0.25) {
+			sampled++
+		}
+	}
+
+	if sampled < traces/5 || sampled > traces*3/10 {
+		t.Fatalf("expected about %d sampled traces, found %d", traces/4, sampled)
+	}
+}
+
+func TestBatching(t *testing.T) {
+	exporter := &fakeExporter{}
+	o := newTestObserver(exporter, BatchSizeOption(4), MaxQueueSizeOption(10))
+
+	// the first batch is exported in the background as soon as it is full
+	for i := 0; i < 4; i++ {
+		tr := transaction{
+			requestID:  fmt.Sprintf("req-%d", i),
+			transport:  subject.EventTransportHTTP,
+			httpStatus: 200,
+		}
+		for _, event := range tr.events() {
+			o.Observe(event)
+		}
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for len(exporter.spans()) != 4 {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for a full batch")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	for i := 4; i < 10; i++ {
+		tr := transaction{
+			requestID:  fmt.Sprintf("req-%d", i),
+			transport:  subject.EventTransportHTTP,
+			httpStatus: 200,
+		}
+		for _, event := range tr.events() {
+			o.Observe(event)
+		}
+	}
+
+	// the rest of the queue is exported at Close, in batches
+	if err := o.Close(); err != nil {
+		t.Fatalf("Close failed: %s", err)
+	}
+
+	if n := len(exporter.spans()); n != 10 {
+		t.Fatalf("expected 10 spans, found %d", n)
+	}
+	for _, batch := range exporter.batches {
+		if len(batch) > 4 {
+			t.Fatalf("expected batches of at most 4 spans, found %d", len(batch))
+		}
+	}
+}
+
+func TestExportFailure(t *testing.T) {
+	exporter := &fakeExporter{err: errors.New("unavailable")}
+	o := newTestObserver(exporter, MaxQueueSizeOption(10))
+
+	for i := 0; i < 12; i++ {
+		tr := transaction{
+			requestID:  fmt.Sprintf("req-%d", i),
+			transport:  subject.EventTransportHTTP,
+			httpStatus: 200,
+		}
+		for _, event := range tr.events() {
+			o.Observe(event)
+		}
+	}
+
+	if err := o.Flush(); err == nil {
+		t.Fatal("expected an export error")
+	}
+
+	stats := o.Stats()
+	if stats.Completed != 12 || stats.Sampled != 12 ||
+		stats.Exported != 0 || stats.Failed != 10 || stats.Dropped != 2 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+
+	// failed spans are not retried
+	if err := o.Close(); err != nil {
+		t.Fatalf("Close failed: %s", err)
+	}
+}
+
+func TestNonPositiveOptions(t *testing.T) {
+	testCases := []struct {
+		option        func(*SpanObserver)
+		flushInterval time.Duration
+		maxQueueSize  int
+		maxActiveAge  time.Duration
+	}{
+		{FlushIntervalOption(0), DefaultFlushInterval, DefaultMaxQueueSize, DefaultMaxActiveAge},
+		{FlushIntervalOption(-time.Second), DefaultFlushInterval, DefaultMaxQueueSize, DefaultMaxActiveAge},
+		{FlushIntervalOption(time.Second), time.Second, DefaultMaxQueueSize, DefaultMaxActiveAge},
+		{MaxQueueSizeOption(0), DefaultFlushInterval, DefaultMaxQueueSize, DefaultMaxActiveAge},
+		{MaxQueueSizeOption(-1), DefaultFlushInterval, DefaultMaxQueueSize, DefaultMaxActiveAge},
+		{MaxQueueSizeOption(10), DefaultFlushInterval, 10, DefaultMaxActiveAge},
+		{MaxActiveAgeOption(0), DefaultFlushInterval, DefaultMaxQueueSize, DefaultMaxActiveAge},
+		{MaxActiveAgeOption(time.Minute), DefaultFlushInterval, DefaultMaxQueueSize, time.Minute},
+	}
+
+	for i, tc := range testCases {
+		o := New(&fakeExporter{}, tc.option)
+		if o.flushInterval != tc.flushInterval || o.maxQueueSize != tc.maxQueueSize ||
+			o.maxActiveAge != tc.maxActiveAge {
+			t.Fatalf("#%d: expected %v, %d, %v found %v, %d, %v", i,
+				tc.flushInterval, tc.maxQueueSize, tc.maxActiveAge,
+				o.flushInterval, o.maxQueueSize, o.maxActiveAge)
+		}
+		if err := o.Close(); err != nil {
+			t.Fatalf("#%d: Close failed: %s", i, err)
+		}
+	}
+}
+
+func TestEvictAbandoned(t *testing.T) {
+	exporter := &fakeExporter{}
+	o := newTestObserver(exporter, MaxActiveAgeOption(time.Minute))
+	now := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	o.now = func() time.Time { return now }
+
+	// transactions whose end events never arrive
+	for i := 0; i < 3; i++ {
+		tr := transaction{
+			requestID: fmt.Sprintf("req-%d", i),
+			transport: subject.EventTransportHTTP,
+		}
+		o.Observe(tr.events()[0])
+	}
+
+	now = now.Add(30 * time.Second)
+	recent := transaction{
+		requestID:  "recent",
+		transport:  subject.EventTransportHTTP,
+		httpStatus: 200,
+	}
+	events := recent.events()
+	o.Observe(events[0])
+
+	now = now.Add(45 * time.Second)
+	o.evictAbandoned()
+	if n := len(o.active); n != 1 {
+		t.Fatalf("expected 1 active transaction, found %d", n)
+	}
+
+	// the transaction that was not evicted still completes
+	for _, event := range events[1:] {
+		o.Observe(event)
+	}
+	if err := o.Close(); err != nil {
+		t.Fatalf("Close failed: %s", err)
+	}
+
+	stats := o.Stats()
+	if stats.Abandoned != 3 || stats.Completed != 1 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+	if spans := exporter.spans(); len(spans) != 1 || spans[0].RequestID != "recent" {
+		t.Fatalf("unexpected spans %+v", spans)
+	}
+}
@@ -0,0 +1,184 @@
+// Copyright 2017 Decipher Technology Studios LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package spanobserver
+
+import (
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// DefaultOTLPURL is the trace endpoint of a local OpenTelemetry collector
+const DefaultOTLPURL = "http://127.0.0.1:4318/v1/traces"
+
+// scopeName is the instrumentation scope of the exported spans
+const scopeName = "github.com/deciphernow/gm-fabric-go/metrics/spanobserver"
+
+// NewOTLPExporter returns an exporter that posts spans in the OTLP/HTTP
+// protobuf format to url, as the spans of serviceName
+func NewOTLPExporter(
+	url string,
+	serviceName string,
+	options ...func(*HTTPExporter),
+) *HTTPExporter {
+	encode := func(spans []Span) ([]byte, error) {
+		return encodeOTLP(serviceName, spans), nil
+	}
+
+	return newHTTPExporter(url, "application/x-protobuf", encode, options)
+}
+
+// field numbers from opentelemetry/proto/trace/v1/trace.proto and
+// opentelemetry/proto/common/v1/common.proto
+const (
+	exportResourceSpans = 1 // ExportTraceServiceRequest.resource_spans
+
+	resourceSpansResource   = 1 // ResourceSpans.resource
+	resourceSpansScopeSpans = 2 // ResourceSpans.scope_spans
+
+	resourceAttributes = 1 // Resource.attributes
+
+	scopeSpansScope = 1 // ScopeSpans.scope
+	scopeSpansSpans = 2 // ScopeSpans.spans
+
+	scopeNameField = 1 // InstrumentationScope.name
+
+	spanTraceID      = 1
+	spanSpanID       = 2
+	spanParentSpanID = 4
+	spanName         = 5
+	spanKind         = 6
+	spanStartTime    = 7
+	spanEndTime      = 8
+	spanAttributes   = 9
+	spanStatus       = 15
+
+	statusMessage = 2
+	statusCode    = 3
+
+	keyValueKey   = 1
+	keyValueValue = 2
+
+	anyValueString = 1
+	anyValueInt    = 3
+)
+
+// OTLP enumerations
+const (
+	otlpSpanKindServer  = 2
+	otlpSpanKindClient  = 3
+	otlpStatusCodeError = 2
+)
+
+func encodeOTLP(serviceName string, spans []Span) []byte {
+	resource := appendStringAttribute(nil, resourceAttributes, "service.name", serviceName)
+
+	scope := appendString(nil, scopeNameField, scopeName)
+
+	scopeSpans := appendMessage(nil, scopeSpansScope, scope)
+	for _, span := range spans {
+		scopeSpans = appendMessage(scopeSpans, scopeSpansSpans, encodeOTLPSpan(span))
+	}
+
+	resourceSpans := appendMessage(nil, resourceSpansResource, resource)
+	resourceSpans = appendMessage(resourceSpans, resourceSpansScopeSpans, scopeSpans)
+
+	return appendMessage(nil, exportResourceSpans, resourceSpans)
+}
+
+func encodeOTLPSpan(span Span) []byte {
+	var b []byte
+
+	b = appendBytes(b, spanTraceID, span.TraceID[:])
+	b = appendBytes(b, spanSpanID, span.SpanID[:])
+	if span.ParentSpanID != [8]byte{} {
+		b = appendBytes(b, spanParentSpanID, span.ParentSpanID[:])
+	}
+	b = appendString(b, spanName, span.Name)
+	switch span.Kind {
+	case SpanKindServer:
+		b = protowire.AppendTag(b, spanKind, protowire.VarintType)
+		b = protowire.AppendVarint(b, otlpSpanKindServer)
+	case SpanKindClient:
+		b = protowire.AppendTag(b, spanKind, protowire.VarintType)
+		b = protowire.AppendVarint(b, otlpSpanKindClient)
+	}
+	b = protowire.AppendTag(b, spanStartTime, protowire.Fixed64Type)
+	b = protowire.AppendFixed64(b, uint64(span.Start.UnixNano()))
+	b = protowire.AppendTag(b, spanEndTime, protowire.Fixed64Type)
+	b = protowire.AppendFixed64(b, uint64(span.End.UnixNano()))
+
+	// attributes named by the OpenTelemetry semantic conventions
+	if span.IsGRPC() {
+		b = appendStringAttribute(b, spanAttributes, "rpc.system", "grpc")
+		b = appendIntAttribute(b, spanAttributes, "rpc.grpc.status_code", int64(span.GRPCCode))
+	} else {
+		b = appendStringAttribute(b, spanAttributes, "http.request.method", span.Method)
+		b = appendIntAttribute(b, spanAttributes, "http.response.status_code", int64(span.HTTPStatus))
+	}
+	if span.RequestID != "" {
+		b = appendStringAttribute(b, spanAttributes, "gm.request_id", span.RequestID)
+	}
+	if span.PrevRoute != "" {
+		b = appendStringAttribute(b, spanAttributes, "gm.prev_route", span.PrevRoute)
+	}
+	if span.Retries > 0 {
+		b = appendIntAttribute(b, spanAttributes, "http.request.resend_count", span.Retries)
+	}
+	for name, value := range span.Tags {
+		b = appendStringAttribute(b, spanAttributes, name, value)
+	}
+
+	if span.IsError() {
+		var status []byte
+		status = appendString(status, statusMessage, span.ErrorMessage())
+		status = protowire.AppendTag(status, statusCode, protowire.VarintType)
+		status = protowire.AppendVarint(status, otlpStatusCodeError)
+		b = appendMessage(b, spanStatus, status)
+	}
+
+	return b
+}
+
+func appendMessage(b []byte, num protowire.Number, message []byte) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, message)
+}
+
+func appendBytes(b []byte, num protowire.Number, value []byte) []byte {
+	return appendMessage(b, num, value)
+}
+
+func appendString(b []byte, num protowire.Number, value string) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, value)
+}
+
+// appendStringAttribute appends a KeyValue with a string AnyValue
+func appendStringAttribute(b []byte, num protowire.Number, key, value string) []byte {
+	anyValue := appendString(nil, anyValueString, value)
+	return appendKeyValue(b, num, key, anyValue)
+}
+
+// appendIntAttribute appends a KeyValue with an int AnyValue
+func appendIntAttribute(b []byte, num protowire.Number, key string, value int64) []byte {
+	anyValue := protowire.AppendTag(nil, anyValueInt, protowire.VarintType)
+	anyValue = protowire.AppendVarint(anyValue, uint64(value))
+	return appendKeyValue(b, num, key, anyValue)
+}
+
+func appendKeyValue(b []byte, num protowire.Number, key string, anyValue []byte) []byte {
+	keyValue := appendString(nil, keyValueKey, key)
+	keyValue = appendMessage(keyValue, keyValueValue, anyValue)
+	return appendMessage(b, num, keyValue)
+}
@@ -0,0 +1,195 @@
+// Copyright 2017 Decipher Technology Studios LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package spanobserver
+
+import (
+	"encoding/hex"
+	"net/http"
+	"time"
+
+	"google.golang.org/grpc/codes"
+
+	"github.com/deciphernow/gm-fabric-go/metrics/apistats"
+	"github.com/deciphernow/gm-fabric-go/metrics/subject"
+	"github.com/deciphernow/gm-fabric-go/metrics/tracecontext"
+)
+
+// SpanKind is the role of the service in a span
+type SpanKind uint8
+
+const (
+	// SpanKindServer is the span of a request received by the service
+	SpanKindServer SpanKind = iota + 1
+
+	// SpanKindClient is the span of a request made by the service
+	SpanKindClient
+)
+
+// Span is a completed HTTP or gRPC transaction
+type Span struct {
+	TraceID [16]byte
+	SpanID  [8]byte
+
+	// ParentSpanID is zero at the root of a trace
+	ParentSpanID [8]byte
+
+	Kind SpanKind
+
+	// Name is the metrics key of the transaction,
+	// e.g. route/acme/services/catalog or function/CatalogStream
+	Name string
+
+	RequestID string
+	Transport subject.EventTransport
+
+	// Method is the HTTP method, if any
+	Method string
+
+	// PrevRoute is the route that led to a gRPC method, a hint at the
+	// parent span when the caller passed no trace context
+	PrevRoute string
+
+	Start time.Time
+	End   time.Time
+
+	HTTPStatus int
+	GRPCCode   codes.Code
+	Err        error
+
+	InBytes  int64
+	OutBytes int64
+
+	// Retries is the number of times an outgoing request was retried
+	Retries int64
+
+	// Tags are the tags of the transaction's events, split into name and value
+	Tags map[string]string
+}
+
+// Duration returns the duration of the span
+func (s Span) Duration() time.Duration {
+	return s.End.Sub(s.Start)
+}
+
+// IsGRPC returns true for a gRPC transaction
+func (s Span) IsGRPC() bool {
+	return s.Transport == subject.EventTransportRPC ||
+		s.Transport == subject.EventTransportRPCWithTLS
+}
+
+// IsError returns true if the transaction failed: it returned an error,
+// a 5xx HTTP status or a gRPC code other than OK
+func (s Span) IsError() bool {
+	if s.Err != nil {
+		return true
+	}
+	if s.IsGRPC() {
+		return s.GRPCCode != codes.OK
+	}
+
+	return s.HTTPStatus >= http.StatusInternalServerError
+}
+
+// ErrorMessage returns the error of a failed span, empty if it did not fail
+func (s Span) ErrorMessage() string {
+	switch {
+	case s.Err != nil:
+		return s.Err.Error()
+	case !s.IsError():
+		return ""
+	case s.IsGRPC():
+		return s.GRPCCode.String()
+	}
+
+	return http.StatusText(s.HTTPStatus)
+}
+
+// activeSpan accumulates the events of a transaction
+type activeSpan struct {
+	entry        apistats.APIStatsEntry
+	traceID      string
+	spanID       string
+	parentSpanID string
+	tags         []string
+
+	// lastSeen is when the latest event of the transaction was observed
+	lastSeen time.Time
+}
+
+func (a *activeSpan) accumulate(event subject.MetricsEvent) {
+	if a.traceID == "" && event.TraceID != "" {
+		a.traceID = event.TraceID
+		a.spanID = event.SpanID
+		a.parentSpanID = event.ParentSpanID
+	}
+	if len(event.Tags) > len(a.tags) {
+		a.tags = event.Tags
+	}
+}
+
+// span returns the Span of the completed transaction; a transaction
+// without a valid trace context is the root of a new trace
+func (a *activeSpan) span() Span {
+	span := Span{
+		Kind:       SpanKindServer,
+		Name:       a.entry.Key,
+		RequestID:  a.entry.RequestID,
+		Transport:  a.entry.Transport,
+		Method:     a.entry.Method,
+		PrevRoute:  a.entry.PrevRoute,
+		Start:      a.entry.BeginTime,
+		End:        a.entry.EndTime,
+		HTTPStatus: a.entry.HTTPStatus,
+		GRPCCode:   a.entry.GRPCCode,
+		Err:        a.entry.Err,
+		InBytes:    a.entry.InWireLength,
+		OutBytes:   a.entry.OutWireLength,
+		Retries:    a.entry.Retries,
+	}
+	if span.Transport.IsClient() {
+		span.Kind = SpanKindClient
+	}
+	if span.Start.IsZero() {
+		span.Start = span.End
+	}
+
+	if !decodeID(a.traceID, span.TraceID[:]) || !decodeID(a.spanID, span.SpanID[:]) {
+		tp := tracecontext.GenerateTraceParent()
+		span.TraceID = tp.TraceID
+		span.SpanID = tp.SpanID
+	} else if !decodeID(a.parentSpanID, span.ParentSpanID[:]) {
+		span.ParentSpanID = [8]byte{}
+	}
+
+	if len(a.tags) > 0 {
+		span.Tags = make(map[string]string, len(a.tags))
+		for _, tag := range a.tags {
+			name, value := subject.SplitTag(tag)
+			span.Tags[name] = value
+		}
+	}
+
+	return span
+}
+
+// decodeID decodes a hex ID into id, returning false unless it has
+// exactly the length of id
+func decodeID(s string, id []byte) bool {
+	if hex.DecodedLen(len(s)) != len(id) {
+		return false
+	}
+	_, err := hex.Decode(id, []byte(s))
+	return err == nil
+}
@@ -0,0 +1,125 @@
+// Copyright 2017 Decipher Technology Studios LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package spanobserver
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// DefaultZipkinURL is the span endpoint of a local Zipkin server
+const DefaultZipkinURL = "http://127.0.0.1:9411/api/v2/spans"
+
+// NewZipkinExporter returns an exporter that posts spans in the Zipkin v2
+// JSON format to url, as the spans of serviceName
+func NewZipkinExporter(
+	url string,
+	serviceName string,
+	options ...func(*HTTPExporter),
+) *HTTPExporter {
+	encode := func(spans []Span) ([]byte, error) {
+		return encodeZipkin(serviceName, spans)
+	}
+
+	return newHTTPExporter(url, "application/json", encode, options)
+}
+
+type zipkinEndpoint struct {
+	ServiceName string `json:"serviceName"`
+}
+
+type zipkinSpan struct {
+	TraceID       string            `json:"traceId"`
+	ID            string            `json:"id"`
+	ParentID      string            `json:"parentId,omitempty"`
+	Name          string            `json:"name"`
+	Kind          string            `json:"kind,omitempty"`
+	Timestamp     int64             `json:"timestamp"`
+	Duration      int64             `json:"duration"`
+	LocalEndpoint zipkinEndpoint    `json:"localEndpoint"`
+	Tags          map[string]string `json:"tags,omitempty"`
+}
+
+func encodeZipkin(serviceName string, spans []Span) ([]byte, error) {
+	zipkinSpans := make([]zipkinSpan, len(spans))
+	for i, span := range spans {
+		zs := zipkinSpan{
+			TraceID:       zipkinTraceID(span.TraceID),
+			ID:            hex.EncodeToString(span.SpanID[:]),
+			Name:          span.Name,
+			Timestamp:     span.Start.UnixNano() / int64(time.Microsecond),
+			Duration:      int64(span.Duration() / time.Microsecond),
+			LocalEndpoint: zipkinEndpoint{ServiceName: serviceName},
+			Tags:          zipkinTags(span),
+		}
+		if span.ParentSpanID != [8]byte{} {
+			zs.ParentID = hex.EncodeToString(span.ParentSpanID[:])
+		}
+		switch span.Kind {
+		case SpanKindServer:
+			zs.Kind = "SERVER"
+		case SpanKindClient:
+			zs.Kind = "CLIENT"
+		}
+		// Zipkin ignores a zero duration
+		if zs.Duration < 1 {
+			zs.Duration = 1
+		}
+		zipkinSpans[i] = zs
+	}
+
+	return json.Marshal(zipkinSpans)
+}
+
+// zipkinTraceID returns the 16 character form of a 64 bit trace ID, padded
+// with zeroes from a B3 header, so that it matches the spans of B3 peers
+func zipkinTraceID(traceID [16]byte) string {
+	if binary.BigEndian.Uint64(traceID[:8]) == 0 {
+		return hex.EncodeToString(traceID[8:])
+	}
+	return hex.EncodeToString(traceID[:])
+}
+
+// zipkinTags returns the tags of a span, named as by Zipkin instrumentation
+func zipkinTags(span Span) map[string]string {
+	tags := make(map[string]string, len(span.Tags)+6)
+	for name, value := range span.Tags {
+		tags[name] = value
+	}
+
+	if span.IsGRPC() {
+		tags["grpc.status_code"] = span.GRPCCode.String()
+	} else {
+		tags["http.method"] = span.Method
+		tags["http.status_code"] = fmt.Sprintf("%d", span.HTTPStatus)
+	}
+	if span.IsError() {
+		tags["error"] = span.ErrorMessage()
+	}
+	if span.RequestID != "" {
+		tags["gm.request_id"] = span.RequestID
+	}
+	if span.PrevRoute != "" {
+		tags["gm.prev_route"] = span.PrevRoute
+	}
+	if span.Retries > 0 {
+		tags["gm.retries"] = fmt.Sprintf("%d", span.Retries)
+	}
+
+	return tags
+}
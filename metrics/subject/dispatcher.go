@@ -0,0 +1,301 @@
+// Copyright 2017 Decipher Technology Studios LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package subject
+
+import (
+	"sync"
+	"time"
+)
+
+// queuedEvent is an event waiting in a dispatcher's ring buffer
+type queuedEvent struct {
+	event    MetricsEvent
+	enqueued time.Time
+}
+
+// dispatcher feeds a single observer from its own ring buffer, so that
+// a slow observer only delays itself
+type dispatcher struct {
+	sync.Mutex
+	notEmpty *sync.Cond
+	notFull  *sync.Cond
+
+	id         SubscriptionID
+	filter     eventFilter
+	observer   Observer
+	policy     OverflowPolicy
+	sampleRate int
+
+	buffer []queuedEvent
+	start  int
+	count  int
+	closed bool
+
+	sampleCount uint64
+	delivered   uint64
+	dropped     uint64
+	lag         time.Duration
+
+	// enqueued and processed count events in and out of the buffer,
+	// processed includes events evicted by OverflowDropOldest
+	enqueued  uint64
+	processed uint64
+	waiters   []drainWaiter
+	stopped   chan struct{}
+}
+
+// drainWaiter is closed when the dispatcher has processed target events
+type drainWaiter struct {
+	target uint64
+	done   chan struct{}
+}
+
+// DispatchStats reports the state of a single observer's dispatcher
+type DispatchStats struct {
+	// ID identifies the observer's subscription
+	ID SubscriptionID
+
+	// Queued is the number of events waiting to be observed
+	Queued int
+
+	// Delivered is the number of events passed to the observer
+	Delivered uint64
+
+	// Dropped is the number of events discarded by the overflow policy
+	Dropped uint64
+
+	// Lag is the time the most recently delivered event spent in the buffer
+	Lag time.Duration
+}
+
+func newDispatcher(
+	id SubscriptionID,
+	filter eventFilter,
+	observer Observer,
+	size int,
+	policy OverflowPolicy,
+	sampleRate int,
+) *dispatcher {
+	d := dispatcher{
+		id:         id,
+		filter:     filter,
+		observer:   observer,
+		policy:     policy,
+		sampleRate: sampleRate,
+		buffer:     make([]queuedEvent, size),
+		stopped:    make(chan struct{}),
+	}
+	d.notEmpty = sync.NewCond(&d)
+	d.notFull = sync.NewCond(&d)
+
+	return &d
+}
+
+// enqueue adds an event to the ring buffer, applying the overflow policy
+// it returns false if the event (or an older event) was dropped
+func (d *dispatcher) enqueue(event MetricsEvent, now time.Time) bool {
+	return d.enqueueUntil(event, now, nil)
+}
+
+// enqueueUntil is enqueue, except that under OverflowBlock it stops waiting
+// for room, and drops the event, once cancel is closed; whoever closes
+// cancel must call interrupt to wake it up
+func (d *dispatcher) enqueueUntil(
+	event MetricsEvent,
+	now time.Time,
+	cancel <-chan struct{},
+) bool {
+	d.Lock()
+	defer d.Unlock()
+
+	if d.closed {
+		d.dropped++
+		return false
+	}
+
+	accepted := true
+
+	switch d.policy {
+	case OverflowBlock:
+		for d.count == len(d.buffer) && !d.closed {
+			select {
+			case <-cancel:
+				d.dropped++
+				return false
+			default:
+			}
+			d.notFull.Wait()
+		}
+		if d.closed {
+			d.dropped++
+			return false
+		}
+	case OverflowDropNewest:
+		if d.count == len(d.buffer) {
+			d.dropped++
+			return false
+		}
+	case OverflowDropOldest:
+		if d.count == len(d.buffer) {
+			d.start = (d.start + 1) % len(d.buffer)
+			d.count--
+			d.dropped++
+			d.markProcessed()
+			accepted = false
+		}
+	case OverflowSample:
+		// once the buffer is half full, keep only one in sampleRate events
+		if d.count >= len(d.buffer)/2 {
+			d.sampleCount++
+			if d.count == len(d.buffer) || d.sampleCount%uint64(d.sampleRate) != 0 {
+				d.dropped++
+				return false
+			}
+		}
+	}
+
+	end := (d.start + d.count) % len(d.buffer)
+	d.buffer[end] = queuedEvent{event: event, enqueued: now}
+	d.count++
+	d.enqueued++
+	d.notEmpty.Signal()
+
+	return accepted
+}
+
+// dequeue removes the oldest event from the ring buffer, waiting if necessary
+// it returns false when the dispatcher is closed and the buffer is empty,
+// so that the events accepted before close are still delivered
+func (d *dispatcher) dequeue() (queuedEvent, bool) {
+	d.Lock()
+	defer d.Unlock()
+
+	for d.count == 0 && !d.closed {
+		d.notEmpty.Wait()
+	}
+	if d.count == 0 {
+		return queuedEvent{}, false
+	}
+
+	qe := d.buffer[d.start]
+	d.buffer[d.start] = queuedEvent{}
+	d.start = (d.start + 1) % len(d.buffer)
+	d.count--
+	d.notFull.Signal()
+
+	return qe, true
+}
+
+// run delivers events to the observer until the dispatcher is closed
+// and its buffer is empty
+func (d *dispatcher) run() {
+	defer close(d.stopped)
+
+	for {
+		qe, ok := d.dequeue()
+		if !ok {
+			return
+		}
+
+		d.observer.Observe(qe.event)
+
+		d.Lock()
+		d.delivered++
+		d.lag = time.Since(qe.enqueued)
+		d.markProcessed()
+		d.Unlock()
+	}
+}
+
+// close stops the dispatcher from accepting events and wakes up anyone
+// waiting on it; run returns once the queued events are delivered
+func (d *dispatcher) close() {
+	d.Lock()
+	defer d.Unlock()
+
+	d.closed = true
+	d.notEmpty.Broadcast()
+	d.notFull.Broadcast()
+}
+
+// interrupt wakes up a sender waiting in enqueueUntil, to check its cancel
+func (d *dispatcher) interrupt() {
+	d.Lock()
+	defer d.Unlock()
+
+	d.notFull.Broadcast()
+}
+
+// abort closes the dispatcher and discards the queued events,
+// counting them as dropped
+func (d *dispatcher) abort() {
+	d.Lock()
+	defer d.Unlock()
+
+	for ; d.count > 0; d.count-- {
+		d.buffer[d.start] = queuedEvent{}
+		d.start = (d.start + 1) % len(d.buffer)
+		d.dropped++
+		d.markProcessed()
+	}
+
+	d.closed = true
+	d.notEmpty.Broadcast()
+	d.notFull.Broadcast()
+}
+
+// waitProcessed returns a channel that is closed when every event
+// enqueued so far has been processed
+func (d *dispatcher) waitProcessed() <-chan struct{} {
+	d.Lock()
+	defer d.Unlock()
+
+	w := drainWaiter{target: d.enqueued, done: make(chan struct{})}
+	if d.processed >= w.target {
+		close(w.done)
+	} else {
+		d.waiters = append(d.waiters, w)
+	}
+
+	return w.done
+}
+
+// markProcessed must be called with the lock held
+func (d *dispatcher) markProcessed() {
+	d.processed++
+
+	waiters := d.waiters[:0]
+	for _, w := range d.waiters {
+		if d.processed >= w.target {
+			close(w.done)
+		} else {
+			waiters = append(waiters, w)
+		}
+	}
+	d.waiters = waiters
+}
+
+func (d *dispatcher) stats() DispatchStats {
+	d.Lock()
+	defer d.Unlock()
+
+	return DispatchStats{
+		ID:        d.id,
+		Queued:    d.count,
+		Delivered: d.delivered,
+		Dropped:   d.dropped,
+		Lag:       d.lag,
+	}
+}
@@ -0,0 +1,64 @@
+// Copyright 2017 Decipher Technology Studios LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package subject
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestDispatcherClose(t *testing.T) {
+	testCases := []struct {
+		abort     bool
+		delivered uint64
+		dropped   uint64
+	}{
+		// the events accepted before close are delivered
+		{abort: false, delivered: 4, dropped: 1},
+		// abort discards them, counting them as dropped
+		{abort: true, delivered: 1, dropped: 4},
+	}
+
+	for i, tc := range testCases {
+		observer := &gateObserver{gate: make(chan struct{})}
+		d := newDispatcher(0, newEventFilter(nil), observer, 8, OverflowBlock, DefaultSampleRate)
+		go d.run()
+
+		for j := 0; j < 4; j++ {
+			d.enqueue(MetricsEvent{Key: fmt.Sprintf("%03d", j)}, time.Now())
+		}
+		// the first event is being observed, the others are queued
+		waitFor(t, func() bool { return d.stats().Queued == 3 })
+
+		if tc.abort {
+			d.abort()
+		} else {
+			d.close()
+		}
+		if d.enqueue(MetricsEvent{Key: "late"}, time.Now()) {
+			t.Fatalf("#%d: expected an event enqueued after close to be dropped", i)
+		}
+
+		close(observer.gate)
+		<-d.stopped
+
+		stats := d.stats()
+		if stats.Delivered != tc.delivered || stats.Dropped != tc.dropped || stats.Queued != 0 {
+			t.Fatalf("#%d: expected %d delivered, %d dropped; found %+v",
+				i, tc.delivered, tc.dropped, stats)
+		}
+	}
+}
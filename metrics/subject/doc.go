@@ -13,5 +13,34 @@
 // limitations under the License.
 
 /*Package subject defines the subject as a target for the observer pattern
- */
+
+Each observer is fed from its own ring buffer by its own goroutine, so a slow
+observer does not stall the sources or the other observers.
+
+usage:
+    s := subject.NewSubject(
+        ctx,
+        []subject.Observer{grpcObserver, sinkObserver},
+        subject.BufferSizeOption(4096),
+        subject.OverflowPolicyOption(subject.OverflowDropOldest),
+    )
+    metricsChan := s.Events()
+
+    // observers can be added and removed while the subject is running;
+    // event type patterns select the events an observer receives
+    id, err := s.Subscribe(auditObserver, "rpc.End", "go-metrics.*")
+    ...
+    err = s.Unsubscribe(ctx, id)
+
+    // report queued and dropped events through the metrics server
+    metricsserver.Start(metricsAddress, nil, s.Report)
+
+    // on SIGTERM, deliver the queued events, then flush and close
+    // the observers that implement Flusher and Closer
+    ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+    defer cancel()
+    if err := s.Close(ctx); err != nil {
+        log.Printf("metrics drain incomplete: %v", err)
+    }
+*/
 package subject
@@ -0,0 +1,424 @@
+// Copyright 2017 Decipher Technology Studios LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package subject
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// OverflowPolicy determines what an observer's dispatcher does with
+// an incoming event when its ring buffer is full
+type OverflowPolicy uint8
+
+const (
+	// OverflowBlock makes the sender wait until the observer catches up
+	OverflowBlock OverflowPolicy = iota
+
+	// OverflowDropNewest discards the incoming event
+	OverflowDropNewest
+
+	// OverflowDropOldest discards the oldest queued event
+	OverflowDropOldest
+
+	// OverflowSample keeps one in every SampleRate events once the
+	// buffer is half full, and discards the incoming event when it is full
+	OverflowSample
+)
+
+// DefaultBufferSize is the number of events that may be queued for
+// each observer before the overflow policy applies
+const DefaultBufferSize = 1024
+
+// DefaultSampleRate is the sampling rate for OverflowSample
+const DefaultSampleRate = 10
+
+// ErrDrainIncomplete is returned by Flush and Close when the context
+// expires before every queued event has been delivered
+var ErrDrainIncomplete = errors.New("metrics subject drain incomplete")
+
+// Flusher is implemented by observers that hold data which can be pushed
+// out on demand. Flush is called by Subject.Flush and Subject.Close.
+type Flusher interface {
+	Flush() error
+}
+
+// Closer is implemented by observers that hold resources, such as
+// background reporters. Close is called by Subject.Close.
+type Closer interface {
+	Close() error
+}
+
+// Subject receives MetricsEvents from sources and feeds them to observers.
+// Each observer has its own ring buffer and dispatch goroutine, so a slow
+// observer does not delay the others.
+//
+// Note that the stateful observers (grpcobserver, sinkobserver) accumulate
+// events by RequestID until "rpc.End": with a dropping policy, an observer
+// may lose part of a transaction.
+type Subject struct {
+	sync.RWMutex
+	events  chan MetricsEvent
+	control chan drainRequest
+	done    chan struct{}
+
+	bufferSize int
+	policy     OverflowPolicy
+	sampleRate int
+
+	received  uint64
+	discarded uint64
+
+	// the remaining fields are protected by the mutex
+	closed      bool
+	closeCalled bool
+	stopped     bool
+	nextID      SubscriptionID
+	dispatchers []*dispatcher
+}
+
+// SubjectStats reports the state of the subject
+type SubjectStats struct {
+	// Received is the number of events read from the event channel
+	Received uint64
+
+	// Discarded is the number of events received after Close
+	Discarded uint64
+
+	// Observers holds the state of each subscribed observer's dispatcher,
+	// in the order the observers were subscribed
+	Observers []DispatchStats
+}
+
+// drainRequest asks the intake goroutine for the current dispatchers
+// and a channel per dispatcher that is closed when the events received
+// so far have been processed. Once cancel is closed, the intake stops
+// waiting for room for those events and drops them instead.
+type drainRequest struct {
+	close  bool
+	cancel <-chan struct{}
+	reply  chan []drainTarget
+}
+
+type drainTarget struct {
+	d      *dispatcher
+	waiter <-chan struct{}
+}
+
+// BufferSizeOption returns a Subject option function that sets the size of
+// the event channel and of each observer's ring buffer
+func BufferSizeOption(size int) func(*Subject) {
+	return func(s *Subject) {
+		if size > 0 {
+			s.bufferSize = size
+		}
+	}
+}
+
+// OverflowPolicyOption returns a Subject option function that sets the
+// policy applied when an observer's ring buffer is full
+func OverflowPolicyOption(policy OverflowPolicy) func(*Subject) {
+	return func(s *Subject) {
+		s.policy = policy
+	}
+}
+
+// SampleRateOption returns a Subject option function that sets the rate
+// for OverflowSample: one in every rate events is kept
+func SampleRateOption(rate int) func(*Subject) {
+	return func(s *Subject) {
+		if rate > 0 {
+			s.sampleRate = rate
+		}
+	}
+}
+
+// NewSubject creates a new metrics subject for feeding events to observers
+// The observers are subscribed in order, as by Subscribe with no event types.
+// The subject runs until ctx is done, abandoning any queued events.
+// Use Close for an orderly shutdown.
+func NewSubject(
+	ctx context.Context,
+	observers []Observer,
+	options ...func(*Subject),
+) *Subject {
+	s := Subject{
+		control:    make(chan drainRequest),
+		done:       make(chan struct{}),
+		bufferSize: DefaultBufferSize,
+		policy:     OverflowBlock,
+		sampleRate: DefaultSampleRate,
+	}
+
+	for _, f := range options {
+		f(&s)
+	}
+
+	s.events = make(chan MetricsEvent, s.bufferSize)
+	for _, observer := range observers {
+		s.subscribe(observer, nil)
+	}
+
+	// the dispatchers must be closed from outside the intake loop,
+	// which may be waiting on a full buffer under OverflowBlock
+	go func() {
+		<-ctx.Done()
+		s.Lock()
+		defer s.Unlock()
+		s.stopped = true
+		for _, d := range s.dispatchers {
+			d.abort()
+		}
+	}()
+
+	go s.intake(ctx)
+
+	return &s
+}
+
+// Events returns the channel that sources send MetricsEvents to
+func (s *Subject) Events() chan<- MetricsEvent {
+	return s.events
+}
+
+// Stats returns the current state of the subject
+func (s *Subject) Stats() SubjectStats {
+	s.RLock()
+	dispatchers := s.dispatchers
+	s.RUnlock()
+
+	stats := SubjectStats{
+		Received:  atomic.LoadUint64(&s.received),
+		Discarded: atomic.LoadUint64(&s.discarded),
+		Observers: make([]DispatchStats, len(dispatchers)),
+	}
+	for i, d := range dispatchers {
+		stats.Observers[i] = d.stats()
+	}
+
+	return stats
+}
+
+// Flush waits until every event sent before the call has been delivered,
+// then calls Flush on each observer that implements Flusher.
+// It returns ErrDrainIncomplete if ctx expires first; under OverflowBlock,
+// the events sent before the call that were still waiting for room in the
+// buffer of a stalled observer are then dropped.
+func (s *Subject) Flush(ctx context.Context) error {
+	targets, err := s.drain(ctx, false)
+	if err != nil {
+		return err
+	}
+
+	for _, target := range targets {
+		if flusher, ok := target.d.observer.(Flusher); ok {
+			if err := flusher.Flush(); err != nil {
+				return errors.Wrapf(err, "observer #%d Flush", target.d.id)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Close stops accepting events, waits until the queued events have been
+// delivered, then calls Flush and Close on each observer that implements
+// Flusher and Closer. Events sent after Close are discarded.
+//
+// If ctx expires first, the remaining events are abandoned and Close
+// returns ErrDrainIncomplete; observers that are still busy in Observe
+// are not flushed or closed.
+func (s *Subject) Close(ctx context.Context) error {
+	s.Lock()
+	if s.closeCalled {
+		s.Unlock()
+		return nil
+	}
+	s.closeCalled = true
+	s.Unlock()
+
+	targets, drainErr := s.drain(ctx, true)
+
+	dispatchers := make([]*dispatcher, len(targets))
+	for i, target := range targets {
+		dispatchers[i] = target.d
+	}
+	if targets == nil && drainErr != nil {
+		// the intake goroutine did not take the request, it may be blocked
+		// on a full buffer under OverflowBlock: stop the dispatchers here
+		s.Lock()
+		s.closed = true
+		dispatchers = s.dispatchers
+		for _, d := range dispatchers {
+			d.close()
+		}
+		s.Unlock()
+	}
+
+	var err error
+	for _, d := range dispatchers {
+		if retireErr := retire(ctx, d); retireErr != nil && err == nil {
+			err = retireErr
+		}
+	}
+
+	if drainErr != nil {
+		return drainErr
+	}
+
+	return err
+}
+
+// drain waits until the events received so far have been processed by
+// every dispatcher
+func (s *Subject) drain(ctx context.Context, close bool) ([]drainTarget, error) {
+	req := drainRequest{
+		close:  close,
+		cancel: ctx.Done(),
+		reply:  make(chan []drainTarget, 1),
+	}
+	select {
+	case s.control <- req:
+	case <-s.done:
+		return nil, errors.Wrap(ErrDrainIncomplete, "subject stopped")
+	case <-ctx.Done():
+		return nil, errors.Wrap(ErrDrainIncomplete, ctx.Err().Error())
+	}
+
+	// the intake may be waiting for room for the events sent before the
+	// request, for as long as an observer is stalled under OverflowBlock
+	var targets []drainTarget
+	select {
+	case targets = <-req.reply:
+	case <-s.done:
+		return nil, errors.Wrap(ErrDrainIncomplete, "subject stopped")
+	case <-ctx.Done():
+		s.RLock()
+		for _, d := range s.dispatchers {
+			d.interrupt()
+		}
+		s.RUnlock()
+		return nil, errors.Wrap(ErrDrainIncomplete, ctx.Err().Error())
+	}
+
+	for _, target := range targets {
+		if err := waitDrained(ctx, s.done, target); err != nil {
+			return targets, err
+		}
+	}
+
+	return targets, nil
+}
+
+func waitDrained(ctx context.Context, done <-chan struct{}, target drainTarget) error {
+	select {
+	case <-target.waiter:
+		return nil
+	case <-done:
+		return errors.Wrapf(ErrDrainIncomplete, "observer #%d: subject stopped", target.d.id)
+	case <-ctx.Done():
+		return errors.Wrapf(ErrDrainIncomplete, "observer #%d: %s", target.d.id, ctx.Err())
+	}
+}
+
+// retire stops a dispatcher, then flushes and closes its observer
+// unless the observer is still busy when ctx expires; the events still
+// queued then are discarded
+func retire(ctx context.Context, d *dispatcher) error {
+	d.close()
+
+	select {
+	case <-d.stopped:
+	default:
+		select {
+		case <-d.stopped:
+		case <-ctx.Done():
+			d.abort()
+			return nil
+		}
+	}
+
+	if flusher, ok := d.observer.(Flusher); ok {
+		if err := flusher.Flush(); err != nil {
+			return errors.Wrapf(err, "observer #%d Flush", d.id)
+		}
+	}
+	if closer, ok := d.observer.(Closer); ok {
+		if err := closer.Close(); err != nil {
+			return errors.Wrapf(err, "observer #%d Close", d.id)
+		}
+	}
+
+	return nil
+}
+
+func (s *Subject) intake(ctx context.Context) {
+	defer close(s.done)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event := <-s.events:
+			s.dispatch(event, nil)
+		case req := <-s.control:
+			// pick up the events sent before the request, without waiting
+			// for room beyond the requester's deadline
+			for n := len(s.events); n > 0; n-- {
+				s.dispatch(<-s.events, req.cancel)
+			}
+			s.Lock()
+			targets := make([]drainTarget, len(s.dispatchers))
+			for i, d := range s.dispatchers {
+				targets[i] = drainTarget{d: d, waiter: d.waitProcessed()}
+			}
+			if req.close {
+				s.closed = true
+			}
+			s.Unlock()
+			req.reply <- targets
+		}
+	}
+}
+
+// dispatch enqueues event for the observers that accept it; under
+// OverflowBlock it waits for room until cancel, if any, is closed
+func (s *Subject) dispatch(event MetricsEvent, cancel <-chan struct{}) {
+	atomic.AddUint64(&s.received, 1)
+
+	// Subscribe and Unsubscribe replace the slice rather than modify it,
+	// so we don't hold the lock while a dispatcher may block
+	s.RLock()
+	closed := s.closed
+	dispatchers := s.dispatchers
+	s.RUnlock()
+
+	if closed {
+		atomic.AddUint64(&s.discarded, 1)
+		return
+	}
+
+	now := time.Now()
+
+	for _, d := range dispatchers {
+		if d.filter.match(event.EventType) {
+			d.enqueueUntil(event, now, cancel)
+		}
+	}
+}
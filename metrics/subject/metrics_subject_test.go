@@ -0,0 +1,403 @@
+// Copyright 2017 Decipher Technology Studios LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package subject
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/deciphernow/gm-fabric-go/metrics/flatjson"
+)
+
+// countObserver counts the events it observes
+type countObserver struct {
+	sync.Mutex
+	keys []string
+}
+
+func (o *countObserver) Observe(event MetricsEvent) {
+	o.Lock()
+	defer o.Unlock()
+	o.keys = append(o.keys, event.Key)
+}
+
+func (o *countObserver) observed() []string {
+	o.Lock()
+	defer o.Unlock()
+	return append([]string(nil), o.keys...)
+}
+
+// gateObserver blocks in Observe until its gate is closed
+type gateObserver struct {
+	countObserver
+	gate chan struct{}
+}
+
+func (o *gateObserver) Observe(event MetricsEvent) {
+	<-o.gate
+	o.countObserver.Observe(event)
+}
+
+func waitFor(t *testing.T, f func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !f() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestSubjectDelivery(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var observers []*countObserver
+	for i := 0; i < 3; i++ {
+		observers = append(observers, &countObserver{})
+	}
+
+	s := NewSubject(ctx, []Observer{observers[0], observers[1], observers[2]})
+	for i := 0; i < 100; i++ {
+		s.Events() <- MetricsEvent{Key: fmt.Sprintf("%03d", i)}
+	}
+
+	for n, observer := range observers {
+		waitFor(t, func() bool { return len(observer.observed()) == 100 })
+		for i, key := range observer.observed() {
+			if key != fmt.Sprintf("%03d", i) {
+				t.Fatalf("observer %d: event %d out of order: %s", n, i, key)
+			}
+		}
+	}
+}
+
+func TestSubjectIsolation(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	slow := &gateObserver{gate: make(chan struct{})}
+	fast := &countObserver{}
+
+	s := NewSubject(
+		ctx,
+		[]Observer{slow, fast},
+		BufferSizeOption(4),
+		OverflowPolicyOption(OverflowDropNewest),
+	)
+	// the fast observer must see every event while the slow one is stuck
+	for i := 0; i < 20; i++ {
+		s.Events() <- MetricsEvent{Key: fmt.Sprintf("%03d", i)}
+		waitFor(t, func() bool { return len(fast.observed()) == i+1 })
+	}
+
+	waitFor(t, func() bool {
+		stats := s.Stats()
+		return stats.Received == 20 && stats.Observers[0].Dropped > 0
+	})
+
+	close(slow.gate)
+}
+
+func TestOverflowPolicies(t *testing.T) {
+	const size = 4
+	const sent = 8
+
+	testCases := []struct {
+		name            string
+		policy          OverflowPolicy
+		expectedQueued  int
+		expectedDropped uint64
+		expectedFirst   string
+	}{
+		{
+			name:            "drop newest",
+			policy:          OverflowDropNewest,
+			expectedQueued:  size,
+			expectedDropped: sent - size,
+			expectedFirst:   "000",
+		},
+		{
+			name:            "drop oldest",
+			policy:          OverflowDropOldest,
+			expectedQueued:  size,
+			expectedDropped: sent - size,
+			expectedFirst:   "004",
+		},
+		{
+			name:            "sample",
+			policy:          OverflowSample,
+			expectedQueued:  3,
+			expectedDropped: sent - 3,
+			expectedFirst:   "000",
+		},
+	}
+
+	for i, tc := range testCases {
+		t.Run(fmt.Sprintf("%d: %s", i, tc.name), func(t *testing.T) {
+			d := newDispatcher(0, newEventFilter(nil), &countObserver{}, size, tc.policy, 5)
+			for j := 0; j < sent; j++ {
+				d.enqueue(MetricsEvent{Key: fmt.Sprintf("%03d", j)}, time.Now())
+			}
+			stats := d.stats()
+			if stats.Queued != tc.expectedQueued {
+				t.Fatalf("queued: expected %d found %d", tc.expectedQueued, stats.Queued)
+			}
+			if stats.Dropped != tc.expectedDropped {
+				t.Fatalf("dropped: expected %d found %d", tc.expectedDropped, stats.Dropped)
+			}
+			qe, _ := d.dequeue()
+			if qe.event.Key != tc.expectedFirst {
+				t.Fatalf("first: expected %s found %s", tc.expectedFirst, qe.event.Key)
+			}
+		})
+	}
+}
+
+func TestOverflowBlock(t *testing.T) {
+	d := newDispatcher(0, newEventFilter(nil), &countObserver{}, 1, OverflowBlock, 1)
+	d.enqueue(MetricsEvent{Key: "000"}, time.Now())
+
+	done := make(chan bool)
+	go func() {
+		done <- d.enqueue(MetricsEvent{Key: "001"}, time.Now())
+	}()
+
+	select {
+	case <-done:
+		t.Fatal("enqueue did not block on a full buffer")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	d.dequeue()
+	if accepted := <-done; !accepted {
+		t.Fatal("blocked event was not accepted")
+	}
+	if stats := d.stats(); stats.Dropped != 0 {
+		t.Fatalf("dropped: expected 0 found %d", stats.Dropped)
+	}
+}
+
+func TestSubjectReport(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	observer := &countObserver{}
+	s := NewSubject(ctx, []Observer{observer})
+	s.Events() <- MetricsEvent{Key: "aaa"}
+	waitFor(t, func() bool { return len(observer.observed()) == 1 })
+
+	var buffer bytes.Buffer
+	w, err := flatjson.New(&buffer)
+	if err != nil {
+		t.Fatalf("New failed: %s", err)
+	}
+	if err = s.Report(w); err != nil {
+		t.Fatalf("s.Report failed: %s", err)
+	}
+	if err = w.Flush(); err != nil {
+		t.Fatalf("w.Flush() failed: %s", err)
+	}
+
+	var ts map[string]interface{}
+	data := buffer.Bytes()
+	if err = json.Unmarshal(data, &ts); err != nil {
+		t.Fatalf("json.Unmarshal failed: %s; %s", err, string(data))
+	}
+
+	for key, expected := range map[string]float64{
+		"subject/received":             1,
+		"subject/dropped":              0,
+		"subject/observer/0/delivered": 1,
+	} {
+		if ts[key] != expected {
+			t.Fatalf("%s: expected %v found %v", key, expected, ts[key])
+		}
+	}
+}
+
+// lifecycleObserver records calls to Flush and Close
+type lifecycleObserver struct {
+	countObserver
+	flushed int
+	closed  int
+}
+
+func (o *lifecycleObserver) Flush() error {
+	o.Lock()
+	defer o.Unlock()
+	o.flushed++
+	return nil
+}
+
+func (o *lifecycleObserver) Close() error {
+	o.Lock()
+	defer o.Unlock()
+	o.closed++
+	return nil
+}
+
+func TestSubjectFlush(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	observer := &lifecycleObserver{}
+	s := NewSubject(ctx, []Observer{observer})
+	for i := 0; i < 100; i++ {
+		s.Events() <- MetricsEvent{Key: fmt.Sprintf("%03d", i)}
+	}
+
+	if err := s.Flush(ctx); err != nil {
+		t.Fatalf("s.Flush failed: %s", err)
+	}
+	if n := len(observer.observed()); n != 100 {
+		t.Fatalf("observed: expected 100 found %d", n)
+	}
+	if observer.flushed != 1 || observer.closed != 0 {
+		t.Fatalf("expected 1 flush, 0 close; found %d, %d",
+			observer.flushed, observer.closed)
+	}
+}
+
+func TestSubjectClose(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	observer := &lifecycleObserver{}
+	s := NewSubject(ctx, []Observer{observer})
+	for i := 0; i < 100; i++ {
+		s.Events() <- MetricsEvent{Key: fmt.Sprintf("%03d", i)}
+	}
+
+	if err := s.Close(ctx); err != nil {
+		t.Fatalf("s.Close failed: %s", err)
+	}
+	if n := len(observer.observed()); n != 100 {
+		t.Fatalf("observed: expected 100 found %d", n)
+	}
+	if observer.flushed != 1 || observer.closed != 1 {
+		t.Fatalf("expected 1 flush, 1 close; found %d, %d",
+			observer.flushed, observer.closed)
+	}
+
+	// events sent after Close are discarded, not delivered
+	s.Events() <- MetricsEvent{Key: "late"}
+	waitFor(t, func() bool { return s.Stats().Discarded == 1 })
+	if n := len(observer.observed()); n != 100 {
+		t.Fatalf("observed after Close: expected 100 found %d", n)
+	}
+
+	if err := s.Close(ctx); err != nil {
+		t.Fatalf("second s.Close failed: %s", err)
+	}
+	if observer.closed != 1 {
+		t.Fatalf("expected 1 close, found %d", observer.closed)
+	}
+}
+
+func TestSubjectCloseDeadline(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	slow := &gateObserver{gate: make(chan struct{})}
+	defer close(slow.gate)
+
+	s := NewSubject(ctx, []Observer{slow})
+	for i := 0; i < 10; i++ {
+		s.Events() <- MetricsEvent{Key: fmt.Sprintf("%03d", i)}
+	}
+
+	closeCtx, closeCancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer closeCancel()
+
+	err := s.Close(closeCtx)
+	if errors.Cause(err) != ErrDrainIncomplete {
+		t.Fatalf("expected ErrDrainIncomplete, found %v", err)
+	}
+}
+
+func TestSubjectCloseBlocked(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	slow := &gateObserver{gate: make(chan struct{})}
+	defer close(slow.gate)
+
+	s := NewSubject(ctx, []Observer{slow}, BufferSizeOption(1))
+	go func() {
+		for i := 0; i < 4; i++ {
+			s.Events() <- MetricsEvent{Key: fmt.Sprintf("%03d", i)}
+		}
+	}()
+	// the intake is blocked on the full buffer of the slow observer
+	waitFor(t, func() bool { return s.Stats().Received == 3 })
+
+	closeCtx, closeCancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer closeCancel()
+
+	err := s.Close(closeCtx)
+	if errors.Cause(err) != ErrDrainIncomplete {
+		t.Fatalf("expected ErrDrainIncomplete, found %v", err)
+	}
+
+	// the subject is closed even though the intake did not take the request
+	s.Events() <- MetricsEvent{Key: "late"}
+	waitFor(t, func() bool { return s.Stats().Discarded == 2 })
+	if _, err = s.Subscribe(&countObserver{}); err != ErrSubjectClosed {
+		t.Fatalf("expected ErrSubjectClosed, found %v", err)
+	}
+}
+
+func TestSubjectCloseDeadlineBlocked(t *testing.T) {
+	// Close may find the intake blocked on a full buffer, or waiting on one
+	// while it picks up the events sent before the request: either way it
+	// returns when its context expires
+	for i := 0; i < 20; i++ {
+		ctx, cancel := context.WithCancel(context.Background())
+
+		slow := &gateObserver{gate: make(chan struct{})}
+		s := NewSubject(ctx, []Observer{slow}, BufferSizeOption(1))
+		s.Events() <- MetricsEvent{Key: "000"}
+		s.Events() <- MetricsEvent{Key: "001"}
+		go func() {
+			for j := 2; j < 5; j++ {
+				s.Events() <- MetricsEvent{Key: fmt.Sprintf("%03d", j)}
+			}
+		}()
+
+		const timeout = 200 * time.Millisecond
+		closeCtx, closeCancel := context.WithTimeout(ctx, timeout)
+		start := time.Now()
+		err := s.Close(closeCtx)
+		elapsed := time.Since(start)
+		closeCancel()
+		close(slow.gate)
+		cancel()
+
+		if errors.Cause(err) != ErrDrainIncomplete {
+			t.Fatalf("#%d: expected ErrDrainIncomplete, found %v", i, err)
+		}
+		if elapsed > timeout+time.Second {
+			t.Fatalf("#%d: Close returned after %s", i, elapsed)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+// Copyright 2017 Decipher Technology Studios LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package subject
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/deciphernow/gm-fabric-go/metrics/flatjson"
+)
+
+// Report implements the Reporter interface it is called by the metrics server
+func (s *Subject) Report(jWriter *flatjson.Writer) error {
+	var queued int
+
+	stats := s.Stats()
+	dropped := stats.Discarded
+
+	for _, ds := range stats.Observers {
+		queued += ds.Queued
+		dropped += ds.Dropped
+
+		for _, x := range []struct {
+			label string
+			value interface{}
+		}{
+			{"queued", ds.Queued},
+			{"delivered", ds.Delivered},
+			{"dropped", ds.Dropped},
+			{"lag_ms", duration2ms(ds.Lag)},
+		} {
+			key := fmt.Sprintf("subject/observer/%d/%s", ds.ID, x.label)
+			if err := jWriter.Write(key, x.value); err != nil {
+				return errors.Wrapf(err, "jWriter.Write %s", key)
+			}
+		}
+	}
+
+	for _, x := range []struct {
+		key   string
+		value interface{}
+	}{
+		{"subject/received", stats.Received},
+		{"subject/queued", queued},
+		{"subject/dropped", dropped},
+	} {
+		if err := jWriter.Write(x.key, x.value); err != nil {
+			return errors.Wrapf(err, "jWriter.Write %s", x.key)
+		}
+	}
+
+	return nil
+}
+
+func duration2ms(d time.Duration) int64 {
+	const nsPerMs = 1000000
+
+	return d.Nanoseconds() / nsPerMs
+}
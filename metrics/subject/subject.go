@@ -38,21 +38,40 @@ const (
 
 	// EventTransportHTTPS HTTPS
 	EventTransportHTTPS
+
+	// EventTransportHTTPClient an outgoing HTTP request
+	EventTransportHTTPClient
+
+	// EventTransportHTTPSClient an outgoing HTTPS request
+	EventTransportHTTPSClient
 )
 
+// IsClient returns true for the transport of an outgoing request, as
+// opposed to a request received by the service
+func (t EventTransport) IsClient() bool {
+	return t == EventTransportHTTPClient || t == EventTransportHTTPSClient
+}
+
 const TagSep = ":"
 
 // MetricsEvent is a low level event.
+// TraceID, SpanID and ParentSpanID are the hex encoded trace context of
+// the request, see package tracecontext; ParentSpanID is empty at the root
+// of a trace.
 type MetricsEvent struct {
-	EventType  string
-	Transport  EventTransport
-	HTTPStatus int
-	RequestID  string
-	Key        string
-	PrevRoute  string
-	Timestamp  time.Time
-	Value      interface{}
-	Tags       []string
+	EventType    string
+	Transport    EventTransport
+	HTTPStatus   int
+	RequestID    string
+	TraceID      string
+	SpanID       string
+	ParentSpanID string
+	Key          string
+	Method       string
+	PrevRoute    string
+	Timestamp    time.Time
+	Value        interface{}
+	Tags         []string
 }
 
 // Observer implements an individual observer of the observer design pattern.
@@ -62,26 +81,13 @@ type Observer interface {
 }
 
 // New creates a new metrics subject for feeding events to observers
+// It returns the channel that sources send MetricsEvents to.
+// See NewSubject for control over buffering and overflow.
 func New(
 	ctx context.Context,
 	observers ...Observer,
 ) chan<- MetricsEvent {
-	metricsChan := make(chan MetricsEvent)
-
-	go func() {
-		for loop := true; loop; {
-			select {
-			case <-ctx.Done():
-				loop = false
-			case event := <-metricsChan:
-				for _, observer := range observers {
-					observer.Observe(event)
-				}
-			}
-		}
-	}()
-
-	return metricsChan
+	return NewSubject(ctx, observers).Events()
 }
 
 // SplitTag takes a tag of the form <name:value> and returns (name, value)
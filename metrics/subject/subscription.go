@@ -0,0 +1,173 @@
+// Copyright 2017 Decipher Technology Studios LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package subject
+
+import (
+	"context"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// SubscriptionID identifies an observer subscribed to a Subject
+type SubscriptionID uint64
+
+// ErrSubjectClosed is returned by Subscribe after the subject is closed
+// or its context is done
+var ErrSubjectClosed = errors.New("metrics subject closed")
+
+// FilteredObserver is an Observer that is only interested in some event types.
+// When it is subscribed without explicit event types, the subject uses
+// EventTypes to filter the events it delivers.
+type FilteredObserver interface {
+	Observer
+
+	// EventTypes returns event type patterns as accepted by Subscribe
+	EventTypes() []string
+}
+
+// eventFilter matches event types against a list of patterns
+type eventFilter struct {
+	all      bool
+	exact    map[string]struct{}
+	prefixes []string
+}
+
+// newEventFilter creates a filter from patterns of the form
+//     "rpc.End"  matches the event type exactly
+//     "rpc.*"    matches event types starting with "rpc."
+//     "*"        matches all event types
+// An empty list of patterns matches all event types.
+func newEventFilter(patterns []string) eventFilter {
+	if len(patterns) == 0 {
+		return eventFilter{all: true}
+	}
+
+	f := eventFilter{exact: make(map[string]struct{})}
+	for _, pattern := range patterns {
+		switch {
+		case pattern == "*":
+			f.all = true
+		case strings.HasSuffix(pattern, "*"):
+			f.prefixes = append(f.prefixes, strings.TrimSuffix(pattern, "*"))
+		default:
+			f.exact[pattern] = struct{}{}
+		}
+	}
+
+	return f
+}
+
+func (f eventFilter) match(eventType string) bool {
+	if f.all {
+		return true
+	}
+	if _, ok := f.exact[eventType]; ok {
+		return true
+	}
+	for _, prefix := range f.prefixes {
+		if strings.HasPrefix(eventType, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Subscribe adds an observer to a running subject.
+// The observer receives only the events whose type matches one of eventTypes:
+//     "rpc.End"  matches the event type exactly
+//     "rpc.*"    matches event types starting with "rpc."
+//     "*"        matches all event types
+// If no event types are given, the observer's own EventTypes are used if it
+// is a FilteredObserver, otherwise it receives all events.
+func (s *Subject) Subscribe(
+	observer Observer,
+	eventTypes ...string,
+) (SubscriptionID, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.closeCalled || s.stopped {
+		return 0, ErrSubjectClosed
+	}
+
+	return s.subscribe(observer, eventTypes), nil
+}
+
+// subscribe must be called with the lock held, or before the subject starts
+func (s *Subject) subscribe(observer Observer, eventTypes []string) SubscriptionID {
+	if len(eventTypes) == 0 {
+		if filtered, ok := observer.(FilteredObserver); ok {
+			eventTypes = filtered.EventTypes()
+		}
+	}
+
+	id := s.nextID
+	s.nextID++
+
+	d := newDispatcher(
+		id,
+		newEventFilter(eventTypes),
+		observer,
+		s.bufferSize,
+		s.policy,
+		s.sampleRate,
+	)
+
+	// copy on write: dispatch reads the slice without holding the lock
+	dispatchers := make([]*dispatcher, len(s.dispatchers), len(s.dispatchers)+1)
+	copy(dispatchers, s.dispatchers)
+	s.dispatchers = append(dispatchers, d)
+
+	go d.run()
+
+	return id
+}
+
+// Unsubscribe removes an observer from a running subject.
+// It waits until the events already queued for the observer have been
+// delivered, then calls Flush and Close if the observer implements Flusher
+// and Closer. It returns ErrDrainIncomplete if ctx expires first.
+func (s *Subject) Unsubscribe(ctx context.Context, id SubscriptionID) error {
+	s.Lock()
+	var d *dispatcher
+	dispatchers := make([]*dispatcher, 0, len(s.dispatchers))
+	for _, candidate := range s.dispatchers {
+		if candidate.id == id {
+			d = candidate
+		} else {
+			dispatchers = append(dispatchers, candidate)
+		}
+	}
+	s.dispatchers = dispatchers
+	s.Unlock()
+
+	if d == nil {
+		return errors.Errorf("unknown subscription %d", id)
+	}
+
+	drainErr := waitDrained(
+		ctx,
+		s.done,
+		drainTarget{d: d, waiter: d.waitProcessed()},
+	)
+
+	if err := retire(ctx, d); err != nil {
+		return err
+	}
+
+	return drainErr
+}
@@ -0,0 +1,145 @@
+// Copyright 2017 Decipher Technology Studios LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package subject
+
+import (
+	"context"
+	"fmt"
+	"testing"
+)
+
+func TestEventFilter(t *testing.T) {
+	for i, td := range []struct {
+		patterns  []string
+		eventType string
+		expected  bool
+	}{
+		{patterns: nil, eventType: "rpc.End", expected: true},
+		{patterns: []string{"*"}, eventType: "rpc.End", expected: true},
+		{patterns: []string{"rpc.*"}, eventType: "rpc.End", expected: true},
+		{patterns: []string{"rpc.*"}, eventType: "go-metrics.SetGauge", expected: false},
+		{patterns: []string{"rpc.End"}, eventType: "rpc.End", expected: true},
+		{patterns: []string{"rpc.End"}, eventType: "rpc.Begin", expected: false},
+		{patterns: []string{"rpc.End", "go-metrics.*"}, eventType: "go-metrics.AddSample", expected: true},
+	} {
+		f := newEventFilter(td.patterns)
+		if f.match(td.eventType) != td.expected {
+			t.Fatalf("#%d: %v match %s: expected %t",
+				i+1, td.patterns, td.eventType, td.expected)
+		}
+	}
+}
+
+// rpcObserver declares its own event types
+type rpcObserver struct {
+	countObserver
+}
+
+func (o *rpcObserver) EventTypes() []string {
+	return []string{"rpc.*"}
+}
+
+func TestSubscribe(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	s := NewSubject(ctx, nil)
+
+	all := &countObserver{}
+	declared := &rpcObserver{}
+	explicit := &rpcObserver{}
+
+	for _, x := range []struct {
+		observer   Observer
+		eventTypes []string
+	}{
+		{all, nil},
+		{declared, nil},
+		{explicit, []string{"go-metrics.*"}},
+	} {
+		if _, err := s.Subscribe(x.observer, x.eventTypes...); err != nil {
+			t.Fatalf("s.Subscribe failed: %s", err)
+		}
+	}
+
+	for _, eventType := range []string{"rpc.Begin", "go-metrics.SetGauge", "rpc.End"} {
+		s.Events() <- MetricsEvent{EventType: eventType, Key: eventType}
+	}
+	if err := s.Flush(ctx); err != nil {
+		t.Fatalf("s.Flush failed: %s", err)
+	}
+
+	for i, x := range []struct {
+		observed []string
+		expected []string
+	}{
+		{all.observed(), []string{"rpc.Begin", "go-metrics.SetGauge", "rpc.End"}},
+		{declared.observed(), []string{"rpc.Begin", "rpc.End"}},
+		{explicit.observed(), []string{"go-metrics.SetGauge"}},
+	} {
+		if fmt.Sprint(x.observed) != fmt.Sprint(x.expected) {
+			t.Fatalf("#%d: expected %v found %v", i+1, x.expected, x.observed)
+		}
+	}
+}
+
+func TestUnsubscribe(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	observer := &lifecycleObserver{}
+	other := &countObserver{}
+	s := NewSubject(ctx, []Observer{other})
+
+	id, err := s.Subscribe(observer)
+	if err != nil {
+		t.Fatalf("s.Subscribe failed: %s", err)
+	}
+	s.Events() <- MetricsEvent{Key: "aaa"}
+	if err = s.Flush(ctx); err != nil {
+		t.Fatalf("s.Flush failed: %s", err)
+	}
+
+	if err = s.Unsubscribe(ctx, id); err != nil {
+		t.Fatalf("s.Unsubscribe failed: %s", err)
+	}
+	if observer.closed != 1 {
+		t.Fatalf("expected 1 close, found %d", observer.closed)
+	}
+	if err = s.Unsubscribe(ctx, id); err == nil {
+		t.Fatal("expected error unsubscribing twice")
+	}
+
+	s.Events() <- MetricsEvent{Key: "bbb"}
+	if err = s.Flush(ctx); err != nil {
+		t.Fatalf("s.Flush failed: %s", err)
+	}
+	if n := len(observer.observed()); n != 1 {
+		t.Fatalf("unsubscribed observer: expected 1 event, found %d", n)
+	}
+	if n := len(other.observed()); n != 2 {
+		t.Fatalf("other observer: expected 2 events, found %d", n)
+	}
+	if stats := s.Stats(); len(stats.Observers) != 1 || stats.Observers[0].ID != 0 {
+		t.Fatalf("unexpected stats after Unsubscribe: %+v", stats)
+	}
+
+	if err = s.Close(ctx); err != nil {
+		t.Fatalf("s.Close failed: %s", err)
+	}
+	if _, err = s.Subscribe(observer); err != ErrSubjectClosed {
+		t.Fatalf("expected ErrSubjectClosed, found %v", err)
+	}
+}
@@ -0,0 +1,402 @@
+package tracecontext
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"net/http"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// B3 header names, described in https://github.com/openzipkin/b3-propagation
+// They are lower case, so they serve as gRPC metadata keys as well.
+const (
+	// B3HeaderName is the name of the single header format
+	B3HeaderName = "b3"
+
+	B3TraceIDHeaderName      = "x-b3-traceid"
+	B3SpanIDHeaderName       = "x-b3-spanid"
+	B3ParentSpanIDHeaderName = "x-b3-parentspanid"
+	B3SampledHeaderName      = "x-b3-sampled"
+	B3FlagsHeaderName        = "x-b3-flags"
+)
+
+// ErrNoB3 is returned when a request carries no B3 headers
+var ErrNoB3 = errors.New("no b3 headers")
+
+// B3Sampling is the sampling state of a B3 trace context
+type B3Sampling uint8
+
+const (
+	// B3SamplingDefer leaves the sampling decision to the callee
+	B3SamplingDefer B3Sampling = iota
+
+	// B3SamplingDeny is "0": the trace is not sampled
+	B3SamplingDeny
+
+	// B3SamplingAccept is "1": the trace is sampled
+	B3SamplingAccept
+
+	// B3SamplingDebug is "d", or x-b3-flags "1": the trace is sampled and
+	// should not be dropped by the backend
+	B3SamplingDebug
+)
+
+// B3Format selects the headers that carry a B3 trace context
+type B3Format uint8
+
+const (
+	// B3MultiHeader is the x-b3-* headers, used by Envoy
+	B3MultiHeader B3Format = iota
+
+	// B3SingleHeader is the b3 header
+	B3SingleHeader
+)
+
+// B3 is a Zipkin trace context. A B3 context may carry only a sampling
+// state, in which case its IDs are zero.
+type B3 struct {
+	// TraceID holds a 64 bit trace ID in its last 8 bytes
+	TraceID [16]byte
+
+	SpanID [8]byte
+
+	// ParentSpanID is zero at the root of a trace
+	ParentSpanID [8]byte
+
+	Sampling B3Sampling
+}
+
+// HasIDs returns true if the B3 context identifies a span
+func (b B3) HasIDs() bool {
+	return b.TraceID != [16]byte{}
+}
+
+// ParseB3 creates a B3 from the value of the single header, of the form
+//     traceid-spanid[-sampling[-parentspanid]]
+// or
+//     sampling
+// where sampling is "0", "1" or "d", for example
+// "80f198ee56343ba864fe8b2a57d3eff7-e457b5a2e4d86bd1-1-05e3ac9a4f6e3b90".
+func ParseB3(s string) (B3, error) {
+	var b B3
+	var err error
+
+	fields := strings.Split(strings.Trim(s, " \t"), "-")
+
+	if len(fields) == 1 {
+		if b.Sampling, err = parseB3Sampling(fields[0]); err != nil {
+			return B3{}, err
+		}
+		if b.Sampling == B3SamplingDefer {
+			return B3{}, errors.New("empty b3 header")
+		}
+		return b, nil
+	}
+
+	if len(fields) > 4 {
+		return B3{}, errors.Errorf("too many fields in %q", s)
+	}
+
+	if err = b.decodeIDs(fields[0], fields[1]); err != nil {
+		return B3{}, err
+	}
+
+	if len(fields) > 2 {
+		if b.Sampling, err = parseB3Sampling(fields[2]); err != nil {
+			return B3{}, err
+		}
+		if b.Sampling == B3SamplingDefer {
+			return B3{}, errors.New("empty sampling state")
+		}
+	}
+
+	if len(fields) > 3 {
+		if err = b.decodeParentSpanID(fields[3]); err != nil {
+			return B3{}, err
+		}
+	}
+
+	return b, nil
+}
+
+// ParseB3Multi creates a B3 from the values of the x-b3-* headers;
+// only the sampling state is required
+func ParseB3Multi(traceID, spanID, parentSpanID, sampled, flags string) (B3, error) {
+	var b B3
+	var err error
+
+	if traceID != "" || spanID != "" {
+		if err = b.decodeIDs(traceID, spanID); err != nil {
+			return B3{}, err
+		}
+		if parentSpanID != "" {
+			if err = b.decodeParentSpanID(parentSpanID); err != nil {
+				return B3{}, err
+			}
+		}
+	}
+
+	switch sampled {
+	case "true":
+		// the legacy form
+		b.Sampling = B3SamplingAccept
+	case "false":
+		b.Sampling = B3SamplingDeny
+	case "d":
+		return B3{}, errors.Errorf("invalid sampled %q", sampled)
+	default:
+		if b.Sampling, err = parseB3Sampling(sampled); err != nil {
+			return B3{}, err
+		}
+	}
+
+	// the debug flag implies that the trace is sampled
+	if flags == "1" {
+		b.Sampling = B3SamplingDebug
+	}
+
+	if !b.HasIDs() && b.Sampling == B3SamplingDefer {
+		return B3{}, ErrNoB3
+	}
+
+	return b, nil
+}
+
+// ParseB3Header creates a B3 from the headers of an HTTP request,
+// preferring the single header format; it returns ErrNoB3 if there are none
+func ParseB3Header(header http.Header) (B3, error) {
+	return parseB3Values(func(name string) []string {
+		return header[http.CanonicalHeaderKey(name)]
+	})
+}
+
+// ParseB3Metadata is ParseB3Header for the metadata of a gRPC call,
+// for example a metadata.MD
+func ParseB3Metadata(md map[string][]string) (B3, error) {
+	return parseB3Values(func(name string) []string {
+		return md[name]
+	})
+}
+
+// parseB3Values parses the B3 headers returned by values
+func parseB3Values(values func(name string) []string) (B3, error) {
+	if single := values(B3HeaderName); len(single) > 0 {
+		if len(single) > 1 {
+			return B3{}, errors.Errorf("%d b3 headers", len(single))
+		}
+		return ParseB3(single[0])
+	}
+
+	first := func(name string) string {
+		if v := values(name); len(v) > 0 {
+			return v[0]
+		}
+		return ""
+	}
+
+	return ParseB3Multi(
+		first(B3TraceIDHeaderName),
+		first(B3SpanIDHeaderName),
+		first(B3ParentSpanIDHeaderName),
+		first(B3SampledHeaderName),
+		first(B3FlagsHeaderName),
+	)
+}
+
+// decodeIDs decodes a trace ID of 16 or 32 characters and a span ID of 16
+func (b *B3) decodeIDs(traceID, spanID string) error {
+	switch len(traceID) {
+	case 16:
+		if err := decodeField(traceID, b.TraceID[8:]); err != nil {
+			return errors.Wrap(err, "trace id")
+		}
+	case 32:
+		if err := decodeField(traceID, b.TraceID[:]); err != nil {
+			return errors.Wrap(err, "trace id")
+		}
+	default:
+		return errors.Errorf("invalid trace id length %d", len(traceID))
+	}
+	if b.TraceID == [16]byte{} {
+		return errors.New("invalid all zero trace id")
+	}
+
+	if len(spanID) != 16 {
+		return errors.Errorf("invalid span id length %d", len(spanID))
+	}
+	if err := decodeField(spanID, b.SpanID[:]); err != nil {
+		return errors.Wrap(err, "span id")
+	}
+	if b.SpanID == [8]byte{} {
+		return errors.New("invalid all zero span id")
+	}
+
+	return nil
+}
+
+func (b *B3) decodeParentSpanID(parentSpanID string) error {
+	if len(parentSpanID) != 16 {
+		return errors.Errorf("invalid parent span id length %d", len(parentSpanID))
+	}
+	if err := decodeField(parentSpanID, b.ParentSpanID[:]); err != nil {
+		return errors.Wrap(err, "parent span id")
+	}
+
+	return nil
+}
+
+func parseB3Sampling(s string) (B3Sampling, error) {
+	switch s {
+	case "":
+		return B3SamplingDefer, nil
+	case "0":
+		return B3SamplingDeny, nil
+	case "1":
+		return B3SamplingAccept, nil
+	case "d":
+		return B3SamplingDebug, nil
+	}
+
+	return B3SamplingDefer, errors.Errorf("invalid sampling state %q", s)
+}
+
+// String returns the sampling state as in the single header,
+// empty for B3SamplingDefer
+func (s B3Sampling) String() string {
+	switch s {
+	case B3SamplingDeny:
+		return "0"
+	case B3SamplingAccept:
+		return "1"
+	case B3SamplingDebug:
+		return "d"
+	}
+
+	return ""
+}
+
+// TraceIDAsString returns the string form of the TraceID,
+// 16 characters for a 64 bit trace ID
+func (b B3) TraceIDAsString() string {
+	if binary.BigEndian.Uint64(b.TraceID[:8]) == 0 {
+		return hex.EncodeToString(b.TraceID[8:])
+	}
+	return hex.EncodeToString(b.TraceID[:])
+}
+
+// String returns the value of the single header
+func (b B3) String() string {
+	sampling := b.Sampling.String()
+
+	if !b.HasIDs() {
+		return sampling
+	}
+
+	s := b.TraceIDAsString() + "-" + hex.EncodeToString(b.SpanID[:])
+	if sampling == "" {
+		return s
+	}
+	s += "-" + sampling
+	if b.ParentSpanID != [8]byte{} {
+		s += "-" + hex.EncodeToString(b.ParentSpanID[:])
+	}
+
+	return s
+}
+
+// SetHeader sets the B3 headers of an outgoing request in format,
+// and removes those of the other format
+func (b B3) SetHeader(header http.Header, format B3Format) {
+	b.set(
+		func(name, value string) { header.Set(name, value) },
+		func(name string) { header.Del(name) },
+		format,
+	)
+}
+
+// SetMetadata sets the B3 metadata of an outgoing RPC in format, in a map
+// of the form accepted by metadata.New, and removes those of the other format
+func (b B3) SetMetadata(metaMap map[string]string, format B3Format) {
+	b.set(
+		func(name, value string) { metaMap[name] = value },
+		func(name string) { delete(metaMap, name) },
+		format,
+	)
+}
+
+func (b B3) set(set func(name, value string), del func(name string), format B3Format) {
+	multi := []string{
+		B3TraceIDHeaderName,
+		B3SpanIDHeaderName,
+		B3ParentSpanIDHeaderName,
+		B3SampledHeaderName,
+		B3FlagsHeaderName,
+	}
+	for _, name := range multi {
+		del(name)
+	}
+	del(B3HeaderName)
+
+	if format == B3SingleHeader {
+		if s := b.String(); s != "" {
+			set(B3HeaderName, s)
+		}
+		return
+	}
+
+	if b.HasIDs() {
+		set(B3TraceIDHeaderName, b.TraceIDAsString())
+		set(B3SpanIDHeaderName, hex.EncodeToString(b.SpanID[:]))
+		if b.ParentSpanID != [8]byte{} {
+			set(B3ParentSpanIDHeaderName, hex.EncodeToString(b.ParentSpanID[:]))
+		}
+	}
+	switch b.Sampling {
+	case B3SamplingDeny:
+		set(B3SampledHeaderName, "0")
+	case B3SamplingAccept:
+		set(B3SampledHeaderName, "1")
+	case B3SamplingDebug:
+		// debug implies an accept decision, so sampled is not sent
+		set(B3FlagsHeaderName, "1")
+	}
+}
+
+// TraceParent returns the TraceParent of the span identified by the B3
+// context, with a 64 bit trace ID padded with zeroes on the left.
+// It returns false if the B3 context carries only a sampling state.
+// A deferred sampling decision is taken to be a decision to sample.
+func (b B3) TraceParent() (TraceParent, bool) {
+	if !b.HasIDs() {
+		return TraceParent{}, false
+	}
+
+	tp := TraceParent{
+		Version: CurrentTraceParentVersion,
+		TraceID: b.TraceID,
+		SpanID:  b.SpanID,
+	}
+	if b.Sampling != B3SamplingDeny {
+		tp.Options = IsTraceable
+	}
+
+	return tp, true
+}
+
+// B3FromTraceParent returns the B3 context of the span identified by tp,
+// whose parent is parentSpanID; zero at the root of a trace
+func B3FromTraceParent(tp TraceParent, parentSpanID [8]byte) B3 {
+	b := B3{
+		TraceID:      tp.TraceID,
+		SpanID:       tp.SpanID,
+		ParentSpanID: parentSpanID,
+		Sampling:     B3SamplingDeny,
+	}
+	if tp.IsTraceable() {
+		b.Sampling = B3SamplingAccept
+	}
+
+	return b
+}
@@ -0,0 +1,356 @@
+package tracecontext
+
+import (
+	"net/http"
+	"testing"
+)
+
+const (
+	b3TraceID      = "80f198ee56343ba864fe8b2a57d3eff7"
+	b3ShortTraceID = "a3ce929d0e0e4736"
+	b3SpanID       = "e457b5a2e4d86bd1"
+	b3ParentSpanID = "05e3ac9a4f6e3b90"
+)
+
+func TestParseB3(t *testing.T) {
+	testCases := []struct {
+		name             string
+		header           string
+		expectError      bool
+		expectedTraceID  string
+		expectedParent   bool
+		expectedSampling B3Sampling
+		expectedString   string
+	}{
+		{
+			name:             "all fields",
+			header:           b3TraceID + "-" + b3SpanID + "-1-" + b3ParentSpanID,
+			expectedTraceID:  b3TraceID,
+			expectedParent:   true,
+			expectedSampling: B3SamplingAccept,
+		},
+		{
+			name:             "no parent",
+			header:           b3TraceID + "-" + b3SpanID + "-0",
+			expectedTraceID:  b3TraceID,
+			expectedSampling: B3SamplingDeny,
+		},
+		{
+			name:             "deferred",
+			header:           b3TraceID + "-" + b3SpanID,
+			expectedTraceID:  b3TraceID,
+			expectedSampling: B3SamplingDefer,
+		},
+		{
+			name:             "debug",
+			header:           b3TraceID + "-" + b3SpanID + "-d",
+			expectedTraceID:  b3TraceID,
+			expectedSampling: B3SamplingDebug,
+		},
+		{
+			name:             "64 bit trace id",
+			header:           b3ShortTraceID + "-" + b3SpanID + "-1",
+			expectedTraceID:  b3ShortTraceID,
+			expectedSampling: B3SamplingAccept,
+		},
+		{
+			name:             "sampling only",
+			header:           "0",
+			expectedSampling: B3SamplingDeny,
+		},
+		{
+			name:             "surrounding space",
+			header:           " " + b3TraceID + "-" + b3SpanID + "-1 ",
+			expectedString:   b3TraceID + "-" + b3SpanID + "-1",
+			expectedTraceID:  b3TraceID,
+			expectedSampling: B3SamplingAccept,
+		},
+		{name: "empty", header: "", expectError: true},
+		{name: "invalid sampling only", header: "2", expectError: true},
+		{name: "missing span id", header: b3TraceID + "-", expectError: true},
+		{name: "short span id", header: b3TraceID + "-e457b5a2e4d86bd", expectError: true},
+		{name: "upper case", header: "80F198EE56343BA864FE8B2A57D3EFF7-" + b3SpanID, expectError: true},
+		{name: "zero trace id", header: "0000000000000000-" + b3SpanID, expectError: true},
+		{name: "zero span id", header: b3TraceID + "-0000000000000000", expectError: true},
+		{name: "invalid sampling", header: b3TraceID + "-" + b3SpanID + "-true", expectError: true},
+		{name: "empty sampling", header: b3TraceID + "-" + b3SpanID + "--" + b3ParentSpanID, expectError: true},
+		{name: "long parent", header: b3TraceID + "-" + b3SpanID + "-1-" + b3TraceID, expectError: true},
+		{name: "extra field", header: b3TraceID + "-" + b3SpanID + "-1-" + b3ParentSpanID + "-1", expectError: true},
+	}
+
+	for i, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			b, err := ParseB3(tc.header)
+			if tc.expectError {
+				if err == nil {
+					t.Fatalf("%d: expected error for %q", i, tc.header)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("%d: ParseB3(%q) failed: %s", i, tc.header, err)
+			}
+
+			if b.HasIDs() && b.TraceIDAsString() != tc.expectedTraceID {
+				t.Fatalf("%d: TraceID: expected %s found %s", i, tc.expectedTraceID, b.TraceIDAsString())
+			}
+			if b.HasIDs() != (tc.expectedTraceID != "") {
+				t.Fatalf("%d: HasIDs: found %t", i, b.HasIDs())
+			}
+			if (b.ParentSpanID != [8]byte{}) != tc.expectedParent {
+				t.Fatalf("%d: ParentSpanID: found %x", i, b.ParentSpanID)
+			}
+			if b.Sampling != tc.expectedSampling {
+				t.Fatalf("%d: Sampling: expected %d found %d", i, tc.expectedSampling, b.Sampling)
+			}
+
+			expectedString := tc.expectedString
+			if expectedString == "" {
+				expectedString = tc.header
+			}
+			if b.String() != expectedString {
+				t.Fatalf("%d: String: expected %q found %q", i, expectedString, b.String())
+			}
+		})
+	}
+}
+
+func TestParseB3Multi(t *testing.T) {
+	testCases := []struct {
+		name             string
+		traceID          string
+		spanID           string
+		parentSpanID     string
+		sampled          string
+		flags            string
+		expectError      bool
+		expectedSampling B3Sampling
+	}{
+		{
+			name:             "all headers",
+			traceID:          b3TraceID,
+			spanID:           b3SpanID,
+			parentSpanID:     b3ParentSpanID,
+			sampled:          "1",
+			expectedSampling: B3SamplingAccept,
+		},
+		{
+			name:             "legacy sampled",
+			traceID:          b3TraceID,
+			spanID:           b3SpanID,
+			sampled:          "false",
+			expectedSampling: B3SamplingDeny,
+		},
+		{
+			name:             "debug flag",
+			traceID:          b3ShortTraceID,
+			spanID:           b3SpanID,
+			flags:            "1",
+			expectedSampling: B3SamplingDebug,
+		},
+		{
+			name:             "sampled only",
+			sampled:          "0",
+			expectedSampling: B3SamplingDeny,
+		},
+		{name: "none", expectError: true},
+		{name: "trace id only", traceID: b3TraceID, expectError: true},
+		{name: "span id only", spanID: b3SpanID, expectError: true},
+		{name: "single header sampling", traceID: b3TraceID, spanID: b3SpanID, sampled: "d", expectError: true},
+		{name: "invalid parent", traceID: b3TraceID, spanID: b3SpanID, parentSpanID: "xyz", expectError: true},
+	}
+
+	for i, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			b, err := ParseB3Multi(tc.traceID, tc.spanID, tc.parentSpanID, tc.sampled, tc.flags)
+			if tc.expectError {
+				if err == nil {
+					t.Fatalf("%d: expected error", i)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("%d: ParseB3Multi failed: %s", i, err)
+			}
+			if b.HasIDs() && b.TraceIDAsString() != tc.traceID {
+				t.Fatalf("%d: TraceID: expected %s found %s", i, tc.traceID, b.TraceIDAsString())
+			}
+			if b.Sampling != tc.expectedSampling {
+				t.Fatalf("%d: Sampling: expected %d found %d", i, tc.expectedSampling, b.Sampling)
+			}
+		})
+	}
+}
+
+func TestB3Header(t *testing.T) {
+	b, err := ParseB3(b3ShortTraceID + "-" + b3SpanID + "-1-" + b3ParentSpanID)
+	if err != nil {
+		t.Fatalf("ParseB3 failed: %s", err)
+	}
+
+	// each format replaces the headers of the other
+	header := make(http.Header)
+	header.Set(B3HeaderName, "0")
+	b.SetHeader(header, B3MultiHeader)
+
+	for name, expected := range map[string]string{
+		B3HeaderName:             "",
+		B3TraceIDHeaderName:      b3ShortTraceID,
+		B3SpanIDHeaderName:       b3SpanID,
+		B3ParentSpanIDHeaderName: b3ParentSpanID,
+		B3SampledHeaderName:      "1",
+		B3FlagsHeaderName:        "",
+	} {
+		if found := header.Get(name); found != expected {
+			t.Fatalf("%s: expected %q found %q", name, expected, found)
+		}
+	}
+
+	parsed, err := ParseB3Header(header)
+	if err != nil {
+		t.Fatalf("ParseB3Header failed: %s", err)
+	}
+	if parsed != b {
+		t.Fatalf("round trip: expected %s found %s", b, parsed)
+	}
+
+	metaMap := make(map[string]string)
+	b.SetMetadata(metaMap, B3SingleHeader)
+	if len(metaMap) != 1 || metaMap[B3HeaderName] != b.String() {
+		t.Fatalf("unexpected metadata %v", metaMap)
+	}
+
+	parsed, err = ParseB3Metadata(map[string][]string{B3HeaderName: {metaMap[B3HeaderName]}})
+	if err != nil {
+		t.Fatalf("ParseB3Metadata failed: %s", err)
+	}
+	if parsed != b {
+		t.Fatalf("round trip: expected %s found %s", b, parsed)
+	}
+
+	if _, err = ParseB3Header(make(http.Header)); err != ErrNoB3 {
+		t.Fatalf("expected ErrNoB3, found %v", err)
+	}
+}
+
+func TestB3TraceParent(t *testing.T) {
+	b, err := ParseB3(b3ShortTraceID + "-" + b3SpanID + "-0")
+	if err != nil {
+		t.Fatalf("ParseB3 failed: %s", err)
+	}
+
+	tp, ok := b.TraceParent()
+	if !ok {
+		t.Fatal("expected a TraceParent")
+	}
+	expected := "00-0000000000000000" + b3ShortTraceID + "-" + b3SpanID + "-00"
+	if tp.String() != expected {
+		t.Fatalf("expected %s found %s", expected, tp)
+	}
+
+	var parentSpanID [8]byte
+	parentSpanID[7] = 1
+	back := B3FromTraceParent(tp, parentSpanID)
+	if back.String() != b3ShortTraceID+"-"+b3SpanID+"-0-0000000000000001" {
+		t.Fatalf("unexpected B3 %s", back)
+	}
+
+	if _, ok = (B3{Sampling: B3SamplingAccept}).TraceParent(); ok {
+		t.Fatal("unexpected TraceParent for sampling only")
+	}
+}
+
+func TestStartSpanFromHeader(t *testing.T) {
+	parent := GenerateTraceParent()
+
+	testCases := []struct {
+		name           string
+		header         http.Header
+		expectedTrace  string
+		expectedParent string
+		expectTraced   bool
+	}{
+		{
+			name:         "no headers",
+			header:       http.Header{},
+			expectTraced: true,
+		},
+		{
+			name: "traceparent preferred",
+			header: http.Header{
+				"Traceparent": {parent.String()},
+				"B3":          {b3TraceID + "-" + b3SpanID + "-1"},
+			},
+			expectedTrace:  parent.TraceIDAsString(),
+			expectedParent: parent.SpanIDAsString(),
+			expectTraced:   true,
+		},
+		{
+			name: "invalid traceparent falls back to b3",
+			header: http.Header{
+				"Traceparent": {"xxx"},
+				"B3":          {b3TraceID + "-" + b3SpanID + "-1"},
+			},
+			expectedTrace:  b3TraceID,
+			expectedParent: b3SpanID,
+			expectTraced:   true,
+		},
+		{
+			name: "b3 multi header",
+			header: http.Header{
+				"X-B3-Traceid": {b3ShortTraceID},
+				"X-B3-Spanid":  {b3SpanID},
+				"X-B3-Sampled": {"0"},
+			},
+			expectedTrace:  "0000000000000000" + b3ShortTraceID,
+			expectedParent: b3SpanID,
+		},
+		{
+			name:   "b3 sampling only",
+			header: http.Header{"B3": {"0"}},
+		},
+		{
+			name:         "invalid b3",
+			header:       http.Header{"B3": {"xxx"}},
+			expectTraced: true,
+		},
+	}
+
+	for i, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			span := StartSpanFromHeader(tc.header)
+			if tc.expectedTrace != "" && span.TraceIDAsString() != tc.expectedTrace {
+				t.Fatalf("%d: TraceID: expected %s found %s", i, tc.expectedTrace, span.TraceIDAsString())
+			}
+			if span.ParentSpanIDAsString() != tc.expectedParent {
+				t.Fatalf("%d: ParentSpanID: expected %q found %q",
+					i, tc.expectedParent, span.ParentSpanIDAsString())
+			}
+			if span.IsTraceable() != tc.expectTraced {
+				t.Fatalf("%d: IsTraceable: expected %t", i, tc.expectTraced)
+			}
+		})
+	}
+}
+
+func TestSetB3Header(t *testing.T) {
+	span := StartSpan(GenerateTraceParent().String(), "")
+
+	header := make(http.Header)
+	span.SetB3Header(header)
+	if header.Get(B3TraceIDHeaderName) != span.TraceIDAsString() ||
+		header.Get(B3SpanIDHeaderName) != span.SpanIDAsString() ||
+		header.Get(B3ParentSpanIDHeaderName) != span.ParentSpanIDAsString() {
+		t.Fatalf("unexpected headers %v", header)
+	}
+
+	// a b3 header forwarded from the incoming request selects the format
+	metaMap := map[string]string{
+		B3HeaderName:       "1",
+		B3SpanIDHeaderName: b3SpanID,
+	}
+	span.SetB3Metadata(metaMap)
+	if len(metaMap) != 1 || metaMap[B3HeaderName] != span.B3().String() {
+		t.Fatalf("unexpected metadata %v", metaMap)
+	}
+}
@@ -1,5 +1,16 @@
 /* package tracecontext manages Trace Context
-Decsribed in https://w3c.github.io/distributed-tracing/report-trace-context.html
+Described in the W3C Recommendation https://www.w3.org/TR/trace-context/
+
+A Span is the trace context of the work done for one request. StartSpan
+creates it from the headers of the request, NewContext and FromContext
+carry it in the request context, and SetHeader and SetMetadata pass it on
+to outgoing HTTP requests and gRPC calls.
+
+Zipkin's B3 propagation https://github.com/openzipkin/b3-propagation is
+supported for peers such as Envoy: a request without a valid traceparent
+continues the trace of its B3 headers, in the b3 single header format or
+the x-b3-* multi header format, and SetB3Header and SetB3Metadata pass the
+span on in B3 form.
 */
 
 package tracecontext
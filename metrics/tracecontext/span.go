@@ -0,0 +1,227 @@
+package tracecontext
+
+import (
+	"context"
+	"encoding/hex"
+	"net/http"
+	"strings"
+)
+
+// Span is the trace context of the unit of work handling a request:
+// a TraceParent with a SpanID of its own, in the trace of the caller
+type Span struct {
+	TraceParent
+
+	// ParentSpanID is the SpanID of the caller, zero at the root of a trace
+	ParentSpanID [8]byte
+
+	// TraceState is the companion header of the caller, forwarded unchanged
+	// unless it is longer than MaxTraceStateLength
+	TraceState TraceState
+}
+
+type spanCtxKey struct{}
+
+// StartSpan returns a Span for the work of a request carrying the
+// traceParent and traceState header values: a child of the caller's span
+// if traceParent is valid, otherwise the root of a new trace.
+// An invalid traceState is dropped.
+func StartSpan(traceParent, traceState string) Span {
+	if span, ok := childSpan(traceParent, traceState); ok {
+		return span
+	}
+
+	// the trace state belongs to the trace of an invalid parent, drop it
+	return Span{TraceParent: GenerateTraceParent()}
+}
+
+// StartSpanFromValues is StartSpan for all the values of the traceparent
+// and tracestate headers, or metadata keys, of a request. Several
+// traceparent values are invalid; tracestate values are combined.
+func StartSpanFromValues(traceParents, traceStates []string) Span {
+	var traceParent string
+	if len(traceParents) == 1 {
+		traceParent = traceParents[0]
+	}
+
+	return StartSpan(traceParent, strings.Join(traceStates, ","))
+}
+
+// StartSpanFromHeader returns a Span for the work of a request with header:
+// a child of the caller's span given by the traceparent header or, failing
+// that, by the B3 headers; otherwise the root of a new trace.
+func StartSpanFromHeader(header http.Header) Span {
+	return startSpan(func(name string) []string {
+		return header[http.CanonicalHeaderKey(name)]
+	})
+}
+
+// StartSpanFromMetadata is StartSpanFromHeader for the metadata of a gRPC
+// call, for example a metadata.MD
+func StartSpanFromMetadata(md map[string][]string) Span {
+	return startSpan(func(name string) []string {
+		return md[name]
+	})
+}
+
+// startSpan starts a span from the headers returned by values
+func startSpan(values func(name string) []string) Span {
+	traceParents := values(TraceParentHeaderName)
+	if len(traceParents) == 1 {
+		traceState := strings.Join(values(TraceStateHeaderName), ",")
+		if span, ok := childSpan(traceParents[0], traceState); ok {
+			return span
+		}
+	}
+
+	b3, err := parseB3Values(values)
+	if err != nil {
+		return Span{TraceParent: GenerateTraceParent()}
+	}
+	if parent, ok := b3.TraceParent(); ok {
+		return Span{
+			TraceParent:  parent.WithNewSpanID(),
+			ParentSpanID: parent.SpanID,
+		}
+	}
+
+	// the caller passed only its sampling decision
+	tp := GenerateTraceParent()
+	if b3.Sampling == B3SamplingDeny {
+		tp.Options &^= IsTraceable
+	}
+
+	return Span{TraceParent: tp}
+}
+
+// childSpan returns a child of the span given by traceParent, if it is valid
+func childSpan(traceParent, traceState string) (Span, bool) {
+	if traceParent == "" {
+		return Span{}, false
+	}
+
+	parent, err := ParseTraceParent(traceParent)
+	if err != nil {
+		return Span{}, false
+	}
+
+	state, err := ParseTraceState(traceState)
+	if err != nil {
+		state = TraceState{}
+	}
+
+	return Span{
+		TraceParent:  parent.WithNewSpanID(),
+		ParentSpanID: parent.SpanID,
+		TraceState:   state.Truncate(MaxTraceStateLength),
+	}, true
+}
+
+// NewChild returns a child of the span, in the same trace, for example
+// the span of an outgoing request made while handling the span's request
+func (s Span) NewChild() Span {
+	return Span{
+		TraceParent:  s.TraceParent.WithNewSpanID(),
+		ParentSpanID: s.SpanID,
+		TraceState:   s.TraceState,
+	}
+}
+
+// ParentSpanIDAsString returns the string form of the ParentSpanID,
+// empty at the root of a trace
+func (s Span) ParentSpanIDAsString() string {
+	if s.ParentSpanID == [8]byte{} {
+		return ""
+	}
+	return hex.EncodeToString(s.ParentSpanID[:])
+}
+
+// SetHeader sets the headers that pass the span to an outgoing request,
+// making it the parent of the callee's span
+func (s Span) SetHeader(header http.Header) {
+	header.Set(TraceParentHeaderName, s.TraceParent.String())
+	if len(s.TraceState.Members) > 0 {
+		header.Set(TraceStateHeaderName, s.TraceState.String())
+	} else {
+		header.Del(TraceStateHeaderName)
+	}
+}
+
+// SetMetadata sets the gRPC metadata that pass the span to an outgoing RPC,
+// in a map of the form accepted by metadata.New
+func (s Span) SetMetadata(metaMap map[string]string) {
+	metaMap[TraceParentMetadataKey] = s.TraceParent.String()
+	if len(s.TraceState.Members) > 0 {
+		metaMap[TraceStateMetadataKey] = s.TraceState.String()
+	} else {
+		delete(metaMap, TraceStateMetadataKey)
+	}
+}
+
+// B3 returns the B3 form of the span
+func (s Span) B3() B3 {
+	return B3FromTraceParent(s.TraceParent, s.ParentSpanID)
+}
+
+// SetB3Header sets the B3 headers that pass the span to an outgoing
+// request, for peers that do not support the traceparent header.
+// The b3 single header is used if header has one, forwarded from the
+// incoming request, otherwise the x-b3-* headers.
+func (s Span) SetB3Header(header http.Header) {
+	format := B3MultiHeader
+	if header.Get(B3HeaderName) != "" {
+		format = B3SingleHeader
+	}
+	s.B3().SetHeader(header, format)
+}
+
+// SetB3Metadata is SetB3Header for the gRPC metadata of an outgoing RPC,
+// in a map of the form accepted by metadata.New
+func (s Span) SetB3Metadata(metaMap map[string]string) {
+	format := B3MultiHeader
+	if metaMap[B3HeaderName] != "" {
+		format = B3SingleHeader
+	}
+	s.B3().SetMetadata(metaMap, format)
+}
+
+// NewContext returns a context that carries span
+func NewContext(ctx context.Context, span Span) context.Context {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return context.WithValue(ctx, spanCtxKey{}, span)
+}
+
+// FromContext returns the span carried by ctx, if any
+func FromContext(ctx context.Context) (Span, bool) {
+	if ctx == nil {
+		return Span{}, false
+	}
+
+	span, ok := ctx.Value(spanCtxKey{}).(Span)
+	return span, ok
+}
+
+// RequestSpan returns the span of an incoming request, and the request
+// with the span in its context. If the context already carries a span,
+// set by an outer handler, that span is used; otherwise one is started
+// from the request headers, see StartSpanFromHeader.
+func RequestSpan(req *http.Request) (Span, *http.Request) {
+	if span, ok := FromContext(req.Context()); ok {
+		return span, req
+	}
+
+	span := StartSpanFromHeader(req.Header)
+
+	return span, req.WithContext(NewContext(req.Context(), span))
+}
+
+// Handler returns an http.Handler that starts the span of each request,
+// stores it in the request context and passes the request to next
+func Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		_, req = RequestSpan(req)
+		next.ServeHTTP(w, req)
+	})
+}
@@ -0,0 +1,125 @@
+package tracecontext
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestStartSpan(t *testing.T) {
+	parent := GenerateTraceParent()
+
+	testCases := []struct {
+		name          string
+		traceParent   string
+		traceState    string
+		expectChild   bool
+		expectedState string
+	}{
+		{name: "no parent", traceParent: "", traceState: "a=b"},
+		{name: "invalid parent", traceParent: "xxx", traceState: "a=b"},
+		{
+			name:          "valid parent",
+			traceParent:   parent.String(),
+			traceState:    "a=b",
+			expectChild:   true,
+			expectedState: "a=b",
+		},
+		{
+			name:        "invalid state",
+			traceParent: parent.String(),
+			traceState:  "A=b",
+			expectChild: true,
+		},
+	}
+
+	for i, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			span := StartSpan(tc.traceParent, tc.traceState)
+			if span.TraceState.String() != tc.expectedState {
+				t.Fatalf("%d: TraceState: expected '%s' found '%s'",
+					i, tc.expectedState, span.TraceState)
+			}
+			if span.SpanID == parent.SpanID {
+				t.Fatalf("%d: span reuses the parent SpanID", i)
+			}
+			if !tc.expectChild {
+				if span.TraceID == parent.TraceID {
+					t.Fatalf("%d: new trace has the parent TraceID", i)
+				}
+				if span.ParentSpanIDAsString() != "" {
+					t.Fatalf("%d: root span has parent %s", i, span.ParentSpanIDAsString())
+				}
+				return
+			}
+			if span.TraceID != parent.TraceID {
+				t.Fatalf("%d: TraceID mismatch: %s != %s",
+					i, span.TraceIDAsString(), parent.TraceIDAsString())
+			}
+			if span.ParentSpanID != parent.SpanID {
+				t.Fatalf("%d: ParentSpanID mismatch: %s != %s",
+					i, span.ParentSpanIDAsString(), parent.SpanIDAsString())
+			}
+		})
+	}
+}
+
+func TestRequestSpan(t *testing.T) {
+	parent := GenerateTraceParent()
+
+	var inner Span
+	handler := Handler(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var ok bool
+		if inner, ok = FromContext(req.Context()); !ok {
+			t.Fatal("no span in the request context")
+		}
+
+		// a nested handler must see the same span
+		if span, _ := RequestSpan(req); span.SpanID != inner.SpanID {
+			t.Fatalf("nested span mismatch: %s != %s",
+				span.SpanIDAsString(), inner.SpanIDAsString())
+		}
+	}))
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set(TraceParentHeaderName, parent.String())
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	if inner.TraceID != parent.TraceID || inner.ParentSpanID != parent.SpanID {
+		t.Fatalf("span %s is not a child of %s", inner.TraceParent, parent)
+	}
+
+	header := make(http.Header)
+	inner.SetHeader(header)
+	if header.Get(TraceParentHeaderName) != inner.TraceParent.String() {
+		t.Fatalf("header: expected %s found %s",
+			inner.TraceParent, header.Get(TraceParentHeaderName))
+	}
+
+	metaMap := make(map[string]string)
+	inner.SetMetadata(metaMap)
+	if metaMap[TraceParentMetadataKey] != inner.TraceParent.String() {
+		t.Fatalf("metadata: expected %s found %s",
+			inner.TraceParent, metaMap[TraceParentMetadataKey])
+	}
+
+	if _, ok := FromContext(context.Background()); ok {
+		t.Fatal("span found in an empty context")
+	}
+}
+
+func TestStartSpanFromValues(t *testing.T) {
+	parent := GenerateTraceParent()
+
+	span := StartSpanFromValues([]string{parent.String()}, []string{"a=1", "b=2"})
+	if span.TraceID != parent.TraceID || span.TraceState.String() != "a=1,b=2" {
+		t.Fatalf("span %s %s is not a child of %s", span.TraceParent, span.TraceState, parent)
+	}
+
+	// several traceparent values restart the trace
+	span = StartSpanFromValues([]string{parent.String(), parent.String()}, []string{"a=1"})
+	if span.TraceID == parent.TraceID || len(span.TraceState.Members) != 0 {
+		t.Fatalf("span %s %s continues the trace of %s", span.TraceParent, span.TraceState, parent)
+	}
+}
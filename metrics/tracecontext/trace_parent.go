@@ -3,7 +3,6 @@ package tracecontext
 import (
 	"encoding/hex"
 	"fmt"
-	"strconv"
 	"strings"
 
 	"github.com/google/uuid"
@@ -11,7 +10,11 @@ import (
 )
 
 // TraceParentHeaderName is the name used in an HTTP header
-const TraceParentHeaderName = "Trace-Parent"
+const TraceParentHeaderName = "traceparent"
+
+// TraceParentMetadataKey is the name used in gRPC metadata,
+// whose keys are lower case
+const TraceParentMetadataKey = "traceparent"
 
 // TraceParent is a trace context header that is used to pass trace context
 // information across systems for a HTTP request.
@@ -20,23 +23,29 @@ type TraceParent struct {
 	Version uint8
 
 	// TraceID traces an entire transaction
-	// Shouldremain constant through the life of the TraceParent
+	// Should remain constant through the life of the TraceParent
 	TraceID [16]byte
 
 	// SpanID identifies a single node in the trace tree
 	// "In a Dapper trace tree, the tree nodes are basic units of
 	// work which we refer to as spans".
+	// It is the parent-id field of the header
 	SpanID [8]byte
 
-	// Options are setsable bits describing the transaction
+	// Options are the trace-flags, settable bits describing the transaction
 	Options uint8
 }
 
 // CurrentTraceParentVersion is the version of distributed-tracing that we
 // currently support
 const CurrentTraceParentVersion = 0
-const minTraceParentVersion = 0
-const maxTraceParentVersion = 0
+
+// invalidTraceParentVersion is forbidden by the specification
+const invalidTraceParentVersion = 0xff
+
+// traceParentLength is the length of a version 0 header; the header of a
+// later version starts with the same fields, and may add more after a '-'
+const traceParentLength = 55
 
 // IsTraceable is an option bit determining whether data should be sampled or not
 const IsTraceable = 0x01
@@ -51,86 +60,87 @@ func GenerateTraceParent() TraceParent {
 	}
 }
 
-type parseFunc func(*TraceParent, string) error
-
-// ParseTraceParent creates a TraceParent from a string
+// ParseTraceParent creates a TraceParent from a string of the form
+//     version-traceid-parentid-traceflags
+// for example "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01".
+// The fields are lower case hex, and the trace and parent IDs may not be
+// all zeroes. A header of a later version than CurrentTraceParentVersion
+// is parsed as far as the fields of the current version.
 func ParseTraceParent(s string) (TraceParent, error) {
-	var err error
 	var tp TraceParent
-	var parseFuncs = []parseFunc{
-		parseVersion,
-		parseTraceID,
-		parseSpanID,
-		parseOptions,
-	}
+	var err error
 
-	parts := strings.Split(s, "-")
+	// surrounding white space is not part of an HTTP header value
+	s = strings.Trim(s, " \t")
 
-	if len(parts) != len(parseFuncs) {
+	if len(s) < traceParentLength {
 		return TraceParent{}, errors.Errorf(
-			"Not enough '-', found %d, expected %d",
-			len(parts),
-			len(parseFuncs),
+			"invalid length %d, expected at least %d", len(s), traceParentLength,
 		)
 	}
 
-	for i := 0; i < len(parseFuncs); i++ {
-		if err = parseFuncs[i](&tp, parts[i]); err != nil {
-			return TraceParent{}, errors.Wrapf(err, "parseFunc[%d] %s",
-				i, parts[i],
-			)
-		}
+	var version [1]byte
+	if err = decodeField(s[0:2], version[:]); err != nil {
+		return TraceParent{}, errors.Wrap(err, "version")
 	}
+	tp.Version = version[0]
 
-	return tp, nil
-}
-
-func parseVersion(tp *TraceParent, data string) error {
-	version, err := strconv.Atoi(data)
-	if err != nil {
-		return errors.Wrapf(err, "strconv.Atoi(%s)", data)
-	}
-
-	if version < minTraceParentVersion || version > maxTraceParentVersion {
-		return errors.Errorf(
-			"Invalid version: expected %d <= %d <= %d",
-			minTraceParentVersion,
-			version,
-			maxTraceParentVersion,
+	switch {
+	case tp.Version == invalidTraceParentVersion:
+		return TraceParent{}, errors.Errorf("invalid version %02x", tp.Version)
+	case tp.Version == CurrentTraceParentVersion && len(s) != traceParentLength:
+		return TraceParent{}, errors.Errorf(
+			"invalid length %d for version %02x, expected %d",
+			len(s), tp.Version, traceParentLength,
+		)
+	case len(s) > traceParentLength && s[traceParentLength] != '-':
+		return TraceParent{}, errors.Errorf(
+			"expected '-' after the trace flags, found %q", s[traceParentLength],
 		)
 	}
 
-	tp.Version = uint8(version)
-	return nil
-}
+	for _, i := range []int{2, 35, 52} {
+		if s[i] != '-' {
+			return TraceParent{}, errors.Errorf("expected '-' at %d, found %q", i, s[i])
+		}
+	}
 
-func parseTraceID(tp *TraceParent, data string) error {
-	traceBytes, err := hex.DecodeString(data)
-	if err != nil {
-		return errors.Wrapf(err, "hex.DecodeString(%s)", data)
+	if err = decodeField(s[3:35], tp.TraceID[:]); err != nil {
+		return TraceParent{}, errors.Wrap(err, "trace-id")
+	}
+	if tp.TraceID == [16]byte{} {
+		return TraceParent{}, errors.New("invalid all zero trace-id")
 	}
-	copy(tp.TraceID[:], traceBytes)
 
-	return nil
-}
+	if err = decodeField(s[36:52], tp.SpanID[:]); err != nil {
+		return TraceParent{}, errors.Wrap(err, "parent-id")
+	}
+	if tp.SpanID == [8]byte{} {
+		return TraceParent{}, errors.New("invalid all zero parent-id")
+	}
 
-func parseSpanID(tp *TraceParent, data string) error {
-	spanBytes, err := hex.DecodeString(data)
-	if err != nil {
-		return errors.Wrapf(err, "hex.DecodeString(%s)", data)
+	var options [1]byte
+	if err = decodeField(s[53:55], options[:]); err != nil {
+		return TraceParent{}, errors.Wrap(err, "trace-flags")
 	}
-	copy(tp.SpanID[:], spanBytes)
+	tp.Options = options[0]
 
-	return nil
+	return tp, nil
 }
 
-func parseOptions(tp *TraceParent, data string) error {
-	options, err := strconv.Atoi(data)
-	if err != nil {
-		return errors.Wrapf(err, "strconv.Atoi(%s)", data)
+// decodeField decodes a field of lower case hex into dst, which is half
+// the length of the field
+func decodeField(field string, dst []byte) error {
+	for i := 0; i < len(field); i++ {
+		c := field[i]
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return errors.Errorf("invalid character %q in %q", c, field)
+		}
 	}
 
-	tp.Options = uint8(options)
+	if _, err := hex.Decode(dst, []byte(field)); err != nil {
+		return errors.Wrapf(err, "hex.Decode(%s)", field)
+	}
 
 	return nil
 }
@@ -138,7 +148,7 @@ func parseOptions(tp *TraceParent, data string) error {
 // String returns a formatted string suitable for use in an HTTP header
 func (tp TraceParent) String() string {
 	return fmt.Sprintf(
-		"%0d-%s-%s-%02d",
+		"%02x-%s-%s-%02x",
 		tp.Version,
 		tp.TraceIDAsString(),
 		tp.SpanIDAsString(),
@@ -146,13 +156,15 @@ func (tp TraceParent) String() string {
 	)
 }
 
-// WithNewSpanID returns a TraceParent with the SpanID set to represent a new Node
+// WithNewSpanID returns a TraceParent with the SpanID set to represent a new Node.
+// The TraceParent is of the current version, which we propagate whatever the
+// version of the parent, so only the options of that version are kept.
 func (tp TraceParent) WithNewSpanID() TraceParent {
 	return TraceParent{
-		Version: tp.Version,
+		Version: CurrentTraceParentVersion,
 		TraceID: tp.TraceID,
 		SpanID:  generateSpanID(),
-		Options: tp.Options,
+		Options: tp.Options & IsTraceable,
 	}
 }
 
@@ -166,7 +178,7 @@ func (tp TraceParent) SpanIDAsString() string {
 	return hex.EncodeToString(tp.SpanID[:])
 }
 
-// VersionIsValid returns true if the15 TraceParent has an acceptble version
+// VersionIsValid returns true if the TraceParent has an acceptble version
 func (tp TraceParent) VersionIsValid() bool {
 	return tp.Version == CurrentTraceParentVersion
 }
@@ -181,6 +193,7 @@ func generateSpanID() [8]byte {
 	u := uuid.New()
 
 	// just give them half of a uuid, maybe something more specific later
+	// the variant bits of the uuid make sure it is not all zeroes
 	for i := 0; i < 8; i++ {
 		s[i] = u[8+i]
 	}
@@ -45,3 +45,125 @@ func TestTraceParent(t *testing.T) {
 		t.Fatalf("Options mismatch: %d != %d", tp1.Options, tp2.Options)
 	}
 }
+
+// TestParseTraceParent follows the traceparent cases of the W3C test suite
+// https://github.com/w3c/trace-context/blob/master/test/test.py
+func TestParseTraceParent(t *testing.T) {
+	const traceID = "12345678901234567890123456789012"
+	const parentID = "1234567890123456"
+
+	testCases := []struct {
+		name           string
+		stringVal      string
+		expectError    bool
+		expectedString string
+	}{
+		{
+			name:           "version 0x00",
+			stringVal:      "00-" + traceID + "-" + parentID + "-01",
+			expectedString: "00-" + traceID + "-" + parentID + "-01",
+		},
+		{
+			name:           "not sampled",
+			stringVal:      "00-" + traceID + "-" + parentID + "-00",
+			expectedString: "00-" + traceID + "-" + parentID + "-00",
+		},
+		{
+			name:           "white space",
+			stringVal:      " \t00-" + traceID + "-" + parentID + "-01\t ",
+			expectedString: "00-" + traceID + "-" + parentID + "-01",
+		},
+		{name: "version 0x00 trailing '.'", stringVal: "00-" + traceID + "-" + parentID + "-01.", expectError: true},
+		{name: "version 0x00 trailing '-'", stringVal: "00-" + traceID + "-" + parentID + "-01-", expectError: true},
+		{
+			name:        "version 0x00 future fields",
+			stringVal:   "00-" + traceID + "-" + parentID + "-01-what-the-future-will-be-like",
+			expectError: true,
+		},
+		{
+			name:           "version 0xcc",
+			stringVal:      "cc-" + traceID + "-" + parentID + "-01",
+			expectedString: "cc-" + traceID + "-" + parentID + "-01",
+		},
+		{
+			name:           "version 0xcc future fields",
+			stringVal:      "cc-" + traceID + "-" + parentID + "-01-what-the-future-will-be-like",
+			expectedString: "cc-" + traceID + "-" + parentID + "-01",
+		},
+		{
+			name:        "version 0xcc future fields without '-'",
+			stringVal:   "cc-" + traceID + "-" + parentID + "-01.what-the-future-will-be-like",
+			expectError: true,
+		},
+		{name: "version 0xff", stringVal: "ff-" + traceID + "-" + parentID + "-01", expectError: true},
+		{name: "version illegal character 1", stringVal: ".0-" + traceID + "-" + parentID + "-01", expectError: true},
+		{name: "version illegal character 2", stringVal: "0.-" + traceID + "-" + parentID + "-01", expectError: true},
+		{name: "version upper case", stringVal: "0A-" + traceID + "-" + parentID + "-01", expectError: true},
+		{name: "version too long", stringVal: "000-" + traceID + "-" + parentID + "-01", expectError: true},
+		{name: "version too short", stringVal: "0-" + traceID + "-" + parentID + "-01", expectError: true},
+		{
+			name:        "trace-id all zero",
+			stringVal:   "00-00000000000000000000000000000000-" + parentID + "-01",
+			expectError: true,
+		},
+		{
+			name:        "trace-id illegal character",
+			stringVal:   "00-.2345678901234567890123456789012-" + parentID + "-01",
+			expectError: true,
+		},
+		{
+			name:        "trace-id upper case",
+			stringVal:   "00-ABCDEF78901234567890123456789012-" + parentID + "-01",
+			expectError: true,
+		},
+		{
+			name:        "trace-id too long",
+			stringVal:   "00-" + traceID + "3-" + parentID + "-01",
+			expectError: true,
+		},
+		{
+			name:        "trace-id too short",
+			stringVal:   "00-1234567890123456789012345678901-" + parentID + "-01",
+			expectError: true,
+		},
+		{name: "parent-id all zero", stringVal: "00-" + traceID + "-0000000000000000-01", expectError: true},
+		{name: "parent-id illegal character", stringVal: "00-" + traceID + "-.234567890123456-01", expectError: true},
+		{name: "parent-id too long", stringVal: "00-" + traceID + "-12345678901234567-01", expectError: true},
+		{name: "parent-id too short", stringVal: "00-" + traceID + "-123456789012345-01", expectError: true},
+		{name: "trace-flags illegal character", stringVal: "00-" + traceID + "-" + parentID + "-.0", expectError: true},
+		{name: "trace-flags too long", stringVal: "00-" + traceID + "-" + parentID + "-001", expectError: true},
+		{name: "trace-flags too short", stringVal: "00-" + traceID + "-" + parentID + "-1", expectError: true},
+		{name: "empty", stringVal: "", expectError: true},
+	}
+
+	for i, tc := range testCases {
+
+		t.Run(tc.name, func(t *testing.T) {
+			tp, err := ParseTraceParent(tc.stringVal)
+			if tc.expectError {
+				if err == nil {
+					t.Fatalf("%d: expecting error", i)
+				}
+			} else {
+				if err != nil {
+					t.Fatalf("%d: unexpected error: %s", i, err)
+				}
+
+				s2 := tp.String()
+				if s2 != tc.expectedString {
+					t.Fatalf("%d: string mismatch: %s != %s", i, s2, tc.expectedString)
+				}
+			}
+		})
+	}
+
+	// a later version is propagated as the current version
+	tp, err := ParseTraceParent("cc-" + traceID + "-" + parentID + "-03-future")
+	if err != nil {
+		t.Fatalf("ParseTraceParent failed: %s", err)
+	}
+	child := tp.WithNewSpanID()
+	if child.Version != CurrentTraceParentVersion || child.Options != IsTraceable {
+		t.Fatalf("child of a later version: %s", child)
+	}
+}
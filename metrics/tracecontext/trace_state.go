@@ -1,98 +1,242 @@
 package tracecontext
 
 import (
-	"fmt"
 	"strings"
 
 	"github.com/pkg/errors"
 )
 
 // TraceStateHeaderName is the name used in an HTTP header
-const TraceStateHeaderName = "Trace-State"
+const TraceStateHeaderName = "tracestate"
 
-// TraceState is used to pass the name-value context properties for the trace.
-// This is a companion header for the Trace-Parent.
+// TraceStateMetadataKey is the name used in gRPC metadata,
+// whose keys are lower case
+const TraceStateMetadataKey = "tracestate"
+
+// MaxTraceStateMembers is the maximum number of list members in a TraceState
+const MaxTraceStateMembers = 32
+
+// MaxTraceStateLength is the length of the tracestate header that we
+// propagate; the specification asks that at least 512 characters be passed on
+const MaxTraceStateLength = 512
+
+// truncateMemberLength is the length above which list members are the
+// first to go when a TraceState is truncated
+const truncateMemberLength = 128
+
+// TraceState is used to pass vendor specific name-value context properties
+// for the trace. This is a companion header for the traceparent.
+// The Members are ordered from the most recently updated; keys are unique.
 type TraceState struct {
-	KVPair
-	Properties []KVPair
+	Members []KVPair
 }
 
-// KVPair represents a Key/Value pair
-//Key and Value are expected to be unescaped
+// KVPair represents a list member of a TraceState
+// The Key is a simple key, or a multi-tenant key of the form tenant@vendor
 type KVPair struct {
 	Key   string
 	Value string
 }
 
-// ParseKVPair returns a KVPair from a string
-//
+// ParseKVPair returns a KVPair from a string of the form key=value
 func ParseKVPair(s string) (KVPair, error) {
 	if len(s) == 0 {
 		return KVPair{}, errors.Errorf("attempt to parse empty string")
 	}
 
-	var kp KVPair
-
-	splitStr := strings.Split(s, "=")
-	kp.Key = strings.TrimSpace(splitStr[0])
-	if kp.Key == "" {
-		return KVPair{}, errors.Errorf("Invalid blank key")
+	i := strings.Index(s, "=")
+	if i < 0 {
+		return KVPair{}, errors.Errorf("missing '=' in %q", s)
 	}
-	switch len(splitStr) {
-	case 1:
-	case 2:
-		kp.Value = strings.TrimSpace(splitStr[1])
-	default:
-		return KVPair{}, errors.Errorf(
-			"invalid format expecting 0 or 1 '=' found %d",
-			len(splitStr)-1,
-		)
+
+	kp := KVPair{Key: s[:i], Value: s[i+1:]}
+	if err := kp.validate(); err != nil {
+		return KVPair{}, err
 	}
 
 	return kp, nil
 }
 
+func (kp KVPair) validate() error {
+	if !validKey(kp.Key) {
+		return errors.Errorf("invalid key %q", kp.Key)
+	}
+	if !validValue(kp.Value) {
+		return errors.Errorf("invalid value %q", kp.Value)
+	}
+
+	return nil
+}
+
+// validKey returns true for a key of the form
+//     simple-key   lcalpha 0*255( lcalpha / DIGIT / "_" / "-"/ "*" / "/" )
+//     tenant@vendor
+//     tenant-id    ( lcalpha / DIGIT ) 0*240( lcalpha / DIGIT / "_" / "-"/ "*" / "/" )
+//     system-id    lcalpha 0*13( lcalpha / DIGIT / "_" / "-"/ "*" / "/" )
+func validKey(key string) bool {
+	at := strings.Index(key, "@")
+	if at < 0 {
+		return validKeyPart(key, 256, false)
+	}
+
+	return validKeyPart(key[:at], 241, true) && validKeyPart(key[at+1:], 14, false)
+}
+
+func validKeyPart(part string, maxLength int, digitFirst bool) bool {
+	if len(part) == 0 || len(part) > maxLength {
+		return false
+	}
+	if !isLowerAlpha(part[0]) && !(digitFirst && isDigit(part[0])) {
+		return false
+	}
+	for i := 1; i < len(part); i++ {
+		c := part[i]
+		if !isLowerAlpha(c) && !isDigit(c) && !strings.ContainsRune("_-*/", rune(c)) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// validValue returns true for 1 to 256 printable ASCII characters,
+// other than ',' and '=', that do not end in a space
+func validValue(value string) bool {
+	if len(value) == 0 || len(value) > 256 || value[len(value)-1] == ' ' {
+		return false
+	}
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		if c < 0x20 || c > 0x7e || c == ',' || c == '=' {
+			return false
+		}
+	}
+
+	return true
+}
+
+func isLowerAlpha(c byte) bool {
+	return c >= 'a' && c <= 'z'
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
 // String returns a formatted string of the form K=V
 func (kp KVPair) String() string {
-	if kp.Value == "" {
-		return kp.Key
-	}
-	return fmt.Sprintf("%s=%s", kp.Key, kp.Value)
+	return kp.Key + "=" + kp.Value
 }
 
 // String returns a formatted string of the TraceState
 func (ts TraceState) String() string {
-	if len(ts.Properties) == 0 {
-		return ts.KVPair.String()
+	members := make([]string, len(ts.Members))
+	for i, member := range ts.Members {
+		members[i] = member.String()
 	}
 
-	var propStr []string
-
-	for _, prop := range ts.Properties {
-		propStr = append(propStr, prop.String())
-	}
-	return fmt.Sprintf("%s;%s",
-		ts.KVPair, strings.Join(propStr, ";"))
+	return strings.Join(members, ",")
 }
 
-// ParseTraceState returns a TraceState from a string
+// ParseTraceState returns a TraceState from a string, the comma separated
+// list members of all the tracestate headers of a request.
+// Empty list members are ignored; an invalid or duplicated member, or more
+// than MaxTraceStateMembers members, invalidate the whole TraceState.
 func ParseTraceState(s string) (TraceState, error) {
 	var state TraceState
-	var err error
+	keys := make(map[string]struct{})
 
-	kvs := strings.Split(s, ";")
-	state.KVPair, err = ParseKVPair(kvs[0])
-	if err != nil {
-		return TraceState{}, errors.Wrap(err, "ParseKVPair")
-	}
+	for _, memberStr := range strings.Split(s, ",") {
+		memberStr = strings.Trim(memberStr, " \t")
+		if memberStr == "" {
+			continue
+		}
 
-	for _, propStr := range kvs[1:] {
-		prop, err := ParseKVPair(propStr)
+		member, err := ParseKVPair(memberStr)
 		if err != nil {
 			return TraceState{}, errors.Wrap(err, "ParseKVPair")
 		}
-		state.Properties = append(state.Properties, prop)
+		if _, ok := keys[member.Key]; ok {
+			return TraceState{}, errors.Errorf("duplicate key %q", member.Key)
+		}
+		keys[member.Key] = struct{}{}
+
+		state.Members = append(state.Members, member)
+	}
+
+	if len(state.Members) > MaxTraceStateMembers {
+		return TraceState{}, errors.Errorf(
+			"%d list members, expected at most %d",
+			len(state.Members),
+			MaxTraceStateMembers,
+		)
 	}
 
 	return state, nil
 }
+
+// Get returns the value of key
+func (ts TraceState) Get(key string) (string, bool) {
+	for _, member := range ts.Members {
+		if member.Key == key {
+			return member.Value, true
+		}
+	}
+
+	return "", false
+}
+
+// Set returns a TraceState with key set to value. As the specification
+// requires, the updated member moves to the beginning of the list; if the
+// list is full, the last member is removed.
+func (ts TraceState) Set(key, value string) (TraceState, error) {
+	kp := KVPair{Key: key, Value: value}
+	if err := kp.validate(); err != nil {
+		return TraceState{}, err
+	}
+
+	members := append([]KVPair{kp}, ts.Delete(key).Members...)
+	if len(members) > MaxTraceStateMembers {
+		members = members[:MaxTraceStateMembers]
+	}
+
+	return TraceState{Members: members}, nil
+}
+
+// Delete returns a TraceState without key
+func (ts TraceState) Delete(key string) TraceState {
+	var members []KVPair
+	for _, member := range ts.Members {
+		if member.Key != key {
+			members = append(members, member)
+		}
+	}
+
+	return TraceState{Members: members}
+}
+
+// Truncate returns a TraceState whose String is at most maxLength long.
+// As the specification suggests, members longer than 128 characters are
+// removed first, then members from the end of the list.
+func (ts TraceState) Truncate(maxLength int) TraceState {
+	members := append([]KVPair(nil), ts.Members...)
+
+	length := len(TraceState{Members: members}.String())
+	for length > maxLength && len(members) > 0 {
+		remove := len(members) - 1
+		for i := len(members) - 1; i >= 0; i-- {
+			if len(members[i].String()) > truncateMemberLength {
+				remove = i
+				break
+			}
+		}
+
+		length -= len(members[remove].String())
+		if len(members) > 1 {
+			length-- // the comma
+		}
+		members = append(members[:remove], members[remove+1:]...)
+	}
+
+	return TraceState{Members: members}
+}
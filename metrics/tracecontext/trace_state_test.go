@@ -1,6 +1,8 @@
 package tracecontext
 
 import (
+	"fmt"
+	"strings"
 	"testing"
 )
 
@@ -13,11 +15,26 @@ func TestKVPair(t *testing.T) {
 	}{
 		{name: "empty", stringVal: "", expectError: true, expectedString: ""},
 		{name: "normal", stringVal: "key=value", expectError: false, expectedString: "key=value"},
-		{name: "space1", stringVal: " key=value", expectError: false, expectedString: "key=value"},
-		{name: "space2", stringVal: " key =value", expectError: false, expectedString: "key=value"},
-		{name: "space3", stringVal: " key = value", expectError: false, expectedString: "key=value"},
-		{name: "no value 1", stringVal: "key=", expectError: false, expectedString: "key"},
-		{name: "no value 2", stringVal: "key", expectError: false, expectedString: "key"},
+		{name: "multi tenant", stringVal: "tenant@vendor=value", expectError: false, expectedString: "tenant@vendor=value"},
+		{name: "digit tenant", stringVal: "1tenant@vendor=value", expectError: false, expectedString: "1tenant@vendor=value"},
+		{name: "key characters", stringVal: "a0_-*/=value", expectError: false, expectedString: "a0_-*/=value"},
+		{name: "value characters", stringVal: "key=!\"#$%&'()*+-./:;<>?@[\\]^_`{|}~", expectError: false, expectedString: "key=!\"#$%&'()*+-./:;<>?@[\\]^_`{|}~"},
+		{name: "value with space", stringVal: "key=a b", expectError: false, expectedString: "key=a b"},
+		{name: "space1", stringVal: " key=value", expectError: true},
+		{name: "space2", stringVal: "key =value", expectError: true},
+		{name: "space3", stringVal: "key=value ", expectError: true},
+		{name: "no value 1", stringVal: "key=", expectError: true},
+		{name: "no value 2", stringVal: "key", expectError: true},
+		{name: "upper case key", stringVal: "Key=value", expectError: true},
+		{name: "digit key", stringVal: "1key=value", expectError: true},
+		{name: "digit vendor", stringVal: "tenant@1vendor=value", expectError: true},
+		{name: "empty tenant", stringVal: "@vendor=value", expectError: true},
+		{name: "empty vendor", stringVal: "tenant@=value", expectError: true},
+		{name: "two '@'", stringVal: "a@b@c=value", expectError: true},
+		{name: "vendor too long", stringVal: "tenant@" + strings.Repeat("v", 15) + "=value", expectError: true},
+		{name: "key too long", stringVal: strings.Repeat("k", 257) + "=value", expectError: true},
+		{name: "value too long", stringVal: "key=" + strings.Repeat("v", 257), expectError: true},
+		{name: "value with '='", stringVal: "key=a=b", expectError: true},
 	}
 
 	for i, tc := range testCases {
@@ -42,6 +59,17 @@ func TestKVPair(t *testing.T) {
 	}
 }
 
+// members returns a tracestate of n members k0=v0 ...
+func members(n int) string {
+	var m []string
+	for i := 0; i < n; i++ {
+		m = append(m, fmt.Sprintf("k%d=v%d", i, i))
+	}
+	return strings.Join(m, ",")
+}
+
+// TestTraceState follows the tracestate cases of the W3C test suite
+// https://github.com/w3c/trace-context/blob/master/test/test.py
 func TestTraceState(t *testing.T) {
 	testCases := []struct {
 		name           string
@@ -49,16 +77,17 @@ func TestTraceState(t *testing.T) {
 		expectError    bool
 		expectedString string
 	}{
-		{name: "empty", stringVal: "", expectError: true, expectedString: ""},
+		{name: "empty", stringVal: "", expectError: false, expectedString: ""},
 		{name: "normal", stringVal: "key=value", expectError: false, expectedString: "key=value"},
-		{name: "space1", stringVal: " key=value", expectError: false, expectedString: "key=value"},
-		{name: "space2", stringVal: " key =value", expectError: false, expectedString: "key=value"},
-		{name: "space3", stringVal: " key = value", expectError: false, expectedString: "key=value"},
-		{name: "no value 1", stringVal: "key=", expectError: false, expectedString: "key"},
-		{name: "no value 2", stringVal: "key", expectError: false, expectedString: "key"},
-		{name: "no properties", stringVal: "key=value;", expectError: true, expectedString: ""},
-		{name: "single property", stringVal: "key=value;v1", expectError: false, expectedString: "key=value;v1"},
-		{name: "multi property", stringVal: "key=value;k1=v1;k2=v2", expectError: false, expectedString: "key=value;k1=v1;k2=v2"},
+		{name: "multiple", stringVal: "foo=1,bar=2", expectError: false, expectedString: "foo=1,bar=2"},
+		{name: "white space", stringVal: " foo=1 ,\tbar=2\t", expectError: false, expectedString: "foo=1,bar=2"},
+		{name: "empty members", stringVal: "foo=1,, ,bar=2,", expectError: false, expectedString: "foo=1,bar=2"},
+		{name: "duplicated keys", stringVal: "foo=1,foo=1", expectError: true},
+		{name: "duplicated keys 2", stringVal: "foo=1,bar=2,foo=3", expectError: true},
+		{name: "invalid member", stringVal: "foo=1,BAR=2", expectError: true},
+		{name: "old properties", stringVal: "key=value;k1=v1", expectError: true},
+		{name: "32 members", stringVal: members(32), expectError: false, expectedString: members(32)},
+		{name: "33 members", stringVal: members(33), expectError: true},
 	}
 
 	for i, tc := range testCases {
@@ -82,3 +111,106 @@ func TestTraceState(t *testing.T) {
 		})
 	}
 }
+
+func TestTraceStateMutation(t *testing.T) {
+	ts, err := ParseTraceState("foo=1,bar=2,baz=3")
+	if err != nil {
+		t.Fatalf("ParseTraceState failed: %s", err)
+	}
+
+	testCases := []struct {
+		name           string
+		mutate         func(TraceState) (TraceState, error)
+		expectError    bool
+		expectedString string
+	}{
+		{
+			name:           "add",
+			mutate:         func(ts TraceState) (TraceState, error) { return ts.Set("new@gm", "x") },
+			expectedString: "new@gm=x,foo=1,bar=2,baz=3",
+		},
+		{
+			name:           "update moves left",
+			mutate:         func(ts TraceState) (TraceState, error) { return ts.Set("baz", "4") },
+			expectedString: "baz=4,foo=1,bar=2",
+		},
+		{
+			name:           "delete",
+			mutate:         func(ts TraceState) (TraceState, error) { return ts.Delete("bar"), nil },
+			expectedString: "foo=1,baz=3",
+		},
+		{
+			name:        "invalid key",
+			mutate:      func(ts TraceState) (TraceState, error) { return ts.Set("Foo", "1") },
+			expectError: true,
+		},
+		{
+			name:        "invalid value",
+			mutate:      func(ts TraceState) (TraceState, error) { return ts.Set("foo", "a,b") },
+			expectError: true,
+		},
+	}
+
+	for i, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ts2, err := tc.mutate(ts)
+			if tc.expectError {
+				if err == nil {
+					t.Fatalf("%d: expecting error", i)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("%d: unexpected error: %s", i, err)
+			}
+			if s2 := ts2.String(); s2 != tc.expectedString {
+				t.Fatalf("%d: string mismatch: %s != %s", i, s2, tc.expectedString)
+			}
+			if s := ts.String(); s != "foo=1,bar=2,baz=3" {
+				t.Fatalf("%d: original mutated: %s", i, s)
+			}
+		})
+	}
+
+	full, err := ParseTraceState(members(MaxTraceStateMembers))
+	if err != nil {
+		t.Fatalf("ParseTraceState failed: %s", err)
+	}
+	full, err = full.Set("new", "x")
+	if err != nil {
+		t.Fatalf("Set failed: %s", err)
+	}
+	if len(full.Members) != MaxTraceStateMembers || full.Members[0].Key != "new" {
+		t.Fatalf("full list: %s", full)
+	}
+	last := fmt.Sprintf("k%d", MaxTraceStateMembers-1)
+	if _, ok := full.Get(last); ok {
+		t.Fatalf("full list: %s was not removed", last)
+	}
+}
+
+func TestTraceStateTruncate(t *testing.T) {
+	long := "long=" + strings.Repeat("x", 200)
+	ts, err := ParseTraceState("a=1," + long + ",b=2,c=3")
+	if err != nil {
+		t.Fatalf("ParseTraceState failed: %s", err)
+	}
+
+	testCases := []struct {
+		maxLength      int
+		expectedString string
+	}{
+		{maxLength: 512, expectedString: ts.String()},
+		{maxLength: 100, expectedString: "a=1,b=2,c=3"},
+		{maxLength: 8, expectedString: "a=1,b=2"},
+		{maxLength: 2, expectedString: ""},
+	}
+
+	for i, tc := range testCases {
+		t.Run(fmt.Sprintf("%d", tc.maxLength), func(t *testing.T) {
+			if s := ts.Truncate(tc.maxLength).String(); s != tc.expectedString {
+				t.Fatalf("%d: string mismatch: %s != %s", i, s, tc.expectedString)
+			}
+		})
+	}
+}